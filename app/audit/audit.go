@@ -0,0 +1,188 @@
+/*
+This package keeps the audit log of a node: one JSON object per line for every sdfs and maplejuice
+request the node handled, with who asked, what for, how it ended and how long it took. The file is
+only ever appended to, also across restarts. The services of a node share its log.
+*/
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one request in the audit log
+type Entry struct {
+	Time     time.Time     `json:"time"`
+	Node     string        `json:"node"`
+	User     string        `json:"user"`              // empty for an rpc caller that failed to authenticate
+	Claimed  string        `json:"claimed,omitempty"` // the user that caller claimed to act as
+	Peer     string        `json:"peer,omitempty"`    // the address of the rpc caller
+	Op       string        `json:"op"`
+	Args     []string      `json:"args,omitempty"`
+	Result   string        `json:"result"` // "ok" or the error
+	Duration time.Duration `json:"duration"`
+}
+
+// Filter selects entries, zero fields match everything
+type Filter struct {
+	User  string
+	Op    string
+	Arg   string // a substring of one of the arguments, e.g. a file name
+	Since time.Time
+	Limit int // the newest Limit entries
+}
+
+type Log struct {
+	path string
+	node string
+	mux  sync.Mutex
+	file *os.File
+}
+
+var (
+	logsMux sync.Mutex
+	logs    = map[string]*Log{}
+)
+
+// Open returns the audit log at path of the node at nodeAddr, opened once per process. An empty
+// path returns a nil log, which records nothing.
+func Open(path string, nodeAddr string) (*Log, error) {
+	if path == "" {
+		return nil, nil
+	}
+	logsMux.Lock()
+	defer logsMux.Unlock()
+	if l, ok := logs[path]; ok {
+		return l, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{path: path, node: nodeAddr, file: file}
+	logs[path] = l
+	return l, nil
+}
+
+// Operation is a request being audited
+type Operation struct {
+	log   *Log
+	entry Entry
+}
+
+// Start begins the entry of a request, which End records. Meant to be deferred as
+//
+//	defer log.Start(user, "put", name).End(&err)
+func (l *Log) Start(user string, op string, args ...string) *Operation {
+	return &Operation{log: l, entry: Entry{Time: time.Now(), User: user, Op: op, Args: args}}
+}
+
+// StartRPC begins the entry of a request from the rpc caller at peer, which claims to act as
+// user. The user is recorded as Claimed until Authenticated is called.
+func (l *Log) StartRPC(peer net.Addr, user string, op string, args ...string) *Operation {
+	o := l.Start("", op, args...)
+	o.entry.Claimed = user
+	if peer != nil {
+		o.entry.Peer = peer.String()
+	}
+	return o
+}
+
+// Authenticated records the user the rpc caller claimed as the user of the request
+func (o *Operation) Authenticated() {
+	o.entry.User, o.entry.Claimed = o.entry.Claimed, ""
+}
+
+// End records the request with the error it ended with
+func (o *Operation) End(err *error) {
+	if o.log == nil {
+		return
+	}
+	o.entry.Duration = time.Since(o.entry.Time)
+	o.entry.Result = "ok"
+	if err != nil && *err != nil {
+		o.entry.Result = (*err).Error()
+	}
+	o.log.record(o.entry)
+}
+
+func (l *Log) record(entry Entry) {
+	entry.Node = l.node
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	_, _ = l.file.Write(append(line, '\n'))
+}
+
+// Query returns the entries matching filter, oldest first. It reads the entries recorded when it
+// starts, on a handle of its own, so that requests keep being recorded while it scans.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+	// lines are written whole under the lock, the size it sees ends with a complete line
+	l.mux.Lock()
+	info, err := l.file.Stat()
+	l.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(io.LimitReader(file, info.Size()))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, scanner.Err()
+}
+
+func (f Filter) Matches(entry Entry) bool {
+	if f.User != "" && entry.User != f.User || f.Op != "" && entry.Op != f.Op || entry.Time.Before(f.Since) {
+		return false
+	}
+	if f.Arg == "" {
+		return true
+	}
+	for _, arg := range entry.Args {
+		if strings.Contains(arg, f.Arg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Entry) String() string {
+	user := e.User
+	if e.Claimed != "" {
+		user = "unauthenticated " + e.Claimed
+	}
+	if e.Peer != "" {
+		user += " from " + e.Peer
+	}
+	return e.Time.Format("2006-01-02 15:04:05.000") + "\t" + e.Node + "\t" + user + "\t" + e.Op + " " +
+		strings.Join(e.Args, " ") + "\t" + e.Result + "\t" + e.Duration.Round(time.Microsecond).String()
+}
@@ -0,0 +1,133 @@
+//go:build integration
+
+package audit_test
+
+import (
+	"better_mp3/app/audit"
+	"better_mp3/app/command"
+	"better_mp3/app/file_service"
+	"better_mp3/app/harness"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestAudit(t *testing.T) {
+	harness.Run(t, "users", harness.Options{Size: 4, Users: []string{"admin", "alice", "bob"}}, checkAudit)
+}
+
+// every request is audited on the nodes handling it with its user and result, admins see all
+// entries of the cluster and other users only their own
+func checkAudit(c *harness.Cluster) error {
+	admin, alice, bob := c.Nodes[0], c.Nodes[1], c.Nodes[2]
+	if err := c.PutFrom(alice, "audited"); err != nil {
+		return err
+	}
+	if err := bob.File.RemoteDelete(bob.File.User(), "audited"); err == nil {
+		return errors.New("bob deleted the file alice owns")
+	}
+	if err := alice.File.RemoteGet(alice.File.User(), "audited", filepath.Join(c.Dir, "audited-got")); err != nil {
+		return err
+	}
+	if err := alice.File.RemoteDelete(alice.File.User(), "audited"); err != nil {
+		return err
+	}
+
+	entries, err := admin.File.QueryAudit(admin.File.User(), audit.Filter{Arg: "audited"})
+	if err != nil {
+		return err
+	}
+	count := func(node *harness.Node, user string, op string, result string) int {
+		n := 0
+		for _, entry := range entries {
+			if (node == nil || entry.Node == node.Addr) && entry.User == user && entry.Op == op &&
+				strings.HasPrefix(entry.Result, result) && entry.Duration > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	for _, want := range []struct {
+		node   *harness.Node
+		user   string
+		op     string
+		result string
+	}{
+		{alice, "alice", "put", "ok"},
+		{alice, "alice", "get", "ok"},
+		{bob, "bob", "delete", file_service.ErrPermissionDenied.Error()},
+		{alice, "alice", "delete", "ok"},
+	} {
+		if count(want.node, want.user, want.op, want.result) != 1 {
+			return fmt.Errorf("no single %v by %v on %v with result %q in %v", want.op, want.user, want.node.Addr, want.result, entries)
+		}
+	}
+	if replicas := count(nil, "alice", "replica-put", "ok"); replicas < 2 {
+		return fmt.Errorf("%v replicas of the put were audited, expected one per replica in %v", replicas, entries)
+	}
+	// the replicas record the node that called them
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Op, "replica-") && (entry.Peer == "" || entry.Claimed != "") {
+			return fmt.Errorf("the replica request %v records no authenticated caller", entry)
+		}
+	}
+
+	own, err := bob.File.QueryAudit(bob.File.User(), audit.Filter{})
+	if err != nil {
+		return err
+	}
+	if len(own) == 0 {
+		return errors.New("bob sees none of his requests")
+	}
+	for _, entry := range own {
+		if entry.User != "bob" {
+			return fmt.Errorf("bob sees the entry of another user: %v", entry)
+		}
+	}
+	if _, err := bob.File.QueryAudit(bob.File.User(), audit.Filter{User: "alice"}); err == nil ||
+		!strings.Contains(err.Error(), file_service.ErrPermissionDenied.Error()) {
+		return fmt.Errorf("bob's query of alice's entries was answered with %v", err)
+	}
+
+	// the log is only appended to
+	before, err := ioutil.ReadFile(alice.Config.AuditLog)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	if err := admin.MapleJuice.ScheduleMapleTask([]string{"maple", "maple_wordcount", "2", "audit-wc", "words"}); err != nil {
+		return err
+	}
+	entries, err = admin.File.QueryAudit(admin.File.User(), audit.Filter{Arg: "maple_wordcount"})
+	if err != nil {
+		return err
+	}
+	if count(admin, "admin", "maple", "ok") != 1 || count(nil, "admin", "maple-task", "ok") < 2 {
+		return fmt.Errorf("the maple job and its tasks were not audited: %v", entries)
+	}
+	after, err := ioutil.ReadFile(alice.Config.AuditLog)
+	if err != nil {
+		return err
+	}
+	if len(after) <= len(before) || !bytes.HasPrefix(after, before) {
+		return errors.New("the audit log of alice was not appended to")
+	}
+
+	if err := admin.File.HandleAudit(command.Command{Method: command.Audit, Params: []string{"op=maple", "since=1h", "limit=5"}}); err != nil {
+		return err
+	}
+	if err := admin.File.HandleAudit(command.Command{Method: command.Audit, Params: []string{"limit"}}); err == nil {
+		return errors.New("audit accepted a parameter without a value")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"better_mp3/app/command"
+	"better_mp3/app/logger"
+	"fmt"
+	"strings"
+)
+
+/*
+Batch mode runs the same commands as the interactive shell, one after another.
+Every command runs to completion before the next one starts, and the first
+failing command stops the whole script.
+
+Besides the normal commands a script may use:
+	set NAME value      define a variable, referenced later as $NAME or ${NAME}
+	wait join [secs]    block until the introducer has accepted this node
+*/
+
+func RunScript(script *command.Script) error {
+	logger.PrintInfo("Running batch script", script.Name, "with", len(script.Lines), "commands")
+	for _, line := range script.Lines {
+		userCommand := command.Parse(script.Expand(line.Text))
+
+		if userCommand.Method == command.Set {
+			if len(userCommand.Params) < 1 {
+				return fmt.Errorf("%v:%v: usage: set NAME value", script.Name, line.Number)
+			}
+			script.Set(userCommand.Params[0], strings.Join(userCommand.Params[1:], " "))
+			continue
+		}
+
+		logger.PrintInfo("Batch >", userCommand.Method, strings.Join(userCommand.Params, " "))
+		err := ExecuteCommand(userCommand)
+		if err == nil && userCommand.Method == command.Join {
+			err = memberService.HandleWait(command.Command{Method: command.Wait, Params: []string{"join"}})
+		}
+		if err != nil {
+			return fmt.Errorf("%v:%v: %v: %v", script.Name, line.Number, line.Text, err)
+		}
+	}
+	logger.PrintInfo("Batch script", script.Name, "finished")
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+Creates a certificate authority and node certificates for the mutual tls of the rpc services:
+
+	go run ./app/cmd/certs -dir certs -hosts 127.0.0.1,localhost -nodes 7008,7018,7028
+
+writes certs/ca.pem, certs/ca-key.pem and, for every node, certs/node-<name>.pem and
+certs/node-<name>-key.pem. An existing authority in -dir is reused, so that nodes can be added
+later. Point tls.ca, tls.cert and tls.key of conf.yaml at the files, e.g.
+
+	cert: ./certs/node-{port}.pem
+*/
+package main
+
+import (
+	"better_mp3/app/logger"
+	"better_mp3/app/secure_rpc"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "directory of the authority and the certificates")
+	hosts := flag.String("hosts", "127.0.0.1,localhost", "comma separated host names and IP addresses the nodes are reached at")
+	nodes := flag.String("nodes", "", "comma separated node names, e.g. their member ports")
+	validFor := flag.Duration("valid", 365*24*time.Hour, "validity of new certificates")
+	flag.Parse()
+
+	caCert := filepath.Join(*dir, "ca.pem")
+	caKey := filepath.Join(*dir, "ca-key.pem")
+	ca, err := secure_rpc.LoadCA(caCert, caKey)
+	if os.IsNotExist(err) {
+		logger.PrintInfo("Creating a certificate authority in", *dir)
+		ca, err = secure_rpc.NewCA("better_mp3 cluster", *validFor)
+		if err == nil {
+			err = ca.Write(caCert, caKey)
+		}
+	}
+	if err != nil {
+		logger.PrintError("Failed to set up the certificate authority:", err)
+		os.Exit(1)
+	}
+
+	for _, node := range strings.Split(*nodes, ",") {
+		if node = strings.TrimSpace(node); node == "" {
+			continue
+		}
+		certPath := filepath.Join(*dir, "node-"+node+".pem")
+		keyPath := filepath.Join(*dir, "node-"+node+"-key.pem")
+		if err := ca.IssueFiles(node, strings.Split(*hosts, ","), *validFor, certPath, keyPath); err != nil {
+			logger.PrintError("Failed to issue the certificate of", node, err)
+			os.Exit(1)
+		}
+		logger.PrintInfo("Issued", certPath)
+	}
+}
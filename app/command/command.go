@@ -12,15 +12,24 @@ const (
 	Send 		= "send"
 	Switch 		= "switch"
 	Display 	= "display"
+	Tag 		= "tag"
+	Key 		= "key"
 
 	Put 		= "put"
 	Get 		= "get"
 	Delete 		= "delete"
 	List 		= "ls"
 	Store 		= "store"
+	Acl 		= "acl"
+	Audit 		= "audit"
 
 	Maple 		= "maple"
 	Juice 		= "juice"
+	Jobs 		= "jobs"
 
 	Quit 		= "quit"
+
+	Set 		= "set"
+	Wait 		= "wait"
+	Log 		= "log"
 )
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Line is one command of a batch script, remembered with where it came from
+type Line struct {
+	Number int
+	Text   string
+}
+
+// Script is a sequence of commands read from a file or a -c argument.
+// Lines are expanded lazily so that a `set` only affects the lines after it.
+type Script struct {
+	Name  string
+	Lines []Line
+	vars  map[string]string
+}
+
+// Parse splits a single line of user input into a Command
+func Parse(line string) Command {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Command{}
+	}
+	return Command{
+		Method: fields[0],
+		Params: fields[1:],
+	}
+}
+
+// NewScript builds a script from text. Commands are separated by newlines or ';',
+// blank lines and lines starting with '#' are skipped.
+func NewScript(name string, text string, vars map[string]string) *Script {
+	script := Script{
+		Name: name,
+		vars: map[string]string{},
+	}
+	for k, v := range vars {
+		script.vars[k] = v
+	}
+	for i, row := range strings.Split(text, "\n") {
+		for _, part := range strings.Split(row, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" || strings.HasPrefix(part, "#") {
+				continue
+			}
+			script.Lines = append(script.Lines, Line{Number: i + 1, Text: part})
+		}
+	}
+	return &script
+}
+
+// LoadScript reads a batch file from disk
+func LoadScript(path string, vars map[string]string) (*Script, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewScript(path, string(content), vars), nil
+}
+
+// Set assigns a script variable, visible to every following line
+func (s *Script) Set(name string, value string) {
+	s.vars[name] = value
+}
+
+// Expand substitutes $NAME and ${NAME} with script variables, falling back to the environment
+func (s *Script) Expand(text string) string {
+	return os.Expand(text, func(name string) string {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+}
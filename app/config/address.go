@@ -0,0 +1,51 @@
+package config
+
+import (
+	"net"
+	"strconv"
+)
+
+/*
+A node is identified by the host:port address of its member service.
+The other services of a node are found through the port layout: each of them
+keeps the same distance to member_service.port on every node. With the default
+ports 7008 (member), 7007 (file) and 7009 (maplejuice), a node started with
+member_service.port 7018 must use 7017 and 7019.
+*/
+
+// ServiceAddr returns the address of a service on the node identified by nodeAddr,
+// given the node selfAddr runs that service on servicePort
+func ServiceAddr(nodeAddr string, selfAddr string, servicePort string) string {
+	host, port, err := net.SplitHostPort(nodeAddr)
+	if err != nil {
+		return net.JoinHostPort(nodeAddr, servicePort)
+	}
+	_, selfPort, _ := net.SplitHostPort(selfAddr)
+	memberPort, _ := strconv.Atoi(selfPort)
+	localPort, _ := strconv.Atoi(servicePort)
+	nodePort, _ := strconv.Atoi(port)
+	return net.JoinHostPort(host, strconv.Itoa(nodePort+localPort-memberPort))
+}
+
+// WithDefaultPort appends port to addr if it has none
+func WithDefaultPort(addr string, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// DetectHost picks the first non-loopback IPv4 address of this machine without
+// touching the network, falling back to loopback on isolated hosts and containers
+func DetectHost() string {
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				return ipNet.IP.String()
+			}
+		}
+	}
+	return "127.0.0.1"
+}
@@ -1,22 +1,27 @@
 package config
 
-var DebugMode = false
-
-var	MemberServicePort = "7008"
-
-const BUFFER_SIZE int = 8192
-
-const (
-	T_TIMEOUT           = 5
-	T_CLEANUP           = 40
-	WaitTimeForElection = 10
-	PULSE_TIME = 500
-	GOSSIP_FANOUT = 5
-)
-
 const STRAT_GOSSIP = "gossip"
 const STRAT_ALL = "all"
+const STRAT_SWIM = "swim"
 
+// failure detectors of the all and gossip strategies
+const DETECTOR_TIMEOUT = "timeout"
+const DETECTOR_ACCRUAL = "accrual"
+const DETECTOR_PHI = "phi"
 
 const PERM_MODE = 0777
 
+// formats of log records
+const LOG_LOGFMT = "logfmt"
+const LOG_JSON = "json"
+
+// exporters of trace spans
+const TRACE_OTLP = "otlp"
+const TRACE_FILE = "file"
+
+// LogLevels are the names of the log levels, from the most verbose on
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// EnvPrefix is prepended to the upper-cased setting key to form its environment variable,
+// e.g. member_service.port can be overridden by BMP3_MEMBER_SERVICE_PORT
+const EnvPrefix = "BMP3_"
@@ -1,20 +1,43 @@
 package config
 
 import (
-	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 type MemberServiceConfig struct {
-	IntroducerIP   string        `yaml:"introducer_ip"`
-	Port           string        `yaml:"port"`
-	GossipInterval time.Duration `yaml:"gossip_interval"`
-	SuspectTime    int           `yaml:"suspect_time"`
-	FailTime       int           `yaml:"fail_time"`
-	RemoveTime     int           `yaml:"remove_time"`
+	Introducer     string            `yaml:"introducer"`
+	Seeds          []string          `yaml:"seeds"`
+	Tags           map[string]string `yaml:"tags"`
+	Host           string            `yaml:"host"`
+	Port           string            `yaml:"port"`
+	Strategy       string            `yaml:"strategy"`
+	GossipInterval time.Duration     `yaml:"gossip_interval"`
+	GossipFanout   int               `yaml:"gossip_fanout"`
+	ProbeTimeout   time.Duration     `yaml:"probe_timeout"`
+	IndirectProbes int               `yaml:"indirect_probes"`
+	SuspectTime    time.Duration     `yaml:"suspect_time"`
+	SuspectMaxTime time.Duration     `yaml:"suspect_max_time"`
+	Confirmations  int               `yaml:"suspect_confirmations"`
+	HealthMax      int               `yaml:"health_max"`
+	Detector       string            `yaml:"detector"`
+	AccrualLevel   float64           `yaml:"accrual_threshold"`
+	PhiThreshold   float64           `yaml:"phi_threshold"`
+	FailTime       time.Duration     `yaml:"fail_time"`
+	RemoveTime     time.Duration     `yaml:"remove_time"`
+	JoinTimeout    time.Duration     `yaml:"join_timeout"`
+	JoinBackoff    time.Duration     `yaml:"join_backoff"`
+	RetransmitMult int               `yaml:"retransmit_mult"`
+	SyncInterval   time.Duration     `yaml:"sync_interval"`
+	Keys           []string          `yaml:"keys"`
+	Encrypt        bool              `yaml:"encrypt"`
+
+	BufferSize int `yaml:"-"` // copied from buffer_size
 }
 
 type MapleJuiceServiceConfig struct {
@@ -23,39 +46,278 @@ type MapleJuiceServiceConfig struct {
 	TmpDir   string `yaml:"tmp_dir"`
 	InputDir string `yaml:"input_dir"`
 	ExecDir  string `yaml:"exec_dir"`
+	// limits of the maple and juice executables
+	TaskTimeout  time.Duration `yaml:"task_timeout"`
+	TaskCPU      time.Duration `yaml:"task_cpu"`
+	TaskMemoryMB int           `yaml:"task_memory_mb"`
+	TaskFileMB   int           `yaml:"task_file_mb"`
+
+	TLS      TLSConfig `yaml:"-"` // copied from the tls section
+	AuditLog string    `yaml:"-"` // copied from audit_log
 }
 
 type FileServiceConfig struct {
-	Port string `yaml:"port"`
-	Path string `yaml:"path"`
+	Port       string `yaml:"port"`
+	Path       string `yaml:"path"`
+	ReplicaNum int    `yaml:"replica_num"`
+	// the user that requests made through this node act as, the login name when not set
+	User   string   `yaml:"user"`
+	Admins []string `yaml:"admins"` // users allowed to do everything, acls included
+
+	TLS      TLSConfig `yaml:"-"` // copied from the tls section
+	AuditLog string    `yaml:"-"` // copied from audit_log
+}
+
+// RaftServiceConfig configures the replicated log of the control plane
+type RaftServiceConfig struct {
+	Port              string        `yaml:"port"`
+	Path              string        `yaml:"path"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	ElectionTimeout   time.Duration `yaml:"election_timeout"`
+	CommitTimeout     time.Duration `yaml:"commit_timeout"`
+	SnapshotEntries   int           `yaml:"snapshot_entries"`
+	Bootstrap         bool          `yaml:"bootstrap"` // the introducer founds a new cluster if it has no log
+
+	TLS TLSConfig `yaml:"-"` // copied from the tls section
+}
+
+// HTTPServiceConfig configures the http endpoint of a node, which serves its metrics, and the
+// listener of its dashboard
+type HTTPServiceConfig struct {
+	Port          string `yaml:"port"`           // empty turns the endpoint off
+	DashboardAddr string `yaml:"dashboard_addr"` // host:port of the web dashboard, empty turns it off
+}
+
+// LogConfig configures the log of a node
+type LogConfig struct {
+	Path        string            `yaml:"path"`         // empty logs to the console only
+	Level       string            `yaml:"level"`        // one of LogLevels
+	Levels      map[string]string `yaml:"levels"`       // by component, overriding level
+	Format      string            `yaml:"format"`       // LOG_LOGFMT or LOG_JSON
+	Console     bool              `yaml:"console"`      // also print records to the console
+	MaxSizeMB   int               `yaml:"max_size_mb"`  // rotate once the file grows larger, 0 never
+	RotateEvery time.Duration     `yaml:"rotate_every"` // rotate at multiples of it since the epoch, 0 never
+	MaxBackups  int               `yaml:"max_backups"`  // rotated files kept, 0 keeps all
+}
+
+// TracingConfig configures where the spans of the requests a node handles are exported to
+type TracingConfig struct {
+	Exporter      string `yaml:"exporter"`       // TRACE_OTLP, TRACE_FILE or empty for no tracing
+	Endpoint      string `yaml:"endpoint"`       // host:port of the otlp/http collector
+	Path          string `yaml:"path"`           // the file spans are appended to, one json object per line
+	SamplePercent int    `yaml:"sample_percent"` // of the requests started on this node
+}
+
+// TLSConfig names the PEM files of mutual tls between the rpc services, plain tcp is used
+// when they are empty
+type TLSConfig struct {
+	CA   string `yaml:"ca"`   // the certificate authority of the cluster
+	Cert string `yaml:"cert"` // the certificate of this node, signed by the authority
+	Key  string `yaml:"key"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CA != ""
 }
 
 type Config struct {
-	Debug      string `yaml:"debug"`
-	BufferSize  string `yaml:"buffer_size"`
+	Debug           bool          `yaml:"debug"`
+	DataDir         string        `yaml:"data_dir"`
+	BufferSize      int           `yaml:"buffer_size"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	TLS             TLSConfig     `yaml:"tls"`
+	AuditLog        string        `yaml:"audit_log"` // empty turns auditing off
+	Log             LogConfig     `yaml:"log"`
+	Tracing         TracingConfig `yaml:"tracing"`
 
-	MemberServiceConfig     MemberServiceConfig `yaml:"member_service"`
-	FileServiceConfig       FileServiceConfig `yaml:"file_service"`
+	MemberServiceConfig     MemberServiceConfig     `yaml:"member_service"`
+	FileServiceConfig       FileServiceConfig       `yaml:"file_service"`
 	MapleJuiceServiceConfig MapleJuiceServiceConfig `yaml:"maplejuice_service"`
+	RaftServiceConfig       RaftServiceConfig       `yaml:"raft_service"`
+	HTTPServiceConfig       HTTPServiceConfig       `yaml:"http_service"`
+}
+
+var config = defaultConfig()
+
+// defaultConfig holds the values used for settings missing from conf.yaml
+func defaultConfig() Config {
+	return Config{
+		Debug:           false,
+		DataDir:         "./",
+		BufferSize:      8192,
+		ShutdownTimeout: 30 * time.Second,
+		AuditLog:        "audit.log",
+		Log: LogConfig{
+			Path:        "logs.txt",
+			Level:       "info",
+			Format:      LOG_LOGFMT,
+			Console:     true,
+			MaxSizeMB:   100,
+			RotateEvery: 24 * time.Hour,
+			MaxBackups:  7,
+		},
+		Tracing: TracingConfig{
+			Endpoint:      "localhost:4318",
+			Path:          "traces.json",
+			SamplePercent: 100,
+		},
+		MemberServiceConfig: MemberServiceConfig{
+			Port:           "7008",
+			Strategy:       STRAT_ALL,
+			GossipInterval: 500 * time.Millisecond,
+			GossipFanout:   5,
+			ProbeTimeout:   200 * time.Millisecond,
+			IndirectProbes: 3,
+			SuspectTime:    2 * time.Second,
+			SuspectMaxTime: 12 * time.Second,
+			Confirmations:  3,
+			HealthMax:      8,
+			Detector:       DETECTOR_TIMEOUT,
+			AccrualLevel:   8,
+			PhiThreshold:   8,
+			FailTime:       5 * time.Second,
+			RemoveTime:     40 * time.Second,
+			JoinTimeout:    10 * time.Second,
+			JoinBackoff:    5 * time.Second,
+			RetransmitMult: 4,
+			SyncInterval:   30 * time.Second,
+		},
+		FileServiceConfig: FileServiceConfig{
+			Port:       "7007",
+			Path:       "./sdfs/",
+			ReplicaNum: 4,
+		},
+		MapleJuiceServiceConfig: MapleJuiceServiceConfig{
+			Port:         "7009",
+			SdfsDir:      "./sdfs/",
+			TmpDir:       "./tmp/",
+			InputDir:     "./input/",
+			ExecDir:      "./exec/",
+			TaskTimeout:  10 * time.Minute,
+			TaskCPU:      5 * time.Minute,
+			TaskMemoryMB: 2048,
+			TaskFileMB:   1024,
+		},
+		RaftServiceConfig: RaftServiceConfig{
+			Port:              "7010",
+			Path:              "./raft/",
+			HeartbeatInterval: 100 * time.Millisecond,
+			ElectionTimeout:   time.Second,
+			CommitTimeout:     10 * time.Second,
+			SnapshotEntries:   1000,
+		},
+		HTTPServiceConfig: HTTPServiceConfig{
+			Port: "7011",
+		},
+	}
 }
 
-var config Config
+// DetectUser returns the login name of the user running this process
+func DetectUser() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "anonymous"
+}
 
 func CreateDir() {
-	_ = os.Mkdir(config.MapleJuiceServiceConfig.TmpDir, PERM_MODE)
-	_ = os.Mkdir(config.MapleJuiceServiceConfig.SdfsDir, PERM_MODE)
+	_ = os.MkdirAll(config.MapleJuiceServiceConfig.TmpDir, PERM_MODE)
+	_ = os.MkdirAll(config.MapleJuiceServiceConfig.SdfsDir, PERM_MODE)
+	_ = os.MkdirAll(config.FileServiceConfig.Path, PERM_MODE)
+	_ = os.MkdirAll(config.RaftServiceConfig.Path, PERM_MODE)
+}
+
+// placeNodeDirs moves the directories, the audit log and the log a node writes to under
+// data_dir, so that several nodes on one host don't share them. "{port}" in
+// data_dir is replaced by the member service port.
+func placeNodeDirs(c *Config) {
+	dataDir := strings.ReplaceAll(c.DataDir, "{port}", c.MemberServiceConfig.Port)
+	place := func(dir string) string {
+		if filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(dataDir, dir) + string(filepath.Separator)
+	}
+	c.FileServiceConfig.Path = place(c.FileServiceConfig.Path)
+	c.MapleJuiceServiceConfig.SdfsDir = place(c.MapleJuiceServiceConfig.SdfsDir)
+	c.MapleJuiceServiceConfig.TmpDir = place(c.MapleJuiceServiceConfig.TmpDir)
+	c.RaftServiceConfig.Path = place(c.RaftServiceConfig.Path)
+	if c.AuditLog != "" && !filepath.IsAbs(c.AuditLog) {
+		c.AuditLog = filepath.Join(dataDir, c.AuditLog)
+	}
+	c.FileServiceConfig.AuditLog = c.AuditLog
+	c.MapleJuiceServiceConfig.AuditLog = c.AuditLog
+	if c.Log.Path != "" && !filepath.IsAbs(c.Log.Path) {
+		c.Log.Path = filepath.Join(dataDir, c.Log.Path)
+	}
+	if c.Tracing.Path != "" && !filepath.IsAbs(c.Tracing.Path) {
+		c.Tracing.Path = filepath.Join(dataDir, c.Tracing.Path)
+	}
+}
+
+// placeTLS hands the tls files to the rpc services, "{port}" in their paths is replaced by the
+// member service port, so that the nodes on one host each get their own certificate
+func placeTLS(c *Config) {
+	port := func(path string) string {
+		return strings.ReplaceAll(path, "{port}", c.MemberServiceConfig.Port)
+	}
+	c.TLS = TLSConfig{CA: port(c.TLS.CA), Cert: port(c.TLS.Cert), Key: port(c.TLS.Key)}
+	c.FileServiceConfig.TLS = c.TLS
+	c.MapleJuiceServiceConfig.TLS = c.TLS
+	c.RaftServiceConfig.TLS = c.TLS
 }
 
-func LoadConfig(configFilePath string) {
+// LoadConfig reads the config file, applies environment and flag overrides on top of it
+// and validates the result. Nothing is changed if an error is returned.
+func LoadConfig(configFilePath string) error {
+	loaded := defaultConfig()
+
 	yamlFile, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
-	err = yaml.Unmarshal(yamlFile, &config)
+	err = yaml.UnmarshalStrict(yamlFile, &loaded)
 	if err != nil {
-		fmt.Println(err)
+		return &Error{Key: configFilePath, Reason: err.Error()}
+	}
+	if err = applyEnvOverrides(&loaded); err != nil {
+		return err
+	}
+	if err = applyFlagOverrides(&loaded); err != nil {
+		return err
+	}
+	if err = Validate(loaded); err != nil {
+		return err
 	}
+	if loaded.MemberServiceConfig.Host == "" {
+		loaded.MemberServiceConfig.Host = DetectHost()
+	}
+	if loaded.FileServiceConfig.User == "" {
+		loaded.FileServiceConfig.User = DetectUser()
+	}
+	if loaded.Debug {
+		loaded.Log.Level = LogLevels[0]
+	}
+	loaded.MemberServiceConfig.BufferSize = loaded.BufferSize
+	loaded.MemberServiceConfig.Introducer = WithDefaultPort(
+		loaded.MemberServiceConfig.Introducer, loaded.MemberServiceConfig.Port)
+	for i, seed := range loaded.MemberServiceConfig.Seeds {
+		loaded.MemberServiceConfig.Seeds[i] = WithDefaultPort(seed, loaded.MemberServiceConfig.Port)
+	}
+	placeNodeDirs(&loaded)
+	placeTLS(&loaded)
+
+	config = loaded
 	CreateDir()
+	return nil
+}
+
+// SetConfig replaces the loaded config, for embedding the services and for the test harness
+func SetConfig(c Config) {
+	config = c
 }
 
 func GetConfig() Config {
@@ -72,4 +334,12 @@ func GetMapleJuiceServiceConfig() MapleJuiceServiceConfig {
 
 func GetFileServiceConfig() FileServiceConfig {
 	return config.FileServiceConfig
-}
\ No newline at end of file
+}
+
+func GetRaftServiceConfig() RaftServiceConfig {
+	return config.RaftServiceConfig
+}
+
+func GetHTTPServiceConfig() HTTPServiceConfig {
+	return config.HTTPServiceConfig
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Every setting of conf.yaml can be overridden without editing the file, which is
+needed to run several nodes on one host. Settings are named by their yaml path:
+	member_service.port
+is read from the environment variable BMP3_MEMBER_SERVICE_PORT and from the flag
+	-member_service.port
+Flags win over environment variables, which win over the file.
+*/
+
+var flagSet *flag.FlagSet
+var flagValues = map[string]*string{}
+
+// RegisterFlags adds one flag per setting, call it before flagSet.Parse
+func RegisterFlags(fs *flag.FlagSet) {
+	flagSet = fs
+	walkSettings(reflect.ValueOf(&config).Elem(), "", func(key string, _ reflect.Value) {
+		flagValues[key] = fs.String(key, "", "override "+key+" of the config file")
+	})
+}
+
+func applyFlagOverrides(c *Config) error {
+	if flagSet == nil {
+		return nil
+	}
+	set := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	walkSettings(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		if err == nil && set[key] {
+			err = setField(key, field, *flagValues[key])
+		}
+	})
+	return err
+}
+
+func applyEnvOverrides(c *Config) error {
+	var err error
+	walkSettings(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		if value, ok := os.LookupEnv(EnvName(key)); ok && err == nil {
+			err = setField(EnvName(key), field, value)
+		}
+	})
+	return err
+}
+
+// EnvName returns the environment variable overriding a setting
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// walkSettings calls fn for every leaf field of a config struct with its yaml path
+func walkSettings(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if v.Field(i).Kind() == reflect.Struct {
+			walkSettings(v.Field(i), key+".", fn)
+		} else {
+			fn(key, v.Field(i))
+		}
+	}
+}
+
+func setField(key string, field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return &Error{Key: key, Reason: "invalid duration " + strconv.Quote(value)}
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return &Error{Key: key, Reason: "invalid integer " + strconv.Quote(value)}
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return &Error{Key: key, Reason: "invalid boolean " + strconv.Quote(value)}
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return &Error{Key: key, Reason: "cannot be overridden"}
+		}
+		// lists are given comma separated, e.g. BMP3_MEMBER_SERVICE_SEEDS=host1,host2:7008
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if field.Type() != reflect.TypeOf(map[string]string{}) {
+			return &Error{Key: key, Reason: "cannot be overridden"}
+		}
+		// maps are given as comma separated pairs, e.g. BMP3_MEMBER_SERVICE_TAGS=zone=a,role=worker
+		items := map[string]string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			pair := strings.SplitN(item, "=", 2)
+			if len(pair) != 2 {
+				return &Error{Key: key, Reason: "invalid pair " + strconv.Quote(item) + ", expected key=value"}
+			}
+			items[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return &Error{Key: key, Reason: "cannot be overridden"}
+	}
+	return nil
+}
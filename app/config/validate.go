@@ -0,0 +1,177 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error describes a single invalid setting
+type Error struct {
+	Key    string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "config: " + e.Key + ": " + e.Reason
+}
+
+// Validate checks every setting and reports all problems at once
+func Validate(c Config) error {
+	var errs []error
+	check := func(ok bool, key string, reason string) {
+		if !ok {
+			errs = append(errs, &Error{Key: key, Reason: reason})
+		}
+	}
+	positive := func(d time.Duration, key string) {
+		check(d > 0, key, "must be a positive duration such as 500ms or 5s")
+	}
+
+	check(c.DataDir != "", "data_dir", "must be set")
+	check(c.BufferSize >= 512, "buffer_size", "must be at least 512 bytes")
+	positive(c.ShutdownTimeout, "shutdown_timeout")
+	set := 0
+	for _, path := range []string{c.TLS.CA, c.TLS.Cert, c.TLS.Key} {
+		if path != "" {
+			set++
+		}
+	}
+	check(set == 0 || set == 3, "tls", "ca, cert and key must be set together")
+
+	l := c.Log
+	levels := strings.Join(LogLevels, ", ")
+	check(validLevel(l.Level), "log.level", "must be one of "+levels)
+	for component, level := range l.Levels {
+		check(component != "" && validLevel(level), "log.levels", "must map components to one of "+levels)
+	}
+	check(l.Format == LOG_LOGFMT || l.Format == LOG_JSON, "log.format", "must be "+LOG_LOGFMT+" or "+LOG_JSON)
+	check(l.MaxSizeMB >= 0, "log.max_size_mb", "must not be negative")
+	check(l.RotateEvery == 0 || l.RotateEvery >= time.Minute, "log.rotate_every", "must be 0 or at least 1m")
+	check(l.MaxBackups >= 0, "log.max_backups", "must not be negative")
+
+	t := c.Tracing
+	check(t.Exporter == "" || t.Exporter == TRACE_OTLP || t.Exporter == TRACE_FILE,
+		"tracing.exporter", "must be empty, "+TRACE_OTLP+" or "+TRACE_FILE)
+	check(t.Exporter != TRACE_OTLP || strings.Contains(t.Endpoint, ":") && validHostPort(t.Endpoint), "tracing.endpoint", "must be host:port of the otlp collector")
+	check(t.Exporter != TRACE_FILE || t.Path != "", "tracing.path", "must be set to export to a file")
+	check(t.SamplePercent >= 0 && t.SamplePercent <= 100, "tracing.sample_percent", "must be between 0 and 100")
+
+	m := c.MemberServiceConfig
+	check(m.Introducer != "", "member_service.introducer", "must be set")
+	check(validHostPort(m.Introducer), "member_service.introducer", "must be host or host:port")
+	for _, seed := range m.Seeds {
+		check(seed != "" && validHostPort(seed), "member_service.seeds", "must be host or host:port, got "+strconv.Quote(seed))
+	}
+	for key := range m.Tags {
+		check(key != "" && !strings.ContainsAny(key, "=,"), "member_service.tags", "invalid tag name "+strconv.Quote(key))
+	}
+	check(validPort(m.Port), "member_service.port", "must be a port number between 1 and 65535")
+	check(m.Strategy == STRAT_GOSSIP || m.Strategy == STRAT_ALL || m.Strategy == STRAT_SWIM,
+		"member_service.strategy", "must be "+STRAT_GOSSIP+", "+STRAT_ALL+" or "+STRAT_SWIM)
+	positive(m.GossipInterval, "member_service.gossip_interval")
+	check(m.GossipFanout > 0, "member_service.gossip_fanout", "must be at least 1")
+	positive(m.ProbeTimeout, "member_service.probe_timeout")
+	check(m.IndirectProbes >= 0, "member_service.indirect_probes", "must not be negative")
+	positive(m.SuspectTime, "member_service.suspect_time")
+	check(m.SuspectMaxTime >= m.SuspectTime, "member_service.suspect_max_time", "must not be shorter than suspect_time")
+	check(m.Confirmations >= 0, "member_service.suspect_confirmations", "must not be negative")
+	check(m.HealthMax >= 0, "member_service.health_max", "must not be negative")
+	check(m.Detector == DETECTOR_TIMEOUT || m.Detector == DETECTOR_ACCRUAL || m.Detector == DETECTOR_PHI,
+		"member_service.detector", "must be "+DETECTOR_TIMEOUT+", "+DETECTOR_ACCRUAL+" or "+DETECTOR_PHI)
+	check(m.AccrualLevel > 0, "member_service.accrual_threshold", "must be positive")
+	check(m.PhiThreshold > 0, "member_service.phi_threshold", "must be positive")
+	positive(m.FailTime, "member_service.fail_time")
+	positive(m.RemoveTime, "member_service.remove_time")
+	positive(m.JoinTimeout, "member_service.join_timeout")
+	check(m.JoinBackoff >= m.GossipInterval, "member_service.join_backoff", "must not be shorter than gossip_interval")
+	check(m.RetransmitMult > 0, "member_service.retransmit_mult", "must be at least 1")
+	check(m.SyncInterval >= m.GossipInterval, "member_service.sync_interval", "must not be shorter than gossip_interval")
+	for _, key := range m.Keys {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		check(err == nil && (len(decoded) == 16 || len(decoded) == 24 || len(decoded) == 32),
+			"member_service.keys", "must be base64 encoded keys of 16, 24 or 32 bytes")
+	}
+	check(!m.Encrypt || len(m.Keys) > 0, "member_service.encrypt", "needs member_service.keys")
+	check(m.FailTime > m.GossipInterval, "member_service.fail_time", "must be longer than gossip_interval")
+	check(m.ProbeTimeout < m.GossipInterval, "member_service.probe_timeout", "must be shorter than gossip_interval")
+
+	f := c.FileServiceConfig
+	check(validPort(f.Port), "file_service.port", "must be a port number between 1 and 65535")
+	check(f.Path != "", "file_service.path", "must be set")
+	check(f.ReplicaNum >= 1, "file_service.replica_num", "must be at least 1")
+	check(f.User == "" || validUser(f.User), "file_service.user", "must not contain spaces or start with '*' or '@'")
+	for _, admin := range f.Admins {
+		check(validUser(admin), "file_service.admins", "invalid user name "+strconv.Quote(admin))
+	}
+
+	mj := c.MapleJuiceServiceConfig
+	check(validPort(mj.Port), "maplejuice_service.port", "must be a port number between 1 and 65535")
+	check(mj.SdfsDir != "", "maplejuice_service.sdfs_dir", "must be set")
+	check(mj.TmpDir != "", "maplejuice_service.tmp_dir", "must be set")
+	check(mj.InputDir != "", "maplejuice_service.input_dir", "must be set")
+	check(mj.ExecDir != "", "maplejuice_service.exec_dir", "must be set")
+	positive(mj.TaskTimeout, "maplejuice_service.task_timeout")
+	check(mj.TaskCPU >= time.Second, "maplejuice_service.task_cpu", "must be at least 1s")
+	check(mj.TaskMemoryMB > 0, "maplejuice_service.task_memory_mb", "must be at least 1")
+	check(mj.TaskFileMB > 0, "maplejuice_service.task_file_mb", "must be at least 1")
+
+	r := c.RaftServiceConfig
+	check(validPort(r.Port), "raft_service.port", "must be a port number between 1 and 65535")
+	check(r.Path != "", "raft_service.path", "must be set")
+	positive(r.HeartbeatInterval, "raft_service.heartbeat_interval")
+	positive(r.ElectionTimeout, "raft_service.election_timeout")
+	positive(r.CommitTimeout, "raft_service.commit_timeout")
+	check(r.ElectionTimeout > 2*r.HeartbeatInterval, "raft_service.election_timeout", "must be longer than twice heartbeat_interval")
+	check(r.SnapshotEntries >= 1, "raft_service.snapshot_entries", "must be at least 1")
+
+	h := c.HTTPServiceConfig
+	check(h.Port == "" || validPort(h.Port), "http_service.port", "must be empty or a port number between 1 and 65535")
+	dashboardHost, dashboardPort, err := net.SplitHostPort(h.DashboardAddr)
+	check(h.DashboardAddr == "" || err == nil && dashboardHost != "" && validPort(dashboardPort),
+		"http_service.dashboard_addr", "must be empty or host:port, such as 127.0.0.1:7012")
+
+	ports := map[string]bool{m.Port: true, f.Port: true, mj.Port: true, r.Port: true}
+	services := 4
+	for _, port := range []string{h.Port, dashboardPort} {
+		if port != "" {
+			ports[port] = true
+			services++
+		}
+	}
+	check(len(ports) == services,
+		"port", "member_service, file_service, maplejuice_service, raft_service, http_service and the dashboard must use different ports")
+
+	return errors.Join(errs...)
+}
+
+func validHostPort(addr string) bool {
+	if !strings.Contains(addr, ":") {
+		return true
+	}
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && validPort(port)
+}
+
+func validLevel(level string) bool {
+	for _, name := range LogLevels {
+		if level == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validUser rejects names reserved for everyone and for the nodes themselves
+func validUser(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "*") && !strings.HasPrefix(name, "@") &&
+		!strings.ContainsAny(name, " \t\n,:")
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func validConfig() Config {
+	c := defaultConfig()
+	c.MemberServiceConfig.Introducer = "10.0.0.1:7008"
+	return c
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if err := Validate(validConfig()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// each invalid setting is reported once, under its key
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		key    string
+		change func(c *Config)
+	}{
+		{"data_dir", func(c *Config) { c.DataDir = "" }},
+		{"buffer_size", func(c *Config) { c.BufferSize = 100 }},
+		{"tls", func(c *Config) { c.TLS.CA = "ca.pem" }},
+		{"log.level", func(c *Config) { c.Log.Level = "verbose" }},
+		{"log.levels", func(c *Config) { c.Log.Levels = map[string]string{"file": "loud"} }},
+		{"log.rotate_every", func(c *Config) { c.Log.RotateEvery = time.Second }},
+		{"tracing.exporter", func(c *Config) { c.Tracing.Exporter = "zipkin" }},
+		{"tracing.endpoint", func(c *Config) { c.Tracing.Exporter, c.Tracing.Endpoint = TRACE_OTLP, "collector" }},
+		{"tracing.sample_percent", func(c *Config) { c.Tracing.SamplePercent = 101 }},
+		{"member_service.seeds", func(c *Config) { c.MemberServiceConfig.Seeds = []string{"host:port"} }},
+		{"member_service.tags", func(c *Config) { c.MemberServiceConfig.Tags = map[string]string{"a=b": "c"} }},
+		{"member_service.strategy", func(c *Config) { c.MemberServiceConfig.Strategy = "flood" }},
+		{"member_service.suspect_max_time", func(c *Config) { c.MemberServiceConfig.SuspectMaxTime = time.Second }},
+		{"member_service.detector", func(c *Config) { c.MemberServiceConfig.Detector = "guess" }},
+		{"member_service.join_backoff", func(c *Config) { c.MemberServiceConfig.JoinBackoff = time.Millisecond }},
+		{"member_service.keys", func(c *Config) { c.MemberServiceConfig.Keys = []string{"c2hvcnQ="} }},
+		{"member_service.encrypt", func(c *Config) { c.MemberServiceConfig.Encrypt = true }},
+		{"member_service.probe_timeout", func(c *Config) { c.MemberServiceConfig.ProbeTimeout = time.Second }},
+		{"file_service.replica_num", func(c *Config) { c.FileServiceConfig.ReplicaNum = 0 }},
+		{"file_service.user", func(c *Config) { c.FileServiceConfig.User = "@system" }},
+		{"file_service.admins", func(c *Config) { c.FileServiceConfig.Admins = []string{"*"} }},
+		{"maplejuice_service.task_cpu", func(c *Config) { c.MapleJuiceServiceConfig.TaskCPU = time.Millisecond }},
+		{"raft_service.election_timeout", func(c *Config) { c.RaftServiceConfig.ElectionTimeout = 150 * time.Millisecond }},
+		{"http_service.port", func(c *Config) { c.HTTPServiceConfig.Port = "70000" }},
+		{"http_service.dashboard_addr", func(c *Config) { c.HTTPServiceConfig.DashboardAddr = ":7012" }},
+		{"port", func(c *Config) { c.HTTPServiceConfig.DashboardAddr = "127.0.0.1:" + c.HTTPServiceConfig.Port }},
+		{"port", func(c *Config) { c.RaftServiceConfig.Port = c.FileServiceConfig.Port }},
+	} {
+		c := validConfig()
+		test.change(&c)
+		err := Validate(c)
+		joined, ok := err.(interface{ Unwrap() []error })
+		if !ok || len(joined.Unwrap()) != 1 {
+			t.Errorf("%v: expected a single error, got %v", test.key, err)
+			continue
+		}
+		var configErr *Error
+		if !errors.As(joined.Unwrap()[0], &configErr) || configErr.Key != test.key {
+			t.Errorf("%v: reported as %v", test.key, err)
+		}
+	}
+}
+
+// every problem is reported at once
+func TestValidateAll(t *testing.T) {
+	c := validConfig()
+	c.DataDir = ""
+	c.FileServiceConfig.ReplicaNum = 0
+	c.RaftServiceConfig.SnapshotEntries = 0
+	joined, ok := Validate(c).(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 3 {
+		t.Errorf("expected 3 errors, got %v", joined)
+	}
+}
@@ -0,0 +1,392 @@
+/*
+This package is the web dashboard of a node, a page served at / of its own listener that polls a
+json api of the same node:
+
+	/api/cluster   the members with their states, the master and whether this side has a quorum
+	/api/ring      the nodes of the consistent-hash ring, and the nodes that hold each file
+	/api/storage   the sdfs files stored on each node, with their sizes
+	/api/jobs      the maplejuice jobs of the cluster
+	/api/job       the stage of a job and the state of its tasks, given ?id=
+	/api/task-log  the log of a task, given ?node=&job=&input=
+
+The dashboard asks no one to log in: it shows what the user of the node, file_service.user, may
+see, the files that user may read and the jobs that user scheduled, everything for the nodes and
+admins. It is off unless http_service.dashboard_addr is set, which should be a loopback address
+or one only the operators of the node can reach.
+
+The membership list, the ring and the job records are known to every node. What only one node
+knows, its storage, the progress of the jobs it schedules and the logs of the tasks it runs, is
+asked from that node over the rpc of its file or maplejuice service, on behalf of the same user.
+A node that doesn't answer is shown with the error.
+*/
+package dashboard
+
+import (
+	"better_mp3/app/file_service"
+	"better_mp3/app/logger"
+	"better_mp3/app/maple_juice_service"
+	"better_mp3/app/member_service"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//go:embed web
+var web embed.FS
+
+// how long the dashboard waits for another node
+const fetchTimeout = 3 * time.Second
+
+var dashboardLog = logger.New(logger.ComponentHTTP)
+
+type Dashboard struct {
+	addr   string
+	ms     *member_service.MemberServer
+	fs     *file_service.FileServer
+	mj     *maple_juice_service.MapleJuiceServer
+	user   string // what the dashboard shows is what user may see
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+// NewDashboard prepares the dashboard of a node, to be served at addr by Run
+func NewDashboard(fileServer *file_service.FileServer, mjServer *maple_juice_service.MapleJuiceServer, addr string) *Dashboard {
+	d := &Dashboard{
+		addr: addr,
+		ms:   fileServer.MemberService(),
+		fs:   fileServer,
+		mj:   mjServer,
+		user: fileServer.User(),
+		mux:  http.NewServeMux(),
+	}
+	static, err := fs.Sub(web, "web")
+	if err != nil {
+		panic(err)
+	}
+	d.mux.Handle("/", http.FileServer(http.FS(static)))
+	d.mux.Handle("/api/cluster", api(d.cluster))
+	d.mux.Handle("/api/ring", api(d.ring))
+	d.mux.Handle("/api/storage", api(d.storage))
+	d.mux.Handle("/api/jobs", api(d.jobs))
+	d.mux.Handle("/api/job", api(d.job))
+	d.mux.Handle("/api/task-log", api(d.taskLog))
+	return d
+}
+
+func (d *Dashboard) Run() {
+	listener, err := net.Listen("tcp", d.addr)
+	if err != nil {
+		log.Fatal("Failed to listen on ", d.addr)
+	}
+	d.server = &http.Server{Handler: d.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := d.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			dashboardLog.Error("Dashboard failed:", err)
+		}
+	}()
+	dashboardLog.Info("Dashboard is now running on " + d.addr)
+}
+
+// Stop waits up to timeout for the requests being served, then closes their connections
+func (d *Dashboard) Stop(timeout time.Duration) {
+	if d.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := d.server.Shutdown(ctx); err != nil {
+		_ = d.server.Close()
+	}
+	dashboardLog.Info("Dashboard stopped")
+}
+
+// apiError is an error with the http status it is answered with
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return apiError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+// errorBody is the json of a failed request
+type errorBody struct {
+	Error string
+}
+
+// api answers with the json of what page returns
+func api(page func(*http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := page(r)
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusInternalServerError
+			var known apiError
+			if errors.As(err, &known) {
+				status = known.status
+			}
+			result = errorBody{Error: err.Error()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
+
+type clusterView struct {
+	Self    string
+	Master  string
+	Term    int64
+	Quorum  bool
+	Members []member_service.MemberInfo
+}
+
+func (d *Dashboard) cluster(*http.Request) (interface{}, error) {
+	master, term := d.ms.Leader()
+	return clusterView{
+		Self:    d.ms.SelfAddr,
+		Master:  master,
+		Term:    term,
+		Quorum:  d.ms.HasQuorum(),
+		Members: d.ms.AllMembers(),
+	}, nil
+}
+
+// memberStates returns the state of the latest member at each address
+func (d *Dashboard) memberStates() map[string]string {
+	states := map[string]string{}
+	// members at the same address are sorted by ID, the latest one comes last
+	for _, member := range d.ms.AllMembers() {
+		states[member.Addr] = member.State
+	}
+	return states
+}
+
+type ringNodeView struct {
+	Pos   uint32
+	Node  string
+	State string
+	Files []string
+}
+
+type placementView struct {
+	File     string
+	Replicas []string
+}
+
+type ringView struct {
+	Size  uint32
+	Nodes []ringNodeView
+	Files []placementView
+}
+
+func (d *Dashboard) ring(*http.Request) (interface{}, error) {
+	states := d.memberStates()
+	view := ringView{Size: file_service.RingSize, Nodes: []ringNodeView{}, Files: []placementView{}}
+	replicas := map[string][]string{}
+	for _, node := range d.fs.FileTable.Ring() {
+		state, ok := states[node.ServerAddr]
+		if !ok {
+			state = "unknown"
+		}
+		files := []string{}
+		for _, file := range node.Files {
+			if d.fs.FileTable.Allowed(d.user, file, file_service.PermRead) {
+				files = append(files, file)
+				replicas[file] = append(replicas[file], node.ServerAddr)
+			}
+		}
+		view.Nodes = append(view.Nodes, ringNodeView{Pos: node.Pos, Node: node.ServerAddr, State: state, Files: files})
+	}
+	for file, nodes := range replicas {
+		view.Files = append(view.Files, placementView{File: file, Replicas: nodes})
+	}
+	sort.Slice(view.Files, func(i, j int) bool {
+		return view.Files[i].File < view.Files[j].File
+	})
+	return view, nil
+}
+
+type storageView struct {
+	Node  string
+	Files []file_service.LocalFile
+	Bytes int64
+	Error string `json:",omitempty"`
+}
+
+// nodeStorage returns the files stored on node that the user may read
+func (d *Dashboard) nodeStorage(node string) (storageView, error) {
+	var files []file_service.LocalFile
+	var err error
+	if node == d.ms.SelfAddr {
+		files, err = d.fs.LocalFiles(d.user)
+	} else {
+		files, err = d.fs.RemoteFiles(node, d.user, fetchTimeout)
+	}
+	if err != nil {
+		return storageView{}, err
+	}
+	view := storageView{Node: node, Files: files}
+	for _, file := range files {
+		view.Bytes += file.Size
+	}
+	return view, nil
+}
+
+// storage asks the nodes of the ring and the alive members for their storage, all at once
+func (d *Dashboard) storage(*http.Request) (interface{}, error) {
+	nodes := map[string]bool{}
+	for _, node := range d.fs.FileTable.Ring() {
+		nodes[node.ServerAddr] = true
+	}
+	for _, addr := range d.ms.GetAliveMemberAddrList() {
+		nodes[addr] = true
+	}
+	views := make([]storageView, 0, len(nodes))
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for node := range nodes {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			view, err := d.nodeStorage(node)
+			if err != nil {
+				view = storageView{Node: node, Error: err.Error()}
+			}
+			mux.Lock()
+			views = append(views, view)
+			mux.Unlock()
+		}(node)
+	}
+	wg.Wait()
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Node < views[j].Node
+	})
+	return views, nil
+}
+
+// jobs returns the records of the jobs the user may see, newest first, with the state of the
+// interrupted ones
+func (d *Dashboard) jobs(*http.Request) (interface{}, error) {
+	records := d.mj.Jobs()
+	views := make([]maple_juice_service.JobRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if !d.mj.MayView(d.user, record) {
+			continue
+		}
+		record.State = d.mj.JobState(record)
+		views = append(views, record)
+	}
+	return views, nil
+}
+
+type jobView struct {
+	Job      maple_juice_service.JobRecord
+	Progress *maple_juice_service.JobProgress `json:",omitempty"`
+	Error    string                           `json:",omitempty"` // why the progress is missing
+}
+
+// job returns the record of a job and its progress, as kept by the node that scheduled it
+func (d *Dashboard) job(r *http.Request) (interface{}, error) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil, badRequest("missing job id")
+	}
+	var view jobView
+	found := false
+	for _, record := range d.mj.Jobs() {
+		if record.ID == id && d.mj.MayView(d.user, record) {
+			record.State = d.mj.JobState(record)
+			view.Job, found = record, true
+		}
+	}
+	if !found {
+		return nil, notFound("no job %v", id)
+	}
+
+	var progress maple_juice_service.JobProgress
+	var err error
+	if view.Job.Master == d.ms.SelfAddr {
+		var ok bool
+		if progress, ok = d.mj.Progress(d.user, id); !ok {
+			err = notFound("%v keeps no progress of job %v", d.ms.SelfAddr, id)
+		}
+	} else {
+		progress, err = d.mj.RemoteProgress(view.Job.Master, d.user, id, fetchTimeout)
+	}
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		view.Progress = &progress
+	}
+	return view, nil
+}
+
+type taskLogView struct {
+	Node  string
+	Lines []string
+}
+
+func (d *Dashboard) isAlive(node string) bool {
+	for _, addr := range d.ms.GetAliveMemberAddrList() {
+		if addr == node {
+			return true
+		}
+	}
+	return false
+}
+
+// taskLog returns the log of a task from the node that ran it
+func (d *Dashboard) taskLog(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	node, job, input := query.Get("node"), query.Get("job"), query.Get("input")
+	if node == "" {
+		return nil, badRequest("missing node")
+	}
+	if input == "" {
+		return nil, badRequest("missing task input")
+	}
+	// only members are asked, the dashboard connects to no host a client names
+	if !d.isAlive(node) {
+		return nil, notFound("%v is not an alive member", node)
+	}
+	var lines []string
+	var err error
+	if node == d.ms.SelfAddr {
+		var ok bool
+		if lines, ok = d.mj.TaskLog(d.user, job, input); !ok {
+			err = notFound("%v keeps no log of task %v of job %v", d.ms.SelfAddr, input, job)
+		}
+	} else {
+		lines, err = d.mj.RemoteTaskLog(node, d.user, job, input, fetchTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return taskLogView{Node: node, Lines: lines}, nil
+}
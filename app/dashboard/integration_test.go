@@ -0,0 +1,215 @@
+//go:build integration
+
+package dashboard_test
+
+import (
+	"better_mp3/app/file_service"
+	"better_mp3/app/harness"
+	"better_mp3/app/maple_juice_service"
+	"better_mp3/app/member_service"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestDashboard(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkDashboard)
+}
+
+// dashboardGet reads a page of the dashboard of node, decoding its json into result unless it is
+// nil, and returns the http status
+func dashboardGet(c *harness.Cluster, node *harness.Node, page string, result interface{}) (int, error) {
+	return get("http://"+node.Config.HTTPServiceConfig.DashboardAddr+page, result)
+}
+
+func get(url string, result interface{}) (int, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if result == nil || response.StatusCode != http.StatusOK {
+		_, err = io.Copy(ioutil.Discard, response.Body)
+		return response.StatusCode, err
+	}
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(result)
+}
+
+// the dashboard of a node that is neither the master nor the scheduler of a job shows the whole
+// cluster: the members and their states, where each file is placed on the ring and stored, and
+// the tasks of the job with the logs the workers kept of them. Files its user may not read are
+// left out.
+func checkDashboard(c *harness.Cluster) error {
+	master, viewer := c.Nodes[0], c.Nodes[1]
+	if err := c.PutFrom(master, "shown"); err != nil {
+		return err
+	}
+	local := filepath.Join(c.Dir, "hidden.txt")
+	if err := ioutil.WriteFile(local, []byte("hidden\n"), 0644); err != nil {
+		return err
+	}
+	if err := master.File.RemotePut("alice", local, "hidden"); err != nil {
+		return err
+	}
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	if err := master.MapleJuice.ScheduleMapleTask([]string{"maple", "maple_wordcount", "3", "shown", "words"}); err != nil {
+		return err
+	}
+
+	for _, page := range []string{"/", "/app.js", "/style.css"} {
+		if status, err := dashboardGet(c, viewer, page, nil); err != nil || status != http.StatusOK {
+			return fmt.Errorf("%v of the dashboard answered %v: %v", page, status, err)
+		}
+	}
+
+	var cluster struct {
+		Self    string
+		Members []member_service.MemberInfo
+	}
+	if _, err := dashboardGet(c, viewer, "/api/cluster", &cluster); err != nil {
+		return err
+	}
+	if cluster.Self != viewer.Addr || len(cluster.Members) != len(c.Nodes) {
+		return fmt.Errorf("the dashboard of %v shows %v as itself and %v members", viewer.Addr, cluster.Self, len(cluster.Members))
+	}
+	for _, member := range cluster.Members {
+		if member.State != "alive" {
+			return fmt.Errorf("the dashboard shows %v as %v", member.Addr, member.State)
+		}
+	}
+
+	var ring struct {
+		Nodes []struct{ Node string }
+		Files []struct {
+			File     string
+			Replicas []string
+		}
+	}
+	if _, err := dashboardGet(c, viewer, "/api/ring", &ring); err != nil {
+		return err
+	}
+	if len(ring.Nodes) != len(c.Nodes) {
+		return fmt.Errorf("the dashboard shows %v nodes on the ring", len(ring.Nodes))
+	}
+	var replicas []string
+	for _, placement := range ring.Files {
+		if placement.File == "shown" {
+			replicas = placement.Replicas
+		}
+		if placement.File == "hidden" {
+			return fmt.Errorf("the dashboard places a file of alice on %v", placement.Replicas)
+		}
+	}
+	locations := viewer.File.FileTable.ListLocations("shown")
+	sort.Strings(replicas)
+	sort.Strings(locations)
+	if fmt.Sprint(replicas) != fmt.Sprint(locations) || len(replicas) != c.Config.FileServiceConfig.ReplicaNum {
+		return fmt.Errorf("the dashboard places shown on %v", replicas)
+	}
+
+	var storage []struct {
+		Node  string
+		Files []file_service.LocalFile
+		Error string
+	}
+	if _, err := dashboardGet(c, viewer, "/api/storage", &storage); err != nil {
+		return err
+	}
+	var stored []string
+	for _, node := range storage {
+		if node.Error != "" {
+			return fmt.Errorf("the storage of %v is missing: %v", node.Node, node.Error)
+		}
+		for _, file := range node.Files {
+			if file.Name == "shown" && file.Size == int64(len("shown\n")) {
+				stored = append(stored, node.Node)
+			}
+			if file.Name == "hidden" {
+				return fmt.Errorf("the dashboard shows a file of alice stored on %v", node.Node)
+			}
+		}
+	}
+	if len(storage) != len(c.Nodes) || fmt.Sprint(stored) != fmt.Sprint(replicas) {
+		return fmt.Errorf("the dashboard shows the storage of %v nodes, shown stored on %v", len(storage), stored)
+	}
+
+	var jobs []maple_juice_service.JobRecord
+	if _, err := dashboardGet(c, viewer, "/api/jobs", &jobs); err != nil {
+		return err
+	}
+	if len(jobs) == 0 || jobs[0].Kind != "maple" || jobs[0].State != maple_juice_service.JobDone {
+		return fmt.Errorf("the dashboard shows the jobs %+v", jobs)
+	}
+	var job struct {
+		Progress *maple_juice_service.JobProgress
+		Error    string
+	}
+	if _, err := dashboardGet(c, viewer, "/api/job?id="+url.QueryEscape(jobs[0].ID), &job); err != nil {
+		return err
+	}
+	if job.Progress == nil || len(job.Progress.Tasks) != 3 {
+		return fmt.Errorf("the dashboard shows no tasks of job %v: %+v", jobs[0].ID, job)
+	}
+	for _, task := range job.Progress.Tasks {
+		if task.State != maple_juice_service.TaskDone || task.Node == "" || task.Attempts != 1 {
+			return fmt.Errorf("the dashboard shows task %+v", task)
+		}
+		var log struct{ Lines []string }
+		query := url.Values{"node": {task.Node}, "job": {jobs[0].ID}, "input": {task.Input}}
+		if _, err := dashboardGet(c, viewer, "/api/task-log?"+query.Encode(), &log); err != nil {
+			return err
+		}
+		if len(log.Lines) == 0 || !strings.Contains(log.Lines[len(log.Lines)-1], "Successfully finished maple task") {
+			return fmt.Errorf("the log of task %v on %v is %q", task.Input, task.Node, log.Lines)
+		}
+	}
+	if status, _ := dashboardGet(c, viewer, "/api/job", nil); status != http.StatusBadRequest {
+		return fmt.Errorf("a job without id answered %v", status)
+	}
+	if status, _ := dashboardGet(c, viewer, "/api/job?id=none", nil); status != http.StatusNotFound {
+		return fmt.Errorf("an unknown job answered %v", status)
+	}
+	query := url.Values{"node": {"127.0.0.1:1"}, "job": {jobs[0].ID}, "input": {"x"}}
+	if status, _ := dashboardGet(c, viewer, "/api/task-log?"+query.Encode(), nil); status != http.StatusNotFound {
+		return fmt.Errorf("the log of a task on a node that is no member answered %v", status)
+	}
+
+	// the http endpoint other nodes reach serves the metrics only
+	endpoint, err := c.HTTPEndpoint(viewer)
+	if err != nil {
+		return err
+	}
+	for _, page := range []string{"/", "/api/storage", "/api/node/storage", "/api/node/task-log"} {
+		if status, _ := get("http://"+endpoint+page, nil); status != http.StatusNotFound {
+			return fmt.Errorf("%v of the http endpoint answered %v", page, status)
+		}
+	}
+
+	// a crashed node is shown failed until it is removed from the list
+	crashed := c.Nodes[3]
+	c.Crash(3)
+	return c.WaitFor("the dashboard to show the crash", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		if _, err := dashboardGet(c, viewer, "/api/cluster", &cluster); err != nil {
+			return false
+		}
+		for _, member := range cluster.Members {
+			if member.Addr == crashed.Addr {
+				return member.State == "failed"
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,301 @@
+// The dashboard polls the json api of the node that serves it, see dashboard.go.
+"use strict";
+
+const POLL_MS = 2000;
+const STORAGE_POLL_MS = 6000;
+const SVG = "http://www.w3.org/2000/svg";
+
+const state = {
+  ring: null,
+  selectedNode: "",
+  selectedFile: "",
+  selectedJob: "",
+  selectedTask: "", // its input, the log is read from the node that ran it last
+  lastStorage: 0,
+  openStorage: new Set(), // the nodes whose files are listed
+};
+
+// el builds an element, attributes starting with "on" are listeners
+function el(tag, attributes, ...children) {
+  const node = tag.startsWith("svg:") ? document.createElementNS(SVG, tag.slice(4)) : document.createElement(tag);
+  for (const [key, value] of Object.entries(attributes || {})) {
+    if (key.startsWith("on")) {
+      node.addEventListener(key.slice(2), value);
+    } else if (value !== undefined && value !== null && value !== false) {
+      node.setAttribute(key, value);
+    }
+  }
+  for (const child of children.flat()) {
+    if (child !== undefined && child !== null) {
+      node.append(child instanceof Node ? child : String(child));
+    }
+  }
+  return node;
+}
+
+function badge(value) {
+  return el("span", {class: "state state-" + value}, value);
+}
+
+function fill(id, rows) {
+  document.getElementById(id).replaceChildren(...rows);
+}
+
+async function getJSON(path) {
+  const response = await fetch(path, {cache: "no-store"});
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.Error || response.statusText);
+  }
+  return body;
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+function isSet(time) {
+  return time && !time.startsWith("0001-");
+}
+
+function formatTime(time) {
+  return isSet(time) ? new Date(time).toLocaleTimeString() : "";
+}
+
+// took is the time from start to end, or until now while running
+function took(start, end) {
+  if (!isSet(start)) {
+    return "";
+  }
+  const ms = (isSet(end) ? new Date(end) : new Date()) - new Date(start);
+  return ms < 1000 ? ms + "ms" : (ms / 1000).toFixed(1) + "s";
+}
+
+// fnv32a hashes a name as the file service does to place it on the ring
+function fnv32a(text) {
+  let hash = 0x811c9dc5;
+  for (const byte of new TextEncoder().encode(text)) {
+    hash ^= byte;
+    hash = Math.imul(hash, 0x01000193) >>> 0;
+  }
+  return hash;
+}
+
+function ringPoint(pos, size, radius) {
+  const angle = (pos / size) * 2 * Math.PI - Math.PI / 2;
+  return [radius * Math.cos(angle), radius * Math.sin(angle)];
+}
+
+async function refreshCluster() {
+  const cluster = await getJSON("api/cluster");
+  document.getElementById("self").textContent = cluster.Self;
+  const alive = cluster.Members.filter(m => m.State === "alive").length;
+  document.getElementById("cluster-summary").textContent =
+    `${alive} of ${cluster.Members.length} members alive · master ${cluster.Master || "none"} in term ${cluster.Term}` +
+    (cluster.Quorum ? "" : " · no quorum, this node is read-only");
+  fill("members", cluster.Members.map(member => el("tr", {},
+    el("td", {title: member.ID}, member.Addr, member.Addr === cluster.Master ? " ★" : ""),
+    el("td", {}, badge(member.State)),
+    el("td", {}, member.Incarnation),
+    el("td", {}, member.Heartbeat),
+    el("td", {}, Object.entries(member.Tags || {}).map(([k, v]) => k + "=" + v).join(", ")),
+    el("td", {class: "muted"}, Object.entries(member.Endpoints || {}).map(([k, v]) => k + " " + v).join(", ")))));
+}
+
+async function refreshRing() {
+  state.ring = await getJSON("api/ring");
+  renderRing();
+}
+
+function renderRing() {
+  const ring = state.ring;
+  const selectedReplicas = new Set();
+  if (state.selectedFile) {
+    const placement = ring.Files.find(f => f.File === state.selectedFile);
+    (placement ? placement.Replicas : []).forEach(node => selectedReplicas.add(node));
+  }
+
+  const shapes = [el("svg:circle", {class: "track", r: 100})];
+  for (const placement of ring.Files) {
+    const [x, y] = ringPoint(fnv32a(placement.File) % ring.Size, ring.Size, 100);
+    const selected = placement.File === state.selectedFile;
+    shapes.push(el("svg:circle", {class: "file", cx: x, cy: y, r: selected ? 3.5 : 1.8},
+      el("svg:title", {}, placement.File)));
+  }
+  for (const node of ring.Nodes) {
+    const [x, y] = ringPoint(node.Pos, ring.Size, 100);
+    const [lx, ly] = ringPoint(node.Pos, ring.Size, 116);
+    const selected = node.Node === state.selectedNode || selectedReplicas.has(node.Node);
+    const color = {alive: "#2e7d32", suspect: "#b26a00", failed: "#b3261e"}[node.State] || "#777";
+    shapes.push(el("svg:g", {class: "node" + (selected ? " selected" : ""), onclick: () => selectNode(node.Node)},
+      el("svg:title", {}, `${node.Node} (${node.State}) at ${node.Pos}, ${node.Files.length} files`),
+      el("svg:circle", {cx: x, cy: y, r: 6, fill: color}),
+      el("svg:text", {x: lx, y: ly, "text-anchor": "middle", "dominant-baseline": "middle"}, node.Node)));
+  }
+  document.getElementById("ring").replaceChildren(...shapes);
+
+  const selection = document.getElementById("ring-selection");
+  const node = ring.Nodes.find(n => n.Node === state.selectedNode);
+  if (node) {
+    selection.textContent = `${node.Node} holds ${node.Files.length} files at position ${node.Pos}`;
+  } else if (state.selectedFile) {
+    selection.textContent = `${state.selectedFile} is replicated on ${[...selectedReplicas].join(", ") || "no node"}`;
+  }
+
+  const filter = document.getElementById("file-filter").value;
+  const shown = ring.Files.filter(placement =>
+    (!filter || placement.File.includes(filter)) && (!node || placement.Replicas.includes(node.Node)));
+  fill("placement", shown.map(placement => el("tr", {
+    class: "clickable" + (placement.File === state.selectedFile ? " selected" : ""),
+    onclick: () => selectFile(placement.File),
+  }, el("td", {}, placement.File), el("td", {}, placement.Replicas.join(", ")))));
+}
+
+function selectNode(node) {
+  state.selectedNode = state.selectedNode === node ? "" : node;
+  state.selectedFile = "";
+  renderRing();
+}
+
+function selectFile(file) {
+  state.selectedFile = state.selectedFile === file ? "" : file;
+  state.selectedNode = "";
+  renderRing();
+}
+
+async function refreshStorage() {
+  const nodes = await getJSON("api/storage");
+  const largest = Math.max(1, ...nodes.map(node => node.Bytes));
+  fill("storage", nodes.map(node => el("tr", {},
+    el("td", {}, node.Node),
+    node.Error ? el("td", {class: "error", colspan: 3}, node.Error) : [
+      el("td", {}, el("details", {
+        open: state.openStorage.has(node.Node),
+        ontoggle: event => event.target.open ? state.openStorage.add(node.Node) : state.openStorage.delete(node.Node),
+      },
+        el("summary", {}, node.Files.length + " files"),
+        el("table", {}, node.Files.map(file => el("tr", {},
+          el("td", {}, file.Name), el("td", {}, formatBytes(file.Size)), el("td", {class: "muted"}, formatTime(file.Modified))))))),
+      el("td", {}, formatBytes(node.Bytes)),
+      el("td", {}, el("div", {class: "bar"}, el("div", {style: `width: ${100 * node.Bytes / largest}%`}))),
+    ])));
+}
+
+async function refreshJobs() {
+  const jobs = await getJSON("api/jobs");
+  fill("jobs", jobs.map(job => el("tr", {
+    class: "clickable" + (job.ID === state.selectedJob ? " selected" : ""),
+    onclick: () => selectJob(job.ID),
+  },
+    el("td", {class: "muted"}, job.ID),
+    el("td", {}, [job.Kind, ...job.Args].join(" ")),
+    el("td", {}, job.Master),
+    el("td", {}, job.User),
+    el("td", {title: job.Error || ""}, badge(job.State)),
+    el("td", {}, formatTime(job.Started)),
+    el("td", {}, took(job.Started, job.Finished)))));
+  document.getElementById("job").hidden = !state.selectedJob;
+  if (state.selectedJob) {
+    await refreshJob();
+  }
+}
+
+function selectJob(id) {
+  state.selectedJob = state.selectedJob === id ? "" : id;
+  state.selectedTask = "";
+  document.getElementById("task-log").hidden = true;
+  refresh();
+}
+
+async function refreshJob() {
+  const view = await getJSON("api/job?id=" + encodeURIComponent(state.selectedJob));
+  const job = view.Job;
+  document.getElementById("job").hidden = false;
+  document.getElementById("job-title").textContent = `${job.Kind} ${job.Args.join(" ")}`;
+
+  const tasks = view.Progress ? view.Progress.Tasks || [] : [];
+  const done = tasks.filter(task => task.State === "done").length;
+  const summary = [badge(job.State), ` ${done} of ${tasks.length} tasks done`];
+  if (view.Progress && view.Progress.Stage) {
+    summary.push(`, ${job.State === "running" ? "in" : "last"} stage ${view.Progress.Stage}`);
+  }
+  if (job.Error) {
+    summary.push(el("div", {class: "error"}, job.Error));
+  }
+  if (view.Error) {
+    summary.push(el("div", {class: "muted"}, "No task progress: " + view.Error));
+  }
+  document.getElementById("job-summary").replaceChildren(...summary);
+  document.getElementById("job-bar").style.width = (tasks.length ? 100 * done / tasks.length : 0) + "%";
+
+  fill("tasks", tasks.map(task => el("tr", {
+    class: "clickable" + (task.Input === state.selectedTask ? " selected" : ""),
+    onclick: () => selectTask(task.Input),
+  },
+    el("td", {}, task.Input),
+    el("td", {}, task.Node),
+    el("td", {}, badge(task.State)),
+    el("td", {}, task.Attempts),
+    el("td", {}, took(task.Started, task.Finished)),
+    el("td", {class: "error"}, task.Error || ""))));
+  const task = tasks.find(task => task.Input === state.selectedTask);
+  if (task) {
+    await refreshTaskLog(task.Node, task.Input);
+  }
+}
+
+function selectTask(input) {
+  state.selectedTask = state.selectedTask === input ? "" : input;
+  document.getElementById("task-log").hidden = !state.selectedTask;
+  refresh();
+}
+
+async function refreshTaskLog(node, input) {
+  document.getElementById("task-log-title").textContent = `Log of ${input} on ${node}`;
+  const lines = document.getElementById("task-log-lines");
+  try {
+    const log = await getJSON("api/task-log?" + new URLSearchParams({node, job: state.selectedJob, input}));
+    const atBottom = lines.scrollTop + lines.clientHeight >= lines.scrollHeight - 4;
+    lines.textContent = log.Lines.join("\n") || "(empty)";
+    if (atBottom) {
+      lines.scrollTop = lines.scrollHeight;
+    }
+  } catch (err) {
+    lines.textContent = err.message;
+  }
+}
+
+let refreshing = false;
+
+async function refresh() {
+  if (refreshing) {
+    return;
+  }
+  refreshing = true;
+  const status = document.getElementById("status");
+  try {
+    const pending = [refreshCluster(), refreshRing(), refreshJobs()];
+    if (Date.now() - state.lastStorage >= STORAGE_POLL_MS) {
+      state.lastStorage = Date.now();
+      pending.push(refreshStorage());
+    }
+    await Promise.all(pending);
+    status.textContent = "updated " + new Date().toLocaleTimeString();
+    status.className = "muted";
+  } catch (err) {
+    status.textContent = "update failed: " + err.message;
+    status.className = "error";
+  } finally {
+    refreshing = false;
+  }
+}
+
+document.getElementById("file-filter").addEventListener("input", () => state.ring && renderRing());
+refresh();
+setInterval(refresh, POLL_MS);
@@ -0,0 +1,345 @@
+package file_service
+
+/*
+Every request to sdfs is made by a user, file_service.user of the node it comes through. Files are
+owned by the user who put them first, and access to a file is governed by its own acl and by the
+acls of the prefixes it starts with, written with a trailing '*' such as "logs/*". A user may do
+something to a file if one of these acls allows it: the owner of an acl may do everything, other
+users what they are granted, where the user '*' stands for everyone. A file no acl covers is open
+to everyone, as before acls existed. The users listed in file_service.admins may do everything.
+
+An acl is changed by those with the admin permission on it. A file nobody owns yet may be claimed
+by anyone, who could use it anyway, but a prefix nobody covers yet is claimed by admins only, so
+that no user locks the others out of a part of the namespace.
+
+The acls are part of the file table, so every node enforces the same ones, both on the node a
+request comes through and on the nodes holding the replicas. Users are asserted by the nodes: only
+they call the rpcs of the services, so a user is only accepted from a peer authenticated as a
+node, with tls by a certificate of the cluster, without tls by the host of a member. Names
+reserved for the nodes other than the system user are never accepted.
+*/
+
+import (
+	"better_mp3/app/secure_rpc"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	PermDelete
+	PermAdmin
+)
+
+const PermAll = PermRead | PermWrite | PermDelete | PermAdmin
+
+// the letters of the permissions, in the order of their bits
+const permLetters = "rwda"
+
+// Everyone is the user a grant to all users is made to
+const Everyone = "*"
+
+// SystemUser is the user of requests the nodes make on their own, such as re-replication
+const SystemUser = "@system"
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ACL is the acl of a file or, with a trailing '*', of every file starting with the prefix
+type ACL struct {
+	Path   string
+	Owner  string
+	Grants map[string]Permission `json:",omitempty"` // by user, Everyone for all users
+}
+
+// ParsePermission reads letters of "rwda", e.g. "rw". "-" and "" are no permission.
+func ParsePermission(s string) (Permission, error) {
+	var perm Permission
+	for _, letter := range s {
+		if letter == '-' {
+			continue
+		}
+		i := strings.IndexRune(permLetters, letter)
+		if i < 0 {
+			return 0, fmt.Errorf("invalid permission %q, expected letters of %q", letter, permLetters)
+		}
+		perm |= 1 << i
+	}
+	return perm, nil
+}
+
+func (p Permission) String() string {
+	letters := []byte("----")
+	for i := range permLetters {
+		if p&(1<<i) != 0 {
+			letters[i] = permLetters[i]
+		}
+	}
+	return string(letters)
+}
+
+func isPrefixPath(path string) bool {
+	return strings.HasSuffix(path, "*")
+}
+
+// covers tells whether the acl applies to a file
+func (a ACL) covers(fileName string) bool {
+	if isPrefixPath(a.Path) {
+		return strings.HasPrefix(fileName, strings.TrimSuffix(a.Path, "*"))
+	}
+	return a.Path == fileName
+}
+
+// coversPath tells whether the acl applies to a file or to every file of a prefix
+func (a ACL) coversPath(path string) bool {
+	if !isPrefixPath(path) {
+		return a.covers(path)
+	}
+	return a.Path == path || isPrefixPath(a.Path) && a.covers(strings.TrimSuffix(path, "*"))
+}
+
+// permits tells whether the acl allows user perm, the admin permission implies all others
+func (a ACL) permits(user string, perm Permission) bool {
+	if user == a.Owner {
+		return true
+	}
+	granted := a.Grants[user] | a.Grants[Everyone]
+	return granted&PermAdmin != 0 || granted&perm == perm
+}
+
+func (a ACL) String() string {
+	grantees := make([]string, 0, len(a.Grants))
+	for user := range a.Grants {
+		grantees = append(grantees, user)
+	}
+	sort.Strings(grantees)
+	grants := make([]string, 0, len(grantees))
+	for _, user := range grantees {
+		grants = append(grants, user+":"+a.Grants[user].String())
+	}
+	return fmt.Sprintf("%v owner %v %v", a.Path, a.Owner, strings.Join(grants, " "))
+}
+
+// validatePath checks the name of a file, or of a prefix with a trailing '*'. "*" covers all files.
+func validatePath(path string) error {
+	if path == "*" {
+		return nil
+	}
+	if !isPrefixPath(path) {
+		return ValidateName(path)
+	}
+	prefix := strings.TrimSuffix(strings.TrimSuffix(path, "*"), "/")
+	if err := ValidateName(prefix); err != nil {
+		return fmt.Errorf("invalid acl prefix %q: %w", path, err)
+	}
+	return nil
+}
+
+// validUser rejects empty names and those reserved for the nodes
+func validUser(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "@") && !strings.ContainsAny(name, " \t\n,:")
+}
+
+// Allowed tells whether user may do perm to a file
+func (t *FileTable) Allowed(user string, fileName string, perm Permission) bool {
+	if user == SystemUser || t.fileServer.isAdmin(user) {
+		return true
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	covered := false
+	for _, acl := range t.acls {
+		if acl.covers(fileName) {
+			if acl.permits(user, perm) {
+				return true
+			}
+			covered = true
+		}
+	}
+	return !covered
+}
+
+// mayAdminister tells whether user may change the acl of a file or prefix
+func (t *FileTable) mayAdminister(user string, path string) bool {
+	if t.fileServer.isAdmin(user) {
+		return true
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	covered := false
+	for _, acl := range t.acls {
+		if acl.coversPath(path) {
+			if acl.permits(user, PermAdmin) {
+				return true
+			}
+			covered = true
+		}
+	}
+	return !covered && !isPrefixPath(path)
+}
+
+// ACLs returns the acls that apply to a file or prefix, ordered by path
+func (t *FileTable) ACLs(path string) []ACL {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	var acls []ACL
+	for _, acl := range t.acls {
+		if acl.coversPath(path) {
+			acls = append(acls, acl)
+		}
+	}
+	sort.Slice(acls, func(i, j int) bool {
+		return acls[i].Path < acls[j].Path
+	})
+	return acls
+}
+
+// applyACL applies an acl command, the caller holds t.mux
+func (t *FileTable) applyACL(c metadataCommand) {
+	acl, found := t.acls[c.FileName]
+	switch c.Op {
+	case opPut:
+		// the first user to put a file owns it
+		if found || c.User == "" || c.User == SystemUser {
+			return
+		}
+		acl = ACL{Path: c.FileName, Owner: c.User}
+	case opDelete, opRemoveACL:
+		delete(t.acls, c.FileName)
+		return
+	case opGrant, opOwner:
+		if !found {
+			acl = ACL{Path: c.FileName, Owner: c.User}
+		}
+		if c.Op == opOwner {
+			acl.Owner = c.Grantee
+			break
+		}
+		grants := make(map[string]Permission, len(acl.Grants)+1)
+		for user, perm := range acl.Grants {
+			grants[user] = perm
+		}
+		if c.Perm == 0 {
+			delete(grants, c.Grantee)
+		} else {
+			grants[c.Grantee] = c.Perm
+		}
+		acl.Grants = grants
+	}
+	t.acls[c.FileName] = acl
+}
+
+// User is the user that requests made through this node act as
+func (fs *FileServer) User() string {
+	return fs.config.User
+}
+
+// IsPrivileged tells whether user may see and do anything: the nodes, which act as SystemUser,
+// and the admins
+func (fs *FileServer) IsPrivileged(user string) bool {
+	return user == SystemUser || fs.isAdmin(user)
+}
+
+func (fs *FileServer) isAdmin(user string) bool {
+	for _, admin := range fs.config.Admins {
+		if admin == user {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNode tells whether the peer of an rpc connection is a node of the cluster: with tls one
+// presenting a certificate of the cluster authority, which the listener requires, without tls
+// one on the host of a member
+func (fs *FileServer) IsNode(peer secure_rpc.Peer) bool {
+	if fs.credentials != nil {
+		return peer.Name != ""
+	}
+	if peer.Addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(peer.Addr.String())
+	if err != nil {
+		return false
+	}
+	for _, addr := range fs.ms.GetAliveMemberAddrList() {
+		if memberHost, _, err := net.SplitHostPort(addr); err == nil && memberHost == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate fails with ErrPermissionDenied unless the peer of an rpc may act as user
+func (fs *FileServer) Authenticate(peer secure_rpc.Peer, user string) error {
+	if !fs.IsNode(peer) {
+		return fmt.Errorf("%w: %v is not a node, it may not act as %q", ErrPermissionDenied, peer.Addr, user)
+	}
+	if user != SystemUser && !fs.isAdmin(user) && !validUser(user) {
+		return fmt.Errorf("%w: invalid or reserved user %q", ErrPermissionDenied, user)
+	}
+	return nil
+}
+
+// authorize fails with ErrPermissionDenied unless user may do perm to a file
+func (fs *FileServer) authorize(user string, fileName string, perm Permission) error {
+	if fs.FileTable.Allowed(user, fileName, perm) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q lacks %v on %v", ErrPermissionDenied, user, perm, fileName)
+}
+
+// isDenied tells whether a peer answered ErrPermissionDenied, rpc errors only keep their message
+func isDenied(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), ErrPermissionDenied.Error())
+}
+
+// Grant sets the permissions of grantee on a file or prefix, no permission revokes the grant.
+// A path without an acl gets one owned by user.
+func (fs *FileServer) Grant(user string, path string, grantee string, perm Permission) error {
+	if grantee != Everyone && !validUser(grantee) {
+		return errors.New("invalid user name " + grantee)
+	}
+	return fs.changeACL(user, metadataCommand{Op: opGrant, FileName: path, User: user, Grantee: grantee, Perm: perm})
+}
+
+// SetOwner hands a file or prefix over to owner
+func (fs *FileServer) SetOwner(user string, path string, owner string) error {
+	if !validUser(owner) {
+		return errors.New("invalid user name " + owner)
+	}
+	return fs.changeACL(user, metadataCommand{Op: opOwner, FileName: path, User: user, Grantee: owner})
+}
+
+// RemoveACL removes the acl of a file or prefix, which leaves it to the acls of shorter prefixes
+func (fs *FileServer) RemoveACL(user string, path string) error {
+	return fs.changeACL(user, metadataCommand{Op: opRemoveACL, FileName: path, User: user})
+}
+
+func (fs *FileServer) changeACL(user string, command metadataCommand) error {
+	if err := fs.begin(); err != nil {
+		return err
+	}
+	defer fs.tasks.Done()
+	if err := fs.Writable(); err != nil {
+		return err
+	}
+	if err := validatePath(command.FileName); err != nil {
+		return err
+	}
+	// the acls of files put through other nodes may not have been applied here yet
+	if err := fs.raft.Sync(); err != nil {
+		return err
+	}
+	if !fs.FileTable.mayAdminister(user, command.FileName) {
+		return fmt.Errorf("%w: %q may not change the acl of %v", ErrPermissionDenied, user, command.FileName)
+	}
+	return fs.proposeMetadata(context.Background(), command)
+}
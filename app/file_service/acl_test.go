@@ -0,0 +1,187 @@
+package file_service
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/member_service"
+	"better_mp3/app/secure_rpc"
+	"errors"
+	"net"
+	"testing"
+)
+
+// newACLTable returns a file table with the acls applied, where "admin" is an admin
+func newACLTable(commands ...metadataCommand) *FileTable {
+	t := &FileTable{
+		fileServer: &FileServer{config: config.FileServiceConfig{Admins: []string{"admin"}}},
+		acls:       map[string]ACL{},
+	}
+	for _, c := range commands {
+		t.applyACL(c)
+	}
+	return t
+}
+
+func TestParsePermission(t *testing.T) {
+	for s, expected := range map[string]Permission{
+		"":     0,
+		"-":    0,
+		"r":    PermRead,
+		"rw":   PermRead | PermWrite,
+		"wr":   PermRead | PermWrite,
+		"r-d-": PermRead | PermDelete,
+		"rwda": PermAll,
+	} {
+		perm, err := ParsePermission(s)
+		if err != nil || perm != expected {
+			t.Errorf("%q parsed as %v with %v, expected %v", s, perm, err, expected)
+		}
+	}
+	if _, err := ParsePermission("rx"); err == nil {
+		t.Error("an unknown letter was parsed")
+	}
+	if s := (PermRead | PermDelete).String(); s != "r-d-" {
+		t.Errorf("read and delete print as %q", s)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	table := newACLTable(
+		metadataCommand{Op: opPut, FileName: "alice.txt", User: "alice"},
+		metadataCommand{Op: opGrant, FileName: "alice.txt", User: "alice", Grantee: "bob", Perm: PermRead},
+		metadataCommand{Op: opGrant, FileName: "logs/*", User: "admin", Grantee: Everyone, Perm: PermRead},
+		metadataCommand{Op: opGrant, FileName: "logs/*", User: "admin", Grantee: "carol", Perm: PermAdmin},
+		// the system puts no file on behalf of anyone
+		metadataCommand{Op: opPut, FileName: "replicated.txt", User: SystemUser},
+	)
+	for _, test := range []struct {
+		user     string
+		fileName string
+		perm     Permission
+		expected bool
+	}{
+		{"alice", "alice.txt", PermAll, true},
+		{"bob", "alice.txt", PermRead, true},
+		{"bob", "alice.txt", PermWrite, false},
+		{"bob", "alice.txt", PermRead | PermWrite, false},
+		{"carol", "alice.txt", PermRead, false},
+		{"admin", "alice.txt", PermDelete, true},
+		// prefixes cover the files starting with them, "*" everyone
+		{"bob", "logs/today", PermRead, true},
+		{"bob", "logs/today", PermWrite, false},
+		{"admin", "logs/today", PermWrite, true},
+		// the admin permission implies the others
+		{"carol", "logs/today", PermDelete, true},
+		{"bob", "logsfile", PermWrite, true},
+		// files no acl covers are open
+		{"bob", "free.txt", PermAll, true},
+		{"bob", "replicated.txt", PermWrite, true},
+	} {
+		if got := table.Allowed(test.user, test.fileName, test.perm); got != test.expected {
+			t.Errorf("%v %v on %v: allowed %v, expected %v", test.user, test.perm, test.fileName, got, test.expected)
+		}
+	}
+}
+
+func TestMayAdminister(t *testing.T) {
+	table := newACLTable(
+		metadataCommand{Op: opPut, FileName: "alice.txt", User: "alice"},
+		metadataCommand{Op: opGrant, FileName: "logs/*", User: "admin", Grantee: "carol", Perm: PermAdmin},
+	)
+	for _, test := range []struct {
+		user     string
+		path     string
+		expected bool
+	}{
+		{"alice", "alice.txt", true},
+		{"bob", "alice.txt", false},
+		{"admin", "alice.txt", true},
+		// a file nobody owns is claimed by anyone, a prefix by admins only
+		{"bob", "free.txt", true},
+		{"bob", "free/*", false},
+		{"admin", "free/*", true},
+		// those who administer a prefix administer its files and longer prefixes
+		{"carol", "logs/*", true},
+		{"carol", "logs/today", true},
+		{"carol", "logs/old/*", true},
+		{"bob", "logs/today", false},
+	} {
+		if got := table.mayAdminister(test.user, test.path); got != test.expected {
+			t.Errorf("%v administering %v: %v, expected %v", test.user, test.path, got, test.expected)
+		}
+	}
+}
+
+func TestApplyACL(t *testing.T) {
+	table := newACLTable(
+		metadataCommand{Op: opPut, FileName: "f", User: "alice"},
+		// the first put owns the file
+		metadataCommand{Op: opPut, FileName: "f", User: "bob"},
+		metadataCommand{Op: opGrant, FileName: "f", User: "alice", Grantee: "bob", Perm: PermRead | PermWrite},
+		metadataCommand{Op: opGrant, FileName: "f", User: "alice", Grantee: "carol", Perm: PermRead},
+		// no permission revokes a grant
+		metadataCommand{Op: opGrant, FileName: "f", User: "alice", Grantee: "carol", Perm: 0},
+	)
+	acl := table.acls["f"]
+	if acl.Owner != "alice" || len(acl.Grants) != 1 || acl.Grants["bob"] != PermRead|PermWrite {
+		t.Fatalf("unexpected acl %v", acl)
+	}
+	if s := acl.String(); s != "f owner alice bob:rw--" {
+		t.Errorf("acl prints as %q", s)
+	}
+
+	table.applyACL(metadataCommand{Op: opOwner, FileName: "f", User: "alice", Grantee: "bob"})
+	if owner := table.acls["f"].Owner; owner != "bob" {
+		t.Errorf("owner %v after handing the file to bob", owner)
+	}
+	table.applyACL(metadataCommand{Op: opDelete, FileName: "f"})
+	if _, found := table.acls["f"]; found {
+		t.Error("the acl of a deleted file is kept")
+	}
+}
+
+// users are only accepted from nodes, reserved names other than the system user never
+func TestAuthenticate(t *testing.T) {
+	fs := &FileServer{
+		config: config.FileServiceConfig{Admins: []string{"admin"}},
+		ms: member_service.NewMemberServerWithConfig(config.MemberServiceConfig{
+			Host:       "10.0.0.1",
+			Port:       "7008",
+			Introducer: "10.0.0.1:7008",
+			Strategy:   config.STRAT_ALL,
+		}),
+	}
+	member := secure_rpc.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}}
+	outsider := secure_rpc.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 40000}}
+	for _, test := range []struct {
+		peer     secure_rpc.Peer
+		user     string
+		expected bool
+	}{
+		{member, "alice", true},
+		{outsider, "alice", false},
+		{member, SystemUser, true},
+		{outsider, SystemUser, false},
+		{member, "admin", true},
+		{outsider, "admin", false},
+		{member, "@other", false},
+		{member, "", false},
+		{member, "a,b", false},
+	} {
+		err := fs.Authenticate(test.peer, test.user)
+		if (err == nil) != test.expected || err != nil && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%q from %v: %v", test.user, test.peer.Addr, err)
+		}
+	}
+
+	// with tls, nodes are those presenting a certificate of the cluster
+	fs.credentials = &secure_rpc.Credentials{}
+	if err := fs.Authenticate(outsider, "alice"); err == nil {
+		t.Error("a user was accepted from a peer without a certificate")
+	}
+	outsider.Name = "node-7008"
+	for _, user := range []string{SystemUser, "alice"} {
+		if err := fs.Authenticate(outsider, user); err != nil {
+			t.Errorf("%q was refused from a node with a certificate: %v", user, err)
+		}
+	}
+}
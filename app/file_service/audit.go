@@ -0,0 +1,92 @@
+package file_service
+
+/*
+Every node appends the requests it handles to its audit log: the get, put, delete and append
+requests made through it, the reads and writes of replicas other nodes ask it for, the copies it
+makes when re-replicating, and the maple and juice jobs and tasks it runs. An entry names the user
+of the request, which is the user of the node it came through, also on the nodes holding the
+replicas.
+
+QueryAudit collects the entries of all alive nodes. Admins may see every entry, other users only
+their own.
+*/
+
+import (
+	"better_mp3/app/audit"
+	"better_mp3/app/tracing"
+	"context"
+	"fmt"
+	"sort"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditArgs asks a node for the entries of its audit log that match Filter
+type AuditArgs struct {
+	User   string
+	Filter audit.Filter
+	Trace  tracing.Carrier
+}
+
+// localAudit returns the entries of the audit log of this node that user may see
+func (fs *FileServer) localAudit(user string, filter audit.Filter) ([]audit.Entry, error) {
+	if !fs.isAdmin(user) {
+		if filter.User != "" && filter.User != user {
+			return nil, fmt.Errorf("%w: %q may only see its own audit entries", ErrPermissionDenied, user)
+		}
+		filter.User = user
+	}
+	return fs.audit.Query(filter)
+}
+
+// QueryAudit returns the matching entries of the audit logs of all alive nodes, oldest first.
+// Nodes that don't answer are reported along with the entries of the others.
+func (fs *FileServer) QueryAudit(user string, filter audit.Filter) (entries []audit.Entry, err error) {
+	ctx, span := fs.tracer.Start(context.Background(), "sdfs.audit")
+	defer tracing.End(span, &err)
+	if err := fs.begin(); err != nil {
+		return nil, err
+	}
+	defer fs.tasks.Done()
+
+	var failed []string
+	for _, member := range fs.ms.Members() {
+		var found []audit.Entry
+		var err error
+		if member.Addr == fs.ms.SelfAddr {
+			found, err = fs.localAudit(user, filter)
+		} else {
+			err = fs.callAudit(ctx, member.Addr, AuditArgs{User: user, Filter: filter}, &found)
+		}
+		if isDenied(err) {
+			return nil, err
+		}
+		if err != nil {
+			failed = append(failed, member.Addr+": "+err.Error())
+			continue
+		}
+		entries = append(entries, found...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	if len(failed) > 0 {
+		return entries, fmt.Errorf("no audit entries from %v", failed)
+	}
+	return entries, nil
+}
+
+func (fs *FileServer) callAudit(ctx context.Context, nodeAddr string, args AuditArgs, entries *[]audit.Entry) (err error) {
+	client, err := fs.credentials.Dial(fs.rpcAddr(nodeAddr))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	var span trace.Span
+	args.Trace, span = tracing.StartCall(ctx, fs.tracer, "FileRPCServer.Audit", nodeAddr)
+	defer tracing.End(span, &err)
+	return client.Call("FileRPCServer.Audit", args, entries)
+}
@@ -0,0 +1,93 @@
+package file_service
+
+import (
+	"better_mp3/app/audit"
+	"better_mp3/app/command"
+	"better_mp3/app/logger"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandleACL shows and changes acls as the user of this node:
+// acl <path> | grant <path> <user> <rwda> | revoke <path> <user> | owner <path> <user> | remove <path>
+func (fs *FileServer) HandleACL(command command.Command) error {
+	usage := errors.New("usage: acl <path> | acl grant <path> <user> <rwda> | acl revoke <path> <user> | " +
+		"acl owner <path> <user> | acl remove <path>")
+	params := command.Params
+	switch {
+	case len(params) == 1:
+		acls := fs.FileTable.ACLs(params[0])
+		if len(acls) == 0 {
+			logger.PrintToConsole(params[0], "is covered by no acl, everyone may access it")
+			return nil
+		}
+		lines := make([]string, 0, len(acls))
+		for _, acl := range acls {
+			lines = append(lines, acl.String())
+		}
+		logger.PrintToConsole(strings.Join(lines, "\n"))
+	case len(params) == 4 && params[0] == "grant":
+		perm, err := ParsePermission(params[3])
+		if err != nil {
+			return err
+		}
+		return fs.Grant(fs.User(), params[1], params[2], perm)
+	case len(params) == 3 && params[0] == "revoke":
+		return fs.Grant(fs.User(), params[1], params[2], 0)
+	case len(params) == 3 && params[0] == "owner":
+		return fs.SetOwner(fs.User(), params[1], params[2])
+	case len(params) == 2 && params[0] == "remove":
+		return fs.RemoveACL(fs.User(), params[1])
+	default:
+		return usage
+	}
+	return nil
+}
+
+// HandleAudit shows the audit entries of the cluster that the user of this node may see:
+// audit [user=<user>] [op=<op>] [file=<substring>] [since=<duration>] [limit=<n>]
+func (fs *FileServer) HandleAudit(command command.Command) error {
+	usage := errors.New("usage: audit [user=<user>] [op=<op>] [file=<substring>] [since=<duration>] [limit=<n>]")
+	filter := audit.Filter{Limit: 50}
+	for _, param := range command.Params {
+		pair := strings.SplitN(param, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			return usage
+		}
+		value := pair[1]
+		switch pair[0] {
+		case "user":
+			filter.User = value
+		case "op":
+			filter.Op = value
+		case "file":
+			filter.Arg = value
+		case "since":
+			age, err := time.ParseDuration(value)
+			if err != nil {
+				return usage
+			}
+			filter.Since = time.Now().Add(-age)
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return usage
+			}
+			filter.Limit = limit
+		default:
+			return usage
+		}
+	}
+	entries, err := fs.QueryAudit(fs.User(), filter)
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no audit entries")
+	}
+	logger.PrintToConsole(strings.Join(lines, "\n"))
+	return err
+}
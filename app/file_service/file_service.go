@@ -1,66 +1,252 @@
 package file_service
 
 import (
+	"better_mp3/app/audit"
 	"better_mp3/app/config"
 	"better_mp3/app/logger"
 	"better_mp3/app/member_service"
+	"better_mp3/app/raft_service"
+	"better_mp3/app/secure_rpc"
+	"better_mp3/app/tracing"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/rpc"
+	"net"
 	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var promptChannel = make(chan string)
-var MyHash uint32
+var fileLog = logger.New(logger.ComponentFile)
+
+var ErrShuttingDown = errors.New("file service is shutting down")
+
+// ErrStaleTerm rejects requests of a node that missed an election
+var ErrStaleTerm = errors.New("request from a stale election term")
+
+// ErrReadOnly rejects changes on a node cut off from the majority of the cluster
+var ErrReadOnly = errors.New("read-only: this node can't reach a majority of the cluster")
+
+// ErrNoQuorum fails a change that reached fewer than a majority of the replicas of a file
+var ErrNoQuorum = errors.New("too few replicas reached")
+
+// ErrEmptyRing fails the operations that need a node to place a file or run a task on before the
+// first configuration entry of raft has put any node on the ring
+var ErrEmptyRing = errors.New("no node is on the ring yet")
 
 type FileServer struct {
 	ms        *member_service.MemberServer
-	FileTable FileTable
+	raft      *raft_service.RaftServer
+	FileTable *FileTable
 	config    config.FileServiceConfig
+
+	credentials *secure_rpc.Credentials
+	audit       *audit.Log
+	metrics     *fileMetrics
+	tracer      trace.Tracer
+	listener    net.Listener
+	mux      sync.Mutex
+	closing  bool
+	tasks    sync.WaitGroup
+
+	// the newest election term seen in a request
+	fence int64
 }
 
 type FileTask struct {
 	FileName string
 	Content []byte
+	Term     int64
+	User     string
+	Trace    tracing.Carrier
+}
+
+// EntryArgs names the file of a request that reads it or changes its replicas or the file table
+type EntryArgs struct {
+	FileName string
+	Term     int64
+	User     string
+	Trace    tracing.Carrier
 }
 
-func NewFileServer(memberService *member_service.MemberServer) *FileServer {
+func NewFileServer(memberService *member_service.MemberServer, raftServer *raft_service.RaftServer) *FileServer {
+	return NewFileServerWithConfig(memberService, raftServer, config.GetFileServiceConfig())
+}
+
+// NewFileServerWithConfig doesn't read the global config, so that several
+// file servers can run in one process. The file table is registered with raftServer.
+func NewFileServerWithConfig(memberService *member_service.MemberServer, raftServer *raft_service.RaftServer, fileConfig config.FileServiceConfig) *FileServer {
 	var fs FileServer
-	fs.config = config.GetFileServiceConfig()
+	fs.config = fileConfig
+	if fs.config.User == "" {
+		fs.config.User = config.DetectUser()
+	}
+	credentials, err := secure_rpc.Load(fileConfig.TLS)
+	if err != nil {
+		log.Fatal("Failed to load the tls credentials: ", err)
+	}
+	fs.credentials = credentials
+	fs.audit, err = audit.Open(fileConfig.AuditLog, memberService.SelfAddr)
+	if err != nil {
+		log.Fatal("Failed to open the audit log: ", err)
+	}
+	fs.metrics = newFileMetrics(memberService.SelfAddr)
+	fs.tracer = tracing.Tracer(memberService.SelfAddr)
 	fs.ms = memberService
+	fs.raft = raftServer
 	fs.FileTable = NewFileTable(&fs)
-	go fs.RunDaemon()
+	raftServer.Register(metadataMachine, fs.FileTable)
 	return &fs
 }
 
 func (fs *FileServer) Run() {
-	go RunRPCServer(fs)
-	logger.PrintInfo(
+	RunRPCServer(fs)
+	fs.ms.AdvertiseEndpoint(member_service.EndpointFile, fs.config.Port)
+	fileLog.Info(
 		"File Service is now running on port " + fs.config.Port,
 		"\n\tSDFS file path: ", fs.config.Path)
 }
 
-func (fs *FileServer) LocalReplicate(filename string, success *bool) error {
+// Stop refuses new file operations, waits for running ones and closes the rpc listener
+func (fs *FileServer) Stop(timeout time.Duration) {
+	fs.mux.Lock()
+	fs.closing = true
+	fs.mux.Unlock()
+
+	if !waitTimeout(&fs.tasks, timeout) {
+		fileLog.Warn("File service stopped with operations still running")
+	}
+	if fs.listener != nil {
+		_ = fs.listener.Close()
+	}
+	fileLog.Info("File service stopped")
+}
+
+// SelfAddr is the address of the node this file server runs on
+func (fs *FileServer) SelfAddr() string {
+	return fs.ms.SelfAddr
+}
+
+// MemberService is the member service of the node this file server runs on
+func (fs *FileServer) MemberService() *member_service.MemberServer {
+	return fs.ms
+}
+
+// rpcAddr returns the address of the file service of another node
+func (fs *FileServer) rpcAddr(nodeAddr string) string {
+	return fs.ms.ServiceAddr(nodeAddr, member_service.EndpointFile, fs.config.Port)
+}
+
+// Term is the election term this node stamps its requests with
+func (fs *FileServer) Term() int64 {
+	return fs.ms.Term()
+}
+
+// Writable fails while this node is on the minority side of a partition, which is read-only
+// until it heals
+func (fs *FileServer) Writable() error {
+	if !fs.ms.HasQuorum() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// Fence rejects a request stamped with an older term than the newest one seen, so that a master
+// deposed by an election, or any node that missed it, can no longer change files
+func (fs *FileServer) Fence(term int64) error {
+	if err := fs.Writable(); err != nil {
+		return err
+	}
+	local := fs.ms.Term()
+
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if local > fs.fence {
+		fs.fence = local
+	}
+	if term < fs.fence {
+		return ErrStaleTerm
+	}
+	fs.fence = term
+	return nil
+}
+
+// isStale tells whether a peer answered ErrStaleTerm, rpc errors only keep their message
+func isStale(err error) bool {
+	return err != nil && err.Error() == ErrStaleTerm.Error()
+}
+
+// checkQuorum fails an operation on a file that fewer than a majority of its replicas
+// acknowledged: done of replicas succeeded, lastErr is the last failure of a replica
+func checkQuorum(op string, fileName string, done int, replicas int, lastErr error) error {
+	if done >= replicas/2+1 {
+		return nil
+	}
+	err := fmt.Errorf("%w: %v of %v reached %v of %v replicas", ErrNoQuorum, op, fileName, done, replicas)
+	if lastErr != nil {
+		err = fmt.Errorf("%w, last error: %v", err, lastErr)
+	}
+	return err
+}
+
+// locate lists the nodes holding a file. A file missing from the table may have been put through
+// another node, whose change hasn't reached this node yet.
+func (fs *FileServer) locate(sdfs string) []string {
+	locations := fs.FileTable.ListLocations(sdfs)
+	if len(locations) > 0 {
+		return locations
+	}
+	if err := fs.raft.Sync(); err != nil {
+		fileLog.Warn("Failed to catch up with the file table:", err)
+		return locations
+	}
+	return fs.FileTable.ListLocations(sdfs)
+}
+
+// begin registers a file operation, it fails once Stop has been called
+func (fs *FileServer) begin() error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if fs.closing {
+		return ErrShuttingDown
+	}
+	fs.tasks.Add(1)
+	return nil
+}
+
+// LocalReplicate copies a file to this node from a node holding it
+func (fs *FileServer) LocalReplicate(ctx context.Context, filename string, success *bool) (err error) {
+	defer fs.audit.Start(SystemUser, "replicate", filename).End(&err)
+	ctx, span := fs.tracer.Start(ctx, "sdfs.replicate", trace.WithAttributes(attribute.String("file", filename)))
+	defer tracing.End(span, &err)
 	var content []byte
-	locations := fs.FileTable.ListLocations(filename)
+	locations := fs.locate(filename)
 	if len(locations) == 0 {
 		return errors.New("no replica available")
 	} else {
-		for _, ip := range locations {
+		for _, addr := range locations {
 			var buffer []byte
-			if ip == fs.ms.SelfIP {
+			if addr == fs.ms.SelfAddr {
 				err := fs.LocalGet(filename, &buffer)
 				if err != nil {
 					continue
 				}
 			} else {
-				client, err := rpc.Dial("tcp", ip+":"+fs.config.Port)
+				client, err := fs.credentials.Dial(fs.rpcAddr(addr))
 				if err != nil {
 					continue
 				}
-				err = client.Call("FileRPCServer.LocalGet", filename, &buffer)
+				defer client.Close()
+				carrier, span := tracing.StartCall(ctx, fs.tracer, "FileRPCServer.LocalGet", addr)
+				err = client.Call("FileRPCServer.LocalGet", EntryArgs{FileName: filename, User: SystemUser, Trace: carrier}, &buffer)
+				tracing.End(span, &err)
 				if err != nil {
 					continue
 				}
@@ -74,6 +260,10 @@ func (fs *FileServer) LocalReplicate(filename string, success *bool) error {
 			FileName: filename,
 			Content: content,
 		}, nil)
+		if err == nil {
+			fs.metrics.replicatedFiles.Inc()
+			fs.metrics.replicatedBytes.Add(float64(len(content)))
+		}
 		return err
 	} else {
 		return nil
@@ -81,12 +271,12 @@ func (fs *FileServer) LocalReplicate(filename string, success *bool) error {
 }
 
 func (fs *FileServer) LocalPut(task FileTask, success *bool) error {
-	err := ioutil.WriteFile(fs.config.Path + task.FileName, task.Content, os.ModePerm)
+	err := ioutil.WriteFile(fs.localPath(task.FileName), task.Content, os.ModePerm)
 	return err
 }
 
 func (fs *FileServer) LocalAppend(task FileTask, success *bool) error {
-	f, err := os.OpenFile(fs.config.Path + task.FileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(fs.localPath(task.FileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -97,177 +287,361 @@ func (fs *FileServer) LocalAppend(task FileTask, success *bool) error {
 	return err
 }
 
+// user: the user putting the file
 // local: local file name
 // remote: remote file name
-func (fs *FileServer) RemotePut(local string, remote string) {
-	target_ips := fs.FileTable.search(remote)
-	//fmt.Println(target_ips)
-	for _, ip := range target_ips {
-		content, err := ioutil.ReadFile(local)
-		if err != nil {
-			fmt.Println("Local file", local, "doesn't exist!")
-			return
-		} else {
-			client, err := rpc.Dial("tcp", ip+":"+fs.config.Port)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			var success bool
-			err = client.Call("FileRPCServer.LocalPut",
-				FileTask {
-				FileName: remote,
-				Content:  content,
-				}, &success)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-		}
+func (fs *FileServer) RemotePut(user string, local string, remote string) error {
+	return fs.RemotePutContext(context.Background(), user, local, remote)
+}
+
+// RemotePutContext is RemotePut as part of the trace of ctx
+func (fs *FileServer) RemotePutContext(ctx context.Context, user string, local string, remote string) (err error) {
+	defer fs.audit.Start(user, "put", remote, local).End(&err)
+	defer fs.observe("put", time.Now(), &err)
+	ctx, span := fs.tracer.Start(ctx, "sdfs.put", trace.WithAttributes(attribute.String("file", remote), attribute.String("user", user)))
+	defer tracing.End(span, &err)
+	if err := fs.begin(); err != nil {
+		return err
 	}
-	err := fs.FileTable.PutEntry(remote, nil)
-	if err != nil {
-		log.Println(err)
+	defer fs.tasks.Done()
+	if err := fs.Writable(); err != nil {
+		return err
+	}
+	if err := ValidateName(remote); err != nil {
+		return err
+	}
+	// catches up with the file table if the file is new here, so that its owner is known
+	fs.locate(remote)
+	if err := fs.authorize(user, remote, PermWrite); err != nil {
+		return err
 	}
 
-	for _, memberIP := range fs.ms.GetAliveMemberIPList() {
-		client, err := rpc.Dial("tcp", memberIP+":"+fs.config.Port)
+	content, err := ioutil.ReadFile(local)
+	if err != nil {
+		return fmt.Errorf("local file %v doesn't exist", local)
+	}
+	term := fs.Term()
+	targetAddrs, err := fs.FileTable.search(remote)
+	if err != nil {
+		return err
+	}
+	//fmt.Println(targetAddrs)
+	stored := 0
+	var lastErr error
+	for _, addr := range targetAddrs {
+		client, err := fs.credentials.Dial(fs.rpcAddr(addr))
 		if err != nil {
-			log.Println(err)
+			fileLog.Warn(err)
+			lastErr = err
 			continue
 		}
-		err = client.Call("FileRPCServer.PutEntry", remote, nil)
+		defer client.Close()
+		var success bool
+		carrier, span := tracing.StartCall(ctx, fs.tracer, "FileRPCServer.LocalPut", addr)
+		err = client.Call("FileRPCServer.LocalPut",
+			FileTask {
+			FileName: remote,
+			Content:  content,
+			Term:     term,
+			User:     user,
+			Trace:    carrier,
+			}, &success)
+		tracing.End(span, &err)
+		if isStale(err) {
+			return ErrStaleTerm
+		}
+		if isDenied(err) {
+			return err
+		}
 		if err != nil {
-			log.Println(err)
+			fileLog.Warn(err)
+			lastErr = err
 			continue
 		}
+		stored++
+		fs.metrics.bytes.WithLabelValues("put").Add(float64(len(content)))
+	}
+	// the file is only recorded once a majority of its replicas hold it
+	if err := checkQuorum("put", remote, stored, len(targetAddrs), lastErr); err != nil {
+		return err
 	}
+	return fs.proposeMetadata(ctx, metadataCommand{Op: opPut, FileName: remote, User: user})
 }
 
 func (fs *FileServer) LocalGet(filename string, content *[]byte) error {
 	var err error
-	*content, err = ioutil.ReadFile(fs.config.Path + filename)
+	*content, err = ioutil.ReadFile(fs.localPath(filename))
 	return err
 }
 
-func (fs *FileServer) RemoteGet(sdfs string, local string) {
-	locations := fs.FileTable.ListLocations(sdfs)
+// LocalFile is a file of sdfs stored on this node
+type LocalFile struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// ListArgs asks a node for the files of sdfs it stores that User may read
+type ListArgs struct {
+	User string
+}
+
+// LocalFiles returns the files of sdfs stored on this node that user may read, sorted by name
+func (fs *FileServer) LocalFiles(user string) ([]LocalFile, error) {
+	entries, err := ioutil.ReadDir(fs.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]LocalFile, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		name, err := DecodeName(entry.Name())
+		if err != nil || !fs.FileTable.Allowed(user, name, PermRead) {
+			continue
+		}
+		files = append(files, LocalFile{Name: name, Size: entry.Size(), Modified: entry.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// RemoteFiles returns the files of sdfs stored on another node that user may read, failing
+// unless the node answers within timeout
+func (fs *FileServer) RemoteFiles(nodeAddr string, user string, timeout time.Duration) ([]LocalFile, error) {
+	var files []LocalFile
+	err := fs.credentials.Call(fs.rpcAddr(nodeAddr), "FileRPCServer.LocalFiles", ListArgs{User: user}, &files, timeout)
+	return files, err
+}
+
+func (fs *FileServer) RemoteGet(user string, sdfs string, local string) error {
+	return fs.RemoteGetContext(context.Background(), user, sdfs, local)
+}
+
+// RemoteGetContext is RemoteGet as part of the trace of ctx
+func (fs *FileServer) RemoteGetContext(ctx context.Context, user string, sdfs string, local string) (err error) {
+	defer fs.audit.Start(user, "get", sdfs, local).End(&err)
+	defer fs.observe("get", time.Now(), &err)
+	ctx, span := fs.tracer.Start(ctx, "sdfs.get", trace.WithAttributes(attribute.String("file", sdfs), attribute.String("user", user)))
+	defer tracing.End(span, &err)
+	if err := fs.begin(); err != nil {
+		return err
+	}
+	defer fs.tasks.Done()
+	if err := ValidateName(sdfs); err != nil {
+		return err
+	}
+
+	locations := fs.locate(sdfs)
+	if err := fs.authorize(user, sdfs, PermRead); err != nil {
+		return err
+	}
 	if len(locations) == 0 {
-		fmt.Println("The file is not available!")
+		return errors.New("the file is not available")
 	} else {
-		for _, ip := range locations {
+		for _, addr := range locations {
 			var buffer []byte
-			if ip == fs.ms.SelfIP {
+			if addr == fs.ms.SelfAddr {
 				err := fs.LocalGet(sdfs, &buffer)
 				if err != nil {
 					continue
 				}
 			} else {
-				client, err := rpc.Dial("tcp", ip+":"+fs.config.Port)
+				client, err := fs.credentials.Dial(fs.rpcAddr(addr))
 				if err != nil {
 					continue
 				}
-				err = client.Call("FileRPCServer.LocalGet", sdfs, &buffer)
+				defer client.Close()
+				carrier, span := tracing.StartCall(ctx, fs.tracer, "FileRPCServer.LocalGet", addr)
+				err = client.Call("FileRPCServer.LocalGet", EntryArgs{FileName: sdfs, User: user, Trace: carrier}, &buffer)
+				tracing.End(span, &err)
+				if isDenied(err) {
+					return err
+				}
 				if err != nil {
 					continue
 				}
 			}
+			fs.metrics.bytes.WithLabelValues("get").Add(float64(len(buffer)))
 			err := ioutil.WriteFile(local, buffer, os.ModePerm)
 			if err != nil {
 				continue
 			}
-			break
+			return nil
 		}
 	}
+	return errors.New("no replica of " + sdfs + " could be fetched")
 }
 
+// LocalDelete removes the replica of a file, a replica that is already gone counts as removed
 func (fs *FileServer) LocalDelete(filename string, success *bool) error {
-	err := os.Remove(fs.config.Path + filename)
+	err := os.Remove(fs.localPath(filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
 	return err
 }
 
-func (fs *FileServer) RemoteDelete(sdfs string) {
-	locations := fs.FileTable.ListLocations(sdfs)
+func (fs *FileServer) RemoteDelete(user string, sdfs string) error {
+	return fs.RemoteDeleteContext(context.Background(), user, sdfs)
+}
+
+// RemoteDeleteContext is RemoteDelete as part of the trace of ctx
+func (fs *FileServer) RemoteDeleteContext(ctx context.Context, user string, sdfs string) (err error) {
+	defer fs.audit.Start(user, "delete", sdfs).End(&err)
+	defer fs.observe("delete", time.Now(), &err)
+	ctx, span := fs.tracer.Start(ctx, "sdfs.delete", trace.WithAttributes(attribute.String("file", sdfs), attribute.String("user", user)))
+	defer tracing.End(span, &err)
+	if err := fs.begin(); err != nil {
+		return err
+	}
+	defer fs.tasks.Done()
+	if err := fs.Writable(); err != nil {
+		return err
+	}
+	if err := ValidateName(sdfs); err != nil {
+		return err
+	}
+
+	term := fs.Term()
+	locations := fs.locate(sdfs)
+	if err := fs.authorize(user, sdfs, PermDelete); err != nil {
+		return err
+	}
 	if len(locations) == 0 {
-		fmt.Println("The file is not available!")
+		return errors.New("the file is not available")
 	} else {
 		//fmt.Println(locations)
 		var success bool
-		for _, ip := range locations {
-			if ip == fs.ms.SelfIP {
+		deleted := 0
+		var lastErr error
+		for _, addr := range locations {
+			if addr == fs.ms.SelfAddr {
 				err := fs.LocalDelete(sdfs, &success)
 				if err != nil {
-					log.Println(err)
+					fileLog.Warn(err)
+					lastErr = err
+					continue
 				}
 			} else {
-				client, err := rpc.Dial("tcp", ip+":"+fs.config.Port)
+				client, err := fs.credentials.Dial(fs.rpcAddr(addr))
 				if err != nil {
-					log.Println(err)
+					fileLog.Warn(err)
+					lastErr = err
 					continue
 				}
-				err = client.Call("FileRPCServer.LocalDelete", sdfs, &success)
+				defer client.Close()
+				carrier, span := tracing.StartCall(ctx, fs.tracer, "FileRPCServer.LocalDelete", addr)
+				err = client.Call("FileRPCServer.LocalDelete", EntryArgs{FileName: sdfs, Term: term, User: user, Trace: carrier}, &success)
+				tracing.End(span, &err)
+				if isStale(err) {
+					return ErrStaleTerm
+				}
+				if isDenied(err) {
+					return err
+				}
 				if err != nil {
-					log.Println(err)
+					fileLog.Warn(err)
+					lastErr = err
 					continue
 				}
 			}
+			deleted++
 		}
-		err := fs.FileTable.DeleteEntry(sdfs, &success)
-		if err != nil {
-			log.Println(err)
-		}
-		for _, memberIP := range fs.ms.GetAliveMemberIPList() {
-			client, err := rpc.Dial("tcp", memberIP+":"+fs.config.Port)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			err = client.Call("FileRPCServer.DeleteEntry", sdfs, &success)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+		// the file stays in the table unless a majority of its replicas are gone, so the
+		// delete can be retried
+		if err := checkQuorum("delete", sdfs, deleted, len(locations), lastErr); err != nil {
+			return err
 		}
+		return fs.proposeMetadata(ctx, metadataCommand{Op: opDelete, FileName: sdfs, User: user})
 	}
 }
 
-func (fs *FileServer) RemoteAppend(content []byte, remoteFileName string) {
-	target_ips := fs.FileTable.search(remoteFileName)
-	//fmt.Println(target_ips)
-	for _, ip := range target_ips {
-		client, err := rpc.Dial("tcp", ip+":"+fs.config.Port)
+// RemoteAppend appends content to an sdfs file, creating it if needed
+func (fs *FileServer) RemoteAppend(user string, content []byte, remoteFileName string) error {
+	return fs.RemoteAppendContext(context.Background(), user, content, remoteFileName)
+}
+
+// RemoteAppendContext is RemoteAppend as part of the trace of ctx
+func (fs *FileServer) RemoteAppendContext(ctx context.Context, user string, content []byte, remoteFileName string) error {
+	err := fs.remoteAppend(ctx, user, content, remoteFileName)
+	if err != nil {
+		fileLog.Error("Append to", remoteFileName, "failed:", err)
+	}
+	return err
+}
+
+func (fs *FileServer) remoteAppend(ctx context.Context, user string, content []byte, remoteFileName string) (err error) {
+	defer fs.audit.Start(user, "append", remoteFileName, strconv.Itoa(len(content))+" bytes").End(&err)
+	defer fs.observe("append", time.Now(), &err)
+	ctx, span := fs.tracer.Start(ctx, "sdfs.append", trace.WithAttributes(attribute.String("file", remoteFileName),
+		attribute.String("user", user), attribute.Int("bytes", len(content))))
+	defer tracing.End(span, &err)
+	if err := fs.begin(); err != nil {
+		return err
+	}
+	defer fs.tasks.Done()
+	if err := fs.Writable(); err != nil {
+		return err
+	}
+	if err := ValidateName(remoteFileName); err != nil {
+		return err
+	}
+	if err := fs.authorize(user, remoteFileName, PermWrite); err != nil {
+		return err
+	}
+
+	term := fs.Term()
+	targetAddrs, err := fs.FileTable.search(remoteFileName)
+	if err != nil {
+		return err
+	}
+	//fmt.Println(targetAddrs)
+	appended := 0
+	var lastErr error
+	for _, addr := range targetAddrs {
+		client, err := fs.credentials.Dial(fs.rpcAddr(addr))
 		if err != nil {
-			log.Println(err)
+			fileLog.Warn(err)
+			lastErr = err
 			continue
 		}
+		defer client.Close()
 		var success bool
+		carrier, span := tracing.StartCall(ctx, fs.tracer, "FileRPCServer.LocalAppend", addr)
 		err = client.Call(
 			"FileRPCServer.LocalAppend",
 			FileTask {
 				FileName: remoteFileName,
 				Content:  content,
+				Term:     term,
+				User:     user,
+				Trace:    carrier,
 			}, &success)
+		tracing.End(span, &err)
+		if isStale(err) || isDenied(err) {
+			return err
+		}
 		if err != nil {
-			log.Println(err)
+			fileLog.Warn(err)
+			lastErr = err
 			continue
 		}
+		appended++
+		fs.metrics.bytes.WithLabelValues("append").Add(float64(len(content)))
 	}
-	var success bool
-	err := fs.FileTable.PutEntry(remoteFileName, &success)
-	if err != nil {
-		log.Println(err)
+	if err := checkQuorum("append", remoteFileName, appended, len(targetAddrs), lastErr); err != nil {
+		return err
 	}
-	for _, memberIP := range fs.ms.GetAliveMemberIPList() {
-		client, err := rpc.Dial("tcp", memberIP+ ":" + fs.config.Port)
-		if err != nil {
-			logger.PrintError(err)
-			continue
-		}
-		err = client.Call("FileRPCServer.PutEntry", remoteFileName, &success)
-		if err != nil {
-			logger.PrintError(err)
-			continue
-		}
+	// appends to a file already in the table don't change it
+	if len(fs.FileTable.ListLocations(remoteFileName)) > 0 {
+		return nil
 	}
+	if err := fs.proposeMetadata(ctx, metadataCommand{Op: opPut, FileName: remoteFileName, User: user}); err != nil {
+		return fmt.Errorf("failed to record %v in the file table: %w", remoteFileName, err)
+	}
+	return nil
 }
-
@@ -1,196 +1,173 @@
 package file_service
 
 import (
+	"better_mp3/app/tracing"
+	"context"
 	"fmt"
 	"github.com/emirpasic/gods/maps/treemap"
 	"k8s.io/apimachinery/pkg/util/sets"
-	"log"
-	"net/rpc"
+	"sort"
 	"strings"
-	"time"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type FileTable struct {
 	Storage    treemap.Map //virtual ring
 	fileServer *FileServer
 	latest     map[string]int64
+	myHash     uint32 // position of this node on the ring
+	acls       map[string]ACL // by file name or prefix
+
+	// entries are changed by rpc calls from several nodes at once
+	mux sync.Mutex
 }
 
 type FileTableEntry struct {
-	ServerIP string
+	ServerAddr string
 	files    []string
 }
 
-func NewFileTable(fs *FileServer) FileTable {
+// NewFileTable starts with an empty ring, nodes are added as the raft configuration grows
+func NewFileTable(fs *FileServer) *FileTable {
 	var tb FileTable
 	tb.fileServer = fs
 	tb.Storage = *treemap.NewWith(compare)
-	tb.AddEmptyEntry(fs.ms.SelfIP)
 	tb.latest = map[string]int64{}
-	MyHash = hash(fs.ms.SelfIP)
-	return tb
+	tb.acls = map[string]ACL{}
+	tb.myHash = hash(fs.ms.SelfAddr)
+	return &tb
 }
 
-/*
-	Monitor member list
- */
-func (fs *FileServer) RunDaemon() {
-	for {
-		select {
-			case joinedNode := <- fs.ms.JoinedNodeChan:
-				fs.FileTable.AddEmptyEntry(joinedNode)
-			case <- fs.ms.FailedNodeChan:
-				fs.FileTable.RemoveFromTable(fs.ms.GetFailedMemberIPList())
-		}
+func (t *FileTable) AddEmptyEntry(addr string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	pos := hash(addr)
+	if _, found := t.Storage.Get(pos); !found {
+		t.Storage.Put(pos, FileTableEntry{ServerAddr: addr, files: []string{}})
 	}
 }
 
-func (t *FileTable) AddEmptyEntry(ip string) {
-	pos := hash(ip)
-	t.Storage.Put(pos, FileTableEntry{ServerIP: ip, files: []string{}})
+// remove failed nodes from fileTable, replicate tells whether their files are copied again
+func (t *FileTable) RemoveFromTable(failed []string, replicate bool) {
+	for _, addr := range failed {
+		t.removeNode(addr, failed, replicate)
+	}
 }
 
-// remove failed nodes from fileTable
-func (t *FileTable) RemoveFromTable(failed []string) {
-	for _, ip := range failed {
-		curHash := hash(ip)
-
-		nextAlive := t.findNextAlive(failed, curHash)
-		secAlive := t.findNextAlive(failed, nextAlive)
-		thrAlive := t.findNextAlive(failed, secAlive)
-		forAlive := t.findNextAlive(failed, thrAlive)
-
-		// only nextAlive handles the re-replication
-		if MyHash == nextAlive {
-			//fmt.Println(nextAlive, secAlive, thrAlive, forAlive)
-			n0s, _ := t.Storage.Get(curHash) // failed node
-			n1s, _ := t.Storage.Get(nextAlive)
-			n2s, _ := t.Storage.Get(secAlive)
-			n3s, _ := t.Storage.Get(thrAlive)
-			n4s, _ := t.Storage.Get(forAlive)
-
-			i1 := n1s.(FileTableEntry).ServerIP
-			i2 := n2s.(FileTableEntry).ServerIP
-			i3 := n3s.(FileTableEntry).ServerIP
-			i4 := n4s.(FileTableEntry).ServerIP
-			//fmt.Println(i1, i2, i3, i4)
-
-			f0 := sets.NewString()
-			for _, f := range n0s.(FileTableEntry).files {
-				f0.Insert(f)
-			}
-			f1 := sets.NewString()
-			for _, f := range n1s.(FileTableEntry).files {
-				f1.Insert(f)
-			}
-			f2 := sets.NewString()
-			for _, f := range n2s.(FileTableEntry).files {
-				f2.Insert(f)
-			}
-			f3 := sets.NewString()
-			for _, f := range n3s.(FileTableEntry).files {
-				f3.Insert(f)
-			}
+// removeNode drops a failed node from the ring. The first alive node after it
+// copies its files again so that each of them keeps replica_num replicas.
+func (t *FileTable) removeNode(addr string, failed []string, replicate bool) {
+	replicaNum := t.fileServer.config.ReplicaNum
 
-			tmp1 := f0.Intersection(f1)
-			tmp2 := tmp1.Intersection(f2)
-			to1 := f0.Difference(f1)
-			to2 := tmp1.Difference(f2)
-			to3 := tmp2.Difference(f3)
-			to4 := tmp2.Intersection(f3)
-			//fmt.Println(to1, to2, to3, to4)
-
-			// nextAlive
-			var success bool
-			for filename := range to1 {
-				err := t.fileServer.LocalReplicate(filename, &success)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-			}
+	t.mux.Lock()
+	curHash := hash(addr)
+	n0s, found := t.Storage.Get(curHash) // failed node
+	if !found {
+		// already removed when an earlier failure was handled
+		t.mux.Unlock()
+		return
+	}
+	if t.Storage.Size() == 1 {
+		// no node is left to copy its files
+		t.Storage.Remove(curHash)
+		t.mux.Unlock()
+		return
+	}
 
-			// secAlive
-			port := t.fileServer.config.Port
-			client, err := rpc.Dial("tcp", i2+":"+port)
-			if err != nil {
-				log.Println(err)
-			} else {
-				for filename := range to2 {
-					err = client.Call("FileRPCServer.LocalReplicate", filename, &success)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
-				}
-			}
+	// the replicaNum alive nodes following the failed node on the ring
+	successors := make([]uint32, replicaNum)
+	next := curHash
+	for i := range successors {
+		next = t.findNextAlive(failed, next)
+		successors[i] = next
+	}
 
-			// thrAlive
-			client, err = rpc.Dial("tcp", i3+":"+port)
-			if err != nil {
-				log.Println(err)
-			} else {
-				for filename := range to3 {
-					err = client.Call("FileRPCServer.LocalReplicate", filename, &success)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
-				}
-			}
+	// only nextAlive handles the re-replication
+	if !replicate || t.myHash != successors[0] {
+		t.Storage.Remove(curHash)
+		t.mux.Unlock()
+		return
+	}
 
-			// forAlive
-			client, err = rpc.Dial("tcp", i4+":"+port)
-			if err != nil {
-				log.Println(err)
-			} else {
-				for filename := range to4 {
-					err = client.Call("FileRPCServer.LocalReplicate", filename, &success)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
+	// each file of the failed node goes to the first successor that doesn't hold it yet,
+	// files already held by all the other successors go to the last one
+	toReplicate := map[uint32][]string{}
+	remaining := sets.NewString(n0s.(FileTableEntry).files...)
+	for i, pos := range successors {
+		if i == len(successors)-1 {
+			toReplicate[pos] = append(toReplicate[pos], remaining.List()...)
+			break
+		}
+		ns, _ := t.Storage.Get(pos)
+		held := sets.NewString(ns.(FileTableEntry).files...)
+		toReplicate[pos] = append(toReplicate[pos], remaining.Difference(held).List()...)
+		remaining = remaining.Intersection(held)
+	}
+
+	t.Storage.Remove(curHash)
+	addrs := map[uint32]string{}
+	for _, v := range t.Storage.Values() {
+		addrs[hash(v.(FileTableEntry).ServerAddr)] = v.(FileTableEntry).ServerAddr
+	}
+	t.mux.Unlock()
+
+	// the copies are made outside of the raft applier, which the proposal waits for
+	go t.replicate(toReplicate, addrs)
+}
+
+// replicate copies files to the nodes at their positions and records the new replicas
+func (t *FileTable) replicate(toReplicate map[uint32][]string, addrs map[uint32]string) {
+	var err error
+	ctx, span := t.fileServer.tracer.Start(context.Background(), "sdfs.rereplicate", trace.WithAttributes(attribute.Int("nodes", len(toReplicate))))
+	defer tracing.End(span, &err)
+	term := t.fileServer.Term()
+	var success bool
+	for pos, files := range toReplicate {
+		if pos == t.myHash {
+			for _, filename := range files {
+				err := t.fileServer.LocalReplicate(ctx, filename, &success)
+				if err != nil {
+					fileLog.Warn(err)
+					continue
 				}
 			}
+			continue
+		}
 
-			t.Storage.Remove(curHash)
-
-			//fmt.Println(failed)
-			// inform each member to update fileTable
-			for _, v := range t.Storage.Values() {
-				p := v.(FileTableEntry).ServerIP
-				if !contains(failed, p) {
-					if p == i1 {
-						_ = t.PutRepEntry(map[uint32][]string{
-							nextAlive: to1.List(),
-							secAlive:  to2.List(),
-							thrAlive:  to3.List(),
-							forAlive:  to4.List(),
-						}, &success)
-					} else {
-						client, err := rpc.Dial("tcp", p+":"+port)
-						if err != nil {
-							log.Println(err)
-							continue
-						}
-						err = client.Call("FileRPCServer.PutRepEntry", map[uint32][]string{
-							nextAlive: to1.List(),
-							secAlive:  to2.List(),
-							thrAlive:  to3.List(),
-							forAlive:  to4.List(),
-						}, &success)
-					}
-				}
+		client, err := t.fileServer.credentials.Dial(t.fileServer.rpcAddr(addrs[pos]))
+		if err != nil {
+			fileLog.Warn(err)
+			continue
+		}
+		defer client.Close()
+		for _, filename := range files {
+			carrier, span := tracing.StartCall(ctx, t.fileServer.tracer, "FileRPCServer.LocalReplicate", addrs[pos])
+			err = client.Call("FileRPCServer.LocalReplicate", EntryArgs{FileName: filename, Term: term, User: SystemUser, Trace: carrier}, &success)
+			tracing.End(span, &err)
+			if err != nil {
+				fileLog.Warn(err)
+				continue
 			}
-		} else {
-			t.Storage.Remove(curHash)
 		}
 	}
-}
 
+	err = t.fileServer.proposeMetadata(ctx, metadataCommand{Op: opReplicas, Entries: toReplicate})
+	if err != nil {
+		fileLog.Error("Failed to record re-replicated files:", err)
+	}
+}
 
-func (t *FileTable) PutEntry(sdfs string, success *bool) error {
-	t.latest[sdfs] = time.Now().UnixNano()
+// PutEntry places a file put at putTime, in unix nanoseconds, on the ring
+func (t *FileTable) PutEntry(sdfs string, putTime int64, success *bool) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.Storage.Empty() {
+		return ErrEmptyRing
+	}
+	t.latest[sdfs] = putTime
 	floorKey, _ := t.Storage.Floor(hash(sdfs))
 
 	if floorKey == nil {
@@ -200,7 +177,7 @@ func (t *FileTable) PutEntry(sdfs string, success *bool) error {
 	//fmt.Println(hash(sdfs))
 	//fmt.Println(floorKey)
 	next := floorKey
-	for i := 0; i < 4; i++ {
+	for i := 0; i < t.fileServer.config.ReplicaNum; i++ {
 		next, _ = t.Storage.Ceiling(next)
 		if next == nil {
 			f, _ := t.Storage.Min()
@@ -221,6 +198,8 @@ func (t *FileTable) PutEntry(sdfs string, success *bool) error {
 }
 
 func (t *FileTable) DeleteEntry(sdfs string, success *bool) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	for _, k := range t.Storage.Keys() {
 		s, found := t.Storage.Get(k)
 		if found {
@@ -228,7 +207,7 @@ func (t *FileTable) DeleteEntry(sdfs string, success *bool) error {
 			for i, file := range tmp.files {
 				if file == sdfs {
 					tmp.files = append(tmp.files[:i], tmp.files[i+1:]...)
-					fmt.Println("File entry for", sdfs, "deleted from", tmp.ServerIP)
+					fileLog.Debug("File entry for", sdfs, "deleted from", tmp.ServerAddr)
 				}
 			}
 			t.Storage.Put(k, tmp)
@@ -237,8 +216,13 @@ func (t *FileTable) DeleteEntry(sdfs string, success *bool) error {
 	return nil
 }
 
-// search for ips that has file
-func (t *FileTable) search(sdfs string) []string {
+// search for addrs that has file, it fails with ErrEmptyRing while no node is on the ring
+func (t *FileTable) search(sdfs string) ([]string, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.Storage.Empty() {
+		return nil, ErrEmptyRing
+	}
 	hashVal := hash(sdfs)
 	floorKey, _ := t.Storage.Floor(hashVal)
 	if floorKey == nil {
@@ -246,8 +230,8 @@ func (t *FileTable) search(sdfs string) []string {
 		floorKey = f
 	}
 	next := floorKey
-	var ips []string
-	for i := 0; i < 4; i++ {
+	var addrs []string
+	for i := 0; i < t.fileServer.config.ReplicaNum; i++ {
 		next, _ = t.Storage.Ceiling(next)
 		if next == nil {
 			f, _ := t.Storage.Min()
@@ -255,25 +239,37 @@ func (t *FileTable) search(sdfs string) []string {
 		}
 		tmp, found := t.Storage.Get(next)
 		if found {
-			ips = append(ips, tmp.(FileTableEntry).ServerIP)
+			addrs = append(addrs, tmp.(FileTableEntry).ServerAddr)
 		}
 		next = next.(uint32) + 1
 	}
-	return ips
+	return addrs, nil
 }
 
+// PutRepEntry skips files that were deleted meanwhile, which no node holds any more
 func (t *FileTable) PutRepEntry(args map[uint32][]string, success *bool) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	stored := sets.NewString()
+	for _, v := range t.Storage.Values() {
+		stored.Insert(v.(FileTableEntry).files...)
+	}
 	for k, extend := range args {
 		v, found := t.Storage.Get(k)
 		if found {
 			tmp := v.(FileTableEntry)
-			tmp.files = append(tmp.files, extend...)
+			for _, sdfs := range extend {
+				if stored.Has(sdfs) && !contains(tmp.files, sdfs) {
+					tmp.files = append(tmp.files, sdfs)
+				}
+			}
 			t.Storage.Put(k, tmp)
 		}
 	}
 	return nil
 }
 
+// findNextAlive expects the caller to hold t.mux
 func (t *FileTable) findNextAlive(failed []string, curHash uint32) uint32 {
 	var hashed []uint32 // hashed list of failed nodes
 	for _, k := range failed {
@@ -302,7 +298,31 @@ func (t *FileTable) findNextAlive(failed []string, curHash uint32) uint32 {
 	return alive
 }
 
+// RingNode is a node of the ring and the files it holds
+type RingNode struct {
+	Pos        uint32
+	ServerAddr string
+	Files      []string
+}
+
+// Ring returns the nodes of the ring in the order of their positions
+func (t *FileTable) Ring() []RingNode {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	ring := make([]RingNode, 0, t.Storage.Size())
+	it := t.Storage.Iterator()
+	for it.Next() {
+		entry := it.Value().(FileTableEntry)
+		files := append([]string{}, entry.files...)
+		sort.Strings(files)
+		ring = append(ring, RingNode{Pos: it.Key().(uint32), ServerAddr: entry.ServerAddr, Files: files})
+	}
+	return ring
+}
+
 func (t *FileTable) ListFilesByPrefix(prefix string) []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	fileset := sets.NewString()
 	for _, s := range t.Storage.Values() {
 		for _, f := range s.(FileTableEntry).files {
@@ -315,30 +335,36 @@ func (t *FileTable) ListFilesByPrefix(prefix string) []string {
 }
 
 func (t *FileTable) ListAllFiles() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	for i, s := range t.Storage.Values() {
 		for _, f := range s.(FileTableEntry).files {
-			fmt.Println(i, s.(FileTableEntry).ServerIP, f)
+			fmt.Println(i, s.(FileTableEntry).ServerAddr, f)
 		}
 	}
 }
 
 func (t *FileTable) ListMyFiles() {
-	v, found := t.Storage.Get(MyHash)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	v, found := t.Storage.Get(t.myHash)
 	if found {
 		for _, rec := range v.(FileTableEntry).files {
 			fmt.Println(rec)
 		}
 	} else {
-		log.Fatal("Failed to list my files")
+		fileLog.Warn("This node holds no files, it is not on the ring yet")
 	}
 }
 
 func (t *FileTable) ListLocations(filename string) []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 	var locations []string
 	for _, s := range t.Storage.Values() {
 		for _, file := range s.(FileTableEntry).files {
 			if file == filename {
-				locations = append(locations, s.(FileTableEntry).ServerIP)
+				locations = append(locations, s.(FileTableEntry).ServerAddr)
 			}
 		}
 	}
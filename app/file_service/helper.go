@@ -1,6 +1,10 @@
 package file_service
 
-import "hash/fnv"
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
 
 func compare(a, b interface{}) int {
 	if a.(uint32) < b.(uint32) {
@@ -30,8 +34,26 @@ func containsInt(s []uint32, e uint32) bool {
 	return false
 }
 
+// RingSize is the number of positions on the ring, nodes and files hash to [0, RingSize)
+const RingSize = 1000000007
+
 func hash(s string) uint32 {
 	h := fnv.New32a()
 	h.Write([]byte(s))
-	return h.Sum32() % 1000000007
+	return h.Sum32() % RingSize
+}
+
+// waitTimeout waits for wg, returns false if it is still not done after timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
@@ -0,0 +1,446 @@
+//go:build integration
+
+package file_service_test
+
+import (
+	"better_mp3/app/command"
+	"better_mp3/app/file_service"
+	"better_mp3/app/harness"
+	"better_mp3/app/maple_juice_service"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestNames(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkNames)
+}
+
+func TestACL(t *testing.T) {
+	harness.Run(t, "users", harness.Options{Size: 4, Users: []string{"admin", "alice", "bob", "carol"}}, checkACL)
+}
+
+func TestReReplication(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 5}, checkReReplication)
+}
+
+func TestOutsider(t *testing.T) {
+	harness.Run(t, "users", harness.Options{Size: 4, Users: []string{"alice"}}, checkOutsider)
+}
+
+func TestEmptyRing(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 1}, checkEmptyRing)
+}
+
+func TestWriteQuorum(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 5}, checkWriteQuorum)
+}
+
+// sdfs names that could escape the sdfs directory, or that are otherwise unsafe
+var hostileNames = []string{
+	"", ".", "..", "../escape", "../../escape", "/escape", "a/../../escape", "./escape", "escape/",
+	"a//escape", "nul\x00escape", "new\nline", "tab\tescape", "\xff\xfe", "escape*", "\u202eescape",
+	strings.Repeat("x", 256), strings.Repeat("\u00e9", 100),
+}
+
+// names that are valid, but need escaping on disk
+var unusualNames = []string{"logs/2020/app", ".hidden", "100%", "100%25", "with space", "\u00fcn\u00efcode", `back\slash`, "-dash", "x..y"}
+
+// hostile names are refused by the commands and the rpc servers, unusual ones are stored within
+// the sdfs directory and read back
+func checkNames(c *harness.Cluster) error {
+	writer, reader, target := c.Nodes[1], c.Nodes[2], c.Nodes[3]
+	local := filepath.Join(c.Dir, "content.txt")
+	if err := ioutil.WriteFile(local, []byte("content\n"), 0644); err != nil {
+		return err
+	}
+	invalid := func(what string, name string, err error) error {
+		if err == nil || !strings.Contains(err.Error(), file_service.ErrInvalidName.Error()) {
+			return fmt.Errorf("%v of %q was answered with %v, expected %v", what, name, err, file_service.ErrInvalidName)
+		}
+		return nil
+	}
+
+	fileClient, err := c.Credentials.Dial("127.0.0.1:" + target.Config.FileServiceConfig.Port)
+	if err != nil {
+		return err
+	}
+	defer fileClient.Close()
+	mapleJuiceClient, err := c.Credentials.Dial("127.0.0.1:" + target.Config.MapleJuiceServiceConfig.Port)
+	if err != nil {
+		return err
+	}
+	defer mapleJuiceClient.Close()
+
+	user := writer.File.User()
+	term := writer.File.Term()
+	for _, name := range hostileNames {
+		var content []byte
+		var success bool
+		var result string
+		for _, attempt := range []struct {
+			what string
+			err  error
+		}{
+			{"put", writer.File.RemotePut(user, local, name)},
+			{"get", writer.File.RemoteGet(user, name, filepath.Join(c.Dir, "got"))},
+			{"delete", writer.File.RemoteDelete(user, name)},
+			{"LocalPut", fileClient.Call("FileRPCServer.LocalPut", file_service.FileTask{FileName: name, Content: []byte("x"), Term: term, User: user}, &success)},
+			{"LocalAppend", fileClient.Call("FileRPCServer.LocalAppend", file_service.FileTask{FileName: name, Content: []byte("x"), Term: term, User: user}, &success)},
+			{"LocalGet", fileClient.Call("FileRPCServer.LocalGet", file_service.EntryArgs{FileName: name, User: user}, &content)},
+			{"LocalDelete", fileClient.Call("FileRPCServer.LocalDelete", file_service.EntryArgs{FileName: name, Term: term, User: user}, &success)},
+			{"LocalReplicate", fileClient.Call("FileRPCServer.LocalReplicate", file_service.EntryArgs{FileName: name, Term: term}, &success)},
+			{"RunMapleTask", mapleJuiceClient.Call("MapleJuiceRPCServer.RunMapleTask",
+				maple_juice_service.MapleJuiceTask{InputFileName: "input", ExecFileName: name, OutputPrefix: "out", Term: term, User: user}, &result)},
+			{"RunJuiceTask", mapleJuiceClient.Call("MapleJuiceRPCServer.RunJuiceTask",
+				maple_juice_service.MapleJuiceTask{InputFileName: name, ExecFileName: "exe", Term: term, User: user}, &result)},
+		} {
+			if err := invalid(attempt.what, name, attempt.err); err != nil {
+				return err
+			}
+		}
+		// a trailing '*' makes a valid acl prefix out of escape*
+		if !strings.HasSuffix(name, "*") {
+			if err := invalid("acl", name, writer.File.Grant(user, name, "bob", file_service.PermRead)); err != nil {
+				return err
+			}
+		}
+	}
+	escaped := ""
+	_ = filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.Contains(info.Name(), "escape") {
+			escaped = path
+		}
+		return nil
+	})
+	if escaped != "" {
+		return errors.New("a hostile name created " + escaped)
+	}
+
+	for _, name := range unusualNames {
+		if err := writer.File.RemotePut(user, local, name); err != nil {
+			return fmt.Errorf("put of %q: %v", name, err)
+		}
+		got := filepath.Join(c.Dir, "got")
+		if err := reader.File.RemoteGet(user, name, got); err != nil {
+			return fmt.Errorf("get of %q: %v", name, err)
+		}
+		if content, err := ioutil.ReadFile(got); err != nil || string(content) != "content\n" {
+			return fmt.Errorf("get of %q read %q, %v", name, content, err)
+		}
+	}
+	for _, node := range c.Nodes {
+		entries, err := ioutil.ReadDir(node.Config.FileServiceConfig.Path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				return fmt.Errorf("%v holds %v, which is not a regular file", node.Addr, entry.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// files belong to the user who put them: other users are denied, through their own node and when
+// calling a replica directly, until the owner grants them access. Prefixes are claimed by admins.
+func checkACL(c *harness.Cluster) error {
+	admin, alice, bob, carol := c.Nodes[0], c.Nodes[1], c.Nodes[2], c.Nodes[3]
+	acl := func(node *harness.Node, params ...string) error {
+		return node.File.HandleACL(command.Command{Method: command.Acl, Params: params})
+	}
+	expectDenied := func(what string, err error) error {
+		if err == nil || !strings.Contains(err.Error(), file_service.ErrPermissionDenied.Error()) {
+			return fmt.Errorf("%v was answered with %v, expected %v", what, err, file_service.ErrPermissionDenied)
+		}
+		return nil
+	}
+	get := func(node *harness.Node, name string) error {
+		return node.File.RemoteGet(node.File.User(), name, filepath.Join(c.Dir, name+"-"+node.File.User()))
+	}
+
+	if err := c.PutFrom(alice, "notes"); err != nil {
+		return err
+	}
+	if err := acl(alice, "notes"); err != nil {
+		return err
+	}
+	if err := expectDenied("bob's get", get(bob, "notes")); err != nil {
+		return err
+	}
+	if err := expectDenied("bob's delete", bob.File.RemoteDelete(bob.File.User(), "notes")); err != nil {
+		return err
+	}
+	if err := expectDenied("bob's put", c.PutFrom(bob, "notes")); err != nil {
+		return err
+	}
+
+	// a replica checks the user asserted by the caller too
+	replica := c.NodeByAddr(alice.File.FileTable.ListLocations("notes")[0])
+	err := c.WaitFor("the acl of notes on "+replica.Addr, 5*time.Second, func() bool {
+		return len(replica.File.FileTable.ACLs("notes")) > 0
+	})
+	if err != nil {
+		return err
+	}
+	client, err := c.Credentials.Dial("127.0.0.1:" + replica.Config.FileServiceConfig.Port)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	term := alice.File.Term()
+	var content []byte
+	var success bool
+	for _, call := range []struct {
+		method string
+		args   interface{}
+		reply  interface{}
+	}{
+		{"FileRPCServer.LocalGet", file_service.EntryArgs{FileName: "notes", Term: term, User: "bob"}, &content},
+		{"FileRPCServer.LocalDelete", file_service.EntryArgs{FileName: "notes", Term: term, User: "bob"}, &success},
+		{"FileRPCServer.LocalPut", file_service.FileTask{FileName: "notes", Content: []byte("bob"), Term: term, User: "bob"}, &success},
+	} {
+		if err := expectDenied(call.method+" as bob", client.Call(call.method, call.args, call.reply)); err != nil {
+			return err
+		}
+	}
+
+	// once granted read, bob may read but still not delete
+	if err := acl(alice, "grant", "notes", "bob", "r"); err != nil {
+		return err
+	}
+	if err := c.WaitFor("bob to read notes", 5*time.Second, func() bool { return get(bob, "notes") == nil }); err != nil {
+		return err
+	}
+	if err := expectDenied("bob's delete after the grant", bob.File.RemoteDelete(bob.File.User(), "notes")); err != nil {
+		return err
+	}
+
+	// only admins claim a prefix nobody covers, its owner then shares it
+	if err := expectDenied("bob claiming team-*", acl(bob, "owner", "team-*", "bob")); err != nil {
+		return err
+	}
+	if err := acl(admin, "owner", "team-*", alice.File.User()); err != nil {
+		return err
+	}
+	if err := acl(alice, "grant", "team-*", file_service.Everyone, "r"); err != nil {
+		return err
+	}
+	if err := c.PutFrom(alice, "team-plan"); err != nil {
+		return err
+	}
+	if err := c.WaitFor("carol to read team-plan", 5*time.Second, func() bool { return get(carol, "team-plan") == nil }); err != nil {
+		return err
+	}
+	if err := expectDenied("carol's delete of team-plan", carol.File.RemoteDelete(carol.File.User(), "team-plan")); err != nil {
+		return err
+	}
+	if err := expectDenied("bob's put under team-", c.PutFrom(bob, "team-bob")); err != nil {
+		return err
+	}
+
+	// admins may do everything, the acl of a deleted file goes with it
+	if err := admin.File.RemoteDelete(admin.File.User(), "notes"); err != nil {
+		return err
+	}
+	if acls := admin.File.FileTable.ACLs("notes"); len(acls) != 0 {
+		return fmt.Errorf("deleted file notes still has the acls %v", acls)
+	}
+	return nil
+}
+
+// files of a crashed member are copied again until they have replica_num replicas
+func checkReReplication(c *harness.Cluster) error {
+	writer := c.Nodes[0]
+	local := filepath.Join(c.Dir, "replicated.txt")
+	content := []byte(strings.Repeat("replicate me\n", 100))
+	if err := ioutil.WriteFile(local, content, 0644); err != nil {
+		return err
+	}
+	if err := writer.File.RemotePut(writer.File.User(), local, "replicated"); err != nil {
+		return err
+	}
+
+	replicaNum := c.Config.FileServiceConfig.ReplicaNum
+	locations := writer.File.FileTable.ListLocations("replicated")
+	if len(locations) != replicaNum {
+		return fmt.Errorf("file stored on %v, expected %v replicas", locations, replicaNum)
+	}
+
+	// crash a replica holder other than the introducer
+	var victim *harness.Node
+	for _, addr := range locations {
+		if node := c.NodeByAddr(addr); node.Index != 0 {
+			victim = node
+			break
+		}
+	}
+	if victim == nil {
+		return errors.New("no replica holder besides the introducer")
+	}
+	c.Crash(victim.Index)
+
+	err := c.WaitFor("the file to be re-replicated", 5*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range c.Alive() {
+			locations := node.File.FileTable.ListLocations("replicated")
+			if len(locations) != replicaNum || contains(locations, victim.Addr) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range writer.File.FileTable.ListLocations("replicated") {
+		stored, err := ioutil.ReadFile(filepath.Join(c.NodeByAddr(addr).Config.FileServiceConfig.Path, "replicated"))
+		if err != nil {
+			return err
+		}
+		if string(stored) != string(content) {
+			return fmt.Errorf("replica on %v differs from the original", addr)
+		}
+	}
+	return nil
+}
+
+// puts and deletes that reach too few replicas fail and leave the file table alone, the delete
+// succeeds once the replicas are replaced
+func checkWriteQuorum(c *harness.Cluster) error {
+	writer := c.Nodes[0]
+	user := writer.File.User()
+	local := filepath.Join(c.Dir, "quorum.txt")
+	if err := ioutil.WriteFile(local, []byte("quorum\n"), 0644); err != nil {
+		return err
+	}
+	if err := writer.File.RemotePut(user, local, "quorum"); err != nil {
+		return err
+	}
+	locations := writer.File.FileTable.ListLocations("quorum")
+
+	// crash all holders but one before their failure is noticed
+	var crashed []string
+	for _, addr := range locations {
+		if node := c.NodeByAddr(addr); node.Index != 0 && len(crashed) < len(locations)-1 {
+			c.Crash(node.Index)
+			crashed = append(crashed, addr)
+		}
+	}
+	if err := writer.File.RemotePut(user, local, "quorum"); !errors.Is(err, file_service.ErrNoQuorum) {
+		return fmt.Errorf("put to %v of %v replicas was answered with %v", len(locations)-len(crashed), len(locations), err)
+	}
+	if err := writer.File.RemoteDelete(user, "quorum"); !errors.Is(err, file_service.ErrNoQuorum) {
+		return fmt.Errorf("delete from %v of %v replicas was answered with %v", len(locations)-len(crashed), len(locations), err)
+	}
+	if len(writer.File.FileTable.ListLocations("quorum")) == 0 {
+		return errors.New("a failed delete removed the file from the table")
+	}
+
+	var err error
+	waitErr := c.WaitFor("the delete to succeed on the new replicas", 10*c.Config.MemberServiceConfig.FailTime, func() bool {
+		err = writer.File.RemoteDelete(user, "quorum")
+		return err == nil
+	})
+	if waitErr != nil {
+		return fmt.Errorf("%v, last error: %v", waitErr, err)
+	}
+	return nil
+}
+
+// a connection from a host that is not a member may not act as any user, not even the owner of
+// a file, while a node may
+func checkOutsider(c *harness.Cluster) error {
+	alice := c.Nodes[0]
+	if err := c.PutFrom(alice, "owned"); err != nil {
+		return err
+	}
+	replica := c.NodeByAddr(alice.File.FileTable.ListLocations("owned")[0])
+	term := alice.File.Term()
+	call := func(from string, port string, method string, args interface{}, reply interface{}) error {
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(from)}, Timeout: 5 * time.Second}
+		conn, err := dialer.Dial("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			return err
+		}
+		client := rpc.NewClient(conn)
+		defer client.Close()
+		return client.Call(method, args, reply)
+	}
+	filePort, mapleJuicePort := replica.Config.FileServiceConfig.Port, replica.Config.MapleJuiceServiceConfig.Port
+
+	var content []byte
+	if err := call("127.0.0.1", filePort, "FileRPCServer.LocalGet", file_service.EntryArgs{FileName: "owned", User: "alice"}, &content); err != nil {
+		return fmt.Errorf("a node was refused the file of alice: %v", err)
+	}
+	var success bool
+	var result string
+	for _, attempt := range []struct {
+		what string
+		err  error
+	}{
+		{"LocalGet", call("127.0.0.2", filePort, "FileRPCServer.LocalGet", file_service.EntryArgs{FileName: "owned", User: "alice"}, &content)},
+		{"LocalPut", call("127.0.0.2", filePort, "FileRPCServer.LocalPut", file_service.FileTask{FileName: "owned", Content: []byte("x"), Term: term, User: "alice"}, &success)},
+		{"LocalAppend", call("127.0.0.2", filePort, "FileRPCServer.LocalAppend", file_service.FileTask{FileName: "owned", Content: []byte("x"), Term: term, User: "alice"}, &success)},
+		{"LocalDelete", call("127.0.0.2", filePort, "FileRPCServer.LocalDelete", file_service.EntryArgs{FileName: "owned", Term: term, User: "alice"}, &success)},
+		{"RunMapleTask", call("127.0.0.2", mapleJuicePort, "MapleJuiceRPCServer.RunMapleTask",
+			maple_juice_service.MapleJuiceTask{InputFileName: "owned", ExecFileName: "exe", OutputPrefix: "out", Term: term, User: "alice"}, &result)},
+	} {
+		if attempt.err == nil || !strings.Contains(attempt.err.Error(), file_service.ErrPermissionDenied.Error()) {
+			return fmt.Errorf("%v from a host that is no member answered %v", attempt.what, attempt.err)
+		}
+	}
+	if err := alice.File.RemoteGet("alice", "owned", filepath.Join(c.Dir, "owned-got")); err != nil {
+		return err
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(c.Dir, "owned-got")); err != nil || string(got) != "owned\n" {
+		return fmt.Errorf("the file of alice holds %q after the outsider's attempts: %v", got, err)
+	}
+	return nil
+}
+
+// puts, appends and maple jobs fail while no node is on the ring, as before the first
+// configuration entry of raft
+func checkEmptyRing(c *harness.Cluster) error {
+	node := c.Nodes[0]
+	if err := node.File.FileTable.Restore(nil); err != nil {
+		return err
+	}
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	local := filepath.Join(c.Dir, "ringless.txt")
+	if err := ioutil.WriteFile(local, []byte("ringless\n"), 0644); err != nil {
+		return err
+	}
+	for what, err := range map[string]error{
+		"put":    node.File.RemotePut(node.File.User(), local, "ringless"),
+		"append": node.File.RemoteAppend(node.File.User(), []byte("ringless\n"), "ringless"),
+		"maple":  node.MapleJuice.ScheduleMapleTask([]string{"maple", "maple_wordcount", "2", "wc", "words"}),
+	} {
+		if !errors.Is(err, file_service.ErrEmptyRing) {
+			return fmt.Errorf("%v on an empty ring answered %v", what, err)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	i := sort.SearchStrings(sorted, s)
+	return i < len(sorted) && sorted[i] == s
+}
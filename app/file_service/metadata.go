@@ -0,0 +1,133 @@
+package file_service
+
+/*
+The file table is the state machine "file" of the raft service. Changes to it are proposed as
+commands and applied by every node in the same order, so that all nodes agree on where each
+file is stored, and a new master doesn't have to rebuild the table. Nodes join and leave the
+ring as the raft configuration changes.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const metadataMachine = "file"
+
+const (
+	opPut       = "put"
+	opDelete    = "delete"
+	opReplicas  = "replicas"
+	opGrant     = "grant"
+	opOwner     = "owner"
+	opRemoveACL = "remove-acl"
+)
+
+type metadataCommand struct {
+	Op       string
+	FileName string              `json:",omitempty"` // or the prefix of an acl
+	Entries  map[uint32][]string `json:",omitempty"` // files re-replicated to each position of the ring
+	User     string              `json:",omitempty"` // the user who made the change
+	Grantee  string              `json:",omitempty"` // the user granted Perm, or the new owner
+	Perm     Permission          `json:",omitempty"`
+	// when the change was proposed in unix nanoseconds, every node applies the same time
+	Time int64 `json:",omitempty"`
+}
+
+// tableSnapshot is the snapshot of the file table
+type tableSnapshot struct {
+	Ring []ringEntry
+	ACLs []ACL
+}
+
+// ringEntry is one node of the ring in a snapshot
+type ringEntry struct {
+	Pos        uint32
+	ServerAddr string
+	Files      []string
+}
+
+// proposeMetadata commits a change of the file table, it returns once this node applied it
+func (fs *FileServer) proposeMetadata(ctx context.Context, command metadataCommand) error {
+	command.Time = time.Now().UnixNano()
+	data, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	return fs.raft.ProposeContext(ctx, metadataMachine, data)
+}
+
+func (t *FileTable) Apply(command []byte) {
+	var c metadataCommand
+	if err := json.Unmarshal(command, &c); err != nil {
+		fileLog.Error("Invalid file table command:", err)
+		return
+	}
+	switch c.Op {
+	case opPut:
+		_ = t.PutEntry(c.FileName, c.Time, nil)
+		t.mux.Lock()
+		t.applyACL(c)
+		t.mux.Unlock()
+	case opDelete:
+		_ = t.DeleteEntry(c.FileName, nil)
+		t.mux.Lock()
+		t.applyACL(c)
+		t.mux.Unlock()
+	case opReplicas:
+		_ = t.PutRepEntry(c.Entries, nil)
+	case opGrant, opOwner, opRemoveACL:
+		t.mux.Lock()
+		t.applyACL(c)
+		t.mux.Unlock()
+	default:
+		fileLog.Error("Unknown file table command:", c.Op)
+	}
+}
+
+func (t *FileTable) Snapshot() ([]byte, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	snapshot := tableSnapshot{Ring: make([]ringEntry, 0, t.Storage.Size()), ACLs: make([]ACL, 0, len(t.acls))}
+	it := t.Storage.Iterator()
+	for it.Next() {
+		entry := it.Value().(FileTableEntry)
+		snapshot.Ring = append(snapshot.Ring, ringEntry{Pos: it.Key().(uint32), ServerAddr: entry.ServerAddr, Files: entry.files})
+	}
+	for _, acl := range t.acls {
+		snapshot.ACLs = append(snapshot.ACLs, acl)
+	}
+	return json.Marshal(snapshot)
+}
+
+func (t *FileTable) Restore(snapshot []byte) error {
+	var restored tableSnapshot
+	if snapshot != nil {
+		if err := json.Unmarshal(snapshot, &restored); err != nil {
+			return err
+		}
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.Storage.Clear()
+	for _, entry := range restored.Ring {
+		t.Storage.Put(entry.Pos, FileTableEntry{ServerAddr: entry.ServerAddr, files: entry.Files})
+	}
+	t.acls = map[string]ACL{}
+	for _, acl := range restored.ACLs {
+		t.acls[acl.Path] = acl
+	}
+	return nil
+}
+
+// PeersChanged adds joined nodes to the ring and removes failed ones. The node following a
+// failed one copies its files again, unless the removal is replayed after a restart.
+func (t *FileTable) PeersChanged(added []string, removed []string, replay bool) {
+	for _, addr := range added {
+		t.AddEmptyEntry(addr)
+	}
+	if len(removed) > 0 {
+		t.RemoveFromTable(removed, !replay)
+	}
+}
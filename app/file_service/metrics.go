@@ -0,0 +1,53 @@
+package file_service
+
+/*
+The metrics of the file service, registered with the registry of the node, see the metrics
+package. The get, put, delete and append requests made through this node are counted by result and
+timed. Bytes are counted per replica written or read, so a put to three replicas counts its file
+three times. Re-replication counts the copies this node makes of the files of failed members.
+*/
+
+import (
+	"better_mp3/app/metrics"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsSubsystem = "sdfs"
+
+type fileMetrics struct {
+	operations      *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	bytes           *prometheus.CounterVec
+	replicatedFiles prometheus.Counter
+	replicatedBytes prometheus.Counter
+}
+
+func newFileMetrics(nodeAddr string) *fileMetrics {
+	return &fileMetrics{
+		operations: metrics.CounterVec(nodeAddr, metricsSubsystem, "operations_total",
+			"Requests made through this node by operation and result: ok, denied or error.", "op", "result"),
+		duration: metrics.HistogramVec(nodeAddr, metricsSubsystem, "operation_duration_seconds",
+			"Time taken by the requests made through this node, by operation.", metrics.LatencyBuckets, "op"),
+		bytes: metrics.CounterVec(nodeAddr, metricsSubsystem, "bytes_total",
+			"Bytes written to or read from replicas by the requests made through this node, by operation.", "op"),
+		replicatedFiles: metrics.Counter(nodeAddr, metricsSubsystem, "rereplicated_files_total",
+			"Replicas this node copied to itself after a member failed."),
+		replicatedBytes: metrics.Counter(nodeAddr, metricsSubsystem, "rereplicated_bytes_total",
+			"Bytes of the replicas this node copied to itself after a member failed."),
+	}
+}
+
+// observe counts and times a request that started at start, used as
+// defer fs.observe(op, time.Now(), &err)
+func (fs *FileServer) observe(op string, start time.Time, err *error) {
+	result := "ok"
+	if isDenied(*err) {
+		result = "denied"
+	} else if *err != nil {
+		result = "error"
+	}
+	fs.metrics.operations.WithLabelValues(op, result).Inc()
+	fs.metrics.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,97 @@
+package file_service
+
+/*
+An sdfs name is printable UTF-8. '/' separates the parts of a name, so that names read like
+paths, e.g. "logs/2020/app", but no part may be empty, "." or "..". '*' is left to acl prefixes.
+Names are checked where requests enter: the commands of a node and its rpc servers refuse invalid
+ones with ErrInvalidName.
+
+On disk every name is one file directly in the sdfs directory. EncodeName escapes every byte but
+letters, digits, '-', '_' and non-leading '.' as %XX, so that the file name has no '/', isn't "." or
+"..", and two names never share a file. Common names are their own file names, and a name is
+refused when its file name would be longer than 255 bytes.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the longest file name most file systems accept
+const maxEncodedName = 255
+
+var ErrInvalidName = errors.New("invalid sdfs name")
+
+// ValidateName fails with ErrInvalidName unless name is a valid sdfs name
+func ValidateName(name string) error {
+	reason := ""
+	switch {
+	case name == "":
+		reason = "empty"
+	case !utf8.ValidString(name):
+		reason = "not UTF-8"
+	case strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) && r != ' ' }) >= 0:
+		reason = "contains control characters"
+	case strings.Contains(name, "*"):
+		reason = "contains '*', which only acl prefixes may end with"
+	case len(EncodeName(name)) > maxEncodedName:
+		reason = "too long"
+	default:
+		for _, part := range strings.Split(name, "/") {
+			if part == "" || part == "." || part == ".." {
+				reason = "parts between '/' must not be empty, '.' or '..'"
+				break
+			}
+		}
+	}
+	if reason != "" {
+		return fmt.Errorf("%w %q: %v", ErrInvalidName, name, reason)
+	}
+	return nil
+}
+
+// EncodeName returns the file name a valid sdfs name is stored under
+func EncodeName(name string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+			b == '-' || b == '_' || b == '.' && i > 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+// DecodeName returns the sdfs name of a file stored under name
+func DecodeName(name string) (string, error) {
+	var decoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			decoded.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", fmt.Errorf("%w %q: truncated escape", ErrInvalidName, name)
+		}
+		b, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("%w %q: invalid escape", ErrInvalidName, name)
+		}
+		decoded.WriteByte(byte(b))
+		i += 2
+	}
+	return decoded.String(), nil
+}
+
+// localPath is where this node stores a file
+func (fs *FileServer) localPath(name string) string {
+	return filepath.Join(fs.config.Path, EncodeName(name))
+}
@@ -0,0 +1,95 @@
+package file_service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		valid bool
+	}{
+		{"file", true},
+		{"logs/2020/app", true},
+		{"with space", true},
+		{"x..y", true},
+		{".hidden", true},
+		{"a/.hidden", true},
+		{"100%", true},
+		{"\u00fcn\u00efcode", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../escape", false},
+		{"a/../escape", false},
+		{"a/./b", false},
+		{"/escape", false},
+		{"escape/", false},
+		{"a//b", false},
+		{"nul\x00byte", false},
+		{"new\nline", false},
+		{"tab\tescape", false},
+		{"\u202eescape", false},
+		{"\xff\xfe", false},
+		{"escape*", false},
+		{strings.Repeat("x", maxEncodedName), true},
+		{strings.Repeat("x", maxEncodedName+1), false},
+		// every accented e takes 6 bytes on disk
+		{strings.Repeat("\u00e9", maxEncodedName/6), true},
+		{strings.Repeat("\u00e9", maxEncodedName/6+1), false},
+	} {
+		err := ValidateName(test.name)
+		if (err == nil) != test.valid || err != nil && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q: %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestEncodeName(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		encoded string
+	}{
+		{"file-1_a.txt", "file-1_a.txt"},
+		{"logs/2020/app", "logs%2F2020%2Fapp"},
+		{".hidden", "%2Ehidden"},
+		{"a/.hidden", "a%2F.hidden"},
+		{"100%", "100%25"},
+		{"100%25", "100%2525"},
+		{"with space", "with%20space"},
+		{"\u00e9", "%C3%A9"},
+	} {
+		encoded := EncodeName(test.name)
+		if encoded != test.encoded {
+			t.Errorf("%q is stored as %q, expected %q", test.name, encoded, test.encoded)
+		}
+		if decoded, err := DecodeName(encoded); err != nil || decoded != test.name {
+			t.Errorf("%q decodes to %q with %v, expected %q", encoded, decoded, err, test.name)
+		}
+	}
+}
+
+// different names are stored in different files, none of them special to the file system
+func TestEncodeNameDistinct(t *testing.T) {
+	files := map[string]string{}
+	for _, name := range []string{"a/b", "a%2Fb", "a_b", ".a", "%2Ea", "a.", "x..y", "100%", "100%25"} {
+		encoded := EncodeName(name)
+		if other, found := files[encoded]; found {
+			t.Errorf("%q and %q are both stored as %q", name, other, encoded)
+		}
+		files[encoded] = name
+		if strings.Contains(encoded, "/") || encoded == "." || encoded == ".." || strings.HasPrefix(encoded, ".") {
+			t.Errorf("%q is stored as %q", name, encoded)
+		}
+	}
+}
+
+func TestDecodeNameInvalid(t *testing.T) {
+	for _, name := range []string{"%", "%4", "a%G1", "%%41"} {
+		if decoded, err := DecodeName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q decodes to %q with %v", name, decoded, err)
+		}
+	}
+}
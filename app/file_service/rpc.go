@@ -1,57 +1,176 @@
 package file_service
 
 import (
+	"better_mp3/app/audit"
+	"better_mp3/app/secure_rpc"
+	"better_mp3/app/tracing"
+	"fmt"
 	"log"
-	"net"
 	"net/rpc"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type FileRPCServer struct {
 	fileServer *FileServer
+	peer       secure_rpc.Peer // who calls, see Authenticate
 }
 
 func RunRPCServer(fileServer *FileServer) {
-	server := FileRPCServer{
-		fileServer: fileServer,
-	}
-	err := rpc.Register(server)
+	listener, err := fileServer.credentials.Listen(fileServer.config.Port)
 	if err != nil {
-		log.Fatal("Failed to register RPC instance")
-	}
-	listener, err := net.Listen("tcp", ":"+fileServer.config.Port)
-	for {
-		rpc.Accept(listener)
+		log.Fatal("Failed to listen on port ", fileServer.config.Port)
 	}
+	fileServer.listener = listener
+	// every connection gets its own rpc server, which tells the methods their peer
+	go secure_rpc.ServePeers(listener, func(peer secure_rpc.Peer) *rpc.Server {
+		rpcServer := rpc.NewServer()
+		if err := rpcServer.Register(FileRPCServer{fileServer: fileServer, peer: peer}); err != nil {
+			log.Fatal("Failed to register RPC instance")
+		}
+		return rpcServer
+	})
 }
 
-func (r FileRPCServer) LocalDelete(filename string, success *bool) error {
-	return r.fileServer.LocalDelete(filename, success)
+func (r FileRPCServer) LocalDelete(args EntryArgs, success *bool) (err error) {
+	op := r.fileServer.audit.StartRPC(r.peer.Addr, args.User, "replica-delete", args.FileName)
+	defer op.End(&err)
+	_, span := tracing.StartServer(r.fileServer.tracer, args.Trace, "FileRPCServer.LocalDelete", attribute.String("file", args.FileName))
+	defer tracing.End(span, &err)
+	if err := ValidateName(args.FileName); err != nil {
+		return err
+	}
+	if err := r.fileServer.Fence(args.Term); err != nil {
+		return err
+	}
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if err := r.fileServer.Authenticate(r.peer, args.User); err != nil {
+		return err
+	}
+	op.Authenticated()
+	if err := r.fileServer.authorize(args.User, args.FileName, PermDelete); err != nil {
+		return err
+	}
+	return r.fileServer.LocalDelete(args.FileName, success)
 }
 
-func (r FileRPCServer) LocalGet(filename string, content *[]byte) error {
-	return r.fileServer.LocalGet(filename, content)
+func (r FileRPCServer) LocalGet(args EntryArgs, content *[]byte) (err error) {
+	op := r.fileServer.audit.StartRPC(r.peer.Addr, args.User, "replica-get", args.FileName)
+	defer op.End(&err)
+	_, span := tracing.StartServer(r.fileServer.tracer, args.Trace, "FileRPCServer.LocalGet", attribute.String("file", args.FileName))
+	defer tracing.End(span, &err)
+	if err := ValidateName(args.FileName); err != nil {
+		return err
+	}
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if err := r.fileServer.Authenticate(r.peer, args.User); err != nil {
+		return err
+	}
+	op.Authenticated()
+	if err := r.fileServer.authorize(args.User, args.FileName, PermRead); err != nil {
+		return err
+	}
+	return r.fileServer.LocalGet(args.FileName, content)
 }
 
-func (r FileRPCServer) LocalAppend(task FileTask, success *bool) error {
+func (r FileRPCServer) LocalAppend(task FileTask, success *bool) (err error) {
+	op := r.fileServer.audit.StartRPC(r.peer.Addr, task.User, "replica-append", task.FileName)
+	defer op.End(&err)
+	_, span := tracing.StartServer(r.fileServer.tracer, task.Trace, "FileRPCServer.LocalAppend", attribute.String("file", task.FileName))
+	defer tracing.End(span, &err)
+	if err := ValidateName(task.FileName); err != nil {
+		return err
+	}
+	if err := r.fileServer.Fence(task.Term); err != nil {
+		return err
+	}
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if err := r.fileServer.Authenticate(r.peer, task.User); err != nil {
+		return err
+	}
+	op.Authenticated()
+	if err := r.fileServer.authorize(task.User, task.FileName, PermWrite); err != nil {
+		return err
+	}
 	return r.fileServer.LocalAppend(task, success)
 }
 
-func (r FileRPCServer) LocalPut(task FileTask, success *bool) error {
+func (r FileRPCServer) LocalPut(task FileTask, success *bool) (err error) {
+	op := r.fileServer.audit.StartRPC(r.peer.Addr, task.User, "replica-put", task.FileName)
+	defer op.End(&err)
+	_, span := tracing.StartServer(r.fileServer.tracer, task.Trace, "FileRPCServer.LocalPut", attribute.String("file", task.FileName))
+	defer tracing.End(span, &err)
+	if err := ValidateName(task.FileName); err != nil {
+		return err
+	}
+	if err := r.fileServer.Fence(task.Term); err != nil {
+		return err
+	}
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if err := r.fileServer.Authenticate(r.peer, task.User); err != nil {
+		return err
+	}
+	op.Authenticated()
+	if err := r.fileServer.authorize(task.User, task.FileName, PermWrite); err != nil {
+		return err
+	}
 	return r.fileServer.LocalPut(task, success)
 }
 
-func (r FileRPCServer) LocalReplicate(filename string, success *bool) error {
-	return r.fileServer.LocalReplicate(filename, success)
+func (r FileRPCServer) LocalReplicate(args EntryArgs, success *bool) (err error) {
+	ctx, span := tracing.StartServer(r.fileServer.tracer, args.Trace, "FileRPCServer.LocalReplicate", attribute.String("file", args.FileName))
+	defer tracing.End(span, &err)
+	if err := ValidateName(args.FileName); err != nil {
+		return err
+	}
+	if err := r.fileServer.Fence(args.Term); err != nil {
+		return err
+	}
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if !r.fileServer.IsNode(r.peer) {
+		return fmt.Errorf("%w: replication requested by %v, which is not a node", ErrPermissionDenied, r.peer.Addr)
+	}
+	return r.fileServer.LocalReplicate(ctx, args.FileName, success)
 }
 
-func (r FileRPCServer) PutRepEntry(args map[uint32][]string, success *bool) error {
-	return r.fileServer.FileTable.PutRepEntry(args, success)
+func (r FileRPCServer) LocalFiles(args ListArgs, files *[]LocalFile) (err error) {
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if err := r.fileServer.Authenticate(r.peer, args.User); err != nil {
+		return err
+	}
+	*files, err = r.fileServer.LocalFiles(args.User)
+	return err
 }
 
-func (r FileRPCServer) DeleteEntry(sdfs string, success *bool) error {
-	return r.fileServer.FileTable.DeleteEntry(sdfs, success)
+func (r FileRPCServer) Audit(args AuditArgs, entries *[]audit.Entry) (err error) {
+	_, span := tracing.StartServer(r.fileServer.tracer, args.Trace, "FileRPCServer.Audit")
+	defer tracing.End(span, &err)
+	if err := r.fileServer.begin(); err != nil {
+		return err
+	}
+	defer r.fileServer.tasks.Done()
+	if err := r.fileServer.Authenticate(r.peer, args.User); err != nil {
+		return err
+	}
+	found, err := r.fileServer.localAudit(args.User, args.Filter)
+	*entries = found
+	return err
 }
-
-func (r FileRPCServer) PutEntry(sdfs string, success *bool) error {
-	return r.fileServer.FileTable.PutEntry(sdfs, success)
-}
\ No newline at end of file
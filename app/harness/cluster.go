@@ -0,0 +1,455 @@
+/*
+This package runs a whole cluster inside one process, on loopback ports, so that
+changes can be checked without VMs. Failures are injected through the network
+model in network.go: crashes, partitions and message loss between members.
+
+The integration tests of the packages start clusters through Start, they are built
+with the integration tag:
+
+	go test -tags integration ./app/...
+*/
+package harness
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/dashboard"
+	"better_mp3/app/file_service"
+	"better_mp3/app/http_service"
+	"better_mp3/app/logger"
+	"better_mp3/app/maple_juice_service"
+	"better_mp3/app/member_service"
+	"better_mp3/app/raft_service"
+	"better_mp3/app/secure_rpc"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Node struct {
+	Index      int
+	Addr       string
+	Config     config.Config
+	Member     *member_service.MemberServer
+	Raft       *raft_service.RaftServer
+	File       *file_service.FileServer
+	MapleJuice *maple_juice_service.MapleJuiceServer
+	HTTP       *http_service.HTTPServer
+	Dashboard  *dashboard.Dashboard // nil unless the config sets its address
+	Crashed    bool
+}
+
+type Cluster struct {
+	Nodes   []*Node
+	Dir     string
+	Config  config.Config
+	network *network
+
+	// set by EnableTLS: the authority signing the nodes, and the identity of the harness itself
+	ca          *secure_rpc.CA
+	Credentials *secure_rpc.Credentials
+}
+
+// NewCluster prepares size nodes, node i uses the ports around basePort+10*i
+// and keeps its files under dir/<member port>/. Node 0 is the introducer.
+func NewCluster(size int, basePort int, dir string) (*Cluster, error) {
+	if size < 1 {
+		return nil, errors.New("a cluster needs at least one node")
+	}
+	c := Cluster{
+		Dir:     dir,
+		Config:  baseConfig(basePort, dir),
+		network: newNetwork(),
+	}
+	for _, sub := range []string{c.Config.MapleJuiceServiceConfig.InputDir, c.Config.MapleJuiceServiceConfig.ExecDir} {
+		if err := os.MkdirAll(sub, config.PERM_MODE); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		if _, err := c.newNode(); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// newNode prepares the next node from the cluster config
+func (c *Cluster) newNode() (*Node, error) {
+	i := len(c.Nodes)
+	basePort, _ := strconv.Atoi(c.Config.MemberServiceConfig.Port)
+	nodeConfig := c.Config
+	port := basePort + 10*i
+	nodeDir := filepath.Join(c.Dir, strconv.Itoa(port))
+	nodeConfig.MemberServiceConfig.Port = strconv.Itoa(port)
+	nodeConfig.FileServiceConfig.Port = strconv.Itoa(port - 1)
+	nodeConfig.FileServiceConfig.Path = filepath.Join(nodeDir, "sdfs") + "/"
+	nodeConfig.MapleJuiceServiceConfig.Port = strconv.Itoa(port + 1)
+	nodeConfig.MapleJuiceServiceConfig.SdfsDir = nodeConfig.FileServiceConfig.Path
+	nodeConfig.MapleJuiceServiceConfig.TmpDir = filepath.Join(nodeDir, "tmp") + "/"
+	nodeConfig.RaftServiceConfig.Port = strconv.Itoa(port + 2)
+	nodeConfig.RaftServiceConfig.Path = filepath.Join(nodeDir, "raft") + "/"
+	nodeConfig.HTTPServiceConfig.Port = strconv.Itoa(port + 3)
+	if nodeConfig.HTTPServiceConfig.DashboardAddr != "" {
+		nodeConfig.HTTPServiceConfig.DashboardAddr = "127.0.0.1:" + strconv.Itoa(port+4)
+	}
+	nodeConfig.AuditLog = filepath.Join(nodeDir, "audit.log")
+	nodeConfig.FileServiceConfig.AuditLog = nodeConfig.AuditLog
+	nodeConfig.MapleJuiceServiceConfig.AuditLog = nodeConfig.AuditLog
+	for _, sub := range []string{nodeConfig.FileServiceConfig.Path, nodeConfig.MapleJuiceServiceConfig.TmpDir} {
+		if err := os.MkdirAll(sub, config.PERM_MODE); err != nil {
+			return nil, err
+		}
+	}
+
+	node := &Node{
+		Index:  i,
+		Addr:   "127.0.0.1:" + strconv.Itoa(port),
+		Config: nodeConfig,
+	}
+	if c.ca != nil {
+		if err := c.issueCertificate(node); err != nil {
+			return nil, err
+		}
+	}
+	c.Nodes = append(c.Nodes, node)
+	return node, nil
+}
+
+// EnableTLS creates a certificate authority under dir/certs and gives every node, including the
+// ones added later, its own certificate for mutual tls between the rpc services. Call it before Start.
+func (c *Cluster) EnableTLS() error {
+	ca, err := secure_rpc.NewCA("harness", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(c.Dir, "certs")
+	if err := ca.Write(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")); err != nil {
+		return err
+	}
+	c.ca = ca
+	if c.Credentials, err = ca.Credentials("harness", []string{"127.0.0.1"}, 24*time.Hour); err != nil {
+		return err
+	}
+	for _, node := range c.Nodes {
+		if err := c.issueCertificate(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CA is the authority set up by EnableTLS
+func (c *Cluster) CA() *secure_rpc.CA {
+	return c.ca
+}
+
+func (c *Cluster) issueCertificate(node *Node) error {
+	dir := filepath.Join(c.Dir, "certs")
+	name := "node-" + node.Config.MemberServiceConfig.Port
+	tlsConfig := config.TLSConfig{
+		CA:   filepath.Join(dir, "ca.pem"),
+		Cert: filepath.Join(dir, name+".pem"),
+		Key:  filepath.Join(dir, name+"-key.pem"),
+	}
+	if err := c.ca.IssueFiles(node.Addr, []string{"127.0.0.1"}, 24*time.Hour, tlsConfig.Cert, tlsConfig.Key); err != nil {
+		return err
+	}
+	node.Config.TLS = tlsConfig
+	node.Config.FileServiceConfig.TLS = tlsConfig
+	node.Config.MapleJuiceServiceConfig.TLS = tlsConfig
+	node.Config.RaftServiceConfig.TLS = tlsConfig
+	return nil
+}
+
+// baseConfig uses short timeouts so that scenarios finish in seconds
+func baseConfig(basePort int, dir string) config.Config {
+	return config.Config{
+		DataDir:         dir,
+		BufferSize:      8192,
+		ShutdownTimeout: 2 * time.Second,
+		MemberServiceConfig: config.MemberServiceConfig{
+			Introducer:     "127.0.0.1:" + strconv.Itoa(basePort),
+			Host:           "127.0.0.1",
+			Port:           strconv.Itoa(basePort),
+			Strategy:       config.STRAT_ALL,
+			GossipInterval: 100 * time.Millisecond,
+			GossipFanout:   3,
+			ProbeTimeout:   40 * time.Millisecond,
+			IndirectProbes: 2,
+			SuspectTime:    time.Second,
+			SuspectMaxTime: 3 * time.Second,
+			Confirmations:  3,
+			HealthMax:      8,
+			Detector:       config.DETECTOR_TIMEOUT,
+			AccrualLevel:   5,
+			PhiThreshold:   8,
+			FailTime:       time.Second,
+			RemoveTime:     4 * time.Second,
+			JoinTimeout:    5 * time.Second,
+			JoinBackoff:    time.Second,
+			RetransmitMult: 4,
+			SyncInterval:   time.Second,
+			BufferSize:     8192,
+		},
+		FileServiceConfig: config.FileServiceConfig{
+			Port:       strconv.Itoa(basePort - 1),
+			ReplicaNum: 3,
+			User:       "harness",
+			Admins:     []string{"admin"},
+		},
+		MapleJuiceServiceConfig: config.MapleJuiceServiceConfig{
+			Port:         strconv.Itoa(basePort + 1),
+			InputDir:     filepath.Join(dir, "input") + "/",
+			ExecDir:      filepath.Join(dir, "exec") + "/",
+			TaskTimeout:  30 * time.Second,
+			TaskCPU:      10 * time.Second,
+			TaskMemoryMB: 512,
+			TaskFileMB:   64,
+		},
+		RaftServiceConfig: config.RaftServiceConfig{
+			Port:              strconv.Itoa(basePort + 2),
+			HeartbeatInterval: 50 * time.Millisecond,
+			ElectionTimeout:   500 * time.Millisecond,
+			CommitTimeout:     3 * time.Second,
+			SnapshotEntries:   100,
+			Bootstrap:         true,
+		},
+		HTTPServiceConfig: config.HTTPServiceConfig{
+			Port:          strconv.Itoa(basePort + 3),
+			DashboardAddr: "127.0.0.1:" + strconv.Itoa(basePort+4),
+		},
+	}
+}
+
+// Start runs the nodes one by one and waits until every node sees all the others
+func (c *Cluster) Start() error {
+	// the global config only provides the port layout, which all nodes share
+	config.SetConfig(c.Config)
+	member_service.SetIntercept(c.network.allow)
+	raft_service.SetIntercept(c.network.allow)
+
+	for _, node := range c.Nodes {
+		if err := c.startNode(node); err != nil {
+			return err
+		}
+	}
+
+	err := c.WaitFor("all nodes to see each other", 10*time.Second, func() bool {
+		for _, node := range c.Nodes {
+			if len(node.Member.GetAliveMemberAddrList()) != len(c.Nodes) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return c.WaitFor("all nodes to join the raft configuration", 10*time.Second, func() bool {
+		for _, node := range c.Nodes {
+			if fmt.Sprint(node.Raft.Peers()) != fmt.Sprint(Addrs(c.Nodes)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// AddNode starts one more node after Start, it joins through the seeds of the cluster config
+func (c *Cluster) AddNode() (*Node, error) {
+	node, err := c.newNode()
+	if err != nil {
+		return nil, err
+	}
+	logger.PrintInfo("Harness: adding", node.Addr)
+	return node, c.startNode(node)
+}
+
+func (c *Cluster) startNode(node *Node) error {
+	node.Member = member_service.NewMemberServerWithConfig(node.Config.MemberServiceConfig)
+	node.Member.Run()
+	node.Raft = raft_service.NewRaftServerWithConfig(node.Member, node.Config.RaftServiceConfig)
+	node.File = file_service.NewFileServerWithConfig(node.Member, node.Raft, node.Config.FileServiceConfig)
+	node.File.Run()
+	node.MapleJuice = maple_juice_service.NewMapleJuiceServerWithConfig(node.File, node.Raft, node.Config.MapleJuiceServiceConfig)
+	node.MapleJuice.Run()
+	node.Raft.Run()
+	node.HTTP = http_service.NewHTTPServerWithConfig(node.Member, node.Config.HTTPServiceConfig)
+	node.HTTP.Run()
+	if addr := node.Config.HTTPServiceConfig.DashboardAddr; addr != "" {
+		node.Dashboard = dashboard.NewDashboard(node.File, node.MapleJuice, addr)
+		node.Dashboard.Run()
+	}
+
+	if node.Index > 0 {
+		if err := node.Member.WaitForJoin(node.Config.MemberServiceConfig.JoinTimeout); err != nil {
+			return fmt.Errorf("node %v: %v", node.Addr, err)
+		}
+	}
+	return nil
+}
+
+// Stop shuts down the nodes that are still running
+func (c *Cluster) Stop() {
+	for _, node := range c.Nodes {
+		if node.Member == nil || node.Crashed {
+			continue
+		}
+		if node.Dashboard != nil {
+			node.Dashboard.Stop(c.Config.ShutdownTimeout)
+		}
+		node.HTTP.Stop(c.Config.ShutdownTimeout)
+		node.MapleJuice.Stop(c.Config.ShutdownTimeout)
+		node.File.Stop(c.Config.ShutdownTimeout)
+		node.Raft.Stop(c.Config.ShutdownTimeout)
+		node.Member.Stop()
+	}
+	member_service.SetIntercept(nil)
+	raft_service.SetIntercept(nil)
+	member_service.SetMessageLossRate(0)
+}
+
+// Crash kills node i without a leave message and cuts it off the network
+func (c *Cluster) Crash(i int) {
+	node := c.Nodes[i]
+	logger.PrintInfo("Harness: crashing", node.Addr)
+	c.network.crash(node.Addr)
+	node.Member.Crash()
+	if node.Dashboard != nil {
+		node.Dashboard.Stop(0)
+	}
+	node.HTTP.Stop(0)
+	node.MapleJuice.Stop(0)
+	node.File.Stop(0)
+	node.Raft.Stop(0)
+	node.Crashed = true
+}
+
+// Partition splits the cluster, members and raft peers only reach nodes of their own group.
+// Nodes not listed in any group form one more group together.
+func (c *Cluster) Partition(groups ...[]int) {
+	assignment := map[string]int{}
+	for g, group := range groups {
+		for _, i := range group {
+			assignment[c.Nodes[i].Addr] = g + 1
+		}
+	}
+	logger.PrintInfo("Harness: partitioning", groups)
+	c.network.partition(assignment)
+}
+
+// CutLink drops the membership messages between nodes i and j only
+func (c *Cluster) CutLink(i int, j int) {
+	logger.PrintInfo("Harness: cutting link", c.Nodes[i].Addr, "<->", c.Nodes[j].Addr)
+	c.network.cutLink(c.Nodes[i].Addr, c.Nodes[j].Addr)
+}
+
+// SlowDown delays every membership message node i sends or receives, like an overloaded
+// machine. Sending blocks for the delay, so the node is also held up meanwhile.
+func (c *Cluster) SlowDown(i int, delay time.Duration) {
+	logger.PrintInfo("Harness: slowing down", c.Nodes[i].Addr, "by", delay)
+	c.network.slowDown(c.Nodes[i].Addr, delay)
+}
+
+// Heal removes any partition and cut link
+func (c *Cluster) Heal() {
+	logger.PrintInfo("Harness: healing partition")
+	c.network.heal()
+}
+
+// Configure changes the member service config of every node, it must be called before Start,
+// or before AddNode for the nodes added later
+func (c *Cluster) Configure(change func(m *config.MemberServiceConfig)) {
+	change(&c.Config.MemberServiceConfig)
+	for _, node := range c.Nodes {
+		change(&node.Config.MemberServiceConfig)
+	}
+}
+
+// SetMessageLoss drops the given fraction of membership messages, zero drops none
+func (c *Cluster) SetMessageLoss(rate float64) {
+	member_service.SetMessageLossRate(rate)
+}
+
+// Alive returns the nodes that have not been crashed
+func (c *Cluster) Alive() []*Node {
+	alive := make([]*Node, 0)
+	for _, node := range c.Nodes {
+		if !node.Crashed {
+			alive = append(alive, node)
+		}
+	}
+	return alive
+}
+
+// Addrs returns the sorted addresses of nodes
+func Addrs(nodes []*Node) []string {
+	addrs := make([]string, 0)
+	for _, node := range nodes {
+		addrs = append(addrs, node.Addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// NodeByAddr finds a node from its member address
+func (c *Cluster) NodeByAddr(addr string) *Node {
+	for _, node := range c.Nodes {
+		if node.Addr == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// WaitFor polls cond until it holds or timeout expires
+func (c *Cluster) WaitFor(what string, timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for " + what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// WaitForLeader waits until nodes follow the same master among them and returns it with its term
+func (c *Cluster) WaitForLeader(nodes []*Node, what string) (string, int64, error) {
+	memberConfig := c.Config.MemberServiceConfig
+	addrs := map[string]bool{}
+	for _, node := range nodes {
+		addrs[node.Addr] = true
+	}
+	var leader string
+	var term int64
+	err := c.WaitFor(what, 3*memberConfig.FailTime+3*c.Config.RaftServiceConfig.ElectionTimeout+memberConfig.RemoveTime, func() bool {
+		leader, term = nodes[0].Member.Leader()
+		if !addrs[leader] {
+			return false
+		}
+		for _, node := range nodes {
+			nodeLeader, nodeTerm := node.Member.Leader()
+			if nodeLeader != leader || nodeTerm != term {
+				return false
+			}
+		}
+		return true
+	})
+	return leader, term, err
+}
+
+// HTTPEndpoint is the http endpoint node advertises to node 0
+func (c *Cluster) HTTPEndpoint(node *Node) (string, error) {
+	for _, member := range c.Nodes[0].Member.Members() {
+		if member.Addr == node.Addr && member.Endpoints[member_service.EndpointHTTP] != "" {
+			return member.Endpoints[member_service.EndpointHTTP], nil
+		}
+	}
+	return "", fmt.Errorf("%v advertises no http endpoint", node.Addr)
+}
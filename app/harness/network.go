@@ -0,0 +1,70 @@
+package harness
+
+import (
+	"sync"
+	"time"
+)
+
+// network decides which membership messages are delivered between nodes,
+// it is installed with member_service.SetIntercept while a cluster runs
+type network struct {
+	mux     sync.Mutex
+	crashed map[string]bool
+	groups  map[string]int // node address -> partition group, nil when healed
+	cut     map[[2]string]bool
+	delay   map[string]time.Duration // node address -> delay of everything it sends or receives
+}
+
+func newNetwork() *network {
+	return &network{
+		crashed: map[string]bool{},
+		cut:     map[[2]string]bool{},
+		delay:   map[string]time.Duration{},
+	}
+}
+
+func (n *network) allow(from string, dest string) bool {
+	n.mux.Lock()
+	delay := n.delay[from] + n.delay[dest]
+	allowed := !n.crashed[from] && !n.crashed[dest] &&
+		(n.groups == nil || n.groups[from] == n.groups[dest]) &&
+		!n.cut[[2]string{from, dest}]
+	n.mux.Unlock()
+
+	// the sender is held up, as if an overloaded process was slow to send or to read
+	time.Sleep(delay)
+	return allowed
+}
+
+func (n *network) crash(addr string) {
+	n.mux.Lock()
+	n.crashed[addr] = true
+	n.mux.Unlock()
+}
+
+func (n *network) partition(groups map[string]int) {
+	n.mux.Lock()
+	n.groups = groups
+	n.mux.Unlock()
+}
+
+// cutLink drops messages between a and b in both directions
+func (n *network) cutLink(a string, b string) {
+	n.mux.Lock()
+	n.cut[[2]string{a, b}] = true
+	n.cut[[2]string{b, a}] = true
+	n.mux.Unlock()
+}
+
+func (n *network) slowDown(addr string, delay time.Duration) {
+	n.mux.Lock()
+	n.delay[addr] = delay
+	n.mux.Unlock()
+}
+
+func (n *network) heal() {
+	n.mux.Lock()
+	n.groups = nil
+	n.cut = map[[2]string]bool{}
+	n.mux.Unlock()
+}
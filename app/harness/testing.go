@@ -0,0 +1,154 @@
+package harness
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/logger"
+	"better_mp3/app/sandbox"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Options describe the cluster of a test
+type Options struct {
+	Size     int
+	Strategy string // membership strategy, all when empty
+	Detector string // failure detector of the all and gossip strategies, timeout when empty
+	// BufferSize overrides the size of membership messages, so that the membership list of a
+	// small cluster doesn't fit in one message
+	BufferSize int
+	// Keys are the cluster keys the membership messages are sealed with, Encrypt encrypts them too
+	Keys    []string
+	Encrypt bool
+	// TLS secures the rpc services with mutual tls
+	TLS bool
+	// Users are the users of the first nodes, the others act as "harness"
+	Users []string
+	// MessageLoss is the fraction of membership messages dropped from the start, joins included
+	MessageLoss float64
+}
+
+// Main runs the tests of a package that start clusters, call it from TestMain. The sandbox starts
+// maple and juice executables through the test binary, and the nodes log to a file that is kept
+// when a test fails.
+func Main(m *testing.M) {
+	sandbox.Init()
+	dir, err := ioutil.TempDir("", "better_mp3-log-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	path := filepath.Join(dir, "nodes.log")
+	if err := logger.Configure(config.LogConfig{Path: path, Level: "info", Format: config.LOG_LOGFMT}); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open the log:", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	logger.Close()
+	if code != 0 {
+		fmt.Println("The log of the nodes is kept in", path)
+	} else {
+		_ = os.RemoveAll(dir)
+	}
+	os.Exit(code)
+}
+
+// Start runs a cluster for a test and stops it when the test ends. The directory of a failed
+// test is kept for inspection.
+func Start(t testing.TB, options Options) *Cluster {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "better_mp3-"+strings.ReplaceAll(t.Name(), "/", "-")+"-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nodes added by the test get ports too
+	basePort, err := freeBasePort(options.Size + 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCluster(options.Size, basePort, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Stop()
+		if t.Failed() {
+			t.Log("The files of the cluster are kept in", dir)
+		} else {
+			_ = os.RemoveAll(dir)
+		}
+	})
+
+	if options.TLS {
+		if err := c.EnableTLS(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, user := range options.Users {
+		c.Nodes[i].Config.FileServiceConfig.User = user
+	}
+	c.Configure(func(m *config.MemberServiceConfig) {
+		if options.Strategy != "" {
+			m.Strategy = options.Strategy
+		}
+		if options.Detector != "" {
+			m.Detector = options.Detector
+		}
+		if options.BufferSize != 0 {
+			m.BufferSize = options.BufferSize
+		}
+		m.Keys = options.Keys
+		m.Encrypt = options.Encrypt
+	})
+	c.SetMessageLoss(options.MessageLoss)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Run runs check as a subtest named name, against a cluster started for it
+func Run(t *testing.T, name string, options Options, check func(c *Cluster) error) {
+	t.Run(name, func(t *testing.T) {
+		c := Start(t, options)
+		if err := check(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// freeBasePort picks a member port for the first of size nodes such that the ports of all of
+// them are free, so that the tests of several packages can run at the same time
+func freeBasePort(size int) (int, error) {
+	for attempt := 0; attempt < 100; attempt++ {
+		basePort := 20000 + 10*rand.Intn(3000)
+		if portsFree(basePort-1, basePort+10*(size-1)+3) {
+			return basePort, nil
+		}
+	}
+	return 0, errors.New("no free ports for the cluster")
+}
+
+func portsFree(first int, last int) bool {
+	for port := first; port <= last; port++ {
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			return false
+		}
+		packetConn, err := net.ListenPacket("udp", ":"+strconv.Itoa(port))
+		_ = listener.Close()
+		if err != nil {
+			return false
+		}
+		_ = packetConn.Close()
+	}
+	return true
+}
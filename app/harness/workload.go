@@ -0,0 +1,116 @@
+package harness
+
+import (
+	"better_mp3/app/maple_juice_service"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WordcountInput is the input file "words" of the wordcount job
+const WordcountInput = `the quick brown fox jumps over the lazy dog
+the dog barks
+a fox is quick and the dog is lazy
+`
+
+const mapleWordcount = `#!/bin/sh
+tr -s ' \t' '\n\n' | grep -v '^$' | awk '{print $1" 1"}'
+`
+
+const juiceWordcount = `#!/bin/sh
+awk '{c[$1]++} END {for (k in c) print k" "c[k]}' "${1:--}"
+`
+
+// WriteWordcount writes the input "words" and the executables maple_wordcount and juice_wordcount
+// where the nodes upload them from
+func (c *Cluster) WriteWordcount() error {
+	mjConfig := c.Config.MapleJuiceServiceConfig
+	for name, content := range map[string]string{
+		filepath.Join(mjConfig.InputDir, "words"):          WordcountInput,
+		filepath.Join(mjConfig.ExecDir, "maple_wordcount"): mapleWordcount,
+		filepath.Join(mjConfig.ExecDir, "juice_wordcount"): juiceWordcount,
+	} {
+		if err := ioutil.WriteFile(name, []byte(content), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunWordcount runs a maple and a juice job from node 0 that count the words of the input, and
+// checks the counts and the job records of every node
+func (c *Cluster) RunWordcount() error {
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	master := c.Nodes[0].MapleJuice
+	if err := master.ScheduleMapleTask([]string{"maple", "maple_wordcount", "3", "wc", "words"}); err != nil {
+		return err
+	}
+	if err := master.ScheduleJuiceTask([]string{"juice", "juice_wordcount", "2", "wc_", "wc-result"}); err != nil {
+		return err
+	}
+
+	output := filepath.Join(c.Dir, "wc-result.txt")
+	if err := c.Nodes[1].File.RemoteGet(c.Nodes[1].File.User(), "wc-result", output); err != nil {
+		return err
+	}
+	result, err := ioutil.ReadFile(output)
+	if err != nil {
+		return err
+	}
+
+	got := map[string]int{}
+	for _, line := range strings.Split(string(result), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("bad juice output line %q", line)
+		}
+		got[fields[0]] += n
+	}
+	expected := map[string]int{}
+	for _, word := range strings.Fields(WordcountInput) {
+		expected[word]++
+	}
+	if !reflect.DeepEqual(got, expected) {
+		return fmt.Errorf("word counts %v, expected %v", got, expected)
+	}
+
+	return c.WaitFor("both jobs to be recorded on every node", 5*time.Second, func() bool {
+		for _, node := range c.Nodes {
+			jobs := node.MapleJuice.Jobs()
+			if len(jobs) != 2 {
+				return false
+			}
+			for _, job := range jobs {
+				if job.State != maple_juice_service.JobDone {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// PutFrom puts a small file from node, which must be listed in its file table afterwards
+func (c *Cluster) PutFrom(node *Node, name string) error {
+	local := filepath.Join(c.Dir, name+".txt")
+	if err := ioutil.WriteFile(local, []byte(name+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := node.File.RemotePut(node.File.User(), local, name); err != nil {
+		return fmt.Errorf("put on %v: %v", node.Addr, err)
+	}
+	if len(node.File.FileTable.ListLocations(name)) == 0 {
+		return fmt.Errorf("%v is not in the file table of %v", name, node.Addr)
+	}
+	return nil
+}
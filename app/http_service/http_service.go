@@ -0,0 +1,84 @@
+/*
+This package is the http server of a node, for what is read over http rather than net/rpc:
+/metrics serves the metrics of the node in the Prometheus format. Other services add their pages
+with Handle before Run. The port is advertised as the http endpoint of the member, so that the
+other members know where to scrape it. An empty http_service.port turns the server off. The
+dashboard isn't served here but on a listener of its own, see package dashboard.
+*/
+package http_service
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/logger"
+	"better_mp3/app/member_service"
+	"better_mp3/app/metrics"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+var httpLog = logger.New(logger.ComponentHTTP)
+
+type HTTPServer struct {
+	config config.HTTPServiceConfig
+	ms     *member_service.MemberServer
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+func NewHTTPServer(memberService *member_service.MemberServer) *HTTPServer {
+	return NewHTTPServerWithConfig(memberService, config.GetHTTPServiceConfig())
+}
+
+// NewHTTPServerWithConfig doesn't read the global config, so that several
+// http servers can run in one process
+func NewHTTPServerWithConfig(memberService *member_service.MemberServer, httpConfig config.HTTPServiceConfig) *HTTPServer {
+	hs := &HTTPServer{
+		config: httpConfig,
+		ms:     memberService,
+		mux:    http.NewServeMux(),
+	}
+	hs.mux.Handle("/metrics", metrics.Handler(memberService.SelfAddr))
+	return hs
+}
+
+// Handle adds a page, it must be called before Run
+func (hs *HTTPServer) Handle(pattern string, handler http.Handler) {
+	hs.mux.Handle(pattern, handler)
+}
+
+func (hs *HTTPServer) Run() {
+	if hs.config.Port == "" {
+		httpLog.Info("HTTP Service is off")
+		return
+	}
+	listener, err := net.Listen("tcp", ":"+hs.config.Port)
+	if err != nil {
+		log.Fatal("Failed to listen on port ", hs.config.Port)
+	}
+	hs.server = &http.Server{Handler: hs.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := hs.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			httpLog.Error("HTTP server failed:", err)
+		}
+	}()
+	hs.ms.AdvertiseEndpoint(member_service.EndpointHTTP, hs.config.Port)
+
+	httpLog.Info("HTTP Service is now running on port " + hs.config.Port)
+}
+
+// Stop waits up to timeout for the requests being served, then closes their connections
+func (hs *HTTPServer) Stop(timeout time.Duration) {
+	if hs.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := hs.server.Shutdown(ctx); err != nil {
+		_ = hs.server.Close()
+	}
+	httpLog.Info("HTTP service stopped")
+}
@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"better_mp3/app/command"
+	"errors"
+	"strings"
+)
+
+// HandleLog shows and changes the log levels, and rotates the log file:
+// log | log level [<component>] <level> | log rotate
+func HandleLog(command command.Command) error {
+	usage := errors.New("usage: log | log level [<component>] <level> | log rotate")
+	params := command.Params
+	switch {
+	case len(params) == 0:
+		defaultLevel, componentLevels := Levels()
+		lines := []string{"default: " + defaultLevel.String()}
+		for _, component := range componentNames(componentLevels) {
+			lines = append(lines, component+": "+componentLevels[component].String())
+		}
+		PrintToConsole(strings.Join(lines, "\n"))
+	case (len(params) == 2 || len(params) == 3) && params[0] == "level":
+		newLevel, err := ParseLevel(params[len(params)-1])
+		if err != nil {
+			return err
+		}
+		component := ""
+		if len(params) == 3 {
+			component = params[1]
+		}
+		SetLevel(component, newLevel)
+	case len(params) == 1 && params[0] == "rotate":
+		return Rotate()
+	default:
+		return usage
+	}
+	return nil
+}
@@ -1,80 +1,367 @@
 /*
-This package contains logger-related utility.
+This package is the log of a node. Every record is one line, in logfmt or JSON, with the time, the
+level, the component that logged it, the message and the fields of the component's logger. The
+services log through loggers of their own, member, file, maplejuice, raft and http, everything else
+through the main component, which also takes what the standard log package writes. The same line
+goes to the log file and, colored by its level, to the console.
+
+Records below the level of their component are dropped. log.level and log.levels set the levels,
+SetLevel and the log command change them while the node runs. Until Configure is called
+records only go to the console, at level info.
 */
 package logger
 
 import (
 	"better_mp3/app/config"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
-var (
-	// InfoLogger : logging for info messages
-	InfoLogger *log.Logger
+type Level int32
 
-	// WarningLogger : logging for warning messages
-	WarningLogger *log.Logger
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
 
-	// ErrorLogger : logging for error messages
-	ErrorLogger *log.Logger
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return config.LogLevels[l]
+}
 
-	// ErrorLogger : logging for error messages
-	DebugLogger *log.Logger
+// ParseLevel reads one of config.LogLevels
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range config.LogLevels {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected one of %v", name, strings.Join(config.LogLevels, ", "))
+}
 
-	LogFile *os.File
+// the components of the services
+const (
+	ComponentMain       = "main"
+	ComponentMember     = "member"
+	ComponentFile       = "file"
+	ComponentMapleJuice = "maplejuice"
+	ComponentRaft       = "raft"
+	ComponentHTTP       = "http"
+)
 
-	TimeFormat = "2006-01-02 15:04:05"
+var (
+	mux        sync.Mutex
+	configured config.LogConfig
+	file       *rotatingFile // nil until Configure opens one
+	console    = true
+	format     = config.LOG_LOGFMT
+	level      = LevelInfo
+	levels     = map[string]Level{} // by component, overriding level
+	colorize   = map[Level]func(string) string{LevelInfo: green, LevelWarn: yellow, LevelError: red}
 )
 
-func init() {
-	LogFile, err := os.OpenFile("logs.txt", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatal(err)
+// Logger writes the records of a component
+type Logger struct {
+	component string
+	fields    []interface{} // key, value, ...
+}
+
+// New returns the logger of a component
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a logger that adds fields, given as key, value pairs, to every record
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	return &Logger{component: l.component, fields: append(append(fields, l.fields...), keyValues...)}
+}
+
+// Enabled tells whether records of level are written
+func (l *Logger) Enabled(recordLevel Level) bool {
+	mux.Lock()
+	defer mux.Unlock()
+	return recordLevel >= componentLevel(l.component)
+}
+
+// Log writes a record with a message and fields given as key, value pairs
+func (l *Logger) Log(recordLevel Level, msg string, keyValues ...interface{}) {
+	if !l.Enabled(recordLevel) {
+		return
 	}
+	now := time.Now()
+	fields := append(append(make([]interface{}, 0, len(l.fields)+len(keyValues)), l.fields...), keyValues...)
 
-	InfoLogger 		= log.New(LogFile, "[INFO]", log.Ldate|log.Ltime|log.Lshortfile)
-	WarningLogger 	= log.New(LogFile, "[WARNING]", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger 	= log.New(LogFile, "[ERROR]", log.Ldate|log.Ltime|log.Lshortfile)
-	DebugLogger 	= log.New(LogFile, "[DEBUG]", log.Ldate|log.Ltime|log.Lshortfile)
+	mux.Lock()
+	defer mux.Unlock()
+	line := formatRecord(now, recordLevel, l.component, msg, fields)
+	if file != nil {
+		if err := file.Write(line); err != nil {
+			fmt.Fprintln(os.Stderr, "logger:", err)
+		}
+	}
+	if console {
+		if color, ok := colorize[recordLevel]; ok {
+			fmt.Println(color(string(bytes.TrimSuffix(line, []byte("\n")))))
+		} else {
+			fmt.Print(string(line))
+		}
+	}
 }
 
-func getTimeString() string {
-	return "(" + strings.Split(time.Now().Format(TimeFormat), " ")[1] + ")"
+// the message of the print functions, their arguments joined as by fmt.Println
+func message(args []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
 }
 
+func (l *Logger) Debug(args ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.Log(LevelDebug, message(args))
+	}
+}
+
+func (l *Logger) Info(args ...interface{}) {
+	l.Log(LevelInfo, message(args))
+}
+
+func (l *Logger) Warn(args ...interface{}) {
+	l.Log(LevelWarn, message(args))
+}
+
+func (l *Logger) Error(args ...interface{}) {
+	l.Log(LevelError, message(args))
+}
+
+// Main is the logger of everything but the services
+var Main = New(ComponentMain)
+
+func PrintInfo(args ...interface{}) {
+	Main.Info(args...)
+}
+
+func PrintWarning(args ...interface{}) {
+	Main.Warn(args...)
+}
+
+func PrintError(args ...interface{}) {
+	Main.Error(args...)
+}
+
+func PrintDebug(args ...interface{}) {
+	Main.Debug(args...)
+}
+
+// PrintToConsole shows the output of a command, it is not logged
 func PrintToConsole(args ...interface{}) {
 	fmt.Print(blue(fmt.Sprintln(args...)))
 }
 
-func PrintInfo(args ...interface{}) {
-	InfoLogger.Println(args)
+// Configure applies the log section of the config, the log file is opened for appending
+func Configure(c config.LogConfig) error {
+	defaultLevel, err := ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	componentLevels := map[string]Level{}
+	for component, name := range c.Levels {
+		if componentLevels[component], err = ParseLevel(name); err != nil {
+			return err
+		}
+	}
+	var opened *rotatingFile
+	if c.Path != "" {
+		if opened, err = openRotating(c.Path, int64(c.MaxSizeMB)<<20, c.RotateEvery, c.MaxBackups); err != nil {
+			return err
+		}
+	}
+
+	mux.Lock()
+	if file != nil {
+		file.Close()
+	}
+	configured = c
+	file = opened
+	console = c.Console
+	format = c.Format
+	level = defaultLevel
+	levels = componentLevels
+	mux.Unlock()
 
-	fmt.Print(green("[INFO] " + getTimeString() + " "))
-	fmt.Print(green(fmt.Sprintln(args...)))
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+	return nil
 }
 
-func PrintWarning(args ...interface{}) {
-	WarningLogger.Println(args)
+// Config returns the config last applied by Configure
+func Config() config.LogConfig {
+	mux.Lock()
+	defer mux.Unlock()
+	return configured
+}
 
-	fmt.Print(yellow("[WARN] " + getTimeString() + " "))
-	fmt.Print(yellow(fmt.Sprintln(args...)))
+// SetLevel changes the level of a component, or the default level of all components without
+// one of their own when component is empty
+func SetLevel(component string, newLevel Level) {
+	mux.Lock()
+	defer mux.Unlock()
+	if component == "" {
+		level = newLevel
+		return
+	}
+	updated := make(map[string]Level, len(levels)+1)
+	for name, l := range levels {
+		updated[name] = l
+	}
+	updated[component] = newLevel
+	levels = updated
 }
 
-func PrintError(args ...interface{}) {
-	ErrorLogger.Println(args)
+// Levels returns the default level and the levels of the components that have one of their own
+func Levels() (Level, map[string]Level) {
+	mux.Lock()
+	defer mux.Unlock()
+	return level, levels
+}
 
-	fmt.Print(red("[ERROR]" + getTimeString() + " "))
-	fmt.Print(red(fmt.Sprintln(args...)))
+// componentLevel is the level of a component, the caller holds mux
+func componentLevel(component string) Level {
+	if l, ok := levels[component]; ok {
+		return l
+	}
+	return level
 }
 
-func PrintDebug(args ...interface{}) {
-	ErrorLogger.Println(args)
-	if config.DebugMode {
-		fmt.Print(yellow("[DEBUG]" + getTimeString() + " "))
-		fmt.Println(args...)
+// Close flushes the log file, it should be the last thing called before exiting
+func Close() {
+	mux.Lock()
+	defer mux.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+}
+
+// stdWriter turns the lines of the standard log package, such as those of net/rpc, into records
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	Main.Log(LevelWarn, strings.TrimSuffix(string(p), "\n"), "source", "log")
+	return len(p), nil
+}
+
+func formatRecord(t time.Time, recordLevel Level, component string, msg string, fields []interface{}) []byte {
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+	var line bytes.Buffer
+	if format == config.LOG_JSON {
+		line.WriteString(`{"time":`)
+		writeJSON(&line, t.Format(time.RFC3339Nano))
+		line.WriteString(`,"level":`)
+		writeJSON(&line, recordLevel.String())
+		line.WriteString(`,"component":`)
+		writeJSON(&line, component)
+		line.WriteString(`,"msg":`)
+		writeJSON(&line, msg)
+		for i := 0; i < len(fields); i += 2 {
+			line.WriteByte(',')
+			writeJSON(&line, fmt.Sprint(fields[i]))
+			line.WriteByte(':')
+			writeJSON(&line, fieldValue(fields[i+1]))
+		}
+		line.WriteString("}\n")
+		return line.Bytes()
+	}
+
+	line.WriteString("time=" + t.Format(time.RFC3339Nano))
+	line.WriteString(" level=" + recordLevel.String())
+	line.WriteString(" component=" + logfmtValue(component))
+	line.WriteString(" msg=" + logfmtValue(msg))
+	for i := 0; i < len(fields); i += 2 {
+		line.WriteString(" " + logfmtKey(fmt.Sprint(fields[i])) + "=" + logfmtValue(fmt.Sprint(fieldValue(fields[i+1]))))
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}
+
+// fieldValue is how a field is written: errors and stringers by their text
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case time.Duration:
+		return v.String()
+	}
+	return value
+}
+
+func writeJSON(line *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(encoded)
+}
+
+// logfmtValue quotes values that are empty or hold spaces, quotes, '=' or control characters
+func logfmtValue(value string) string {
+	if value == "" || strings.IndexFunc(value, func(r rune) bool {
+		return r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// logfmtKey replaces the characters keys can't hold
+func logfmtKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, key)
+	if key == "" {
+		return "_"
+	}
+	return key
+}
+
+// ErrNoLogFile is returned by operations that need the log file when only the console is logged to
+var ErrNoLogFile = errors.New("no log file is configured")
+
+// Rotate rotates the log file now
+func Rotate() error {
+	mux.Lock()
+	defer mux.Unlock()
+	if file == nil {
+		return ErrNoLogFile
+	}
+	return file.rotate(time.Now())
+}
+
+// componentNames returns the components with a level of their own, sorted
+func componentNames(levels map[string]Level) []string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,136 @@
+package logger_test
+
+import (
+	"better_mp3/app/command"
+	"better_mp3/app/config"
+	"better_mp3/app/logger"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configure applies logConfig for the rest of the test
+func configure(t *testing.T, logConfig config.LogConfig) {
+	saved := logger.Config()
+	t.Cleanup(func() {
+		_ = logger.Configure(saved)
+	})
+	if err := logger.Configure(logConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLog(t *testing.T, path string) []byte {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// records are single structured lines filtered by the level of their component, and the levels
+// change at runtime
+func TestRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	configure(t, config.LogConfig{
+		Path:   path,
+		Level:  "info",
+		Levels: map[string]string{logger.ComponentMember: "warn"},
+		Format: config.LOG_LOGFMT,
+	})
+
+	fileLog := logger.New(logger.ComponentFile).With("node", "127.0.0.1:8000")
+	memberLog := logger.New(logger.ComponentMember)
+	fileLog.Debug("hidden debug record")
+	fileLog.Info("stored", "x y")
+	fileLog.Log(logger.LevelWarn, "replica lost", "file", "a b", "replicas", 2)
+	memberLog.Info("hidden member record")
+	log.Println("from the standard log")
+	if err := logger.HandleLog(command.Command{Method: command.Log, Params: []string{"level", logger.ComponentMember, "debug"}}); err != nil {
+		t.Fatal(err)
+	}
+	memberLog.Debug("member debug record")
+
+	content := readLog(t, path)
+	for _, want := range []string{
+		`level=info component=file msg="stored x y" node=127.0.0.1:8000`,
+		`level=warn component=file msg="replica lost" node=127.0.0.1:8000 file="a b" replicas=2`,
+		`level=warn component=main msg="from the standard log" source=log`,
+		`level=debug component=member msg="member debug record"`,
+	} {
+		if !bytes.Contains(content, []byte(want+"\n")) {
+			t.Errorf("no record %q in the log:\n%s", want, content)
+		}
+	}
+	if bytes.Contains(content, []byte("hidden")) {
+		t.Errorf("records below the level of their component were written:\n%s", content)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if _, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(fields[0], "time=")); err != nil {
+			t.Errorf("record without a time: %q", line)
+		}
+	}
+}
+
+// configuring the log again appends to the file, in the new format
+func TestConfigureAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	logConfig := config.LogConfig{Path: path, Level: "info", Format: config.LOG_LOGFMT}
+	configure(t, logConfig)
+	fileLog := logger.New(logger.ComponentFile).With("node", "127.0.0.1:8000")
+	fileLog.Info("stored", "x y")
+
+	logConfig.Format = config.LOG_JSON
+	configure(t, logConfig)
+	fileLog.Log(logger.LevelError, "json record", "error", errors.New("boom"), "replicas", 3)
+
+	content := readLog(t, path)
+	if !bytes.Contains(content, []byte(`msg="stored x y"`)) {
+		t.Fatalf("configuring the log truncated it:\n%s", content)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &record); err != nil {
+		t.Fatalf("invalid json record %q: %v", lines[len(lines)-1], err)
+	}
+	if record["level"] != "error" || record["component"] != logger.ComponentFile || record["msg"] != "json record" ||
+		record["node"] != "127.0.0.1:8000" || record["error"] != "boom" || record["replicas"] != 3.0 {
+		t.Errorf("unexpected json record %v", record)
+	}
+}
+
+// 3MB of records rotate the file at 1MB, only the two newest backups are kept
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "node.log")
+	configure(t, config.LogConfig{Path: path, Level: "info", Format: config.LOG_LOGFMT, MaxSizeMB: 1, MaxBackups: 2})
+
+	fileLog := logger.New(logger.ComponentFile)
+	padding := strings.Repeat("x", 1000)
+	for i := 0; i < 3000; i++ {
+		fileLog.Info("filler", i, padding)
+	}
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 rotated files, found %v", backups)
+	}
+	for _, name := range append(backups, path) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1<<20 {
+			t.Errorf("%v grew to %v bytes, beyond max_size_mb", name, info.Size())
+		}
+	}
+}
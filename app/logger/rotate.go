@@ -0,0 +1,120 @@
+package logger
+
+/*
+The log file is only appended to. It is rotated when a record would make it larger than maxSize,
+and when a record is the first one of a new period of rotateEvery since the epoch, so that a day
+starts a new file at midnight UTC even across restarts. Rotating renames the file with the time it
+was rotated at, which sorts the backups by age, and removes the oldest backups beyond maxBackups.
+*/
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the suffix of rotated files, which sorts by time
+const backupTimeFormat = "20060102-150405.000000000"
+
+type rotatingFile struct {
+	path        string
+	maxSize     int64         // 0 for no limit
+	rotateEvery time.Duration // 0 for never
+	maxBackups  int           // 0 keeps all
+
+	file      *os.File
+	size      int64
+	lastWrite time.Time
+}
+
+func openRotating(path string, maxSize int64, rotateEvery time.Duration, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, rotateEvery: rotateEvery, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.lastWrite = info.ModTime()
+	return nil
+}
+
+func (f *rotatingFile) Write(line []byte) error {
+	now := time.Now()
+	if f.size > 0 && (f.maxSize > 0 && f.size+int64(len(line)) > f.maxSize ||
+		f.rotateEvery > 0 && now.Truncate(f.rotateEvery).After(f.lastWrite.Truncate(f.rotateEvery))) {
+		if err := f.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	f.lastWrite = now
+	return err
+}
+
+// rotate moves the file aside and starts a new one
+func (f *rotatingFile) rotate(now time.Time) error {
+	f.file.Close()
+	renameErr := os.Rename(f.path, f.path+"."+now.Format(backupTimeFormat))
+	// a new file is opened even if the old one couldn't be moved, so that logging goes on
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	return f.prune()
+}
+
+// prune removes the oldest backups beyond maxBackups
+func (f *rotatingFile) prune() error {
+	if f.maxBackups == 0 {
+		return nil
+	}
+	dir, base := filepath.Split(f.path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		suffix := strings.TrimPrefix(entry.Name(), base+".")
+		if suffix == entry.Name() || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (f *rotatingFile) Close() {
+	_ = f.file.Sync()
+	_ = f.file.Close()
+}
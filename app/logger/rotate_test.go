@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func openTestFile(t *testing.T, maxSize int64, rotateEvery time.Duration, maxBackups int) (*rotatingFile, string) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	f, err := openRotating(path, maxSize, rotateEvery, maxBackups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Close)
+	return f, path
+}
+
+func write(t *testing.T, f *rotatingFile, line string) {
+	if err := f.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func backups(t *testing.T, path string) []string {
+	names, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func content(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// a line that doesn't fit starts a new file, a line longer than the limit still gets written
+func TestRotateSize(t *testing.T) {
+	f, path := openTestFile(t, 10, 0, 0)
+	write(t, f, "12345\n")
+	write(t, f, "1234\n")
+	write(t, f, "123\n")
+	write(t, f, "a line longer than the limit\n")
+
+	names := backups(t, path)
+	if len(names) != 2 || content(t, names[0]) != "12345\n" || content(t, names[1]) != "1234\n123\n" {
+		t.Fatalf("unexpected backups %v", names)
+	}
+	if got := content(t, path); got != "a line longer than the limit\n" {
+		t.Errorf("the log holds %q", got)
+	}
+}
+
+// the first line of a new period starts a new file
+func TestRotatePeriod(t *testing.T) {
+	f, path := openTestFile(t, 0, time.Hour, 0)
+	write(t, f, "today\n")
+	write(t, f, "still today\n")
+	if names := backups(t, path); len(names) != 0 {
+		t.Fatalf("rotated within a period: %v", names)
+	}
+
+	f.lastWrite = f.lastWrite.Add(-time.Hour)
+	write(t, f, "the next hour\n")
+	names := backups(t, path)
+	if len(names) != 1 || content(t, names[0]) != "today\nstill today\n" || content(t, path) != "the next hour\n" {
+		t.Errorf("unexpected rotation to %v", names)
+	}
+}
+
+// only the newest maxBackups backups are kept, other files are left alone
+func TestPrune(t *testing.T) {
+	f, path := openTestFile(t, 1, 0, 2)
+	dir := filepath.Dir(path)
+	unrelated := []string{"node.log.old", "other.log.20200101-000000.000000000", "node.logs"}
+	for _, name := range unrelated {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, line := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		write(t, f, line)
+	}
+	// node.log.old sorts after the backups
+	names := backups(t, path)
+	if len(names) != 3 || content(t, names[0]) != "3\n" || content(t, names[1]) != "4\n" {
+		t.Errorf("unexpected backups %v", names)
+	}
+	for _, name := range unrelated {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("an unrelated file was removed: %v", err)
+		}
+	}
+}
+
+// reopening the log appends to it, and counts what it holds towards the size limit
+func TestReopen(t *testing.T) {
+	f, path := openTestFile(t, 10, 0, 0)
+	write(t, f, "12345\n")
+	f.Close()
+
+	f, err := openRotating(path, 10, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	write(t, f, "123\n")
+	write(t, f, "1\n")
+	if got := content(t, path); got != "1\n" || len(backups(t, path)) != 1 {
+		t.Errorf("the log holds %q, backups %v", got, backups(t, path))
+	}
+	if got := content(t, backups(t, path)[0]); got != "12345\n123\n" {
+		t.Errorf("the reopened log was not appended to, it holds %q", got)
+	}
+}
@@ -3,97 +3,259 @@ package main
 import (
 	"better_mp3/app/command"
 	"better_mp3/app/config"
+	"better_mp3/app/dashboard"
 	"better_mp3/app/file_service"
+	"better_mp3/app/http_service"
 	"better_mp3/app/logger"
 	"better_mp3/app/maple_juice_service"
 	"better_mp3/app/member_service"
+	"better_mp3/app/raft_service"
+	"better_mp3/app/sandbox"
+	"better_mp3/app/tracing"
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 )
 
 var (
 	memberService    *member_service.MemberServer
+	raftService      *raft_service.RaftServer
 	fileService      *file_service.FileServer
 	maplejuiceServer *maple_juice_service.MapleJuiceServer
+	httpService      *http_service.HTTPServer
+	dashboardServer  *dashboard.Dashboard
+
+	shutdownOnce sync.Once
 )
 
 func HandleCommand() {
 	inputReader := bufio.NewReader(os.Stdin)
 	for {
-		userInput, _ := inputReader.ReadString('\n')
-		userInputs := strings.Split(strings.TrimSpace(userInput), " ")
-		userCommand := command.Command{
-			Method: userInputs[0],
-			Params: userInputs[1:],
-		}
-
-		switch userCommand.Method {
-
-		// member related commands
-		case command.Join:
-			memberService.HandleJoin(userCommand)
-		case command.Display:
-			memberService.HandleDisplay(userCommand)
-		case command.Switch:
-			memberService.HandleSwitch(userCommand)
-		case command.Leave:
-			memberService.HandleLeave(userCommand)
-
-		// file related commands
-		case command.Put:
-			if len(userInputs) == 3 {
-				fileService.RemotePut(userInputs[1], userInputs[2])
-			}
-		case command.Get:
-			if len(userInputs) == 3 {
-				fileService.RemoteGet(userInputs[1], userInputs[2])
-			}
-		case command.Delete:
-			if len(userInputs) == 2 {
-				fileService.RemoteDelete(userInputs[1])
-			}
-		case command.Store:
-			fileService.FileTable.ListMyFiles()
-		case command.List:
-			fmt.Println(fileService.FileTable.ListLocations(userInputs[1]))
-		case "all":
-			fileService.FileTable.ListAllFiles()
-
-		// maple juice relate functions
-		case command.Maple:
-			if len(userInputs) == 5 {
-				maplejuiceServer.ScheduleMapleTask(userInputs)
-			}
-		case command.Juice:
-			if len(userInputs) == 5 || len(userInputs) == 6 {
-				maplejuiceServer.ScheduleJuiceTask(userInputs)
-			}
-
-		default:
-			logger.PrintWarning("Invalid userInputs.")
+		userInput, err := inputReader.ReadString('\n')
+		if err == io.EOF && userInput == "" {
+			// no terminal attached, keep serving until quit by a signal
+			select {}
+		}
+		userCommand := command.Parse(userInput)
+		if userCommand.Method == "" {
+			continue
+		}
+
+		err = ExecuteCommand(userCommand)
+		if err != nil {
+			logger.PrintError(err)
+		}
+	}
+}
+
+// ExecuteCommand runs one command to completion and reports whether it failed
+func ExecuteCommand(userCommand command.Command) error {
+	userInputs := append([]string{userCommand.Method}, userCommand.Params...)
+
+	switch userCommand.Method {
+
+	// member related commands
+	case command.Join:
+		return memberService.HandleJoin(userCommand)
+	case command.Display:
+		return memberService.HandleDisplay(userCommand)
+	case command.Tag:
+		return memberService.HandleTag(userCommand)
+	case command.Key:
+		return memberService.HandleKey(userCommand)
+	case command.Switch:
+		return memberService.HandleSwitch(userCommand)
+	case command.Leave:
+		return memberService.HandleLeave(userCommand)
+	case command.Wait:
+		return memberService.HandleWait(userCommand)
+	case command.Log:
+		return logger.HandleLog(userCommand)
+	case command.Quit:
+		Shutdown()
+		os.Exit(0)
+
+	// file related commands
+	case command.Put:
+		if len(userInputs) != 3 {
+			return errors.New("usage: put <localfilename> <sdfsfilename>")
 		}
+		return fileService.RemotePut(fileService.User(), userInputs[1], userInputs[2])
+	case command.Get:
+		if len(userInputs) != 3 {
+			return errors.New("usage: get <sdfsfilename> <localfilename>")
+		}
+		return fileService.RemoteGet(fileService.User(), userInputs[1], userInputs[2])
+	case command.Delete:
+		if len(userInputs) != 2 {
+			return errors.New("usage: delete <sdfsfilename>")
+		}
+		return fileService.RemoteDelete(fileService.User(), userInputs[1])
+	case command.Acl:
+		return fileService.HandleACL(userCommand)
+	case command.Audit:
+		return fileService.HandleAudit(userCommand)
+	case command.Store:
+		fileService.FileTable.ListMyFiles()
+	case command.List:
+		if len(userInputs) != 2 {
+			return errors.New("usage: ls <sdfsfilename>")
+		}
+		fmt.Println(fileService.FileTable.ListLocations(userInputs[1]))
+	case "all":
+		fileService.FileTable.ListAllFiles()
+
+	// maple juice relate functions
+	case command.Maple:
+		if len(userInputs) != 5 {
+			return errors.New("usage: maple <maple_exe> <num_maples> <sdfs_intermediate_filename_prefix> <sdfs_src_directory>")
+		}
+		return maplejuiceServer.ScheduleMapleTask(userInputs)
+	case command.Juice:
+		if len(userInputs) != 5 && len(userInputs) != 6 {
+			return errors.New("usage: juice <juice_exe> <num_juices> <sdfs_intermediate_filename_prefix> <sdfs_dest_filename> [delete_input={0,1}]")
+		}
+		return maplejuiceServer.ScheduleJuiceTask(userInputs)
+	case command.Jobs:
+		maplejuiceServer.PrintJobs()
 
+	default:
+		return errors.New("invalid command: " + strings.Join(userInputs, " "))
 	}
+	return nil
+}
+
+// Shutdown stops the services from the top down: no new maple/juice or file work is accepted,
+// running work is drained, then the node leaves the group and the logs are flushed.
+func Shutdown() {
+	shutdownOnce.Do(func() {
+		logger.PrintInfo("Shutting down...")
+		timeout := config.GetConfig().ShutdownTimeout
+		if dashboardServer != nil {
+			dashboardServer.Stop(timeout)
+		}
+		if httpService != nil {
+			httpService.Stop(timeout)
+		}
+		if maplejuiceServer != nil {
+			maplejuiceServer.Stop(timeout)
+		}
+		if fileService != nil {
+			fileService.Stop(timeout)
+		}
+		if raftService != nil {
+			raftService.Stop(timeout)
+		}
+		if memberService != nil {
+			memberService.Stop()
+		}
+		tracing.Close()
+		logger.PrintInfo("Bye!")
+		logger.Close()
+	})
+}
+
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.PrintInfo("Received signal", sig)
+	Shutdown()
+	os.Exit(0)
 }
 
 func main() {
+	// a copy of this program started to sandbox a maple or juice executable becomes it here
+	sandbox.Init()
+	configPath := flag.String("config", "./app/conf.yaml", "path of the config file")
+	scriptPath := flag.String("f", "", "run commands from a batch file before reading stdin")
+	scriptText := flag.String("c", "", "run commands separated by ';' before reading stdin")
+	exitAfterScript := flag.Bool("exit", false, "exit once the batch commands have finished")
+	scriptVars := map[string]string{}
+	flag.Func("var", "batch variable as NAME=VALUE, may be repeated", func(s string) error {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return errors.New("expected NAME=VALUE")
+		}
+		scriptVars[kv[0]] = kv[1]
+		return nil
+	})
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
 	logger.PrintInfo("Loading config...")
-	config.LoadConfig("./app/conf.yaml")
+	if err := config.LoadConfig(*configPath); err != nil {
+		logger.PrintError("Invalid configuration:\n" + err.Error())
+		os.Exit(2)
+	}
+	if err := logger.Configure(config.GetConfig().Log); err != nil {
+		logger.PrintError("Failed to open the log:", err)
+		os.Exit(2)
+	}
+	if err := tracing.Configure(config.GetConfig().Tracing); err != nil {
+		logger.PrintError("Failed to set up tracing:", err)
+		os.Exit(2)
+	}
 
 	logger.PrintInfo("Starting member service...")
 	memberService = member_service.NewMemberServer()
 	memberService.Run()
 
+	// the other services register their state machines before the raft log is replayed
+	raftService = raft_service.NewRaftServer(memberService)
+
 	logger.PrintInfo("Starting sdfs file service...")
-	fileService = file_service.NewFileServer(memberService)
+	fileService = file_service.NewFileServer(memberService, raftService)
 	fileService.Run()
 
 	logger.PrintInfo("Starting maple juice service...")
-	maplejuiceServer = maple_juice_service.NewMapleJuiceServer(fileService)
+	maplejuiceServer = maple_juice_service.NewMapleJuiceServer(fileService, raftService)
 	maplejuiceServer.Run()
 
+	logger.PrintInfo("Starting raft service...")
+	raftService.Run()
+
+	logger.PrintInfo("Starting http service...")
+	httpService = http_service.NewHTTPServer(memberService)
+	httpService.Run()
+	if addr := config.GetHTTPServiceConfig().DashboardAddr; addr != "" {
+		dashboardServer = dashboard.NewDashboard(fileService, maplejuiceServer, addr)
+		dashboardServer.Run()
+	}
+
+	go handleSignals()
+
+	var scripts []*command.Script
+	if *scriptPath != "" {
+		script, err := command.LoadScript(*scriptPath, scriptVars)
+		if err != nil {
+			logger.PrintError("Failed to load batch file:", err)
+			os.Exit(1)
+		}
+		scripts = append(scripts, script)
+	}
+	if *scriptText != "" {
+		scripts = append(scripts, command.NewScript("-c", *scriptText, scriptVars))
+	}
+	for _, script := range scripts {
+		if err := RunScript(script); err != nil {
+			logger.PrintError(err)
+			Shutdown()
+			os.Exit(1)
+		}
+	}
+	if len(scripts) > 0 && *exitAfterScript {
+		Shutdown()
+		return
+	}
+
 	logger.PrintInfo("Setup complete! You can input command now.")
 	HandleCommand()
 }
@@ -0,0 +1,65 @@
+//go:build integration
+
+package maple_juice_service_test
+
+import (
+	"better_mp3/app/harness"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+// a maple and juice job counts the words of the input
+func TestWordcount(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, (*harness.Cluster).RunWordcount)
+}
+
+func TestKeys(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkKeys)
+}
+
+const mapleKeys = `#!/bin/sh
+printf '../up 1\na/b 1\nstar* 1\n'
+`
+
+// keys that aren't valid sdfs names are encoded in the names of their files, which get the output
+// of every task once
+func checkKeys(c *harness.Cluster) error {
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.Config.MapleJuiceServiceConfig.ExecDir, "maple_keys"), []byte(mapleKeys), 0755); err != nil {
+		return err
+	}
+	master := c.Nodes[0]
+	if err := master.MapleJuice.ScheduleMapleTask([]string{"maple", "maple_keys", "3", "keys", "words"}); err != nil {
+		return err
+	}
+
+	files := master.File.FileTable.ListFilesByPrefix("keys")
+	sort.Strings(files)
+	expected := []string{"keys-words-maple-0", "keys-words-maple-1", "keys-words-maple-2", "keys_%2E.%2Fup", "keys_a%2Fb", "keys_star%2A"}
+	if !reflect.DeepEqual(files, expected) {
+		return fmt.Errorf("maple left the files %q, expected %q", files, expected)
+	}
+	local := filepath.Join(c.Dir, "keys-a-b.txt")
+	if err := master.File.RemoteGet(master.File.User(), "keys_a%2Fb", local); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(local)
+	if err != nil {
+		return err
+	}
+	if string(content) != strings.Repeat("a/b 1\n", 3) {
+		return fmt.Errorf("the file of key a/b holds %q, expected the output of each of the 3 tasks", content)
+	}
+	return nil
+}
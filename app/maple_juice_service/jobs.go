@@ -0,0 +1,185 @@
+package maple_juice_service
+
+/*
+Every maple and juice job is recorded in the state machine "jobs" of the raft service when it
+starts and when it ends, so that the records survive the node that scheduled the job. A job
+still running on a node that has left the raft configuration was interrupted.
+*/
+
+import (
+	"better_mp3/app/tracing"
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const jobsMachine = "jobs"
+
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	JobInterrupted = "interrupted"
+)
+
+type JobRecord struct {
+	ID       string
+	Kind     string // maple or juice
+	Args     []string
+	Master   string // the node that scheduled the job
+	User     string // the user who scheduled it
+	State    string
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time
+}
+
+// jobTable applies job records, a record replaces the one with the same ID
+type jobTable struct {
+	mux     sync.Mutex
+	records map[string]JobRecord
+}
+
+func newJobTable() *jobTable {
+	return &jobTable{records: map[string]JobRecord{}}
+}
+
+func (t *jobTable) Apply(command []byte) {
+	var record JobRecord
+	if err := json.Unmarshal(command, &record); err != nil {
+		mjLog.Error("Invalid job record:", err)
+		return
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.records[record.ID] = record
+}
+
+func (t *jobTable) Snapshot() ([]byte, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return json.Marshal(t.records)
+}
+
+func (t *jobTable) Restore(snapshot []byte) error {
+	records := map[string]JobRecord{}
+	if snapshot != nil {
+		if err := json.Unmarshal(snapshot, &records); err != nil {
+			return err
+		}
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.records = records
+	return nil
+}
+
+// runJob records the job, runs it and records how it ended. A job is not run in a
+// minority partition, nor when it can't be recorded.
+func (mjServer *MapleJuiceServer) runJob(cmd []string, run func(context.Context, *jobTracker, []string) error) (err error) {
+	defer mjServer.audit.Start(mjServer.fileServer.User(), cmd[0], cmd[1:]...).End(&err)
+	ctx, span := mjServer.tracer.Start(context.Background(), "maplejuice."+cmd[0],
+		trace.WithAttributes(attribute.StringSlice("args", cmd[1:]), attribute.String("user", mjServer.fileServer.User())))
+	defer tracing.End(span, &err)
+	if err := mjServer.fileServer.Writable(); err != nil {
+		return err
+	}
+	self := mjServer.fileServer.SelfAddr()
+	record := JobRecord{
+		ID:      fmt.Sprintf("%v-%v", self, time.Now().UnixNano()),
+		Kind:    cmd[0],
+		Args:    cmd[1:],
+		Master:  self,
+		User:    mjServer.fileServer.User(),
+		State:   JobRunning,
+		Started: time.Now(),
+	}
+	if err := mjServer.recordJob(record); err != nil {
+		return fmt.Errorf("failed to record %v job: %v", record.Kind, err)
+	}
+
+	span.SetAttributes(attribute.String("job", record.ID))
+	err = run(ctx, mjServer.progress.track(record.ID), cmd)
+	record.Finished = time.Now()
+	mjServer.metrics.jobDuration.WithLabelValues(record.Kind, resultLabel(err)).Observe(record.Finished.Sub(record.Started).Seconds())
+	record.State = JobDone
+	if err != nil {
+		record.State = JobFailed
+		record.Error = err.Error()
+	}
+	if recordErr := mjServer.recordJob(record); recordErr != nil {
+		mjLog.Warn("Failed to record the end of job", record.ID, recordErr)
+	}
+	return err
+}
+
+func (mjServer *MapleJuiceServer) recordJob(record JobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return mjServer.raft.Propose(jobsMachine, data)
+}
+
+// Jobs returns the job records, oldest first
+func (mjServer *MapleJuiceServer) Jobs() []JobRecord {
+	mjServer.jobs.mux.Lock()
+	defer mjServer.jobs.mux.Unlock()
+	records := make([]JobRecord, 0, len(mjServer.jobs.records))
+	for _, record := range mjServer.jobs.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Started.Before(records[j].Started)
+	})
+	return records
+}
+
+// MayView tells whether user may see a job, its progress and the logs of its tasks: the user who
+// scheduled it may, and the nodes and admins
+func (mjServer *MapleJuiceServer) MayView(user string, record JobRecord) bool {
+	return record.User == user || mjServer.fileServer.IsPrivileged(user)
+}
+
+// mayViewJob is MayView for the job with the given ID, which no one may see before it is recorded
+func (mjServer *MapleJuiceServer) mayViewJob(user string, id string) bool {
+	mjServer.jobs.mux.Lock()
+	record, ok := mjServer.jobs.records[id]
+	mjServer.jobs.mux.Unlock()
+	return ok && mjServer.MayView(user, record)
+}
+
+// JobState is the state of a job, a running job whose master has left the raft configuration
+// was interrupted
+func (mjServer *MapleJuiceServer) JobState(record JobRecord) string {
+	if record.State == JobRunning && !sets.NewString(mjServer.raft.Peers()...).Has(record.Master) {
+		return JobInterrupted
+	}
+	return record.State
+}
+
+// PrintJobs lists the jobs of the whole cluster
+func (mjServer *MapleJuiceServer) PrintJobs() {
+	for _, record := range mjServer.Jobs() {
+		state := mjServer.JobState(record)
+		line := fmt.Sprintf("%v\t%v %v\t%v\t%v\t%v\tstarted %v",
+			record.ID, record.Kind, strings.Join(record.Args, " "), record.Master, record.User, state,
+			record.Started.Format(time.Stamp))
+		if !record.Finished.IsZero() {
+			line += fmt.Sprintf("\ttook %v", record.Finished.Sub(record.Started).Round(time.Millisecond))
+		}
+		if record.Error != "" {
+			line += "\t" + record.Error
+		}
+		fmt.Println(line)
+	}
+}
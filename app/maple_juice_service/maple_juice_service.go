@@ -1,33 +1,156 @@
 package maple_juice_service
 
 import (
+	"better_mp3/app/audit"
 	"better_mp3/app/config"
 	"better_mp3/app/file_service"
 	"better_mp3/app/logger"
+	"better_mp3/app/member_service"
+	"better_mp3/app/raft_service"
+	"better_mp3/app/secure_rpc"
+	"better_mp3/app/tracing"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+var mjLog = logger.New(logger.ComponentMapleJuice)
+
+var ErrShuttingDown = errors.New("maple juice service is shutting down")
+
 type MapleJuiceServer struct {
 	config     config.MapleJuiceServiceConfig
 	fileServer *file_service.FileServer
+	raft       *raft_service.RaftServer
+	jobs       *jobTable
+	progress   *progressTable
+	taskLogs   *taskLogTable
+
+	credentials *secure_rpc.Credentials
+	audit       *audit.Log
+	metrics     *mjMetrics
+	tracer      trace.Tracer
+	listener    net.Listener
+	mux      sync.Mutex
+	closing  bool
+	tasks    sync.WaitGroup
 }
 
 type MapleJuiceTask struct {
 	InputFileName string
 	ExecFileName string
 	OutputPrefix string
+	Term         int64
+	User         string // the user who scheduled the job, files are read and written as
+	Job          string // the ID of the job the task is part of
+	Trace        tracing.Carrier
+}
+
+// validate checks the sdfs names of a task, juice tasks have no output prefix
+func (task MapleJuiceTask) validate() error {
+	names := []string{task.ExecFileName, task.InputFileName}
+	if task.OutputPrefix != "" {
+		names = append(names, task.OutputPrefix)
+	}
+	for _, name := range names {
+		if err := file_service.ValidateName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tmpDir creates a directory of its own in TmpDir for the files of a task or job, so that
+// tasks running at the same time don't overwrite each other's. The caller removes it.
+func (mjServer *MapleJuiceServer) tmpDir(prefix string) (string, error) {
+	return ioutil.TempDir(mjServer.config.TmpDir, prefix)
+}
+
+// tmpPath is where a file of sdfs, or one named after it, is kept in dir while a task runs
+func tmpPath(dir string, name string) string {
+	return path.Join(dir, file_service.EncodeName(name))
 }
 
-func NewMapleJuiceServer(fileServer *file_service.FileServer) *MapleJuiceServer {
+func NewMapleJuiceServer(fileServer *file_service.FileServer, raftServer *raft_service.RaftServer) *MapleJuiceServer {
+	return NewMapleJuiceServerWithConfig(fileServer, raftServer, config.GetMapleJuiceServiceConfig())
+}
+
+// NewMapleJuiceServerWithConfig doesn't read the global config, so that several
+// maplejuice servers can run in one process. The job records are registered with raftServer.
+func NewMapleJuiceServerWithConfig(fileServer *file_service.FileServer, raftServer *raft_service.RaftServer, mjConfig config.MapleJuiceServiceConfig) *MapleJuiceServer {
 	var f MapleJuiceServer
-	f.config = config.GetMapleJuiceServiceConfig()
+	f.config = mjConfig
+	credentials, err := secure_rpc.Load(mjConfig.TLS)
+	if err != nil {
+		log.Fatal("Failed to load the tls credentials: ", err)
+	}
+	f.credentials = credentials
+	f.audit, err = audit.Open(mjConfig.AuditLog, fileServer.SelfAddr())
+	if err != nil {
+		log.Fatal("Failed to open the audit log: ", err)
+	}
+	f.metrics = newMJMetrics(fileServer.SelfAddr())
+	f.tracer = tracing.Tracer(fileServer.SelfAddr())
 	f.fileServer = fileServer
+	f.raft = raftServer
+	f.jobs = newJobTable()
+	f.progress = newProgressTable()
+	f.taskLogs = newTaskLogTable()
+	raftServer.Register(jobsMachine, f.jobs)
 	return &f
 }
 
 func (mjServer *MapleJuiceServer) Run() {
-	go RunMapleJuiceRPCServer(mjServer)
+	RunMapleJuiceRPCServer(mjServer)
+	mjServer.fileServer.MemberService().AdvertiseEndpoint(member_service.EndpointMapleJuice, mjServer.config.Port)
 
-	logger.PrintInfo(
+	mjLog.Info(
 		"MapleJuice Service is now running on port " + mjServer.config.Port,
 		"\n")
 }
+
+// Stop refuses new tasks and waits for the running ones to finish.
+// Refused tasks fail on the scheduler side, which reschedules them to another node.
+func (mjServer *MapleJuiceServer) Stop(timeout time.Duration) {
+	mjServer.mux.Lock()
+	mjServer.closing = true
+	mjServer.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		mjServer.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		mjLog.Warn("MapleJuice service stopped with tasks still running, they will be rescheduled")
+	}
+
+	if mjServer.listener != nil {
+		_ = mjServer.listener.Close()
+	}
+	mjLog.Info("MapleJuice service stopped")
+}
+
+// rpcAddr returns the address of the maplejuice service of another node
+func (mjServer *MapleJuiceServer) rpcAddr(nodeAddr string) string {
+	return mjServer.fileServer.MemberService().ServiceAddr(nodeAddr, member_service.EndpointMapleJuice, mjServer.config.Port)
+}
+
+// begin registers a running task or job, it fails once Stop has been called
+func (mjServer *MapleJuiceServer) begin() error {
+	mjServer.mux.Lock()
+	defer mjServer.mux.Unlock()
+	if mjServer.closing {
+		return ErrShuttingDown
+	}
+	mjServer.tasks.Add(1)
+	return nil
+}
@@ -0,0 +1,62 @@
+package maple_juice_service
+
+/*
+The metrics of the maplejuice service, registered with the registry of the node, see the metrics
+package. Tasks are timed on the node running them, from when they are accepted to when their
+output is written, and jobs on the master that schedules them. Tasks refused before they start,
+for a stale term or a shutdown, are not counted: the scheduler reschedules them.
+*/
+
+import (
+	"better_mp3/app/metrics"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsSubsystem = "maplejuice"
+
+type mjMetrics struct {
+	taskDuration *prometheus.HistogramVec
+	jobDuration  *prometheus.HistogramVec
+	runningTasks *prometheus.GaugeVec
+}
+
+func newMJMetrics(nodeAddr string) *mjMetrics {
+	return &mjMetrics{
+		taskDuration: metrics.HistogramVec(nodeAddr, metricsSubsystem, "task_duration_seconds",
+			"Time taken by the tasks run on this node, by kind, maple or juice, and result, ok or error.",
+			metrics.TaskBuckets, "kind", "result"),
+		jobDuration: metrics.HistogramVec(nodeAddr, metricsSubsystem, "job_duration_seconds",
+			"Time taken by the jobs scheduled by this node, by kind, maple or juice, and result, ok or error.",
+			metrics.TaskBuckets, "kind", "result"),
+		runningTasks: metrics.GaugeVec(nodeAddr, metricsSubsystem, "running_tasks",
+			"Tasks running on this node, by kind.", "kind"),
+	}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// taskTimer times a running task
+type taskTimer struct {
+	metrics *mjMetrics
+	kind    string
+	start   time.Time
+}
+
+// startTask counts a task as running until its timer ends, used as
+// defer mjServer.metrics.startTask(kind).end(&err)
+func (m *mjMetrics) startTask(kind string) taskTimer {
+	m.runningTasks.WithLabelValues(kind).Inc()
+	return taskTimer{metrics: m, kind: kind, start: time.Now()}
+}
+
+func (t taskTimer) end(err *error) {
+	t.metrics.runningTasks.WithLabelValues(t.kind).Dec()
+	t.metrics.taskDuration.WithLabelValues(t.kind, resultLabel(*err)).Observe(time.Since(t.start).Seconds())
+}
@@ -0,0 +1,264 @@
+package maple_juice_service
+
+/*
+The node that schedules a job keeps its progress: the stage the job is in, and where each of its
+tasks runs and how the last attempt went, as the replies of the workers tell. The workers keep
+the log of each task they run. Both are only kept in memory, for the latest jobs and tasks, and
+are lost with the node; the job records in raft are what outlives it.
+*/
+
+import (
+	"better_mp3/app/logger"
+	"better_mp3/app/tracing"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	TaskPending = "pending"
+	TaskRunning = "running"
+	TaskDone    = "done"
+	TaskFailed  = "failed"
+)
+
+// the progress of this many jobs, and the logs of this many tasks, are kept
+const (
+	maxTrackedJobs  = 32
+	maxTaskLogs     = 256
+	maxTaskLogLines = 200
+)
+
+// TaskProgress is the state of a task of a job
+type TaskProgress struct {
+	Input    string // the sdfs file the task reads
+	Node     string // the node running it, or that ran it last
+	State    string
+	Attempts int
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time
+}
+
+// JobProgress is the stage of a job and the state of its tasks
+type JobProgress struct {
+	ID    string
+	Stage string
+	Tasks []TaskProgress
+}
+
+type progressTable struct {
+	mux   sync.Mutex
+	jobs  map[string]*JobProgress
+	order []string // oldest first
+}
+
+func newProgressTable() *progressTable {
+	return &progressTable{jobs: map[string]*JobProgress{}}
+}
+
+// jobTracker updates the progress of one job
+type jobTracker struct {
+	table *progressTable
+	id    string
+}
+
+// track starts keeping the progress of a job, forgetting the oldest job kept
+func (t *progressTable) track(id string) *jobTracker {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.jobs[id] = &JobProgress{ID: id}
+	t.order = append(t.order, id)
+	if len(t.order) > maxTrackedJobs {
+		delete(t.jobs, t.order[0])
+		t.order = t.order[1:]
+	}
+	return &jobTracker{table: t, id: id}
+}
+
+// update changes the progress of the job, if it is still kept
+func (j *jobTracker) update(change func(*JobProgress)) {
+	j.table.mux.Lock()
+	defer j.table.mux.Unlock()
+	if progress, ok := j.table.jobs[j.id]; ok {
+		change(progress)
+	}
+}
+
+// task returns the task reading input, added if it is new
+func (p *JobProgress) task(input string) *TaskProgress {
+	for i := range p.Tasks {
+		if p.Tasks[i].Input == input {
+			return &p.Tasks[i]
+		}
+	}
+	p.Tasks = append(p.Tasks, TaskProgress{Input: input, State: TaskPending})
+	return &p.Tasks[len(p.Tasks)-1]
+}
+
+// next moves the job to its next stage, which is traced by stages
+func (j *jobTracker) next(stages *tracing.Stages, name string, attributes ...attribute.KeyValue) context.Context {
+	j.update(func(p *JobProgress) {
+		p.Stage = name
+	})
+	return stages.Next(name, attributes...)
+}
+
+// add adds a task assigned to node
+func (j *jobTracker) add(input string, node string) {
+	j.update(func(p *JobProgress) {
+		p.task(input).Node = node
+	})
+}
+
+// start records an attempt of a task on node
+func (j *jobTracker) start(input string, node string) {
+	j.update(func(p *JobProgress) {
+		task := p.task(input)
+		task.Node = node
+		task.State = TaskRunning
+		task.Attempts++
+		task.Error = ""
+		task.Started = time.Now()
+		task.Finished = time.Time{}
+	})
+}
+
+// finish records how the last attempt of a task ended
+func (j *jobTracker) finish(input string, err error) {
+	j.update(func(p *JobProgress) {
+		task := p.task(input)
+		task.State = TaskDone
+		if err != nil {
+			task.State = TaskFailed
+			task.Error = err.Error()
+		}
+		task.Finished = time.Now()
+	})
+}
+
+// ViewArgs asks a node for what it keeps of a job, its progress or the log of its task on Input,
+// on behalf of User
+type ViewArgs struct {
+	User  string
+	Job   string
+	Input string
+}
+
+// Progress returns the progress of a job scheduled by this node, if user may see the job
+func (mjServer *MapleJuiceServer) Progress(user string, id string) (JobProgress, bool) {
+	if !mjServer.mayViewJob(user, id) {
+		return JobProgress{}, false
+	}
+	t := mjServer.progress
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	progress, ok := t.jobs[id]
+	if !ok {
+		return JobProgress{}, false
+	}
+	copied := *progress
+	copied.Tasks = append([]TaskProgress{}, progress.Tasks...)
+	return copied, true
+}
+
+// taskLogTable keeps the log lines of the latest tasks run on this node
+type taskLogTable struct {
+	mux   sync.Mutex
+	logs  map[string][]string
+	order []string // oldest first
+}
+
+func newTaskLogTable() *taskLogTable {
+	return &taskLogTable{logs: map[string][]string{}}
+}
+
+func taskKey(job string, input string) string {
+	return job + "\x00" + input
+}
+
+// taskLog writes the log of a task to the log of the node, and keeps its lines for the dashboard
+type taskLog struct {
+	table *taskLogTable
+	key   string
+	log   *logger.Logger
+}
+
+// open starts the log of a task, a task run again on this node goes on with its old log
+func (t *taskLogTable) open(job string, input string) *taskLog {
+	key := taskKey(job, input)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if _, ok := t.logs[key]; !ok {
+		t.logs[key] = []string{}
+		t.order = append(t.order, key)
+		if len(t.order) > maxTaskLogs {
+			delete(t.logs, t.order[0])
+			t.order = t.order[1:]
+		}
+	}
+	return &taskLog{table: t, key: key, log: mjLog.With("job", job, "input", input)}
+}
+
+func (l *taskLog) keep(level logger.Level, args []interface{}) {
+	line := fmt.Sprintf("%v %v %v", time.Now().Format("15:04:05.000"), level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	l.table.mux.Lock()
+	defer l.table.mux.Unlock()
+	lines, ok := l.table.logs[l.key]
+	if !ok {
+		return
+	}
+	if len(lines) >= maxTaskLogLines {
+		lines = lines[1:]
+	}
+	l.table.logs[l.key] = append(lines, line)
+}
+
+func (l *taskLog) Info(args ...interface{}) {
+	l.log.Info(args...)
+	l.keep(logger.LevelInfo, args)
+}
+
+func (l *taskLog) Warn(args ...interface{}) {
+	l.log.Warn(args...)
+	l.keep(logger.LevelWarn, args)
+}
+
+func (l *taskLog) Error(args ...interface{}) {
+	l.log.Error(args...)
+	l.keep(logger.LevelError, args)
+}
+
+// RemoteProgress returns the progress of a job kept by the node that scheduled it, failing
+// unless the node answers within timeout
+func (mjServer *MapleJuiceServer) RemoteProgress(nodeAddr string, user string, id string, timeout time.Duration) (JobProgress, error) {
+	var progress JobProgress
+	err := mjServer.credentials.Call(mjServer.rpcAddr(nodeAddr), "MapleJuiceRPCServer.Progress",
+		ViewArgs{User: user, Job: id}, &progress, timeout)
+	return progress, err
+}
+
+// TaskLog returns the log lines of a task of a job run on this node, if user may see the job
+func (mjServer *MapleJuiceServer) TaskLog(user string, job string, input string) ([]string, bool) {
+	if !mjServer.mayViewJob(user, job) {
+		return nil, false
+	}
+	t := mjServer.taskLogs
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	lines, ok := t.logs[taskKey(job, input)]
+	return append([]string{}, lines...), ok
+}
+
+// RemoteTaskLog returns the log lines of a task kept by the node that ran it, failing unless the
+// node answers within timeout
+func (mjServer *MapleJuiceServer) RemoteTaskLog(nodeAddr string, user string, job string, input string, timeout time.Duration) ([]string, error) {
+	var lines []string
+	err := mjServer.credentials.Call(mjServer.rpcAddr(nodeAddr), "MapleJuiceRPCServer.TaskLog",
+		ViewArgs{User: user, Job: job, Input: input}, &lines, timeout)
+	return lines, err
+}
@@ -1,39 +1,109 @@
 package maple_juice_service
 
 import (
+	"better_mp3/app/secure_rpc"
+	"better_mp3/app/tracing"
+	"fmt"
 	"log"
-	"net"
 	"net/rpc"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RPCTask struct {
 	fileName string
-	ip       string
+	addr     string
 	call     rpc.Call
+	span     trace.Span
 }
 
 type MapleJuiceRPCServer struct {
 	mjServer *MapleJuiceServer
+	peer     secure_rpc.Peer // who calls, the user of a task is authenticated against it
 }
 
 func RunMapleJuiceRPCServer(mjServer *MapleJuiceServer) {
-	server := MapleJuiceRPCServer{
-		mjServer: mjServer,
-	}
-	err := rpc.Register(server)
+	listener, err := mjServer.credentials.Listen(mjServer.config.Port)
 	if err != nil {
-		log.Fatal("Failed to register RPC instance")
+		log.Fatal("Failed to listen on port ", mjServer.config.Port)
+	}
+	mjServer.listener = listener
+	go secure_rpc.ServePeers(listener, func(peer secure_rpc.Peer) *rpc.Server {
+		rpcServer := rpc.NewServer()
+		if err := rpcServer.Register(MapleJuiceRPCServer{mjServer: mjServer, peer: peer}); err != nil {
+			log.Fatal("Failed to register RPC instance")
+		}
+		return rpcServer
+	})
+}
+
+func (s MapleJuiceRPCServer) RunMapleTask(task MapleJuiceTask, mapleResult *string) (err error) {
+	op := s.mjServer.audit.StartRPC(s.peer.Addr, task.User, "maple-task", task.ExecFileName, task.InputFileName, task.OutputPrefix)
+	defer op.End(&err)
+	if err := task.validate(); err != nil {
+		return err
 	}
-	listener, err := net.Listen("tcp", ":"+server.mjServer.config.Port)
-	for {
-		rpc.Accept(listener)
+	if err := s.mjServer.fileServer.Authenticate(s.peer, task.User); err != nil {
+		return err
 	}
+	op.Authenticated()
+	// tasks scheduled by a master that missed an election are refused
+	if err := s.mjServer.fileServer.Fence(task.Term); err != nil {
+		return err
+	}
+	if err := s.mjServer.begin(); err != nil {
+		return err
+	}
+	defer s.mjServer.tasks.Done()
+	defer s.mjServer.metrics.startTask("maple").end(&err)
+	ctx, span := tracing.StartServer(s.mjServer.tracer, task.Trace, "MapleJuiceRPCServer.RunMapleTask", attribute.String("input", task.InputFileName))
+	defer tracing.End(span, &err)
+	return s.mjServer.RunMapleTask(ctx, task, mapleResult)
 }
 
-func (s MapleJuiceRPCServer) RunMapleTask(task MapleJuiceTask, mapleResult *string) error {
-	return s.mjServer.RunMapleTask(task, mapleResult)
+func (s MapleJuiceRPCServer) RunJuiceTask(task MapleJuiceTask, juiceResult *string) (err error) {
+	op := s.mjServer.audit.StartRPC(s.peer.Addr, task.User, "juice-task", task.ExecFileName, task.InputFileName)
+	defer op.End(&err)
+	if err := task.validate(); err != nil {
+		return err
+	}
+	if err := s.mjServer.fileServer.Authenticate(s.peer, task.User); err != nil {
+		return err
+	}
+	op.Authenticated()
+	if err := s.mjServer.fileServer.Fence(task.Term); err != nil {
+		return err
+	}
+	if err := s.mjServer.begin(); err != nil {
+		return err
+	}
+	defer s.mjServer.tasks.Done()
+	defer s.mjServer.metrics.startTask("juice").end(&err)
+	ctx, span := tracing.StartServer(s.mjServer.tracer, task.Trace, "MapleJuiceRPCServer.RunJuiceTask", attribute.String("input", task.InputFileName))
+	defer tracing.End(span, &err)
+	return s.mjServer.RunJuiceTask(ctx, task, juiceResult)
+}
+func (s MapleJuiceRPCServer) Progress(args ViewArgs, progress *JobProgress) error {
+	if err := s.mjServer.fileServer.Authenticate(s.peer, args.User); err != nil {
+		return err
+	}
+	found, ok := s.mjServer.Progress(args.User, args.Job)
+	if !ok {
+		return fmt.Errorf("%v keeps no progress of job %v", s.mjServer.fileServer.SelfAddr(), args.Job)
+	}
+	*progress = found
+	return nil
 }
 
-func (s MapleJuiceRPCServer) RunJuiceTask(task MapleJuiceTask, juiceResult *string) error {
-	return s.mjServer.RunJuiceTask(task, juiceResult)
-}
\ No newline at end of file
+func (s MapleJuiceRPCServer) TaskLog(args ViewArgs, lines *[]string) error {
+	if err := s.mjServer.fileServer.Authenticate(s.peer, args.User); err != nil {
+		return err
+	}
+	found, ok := s.mjServer.TaskLog(args.User, args.Job, args.Input)
+	if !ok {
+		return fmt.Errorf("%v keeps no log of task %v of job %v", s.mjServer.fileServer.SelfAddr(), args.Input, args.Job)
+	}
+	*lines = found
+	return nil
+}
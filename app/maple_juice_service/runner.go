@@ -1,20 +1,39 @@
 package maple_juice_service
 
 import (
-	"better_mp3/app/logger"
+	"better_mp3/app/file_service"
+	"better_mp3/app/sandbox"
+	"better_mp3/app/tracing"
 	"bufio"
-	"fmt"
+	"bytes"
+	"context"
+	"io"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
-	"time"
 )
 
-// execute maple_exe and get result file
-func execute(execFileName string, inputFileName string, outputFileName string) error {
-	cmd := "./" + execFileName + "<" + inputFileName + ">" + outputFileName
-	_, err := exec.Command("/bin/sh", "-c", cmd).Output()
+// execute runs an executable fetched from sdfs in a sandbox with the task limits. The input file
+// is its stdin, and is copied to its working directory for args to name it. What it writes to
+// stderr goes to the log of the task.
+func (mjServer *MapleJuiceServer) execute(log *taskLog, execFileName string, inputFileName string, args []string, output io.Writer) error {
+	stderr, err := sandbox.Run(sandbox.Spec{
+		Dir:        mjServer.config.TmpDir,
+		Executable: execFileName,
+		Args:       args,
+		Files:      []string{inputFileName},
+		Stdin:      inputFileName,
+		Stdout:     output,
+		Limits: sandbox.Limits{
+			Timeout:  mjServer.config.TaskTimeout,
+			CPU:      mjServer.config.TaskCPU,
+			Memory:   int64(mjServer.config.TaskMemoryMB) << 20,
+			FileSize: int64(mjServer.config.TaskFileMB) << 20,
+		},
+	})
+	if len(stderr) > 0 {
+		log.Warn(path.Base(execFileName), "wrote to stderr:", string(stderr))
+	}
 	return err
 }
 
@@ -22,7 +41,7 @@ func execute(execFileName string, inputFileName string, outputFileName string) e
 func splitMapleResultFile(resultFileName string) (kv map[string][]string, err error) {
 	file, err := os.Open(resultFileName) // May need to updated to filePath
 	if err != nil {
-		fmt.Println("Can not open the maple_result file!")
+		mjLog.Error("Can not open the maple_result file", resultFileName)
 		return nil, err
 	}
 	defer file.Close()
@@ -41,82 +60,160 @@ func splitMapleResultFile(resultFileName string) (kv map[string][]string, err er
 }
 
 
-func (mjServer *MapleJuiceServer) RunMapleTask(task MapleJuiceTask, mapleResult *string) error {
-	logger.PrintInfo("Start running Maple task...")
+func (mjServer *MapleJuiceServer) RunMapleTask(ctx context.Context, task MapleJuiceTask, mapleResult *string) (err error) {
+	stages := tracing.NewStages(ctx, mjServer.tracer, &err)
+	defer stages.End()
+	log := mjServer.taskLogs.open(task.Job, task.InputFileName)
+	defer func() {
+		if err != nil {
+			log.Error("Maple task failed:", err)
+		}
+	}()
+	log.Info("Start running Maple task...")
+	dir, err := mjServer.tmpDir("maple-task-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
 
-	logger.PrintInfo("Getting executable file", task.ExecFileName,  "from SDFS...")
-	mjServer.fileServer.RemoteGet(
+	log.Info("Getting executable file", task.ExecFileName,  "from SDFS...")
+	stageCtx := stages.Next("maple-task.fetch-executable")
+	err = mjServer.fileServer.RemoteGetContext(
+		stageCtx,
+		task.User,
 		task.ExecFileName,
-		path.Join(mjServer.config.TmpDir, task.ExecFileName))
+		tmpPath(dir, task.ExecFileName))
+	if err != nil {
+		return err
+	}
 
-	logger.PrintInfo("Getting input file clip", task.InputFileName, "from SDFS...")
-	mjServer.fileServer.RemoteGet(
+	log.Info("Getting input file clip", task.InputFileName, "from SDFS...")
+	stageCtx = stages.Next("maple-task.fetch-input")
+	err = mjServer.fileServer.RemoteGetContext(
+		stageCtx,
+		task.User,
 		task.InputFileName,
-		path.Join(mjServer.config.TmpDir, task.InputFileName))
+		tmpPath(dir, task.InputFileName))
+	if err != nil {
+		return err
+	}
 
-	logger.PrintInfo("Running maple executable...")
-	err := execute(
-		path.Join(mjServer.config.TmpDir, task.ExecFileName),
-		path.Join(mjServer.config.TmpDir, task.InputFileName),
-		path.Join(mjServer.config.TmpDir, task.OutputPrefix + "-" + "TMP"))
+	log.Info("Running maple executable...")
+	stages.Next("maple-task.execute")
+	output, err := os.Create(tmpPath(dir, task.OutputPrefix + "-" + "TMP"))
 	if err != nil {
-		logger.PrintError(err)
+		return err
+	}
+	err = mjServer.execute(
+		log,
+		tmpPath(dir, task.ExecFileName),
+		tmpPath(dir, task.InputFileName),
+		nil,
+		output)
+	output.Close()
+	if err != nil {
+		return err
 	}
 
-	logger.PrintInfo("Splitting maple result...")
-	kv, err := splitMapleResultFile(path.Join(mjServer.config.TmpDir, task.OutputPrefix + "-" + "TMP"))
+	// the job appends the output to the files of its keys once every task succeeded, a task run
+	// again replaces the output of the runs that failed
+	log.Info("Uploading maple result...")
+	stageCtx = stages.Next("maple-task.upload")
+	err = mjServer.fileServer.RemotePutContext(
+		stageCtx,
+		task.User,
+		tmpPath(dir, task.OutputPrefix + "-" + "TMP"),
+		mapleOutputName(task.InputFileName))
 	if err != nil {
-		logger.PrintError(err)
 		return err
 	}
 
-	logger.PrintInfo("Uploading maple result...")
+	log.Info("Successfully finished maple task!")
+	return nil
+}
+
+// mapleOutputName is the sdfs file the maple task of input puts its output in
+func mapleOutputName(input string) string {
+	return input + "-output"
+}
+
+// commitMapleOutput appends the output of the maple task of input to the files of its keys and
+// deletes it. Keys may hold any bytes, so they are encoded like sdfs names on disk.
+func (mjServer *MapleJuiceServer) commitMapleOutput(ctx context.Context, dir string, user string, outputPrefix string, input string) error {
+	output := mapleOutputName(input)
+	local := tmpPath(dir, output)
+	if err := mjServer.fileServer.RemoteGetContext(ctx, user, output, local); err != nil {
+		return err
+	}
+	kv, err := splitMapleResultFile(local)
+	if err != nil {
+		return err
+	}
 	for key, value := range kv {
-		mjServer.fileServer.RemoteAppend(
+		err = mjServer.fileServer.RemoteAppendContext(
+			ctx,
+			user,
 			[]byte(strings.Join(value, "\n") + "\n"),
-			task.OutputPrefix + "_" + key)
+			outputPrefix + "_" + file_service.EncodeName(key))
+		if err != nil {
+			return err
+		}
 	}
-
-	logger.PrintInfo("Successfully finished maple task!")
-	return nil
+	return mjServer.fileServer.RemoteDeleteContext(ctx, user, output)
 }
 
-func (mjServer *MapleJuiceServer) RunJuiceTask(task MapleJuiceTask, juiceResult *string) error {
-	fmt.Println("Start running Juice task...")
+func (mjServer *MapleJuiceServer) RunJuiceTask(ctx context.Context, task MapleJuiceTask, juiceResult *string) (err error) {
+	stages := tracing.NewStages(ctx, mjServer.tracer, &err)
+	defer stages.End()
+	log := mjServer.taskLogs.open(task.Job, task.InputFileName)
+	defer func() {
+		if err != nil {
+			log.Error("Juice task failed:", err)
+		}
+	}()
+	log.Info("Start running Juice task...")
+	dir, err := mjServer.tmpDir("juice-task-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
 
-	logger.PrintInfo("Getting executable file from SDFS...")
-	mjServer.fileServer.RemoteGet(
+	log.Info("Getting executable file from SDFS...")
+	stageCtx := stages.Next("juice-task.fetch-executable")
+	err = mjServer.fileServer.RemoteGetContext(
+		stageCtx,
+		task.User,
 		task.ExecFileName,
-		path.Join(mjServer.config.TmpDir, task.ExecFileName))
+		tmpPath(dir, task.ExecFileName))
+	if err != nil {
+		return err
+	}
 
-	logger.PrintInfo("Getting input file clip from SDFS...")
-	mjServer.fileServer.RemoteGet(
+	log.Info("Getting input file clip from SDFS...")
+	stageCtx = stages.Next("juice-task.fetch-input")
+	err = mjServer.fileServer.RemoteGetContext(
+		stageCtx,
+		task.User,
 		task.InputFileName,
-		path.Join(mjServer.config.TmpDir, task.InputFileName))
-
-	time.Sleep(time.Second)
-
-	logger.PrintInfo("Running juice executable...")
-	logger.PrintInfo("DEBUG:",
-		path.Join(mjServer.config.TmpDir, task.ExecFileName),
-		path.Join(mjServer.config.TmpDir, task.InputFileName))
-	err := execute(
-		path.Join(mjServer.config.TmpDir, task.ExecFileName),
-		path.Join(mjServer.config.TmpDir, task.InputFileName),
-		path.Join(mjServer.config.TmpDir, task.OutputPrefix + "-" + "DEBUG"))
-	logger.PrintInfo("Debug running finished.")
-	cmd := exec.Command(
-		path.Join(mjServer.config.TmpDir, task.ExecFileName),
-		path.Join(mjServer.config.TmpDir, task.InputFileName))
-	ret, err := cmd.CombinedOutput()
+		tmpPath(dir, task.InputFileName))
+	if err != nil {
+		return err
+	}
+
+	log.Info("Running juice executable...")
+	stages.Next("juice-task.execute")
+	var output bytes.Buffer
+	err = mjServer.execute(
+		log,
+		tmpPath(dir, task.ExecFileName),
+		tmpPath(dir, task.InputFileName),
+		[]string{file_service.EncodeName(task.InputFileName)},
+		&output)
 	if err != nil {
-		logger.PrintError(err)
 		return err
 	}
-	logger.PrintInfo("DEBUG:", ret)
 
-	// RemoteGet the resulting key-value pair
-	logger.PrintInfo("Successfully finished juice task!")
-	*juiceResult = string(ret)
+	log.Info("Successfully finished juice task!")
+	*juiceResult = output.String()
 	return nil
 }
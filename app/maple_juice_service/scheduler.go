@@ -2,42 +2,45 @@ package maple_juice_service
 
 import (
 	"better_mp3/app/file_service"
-	"better_mp3/app/logger"
+	"better_mp3/app/tracing"
 	"bufio"
-	"fmt"
+	"context"
+	"errors"
 	"io"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"log"
-	"net/rpc"
 	"os"
 	"path"
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 func getOutputFileName(outputPrefix string, taskIndex int) string {
 	return outputPrefix + "-" + strconv.Itoa(taskIndex)
 }
 
-func (mjServer *MapleJuiceServer) HashBasedPartition(inputFileName string, outputPrefix string, taskNum int) {
-	logger.PrintInfo("Start partitioning")
+// HashBasedPartition splits the input file into taskNum files in dir
+func (mjServer *MapleJuiceServer) HashBasedPartition(dir string, inputFileName string, outputPrefix string, taskNum int) error {
+	mjLog.Info("Start partitioning")
 	// Partition input data (hash partitioning)
 
 	// open output files
 	outputFiles := map[int]*os.File{}
 	for i := 0; i < taskNum; i++ {
-		outputFile := path.Join(mjServer.config.TmpDir, getOutputFileName(outputPrefix, i))
+		outputFile := tmpPath(dir, getOutputFileName(outputPrefix, i))
 		f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 		outputFiles[i] = f
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
 	// open input file
 	inputFile, err := os.Open(path.Join(mjServer.config.InputDir, inputFileName))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	inputFileReader := bufio.NewReader(inputFile)
 	var line string
@@ -48,12 +51,12 @@ func (mjServer *MapleJuiceServer) HashBasedPartition(inputFileName string, outpu
 			break
 		}
 		if _, err = outputFiles[lineNum % taskNum].WriteString(line); err != nil {
-			log.Fatal(err)
+			return err
 		}
 		lineNum++
 	}
 	if err != io.EOF {
-		fmt.Printf(" > Failed!: %v\n", err)
+		mjLog.Error("Failed to partition", inputFileName, err)
 	}
 
 	// close all output files
@@ -64,36 +67,75 @@ func (mjServer *MapleJuiceServer) HashBasedPartition(inputFileName string, outpu
 	}
 	// close input file
 	if err := inputFile.Close(); err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	fmt.Println("Done partitioning")
+	mjLog.Debug("Done partitioning")
+	return nil
 }
 
 
-func (mjServer *MapleJuiceServer) ScheduleMapleTask(cmd []string) {
-	logger.PrintInfo("Start scheduling maple task...")
+// usage: maple <maple_exe> <num_maples> <sdfs_intermediate_filename_prefix> <sdfs_src_directory>
+func (mjServer *MapleJuiceServer) ScheduleMapleTask(cmd []string) error {
+	return mjServer.runJob(cmd, mjServer.scheduleMaple)
+}
+
+func (mjServer *MapleJuiceServer) scheduleMaple(ctx context.Context, job *jobTracker, cmd []string) (err error) {
+	if err := mjServer.begin(); err != nil {
+		return err
+	}
+	defer mjServer.tasks.Done()
+	stages := tracing.NewStages(ctx, mjServer.tracer, &err)
+	defer stages.End()
+
+	mjLog.Info("Start scheduling maple task...")
 	start := time.Now().UnixNano() / int64(time.Millisecond)
+	term := mjServer.fileServer.Term()
+	user := mjServer.fileServer.User()
 
 	execFileName := cmd[1]
 	executableFilePath := path.Join(mjServer.config.ExecDir, execFileName)
-	taskNum, _ := strconv.Atoi(cmd[2])
+	taskNum, err := strconv.Atoi(cmd[2])
+	if err != nil || taskNum <= 0 {
+		return errors.New("invalid number of maple tasks: " + cmd[2])
+	}
 	outputPrefix := cmd[3]
 	inputFileName := cmd[4]
+	for _, name := range []string{execFileName, outputPrefix, inputFileName} {
+		if err := file_service.ValidateName(name); err != nil {
+			return err
+		}
+	}
 
-	mjServer.HashBasedPartition(inputFileName, outputPrefix, taskNum)
+	job.next(stages, "maple.partition")
+	dir, err := mjServer.tmpDir("maple-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := mjServer.HashBasedPartition(dir, inputFileName, outputPrefix, taskNum); err != nil {
+		return err
+	}
 
-	logger.PrintInfo("Start scheduling...")
+	mjLog.Info("Start scheduling...")
 	// Schedule mapleTasks (in turn)
-	mjServer.fileServer.RemotePut(executableFilePath, execFileName)
-	logger.PrintInfo("Uploaded exec file", execFileName, "in sdfs")
-	mapleTasks := map[string]string{} // taskNum -> serverIP
+	stageCtx := job.next(stages, "maple.upload")
+	if err := mjServer.fileServer.RemotePutContext(stageCtx, user, executableFilePath, execFileName); err != nil {
+		return err
+	}
+	mjLog.Info("Uploaded exec file", execFileName, "in sdfs")
+	mapleTasks := map[string]string{} // taskNum -> server address
+	if mjServer.fileServer.FileTable.Storage.Empty() {
+		return file_service.ErrEmptyRing
+	}
 	it := mjServer.fileServer.FileTable.Storage.Iterator()
 	for i := 0; i < taskNum; i++ {
 		// upload partitioned input file to sdfs
-		fileClipLocalPath := path.Join(mjServer.config.TmpDir, getOutputFileName(outputPrefix, i))
+		fileClipLocalPath := tmpPath(dir, getOutputFileName(outputPrefix, i))
 		fileClipSdfsName := outputPrefix + "-" + inputFileName + "-maple-" + strconv.Itoa(i)
-		mjServer.fileServer.RemotePut(fileClipLocalPath, fileClipSdfsName)
-		logger.PrintInfo("Uploaded file clip", fileClipLocalPath, "with name", fileClipSdfsName, "in sdfs")
+		if err := mjServer.fileServer.RemotePutContext(stageCtx, user, fileClipLocalPath, fileClipSdfsName); err != nil {
+			return err
+		}
+		mjLog.Info("Uploaded file clip", fileClipLocalPath, "with name", fileClipSdfsName, "in sdfs")
 
 		if it.Next() == false {
 			it.First()
@@ -101,41 +143,52 @@ func (mjServer *MapleJuiceServer) ScheduleMapleTask(cmd []string) {
 		node := it.Value()
 
 		// assign task to one server
-		mapleTasks[strconv.Itoa(i)] = node.(file_service.FileTableEntry).ServerIP
-		logger.PrintInfo("Schedule: maple task", strconv.Itoa(i), "is assigned to", node.(file_service.FileTableEntry).ServerIP)
+		mapleTasks[strconv.Itoa(i)] = node.(file_service.FileTableEntry).ServerAddr
+		job.add(fileClipSdfsName, node.(file_service.FileTableEntry).ServerAddr)
+		mjLog.Info("Schedule: maple task", strconv.Itoa(i), "is assigned to", node.(file_service.FileTableEntry).ServerAddr)
 	}
-	logger.PrintInfo("Done scheduling")
+	mjLog.Info("Done scheduling")
 
-	logger.PrintInfo("Start calling RPC...")
+	mjLog.Info("Start calling RPC...")
+	stageCtx = job.next(stages, "maple.run-tasks", attribute.Int("tasks", len(mapleTasks)))
 	// Asynchronous RPC
-	port := mjServer.config.Port
 	var calls []RPCTask
 	var unfinishedTasks []string
-	var failedIP []string
+	var failedAddrs []string
 	mapleResults := make([]string, len(mapleTasks))
 	cnt := 0
-	for taskIndex, ip := range mapleTasks {
-		client, err := rpc.Dial("tcp", ip+":"+port)
+	for taskIndex, addr := range mapleTasks {
+		input := outputPrefix + "-" + inputFileName + "-maple-" + taskIndex
+		job.start(input, addr)
+		client, err := mjServer.credentials.Dial(mjServer.rpcAddr(addr))
 		if err != nil {
-			logger.PrintWarning("Task for", ip, "needs rescheduling: ", err)
+			mjLog.Warn("Task for", addr, "needs rescheduling: ", err)
+			job.finish(input, err)
 			unfinishedTasks = append(unfinishedTasks, taskIndex)
-			failedIP = append(failedIP, ip)
+			failedAddrs = append(failedAddrs, addr)
 			continue
 		}
+		defer client.Close()
 
+		carrier, span := tracing.StartCall(stageCtx, mjServer.tracer, "MapleJuiceRPCServer.RunMapleTask", addr)
 		calls = append(calls,
 			RPCTask{
-				outputPrefix + "-" + inputFileName + "-maple-" + taskIndex,
-				ip,
+				taskIndex,
+				addr,
 				*client.Go(
 					"MapleJuiceRPCServer.RunMapleTask",
 					MapleJuiceTask{
-						InputFileName: outputPrefix + "-" + inputFileName + "-maple-" + taskIndex,
+						InputFileName: input,
 						ExecFileName:  execFileName,
 						OutputPrefix:  outputPrefix,
+						Term:          term,
+						User:          user,
+						Job:           job.id,
+						Trace:         carrier,
 					},
 					&mapleResults[cnt],
 					nil),
+				span,
 			})
 		cnt++
 	}
@@ -143,125 +196,199 @@ func (mjServer *MapleJuiceServer) ScheduleMapleTask(cmd []string) {
 	// Wait for all replies done
 	for _, call := range calls {
 		replyCall := <-call.call.Done
+		tracing.End(call.span, &replyCall.Error)
+		job.finish(outputPrefix+"-"+inputFileName+"-maple-"+call.fileName, replyCall.Error)
 		if replyCall.Error != nil {
-			log.Println("Some mapleTasks failed. Rescheduling is needed!", replyCall.Error)
+			mjLog.Warn("Some mapleTasks failed. Rescheduling is needed!", replyCall.Error)
 			unfinishedTasks = append(unfinishedTasks, call.fileName)
-			failedIP = append(failedIP, call.ip)
+			failedAddrs = append(failedAddrs, call.addr)
 			continue
 		}
 	}
 
 	// Reschedule unfinished mapleTasks
+	if len(unfinishedTasks) > 0 {
+		stageCtx = job.next(stages, "maple.reschedule", attribute.Int("tasks", len(unfinishedTasks)))
+	}
 	mapleTasks = map[string]string{}
+	if mjServer.fileServer.FileTable.Storage.Empty() {
+		return file_service.ErrEmptyRing
+	}
 	it = mjServer.fileServer.FileTable.Storage.Iterator()
 	for i := 0; i < len(unfinishedTasks); i++ {
 		if it.Next() == false {
 			it.First()
 		}
 		node := it.Value()
-		for _, ip := range failedIP {
-			if node.(file_service.FileTableEntry).ServerIP == ip {
+		for _, addr := range failedAddrs {
+			if node.(file_service.FileTableEntry).ServerAddr == addr {
 				if it.Next() == false {
 					it.First()
 				}
 				node = it.Value()
 			}
 		}
-		mapleTasks[unfinishedTasks[i]] = node.(file_service.FileTableEntry).ServerIP
+		mapleTasks[unfinishedTasks[i]] = node.(file_service.FileTableEntry).ServerAddr
 	}
-	var newCalls []rpc.Call
+	var newCalls []RPCTask
 	newResults := make([]string, len(unfinishedTasks))
 	cnt = 0
-	for taskIndex, ip := range mapleTasks {
-		client, err := rpc.Dial("tcp", ip+":"+port)
+	for taskIndex, addr := range mapleTasks {
+		input := outputPrefix + "-" + inputFileName + "-maple-" + taskIndex
+		job.start(input, addr)
+		client, err := mjServer.credentials.Dial(mjServer.rpcAddr(addr))
 		if err != nil {
-			log.Fatal(err)
+			job.finish(input, err)
+			return err
 		}
+		defer client.Close()
 
+		carrier, span := tracing.StartCall(stageCtx, mjServer.tracer, "MapleJuiceRPCServer.RunMapleTask", addr)
 		newCalls = append(
 			newCalls,
-			*client.Go(
-				"MapleJuiceRPCServer.RunMapleTask",
-				MapleJuiceTask{
-					InputFileName: outputPrefix + "-" + inputFileName + "-maple-" + taskIndex,
-					ExecFileName:  execFileName,
-					OutputPrefix:  outputPrefix,
-				},
-				&newResults[cnt],
-				nil))
+			RPCTask{
+				taskIndex,
+				addr,
+				*client.Go(
+					"MapleJuiceRPCServer.RunMapleTask",
+					MapleJuiceTask{
+						InputFileName: input,
+						ExecFileName:  execFileName,
+						OutputPrefix:  outputPrefix,
+						Term:          term,
+						User:          user,
+						Job:           job.id,
+						Trace:         carrier,
+					},
+					&newResults[cnt],
+					nil),
+				span,
+			})
 		cnt++
 	}
 
 	for _, call := range newCalls {
-		replyCall := <-call.Done
+		replyCall := <-call.call.Done
+		tracing.End(call.span, &replyCall.Error)
+		job.finish(outputPrefix+"-"+inputFileName+"-maple-"+call.fileName, replyCall.Error)
 		if replyCall.Error != nil {
-			log.Fatalln("Reschedule failed: ", replyCall.Error)
+			return errors.New("reschedule failed: " + replyCall.Error.Error())
+		}
+	}
+	mjLog.Info("Done RPC")
+
+	// the output of each task is only appended once, from the run that succeeded
+	stageCtx = job.next(stages, "maple.commit", attribute.Int("tasks", taskNum))
+	for i := 0; i < taskNum; i++ {
+		input := outputPrefix + "-" + inputFileName + "-maple-" + strconv.Itoa(i)
+		if err := mjServer.commitMapleOutput(stageCtx, dir, user, outputPrefix, input); err != nil {
+			return err
 		}
 	}
-	logger.PrintInfo("Done RPC")
 
 	end := time.Now().UnixNano() / int64(time.Millisecond)
-	logger.PrintInfo("Maple cost", (end - start) / 1000, "seconds.")
+	mjLog.Info("Maple cost", (end - start) / 1000, "seconds.")
+	return nil
 }
 
-func (mjServer *MapleJuiceServer) ScheduleJuiceTask(cmd []string) {
-	logger.PrintInfo("Start scheduling maple task...")
+// usage: juice <juice_exe> <num_juices> <sdfs_intermediate_filename_prefix> <sdfs_dest_filename> [delete_input={0,1}]
+func (mjServer *MapleJuiceServer) ScheduleJuiceTask(cmd []string) error {
+	return mjServer.runJob(cmd, mjServer.scheduleJuice)
+}
+
+func (mjServer *MapleJuiceServer) scheduleJuice(ctx context.Context, job *jobTracker, cmd []string) (err error) {
+	if err := mjServer.begin(); err != nil {
+		return err
+	}
+	defer mjServer.tasks.Done()
+	stages := tracing.NewStages(ctx, mjServer.tracer, &err)
+	defer stages.End()
+
+	mjLog.Info("Start scheduling maple task...")
 
 	start := time.Now().UnixNano() / int64(time.Millisecond)
+	term := mjServer.fileServer.Term()
+	user := mjServer.fileServer.User()
 
 	execFileName := cmd[1]
 	executableFilePath := path.Join(mjServer.config.ExecDir, execFileName)
-	taskNum, _ := strconv.Atoi(cmd[2])
+	taskNum, err := strconv.Atoi(cmd[2])
+	if err != nil || taskNum <= 0 {
+		return errors.New("invalid number of juice tasks: " + cmd[2])
+	}
 	filenamePrefix := cmd[3]
 	output := cmd[4]
+	for _, name := range []string{execFileName, output} {
+		if err := file_service.ValidateName(name); err != nil {
+			return err
+		}
+	}
 
-	fmt.Println("Start searching for maple result files")
+	mjLog.Debug("Start searching for maple result files")
 	// Find intermediate files
 	files := mjServer.fileServer.FileTable.ListFilesByPrefix(filenamePrefix)
-	fmt.Println("Done searching")
+	mjLog.Debug("Done searching")
+	if len(files) == 0 {
+		return errors.New("no intermediate files with prefix " + filenamePrefix)
+	}
 
-	fmt.Println("Start scheduling")
+	mjLog.Debug("Start scheduling")
 	// Schedule tasks (in turn)
-	mjServer.fileServer.RemotePut(executableFilePath, execFileName)
+	stageCtx := job.next(stages, "juice.upload")
+	if err := mjServer.fileServer.RemotePutContext(stageCtx, user, executableFilePath, execFileName); err != nil {
+		return err
+	}
 	var tasks []map[string]string
 	for i := 0; i < taskNum; i++ {
 		tasks = append(tasks, map[string]string{})
 	}
+	if mjServer.fileServer.FileTable.Storage.Empty() {
+		return file_service.ErrEmptyRing
+	}
 	it := mjServer.fileServer.FileTable.Storage.Iterator()
 	for i, filename := range files {
 		if it.Next() == false {
 			it.First()
 		}
 		node := it.Value()
-		tasks[i%taskNum][filename] = node.(file_service.FileTableEntry).ServerIP
+		tasks[i%taskNum][filename] = node.(file_service.FileTableEntry).ServerAddr
+		job.add(filename, node.(file_service.FileTableEntry).ServerAddr)
 	}
-	fmt.Println("Done scheduling")
+	mjLog.Debug("Done scheduling")
 
-	fmt.Println("Start RPC")
+	mjLog.Debug("Start RPC")
+	stageCtx = job.next(stages, "juice.run-tasks", attribute.Int("tasks", len(files)))
 	// Asynchronous RPC
-	port := mjServer.config.Port
 	var calls []RPCTask
 	var unfinishedTasks []string
-	var failedIP []string
+	var failedAddrs []string
 	juiceResults := make([]string, len(files))
 	cnt := 0
 	for _, m := range tasks {
-		for inputFile, ip := range m {
-			client, err := rpc.Dial("tcp", ip+":"+port)
+		for inputFile, addr := range m {
+			job.start(inputFile, addr)
+			client, err := mjServer.credentials.Dial(mjServer.rpcAddr(addr))
 			if err != nil {
-				log.Println("Need rescheduling:  ", err)
+				mjLog.Warn("Need rescheduling:", err)
+				job.finish(inputFile, err)
 				unfinishedTasks = append(unfinishedTasks, inputFile)
-				failedIP = append(failedIP, ip)
+				failedAddrs = append(failedAddrs, addr)
 				continue
 			}
+			defer client.Close()
 
+			carrier, span := tracing.StartCall(stageCtx, mjServer.tracer, "MapleJuiceRPCServer.RunJuiceTask", addr)
 			calls = append(calls,
-				RPCTask{inputFile, ip,
+				RPCTask{inputFile, addr,
 					*client.Go("MapleJuiceRPCServer.RunJuiceTask",
 						MapleJuiceTask{
 							InputFileName: inputFile,
 							ExecFileName:  execFileName,
-						}, &juiceResults[cnt], nil)})
+							Term:          term,
+							User:          user,
+							Job:           job.id,
+							Trace:         carrier,
+						}, &juiceResults[cnt], nil), span})
 			cnt++
 		}
 	}
@@ -269,65 +396,85 @@ func (mjServer *MapleJuiceServer) ScheduleJuiceTask(cmd []string) {
 	// Synchronization
 	for _, tmp := range calls {
 		replyCall := <-tmp.call.Done
+		tracing.End(tmp.span, &replyCall.Error)
+		job.finish(tmp.fileName, replyCall.Error)
 		if replyCall.Error != nil {
-			log.Println("Need rescheduling:  ", replyCall.Error)
+			mjLog.Warn("Need rescheduling:", replyCall.Error)
 			unfinishedTasks = append(unfinishedTasks, tmp.fileName)
-			failedIP = append(failedIP, tmp.ip)
+			failedAddrs = append(failedAddrs, tmp.addr)
 			continue
 		}
 	}
 
 	// Reschedule unfinished tasks
+	if len(unfinishedTasks) > 0 {
+		stageCtx = job.next(stages, "juice.reschedule", attribute.Int("tasks", len(unfinishedTasks)))
+	}
 	var newTasks []map[string]string
 	for i := 0; i < len(unfinishedTasks); i++ {
 		newTasks = append(newTasks, map[string]string{})
 	}
+	if mjServer.fileServer.FileTable.Storage.Empty() {
+		return file_service.ErrEmptyRing
+	}
 	it = mjServer.fileServer.FileTable.Storage.Iterator()
 	for i, filename := range unfinishedTasks {
 		if it.Next() == false {
 			it.First()
 		}
 		node := it.Value()
-		for _, ip := range failedIP {
-			if node.(file_service.FileTableEntry).ServerIP == ip {
+		for _, addr := range failedAddrs {
+			if node.(file_service.FileTableEntry).ServerAddr == addr {
 				if it.Next() == false {
 					it.First()
 				}
 				node = it.Value()
 			}
 		}
-		newTasks[i%len(unfinishedTasks)][filename] = node.(file_service.FileTableEntry).ServerIP
+		newTasks[i%len(unfinishedTasks)][filename] = node.(file_service.FileTableEntry).ServerAddr
 	}
-	var newCalls []rpc.Call
+	var newCalls []RPCTask
 	newResults := make([]string, len(unfinishedTasks))
 	cnt = 0
 	for _, m := range newTasks {
-		for inputFile, ip := range m {
-			client, err := rpc.Dial("tcp", ip+":"+port)
+		for inputFile, addr := range m {
+			job.start(inputFile, addr)
+			client, err := mjServer.credentials.Dial(mjServer.rpcAddr(addr))
 			if err != nil {
-				log.Fatal(err)
+				job.finish(inputFile, err)
+				return err
 			}
+			defer client.Close()
+			carrier, span := tracing.StartCall(stageCtx, mjServer.tracer, "MapleJuiceRPCServer.RunJuiceTask", addr)
 			newCalls = append(
 				newCalls,
-				*client.Go(
-					"MapleJuiceRPCServer.RunJuiceTask",
-					MapleJuiceTask{
-						InputFileName: inputFile,
-						ExecFileName:  execFileName,
-					},
-					&newResults[cnt], nil))
+				RPCTask{inputFile, addr,
+					*client.Go(
+						"MapleJuiceRPCServer.RunJuiceTask",
+						MapleJuiceTask{
+							InputFileName: inputFile,
+							ExecFileName:  execFileName,
+							Term:          term,
+							User:          user,
+							Job:           job.id,
+							Trace:         carrier,
+						},
+						&newResults[cnt], nil), span})
 			cnt++
 		}
 	}
 	for _, call := range newCalls {
-		replyCall := <-call.Done
+		replyCall := <-call.call.Done
+		tracing.End(call.span, &replyCall.Error)
+		job.finish(call.fileName, replyCall.Error)
 		if replyCall.Error != nil {
-			log.Fatalln("Reschedule failed ", replyCall.Error)
+			return errors.New("reschedule failed: " + replyCall.Error.Error())
 		}
 	}
-	logger.PrintInfo("Done RPC")
+	mjLog.Info("Done RPC")
 
-	logger.PrintInfo("Start sorting results...")
+	mjLog.Info("Start sorting results...")
+	stageCtx = job.next(stages, "juice.collect", attribute.String("output", output))
 	// Sort results and write to DFS
 	var results []string
 	for _, s := range juiceResults {
@@ -345,16 +492,20 @@ func (mjServer *MapleJuiceServer) ScheduleJuiceTask(cmd []string) {
 		sortedResults.Insert(kvPair)
 	}
 	content := []byte(strings.Join(sortedResults.List(), "\n") + "\n")
-	mjServer.fileServer.RemoteAppend(content, output)
-	fmt.Println("Done sorting")
+	if err := mjServer.fileServer.RemoteAppendContext(stageCtx, user, content, output); err != nil {
+		return err
+	}
+	mjLog.Debug("Done sorting")
 
 	// RemoteDelete intermediate files
 	if len(cmd) == 6 && cmd[5] == "1" {
+		stageCtx = job.next(stages, "juice.delete-input", attribute.Int("files", len(files)))
 		for _, file := range files {
-			mjServer.fileServer.RemoteDelete(file)
+			mjServer.fileServer.RemoteDeleteContext(stageCtx, user, file)
 		}
 	}
 
 	end := time.Now().UnixNano() / int64(time.Millisecond)
-	logger.PrintInfo("Juice cost", end - start / 1000, "seconds.")
+	mjLog.Info("Juice cost", end - start / 1000, "seconds.")
+	return nil
 }
\ No newline at end of file
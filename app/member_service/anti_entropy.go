@@ -0,0 +1,203 @@
+package member_service
+
+/*
+Delta gossip may miss a change, e.g. when a member was unreachable for longer than the change was
+retransmitted, and a joining member only gets the part of the list that fits in a datagram. Every
+sync_interval, a member exchanges its whole membership list with a random other member over tcp,
+on the port number of the member service, and both sides merge what they received. A member that
+joined pulls the whole list from the member that let it in.
+*/
+
+import (
+	"better_mp3/app/member_service/protocol_buffer"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// a full sync must finish within syncTimeout
+const syncTimeout = 5 * time.Second
+
+// maxSyncSize bounds the membership list a member accepts in a full sync
+const maxSyncSize = 64 << 20
+
+var errSyncTooLarge = errors.New("membership list of a full sync is too large")
+
+// listenSync starts the tcp listener of the full syncs and the loop that starts them
+func (ms *MemberServer) listenSync() error {
+	listener, err := net.Listen("tcp", ":"+ms.config.Port)
+	if err != nil {
+		return err
+	}
+	ms.syncListener = listener
+	ms.syncDone = make(chan struct{})
+	go ms.serveSync(listener)
+	go ms.syncLoop(ms.syncDone)
+	return nil
+}
+
+// closeSync stops the full syncs, it may be called more than once
+func (ms *MemberServer) closeSync() {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if ms.syncListener == nil {
+		return
+	}
+	_ = ms.syncListener.Close()
+	close(ms.syncDone)
+	ms.syncListener = nil
+}
+
+func (ms *MemberServer) serveSync(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go ms.answerSync(conn)
+	}
+}
+
+// syncLoop starts a full sync with a random member every sync_interval, give or take a quarter
+func (ms *MemberServer) syncLoop(done chan struct{}) {
+	for {
+		jitter := time.Duration(rand.Int63n(int64(ms.config.SyncInterval)/2+1)) - ms.config.SyncInterval/4
+		select {
+		case <-done:
+			return
+		case <-time.After(ms.config.SyncInterval + jitter):
+		}
+
+		ms.mux.Lock()
+		peer := ms.syncPeer()
+		ms.mux.Unlock()
+		if peer != "" {
+			ms.pushPull(peer)
+		}
+	}
+}
+
+// syncPeer picks the address of a random alive member, the caller holds ms.mux
+func (ms *MemberServer) syncPeer() string {
+	if ms.localMessage == nil || ms.isJoining {
+		return ""
+	}
+	peers := make([]string, 0)
+	for machineID, member := range ms.localMessage.MemberList {
+		if machineID != ms.SelfID && !ms.failureList[machineID] && !member.IsLeaving &&
+			member.State != protocol_buffer.MemberState_DEAD {
+			peers = append(peers, AddrOfID(machineID))
+		}
+	}
+	if len(peers) == 0 {
+		return ""
+	}
+	return peers[rand.Intn(len(peers))]
+}
+
+// pushPull sends the whole membership list to the member at addr and merges the list it answers with
+func (ms *MemberServer) pushPull(addr string) {
+	if intercepted(ms.SelfAddr, addr) {
+		return
+	}
+	conn, err := net.DialTimeout("tcp", addr, syncTimeout)
+	if err != nil {
+		memberLog.Debug("Full sync with", addr, "failed:", err)
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(syncTimeout))
+
+	if err := ms.writeSync(conn); err != nil {
+		memberLog.Debug("Full sync with", addr, "failed:", err)
+		return
+	}
+	remoteMessage, err := ms.readSync(conn)
+	if err != nil {
+		memberLog.Debug("Full sync with", addr, "failed:", err)
+		return
+	}
+	ms.mergeSync(remoteMessage)
+	memberLog.Debug("Full sync with", addr, "merged", len(remoteMessage.MemberList), "members")
+}
+
+// answerSync handles a full sync started by another member
+func (ms *MemberServer) answerSync(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(syncTimeout))
+
+	remoteMessage, err := ms.readSync(conn)
+	if err != nil {
+		memberLog.Debug("Failed to read a full sync:", err)
+		return
+	}
+	if intercepted(AddrOfID(remoteMessage.Sender), ms.SelfAddr) {
+		return
+	}
+	if err := ms.writeSync(conn); err != nil {
+		memberLog.Debug("Failed to answer a full sync:", err)
+		return
+	}
+	ms.mergeSync(remoteMessage)
+}
+
+// writeSync sends the whole membership list, prefixed with its length
+func (ms *MemberServer) writeSync(conn net.Conn) error {
+	ms.mux.Lock()
+	if ms.localMessage == nil {
+		ms.mux.Unlock()
+		return errors.New("not a member")
+	}
+	message, err := ms.encodeMessage(&protocol_buffer.MembershipServiceMessage{
+		MemberList:      ms.localMessage.MemberList,
+		Strategy:        ms.localMessage.Strategy,
+		StrategyCounter: ms.localMessage.StrategyCounter,
+		Type:            protocol_buffer.MessageType_SYNC,
+		Sender:          ms.SelfID,
+		Term:            ms.localMessage.Term,
+		Leader:          ms.localMessage.Leader,
+	})
+	ms.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(message)))
+	if _, err := conn.Write(header); err != nil {
+		return err
+	}
+	_, err = conn.Write(message)
+	return err
+}
+
+func (ms *MemberServer) readSync(conn net.Conn) (*protocol_buffer.MembershipServiceMessage, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxSyncSize {
+		return nil, errSyncTooLarge
+	}
+	// the buffer only grows as the message arrives, so that the size a peer claims before the
+	// message is authenticated allocates nothing
+	var message bytes.Buffer
+	if _, err := io.CopyN(&message, conn, int64(size)); err != nil {
+		return nil, err
+	}
+	return ms.decodeMessage(message.Bytes())
+}
+
+func (ms *MemberServer) mergeSync(remoteMessage *protocol_buffer.MembershipServiceMessage) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if !ms.isSending.Load() || ms.localMessage == nil || ms.isJoining {
+		return
+	}
+	ms.mergeMembershipLists(ms.localMessage, remoteMessage, ms.failureList)
+}
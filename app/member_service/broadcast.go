@@ -0,0 +1,131 @@
+package member_service
+
+/*
+Members spread the changes of the membership list instead of the whole list. A changed entry (a
+join, a suspicion, a failure, a leave, a refutation or new metadata) is queued and piggybacked on
+the next messages this member sends anyway: heartbeats, gossip, pings and acks. Each change goes
+out at most retransmit_mult * ceil(log10(n+1)) times, which reaches every member with high
+probability, and every message stays within buffer_size. The gossip strategy fills the rest of
+a message with random entries, since heartbeats spread through them. Whatever is missed is
+repaired by the full syncs of anti_entropy.go.
+*/
+
+import (
+	"better_mp3/app/member_service/protocol_buffer"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// the bytes a map entry adds to a message besides the member and its ID
+const entryOverhead = 10
+
+// queueBroadcast schedules the entry of machineID to be piggybacked, a newer change of the same
+// entry starts over. The caller holds ms.mux.
+func (ms *MemberServer) queueBroadcast(machineID string) {
+	ms.broadcasts[machineID] = 0
+}
+
+// retransmitLimit is how many messages carry each change, the caller holds ms.mux
+func (ms *MemberServer) retransmitLimit() int {
+	members := float64(len(ms.localMessage.MemberList))
+	return ms.config.RetransmitMult * int(math.Ceil(math.Log10(members+1)))
+}
+
+// outgoingMessage builds a message with the entry of this member and the queued changes, the
+// least sent first. With fill, random other entries take up the rest of buffer_size.
+// The message shares the entries of the list, so it must be encoded before ms.mux is released.
+func (ms *MemberServer) outgoingMessage(messageType protocol_buffer.MessageType, fill bool) *protocol_buffer.MembershipServiceMessage {
+	message := &protocol_buffer.MembershipServiceMessage{
+		MemberList:      make(map[string]*protocol_buffer.Member),
+		Strategy:        ms.localMessage.Strategy,
+		StrategyCounter: ms.localMessage.StrategyCounter,
+		Type:            messageType,
+		Sender:          ms.SelfID,
+		Term:            ms.localMessage.Term,
+		Leader:          ms.localMessage.Leader,
+	}
+	budget := ms.config.BufferSize - ms.keyring.overhead()
+	size := proto.Size(message)
+	add := func(machineID string) bool {
+		member, ok := ms.localMessage.MemberList[machineID]
+		if !ok {
+			return false
+		}
+		entry := proto.Size(member) + len(machineID) + entryOverhead
+		if size+entry > budget {
+			return false
+		}
+		message.MemberList[machineID] = member
+		size += entry
+		return true
+	}
+	add(ms.SelfID)
+
+	queued := make([]string, 0, len(ms.broadcasts))
+	for machineID := range ms.broadcasts {
+		queued = append(queued, machineID)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return ms.broadcasts[queued[i]] < ms.broadcasts[queued[j]]
+	})
+	limit := ms.retransmitLimit()
+	for _, machineID := range queued {
+		if _, ok := ms.localMessage.MemberList[machineID]; !ok {
+			delete(ms.broadcasts, machineID)
+			continue
+		}
+		if _, sent := message.MemberList[machineID]; !sent && !add(machineID) {
+			continue
+		}
+		ms.broadcasts[machineID]++
+		if ms.broadcasts[machineID] >= limit {
+			delete(ms.broadcasts, machineID)
+		}
+	}
+
+	if fill {
+		ids := make([]string, 0, len(ms.localMessage.MemberList))
+		for machineID := range ms.localMessage.MemberList {
+			if _, sent := message.MemberList[machineID]; !sent {
+				ids = append(ids, machineID)
+			}
+		}
+		rand.Shuffle(len(ids), func(i, j int) {
+			ids[i], ids[j] = ids[j], ids[i]
+		})
+		for _, machineID := range ids {
+			if !add(machineID) {
+				break
+			}
+		}
+	}
+	return message
+}
+
+// sendGossip sends a filled message to gossip_fanout random members, the caller holds ms.mux
+func (ms *MemberServer) sendGossip() error {
+	message, err := ms.encodeMessage(ms.outgoingMessage(protocol_buffer.MessageType_STANDARD, true))
+	if err != nil {
+		return err
+	}
+	dests := GetOtherMembershipListAddrs(ms.localMessage, ms.SelfID)
+	rand.Shuffle(len(dests), func(i, j int) {
+		dests[i], dests[j] = dests[j], dests[i]
+	})
+	if len(dests) > ms.config.GossipFanout {
+		dests = dests[:ms.config.GossipFanout]
+	}
+	return ms.sendAll(dests, message)
+}
+
+// sendHeartbeat sends this member's entry and the queued changes to every member, the caller holds ms.mux
+func (ms *MemberServer) sendHeartbeat() error {
+	message, err := ms.encodeMessage(ms.outgoingMessage(protocol_buffer.MessageType_STANDARD, false))
+	if err != nil {
+		return err
+	}
+	return ms.sendAll(GetOtherMembershipListAddrs(ms.localMessage, ms.SelfID), message)
+}
@@ -2,10 +2,15 @@ package member_service
 
 import (
 	"better_mp3/app/command"
+	"better_mp3/app/config"
 	"better_mp3/app/logger"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func (ms *MemberServer) HandleSwitch(command command.Command) {
+func (ms *MemberServer) HandleSwitch(command command.Command) error {
 	var param string
 	if len(command.Params) >= 1 {
 		param = command.Params[0]
@@ -13,10 +18,10 @@ func (ms *MemberServer) HandleSwitch(command command.Command) {
 		param = ""
 	}
 
-	ms.ChangeStrategy(param)
+	return ms.ChangeStrategy(param)
 }
 
-func (ms *MemberServer) HandleDisplay(command command.Command) {
+func (ms *MemberServer) HandleDisplay(command command.Command) error {
 	var param string
 	if len(command.Params) >= 1 {
 		param = command.Params[0]
@@ -32,41 +37,117 @@ func (ms *MemberServer) HandleDisplay(command command.Command) {
 				ms.GetMembershipListString(ms.localMessage, ms.failureList))
 			ms.mux.Unlock()
 		} else {
-			logger.PrintInfo("Membership list is nil")
+			memberLog.Info("Membership list is nil")
 		}
 	} else if param == "self" {
 		if ms.SelfID == "" {
-			logger.PrintInfo("selfID is non-existent")
+			memberLog.Info("selfID is non-existent")
 		} else {
 			logger.PrintToConsole(ms.SelfID)
 		}
 	} else {
-		logger.PrintError("Invalid argument to 'list':", param)
+		return errors.New("invalid argument to 'display': " + param)
 	}
+	return nil
 }
 
-func (ms *MemberServer) HandleJoin(command command.Command) {
-	var param string
-	if len(command.Params) >= 1 {
-		param = command.Params[0]
-	} else {
-		param = ""
+// HandleTag sets the tags given as key=value, an empty value removes the tag.
+// Without parameters, it prints the tags of this member.
+func (ms *MemberServer) HandleTag(command command.Command) error {
+	if len(command.Params) == 0 {
+		ms.mux.Lock()
+		tags := formatTags(ms.tags)
+		ms.mux.Unlock()
+		logger.PrintToConsole(tags)
+		return nil
 	}
+	for _, param := range command.Params {
+		pair := strings.SplitN(param, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || strings.Contains(pair[0], ",") {
+			return errors.New("usage: tag [<key>=<value> ...]")
+		}
+		if pair[1] == "" {
+			ms.RemoveTag(pair[0])
+		} else {
+			ms.SetTag(pair[0], pair[1])
+		}
+	}
+	return nil
+}
 
-	if param == "" {
-		logger.PrintInfo("Please specify introducer IP address for joining")
-	} else if !ms.isSending {
-		ms.LeaderIP = param
-		ms.initMembershipList(true)
-		ms.isJoining = true
-		ms.isSending = true
-		go ms.startHeartbeat()
-		logger.PrintInfo("Successfully sent join request")
+// HandleKey manages the cluster keys: key list | generate | install <key> | use <key> | remove <key>
+func (ms *MemberServer) HandleKey(command command.Command) error {
+	usage := errors.New("usage: key list | generate | install <key> | use <key> | remove <key>")
+	if len(command.Params) == 0 {
+		return usage
+	}
+	switch action := command.Params[0]; {
+	case action == "list" && len(command.Params) == 1:
+		logger.PrintToConsole(strings.Join(ms.Keys(), "\n"))
+	case action == "generate" && len(command.Params) == 1:
+		logger.PrintToConsole(GenerateKey())
+	case action == "install" && len(command.Params) == 2:
+		return ms.InstallKey(command.Params[1])
+	case action == "use" && len(command.Params) == 2:
+		return ms.UseKey(command.Params[1])
+	case action == "remove" && len(command.Params) == 2:
+		return ms.RemoveKey(command.Params[1])
+	default:
+		return usage
+	}
+	return nil
+}
+
+// HandleJoin joins through the seeds given as parameters, or the configured ones
+func (ms *MemberServer) HandleJoin(command command.Command) error {
+	if ms.isSending.Load() {
+		return errors.New("cannot join, already actively sending")
+	}
+
+	if len(command.Params) > 0 {
+		seeds := make([]string, 0)
+		for _, param := range command.Params {
+			seeds = append(seeds, config.WithDefaultPort(param, ms.config.Port))
+		}
+		ms.seeds = ms.joinSeeds("", seeds)
 	} else {
-		logger.PrintError("Cannot join, already actively sending")
+		ms.seeds = ms.joinSeeds(ms.config.Introducer, ms.config.Seeds)
+	}
+	if len(ms.seeds) == 0 {
+		return errors.New("please specify seed addresses for joining")
 	}
+	ms.joinAttempt = 0
+	ms.nextJoin = time.Time{}
+	ms.initMembershipList(ms.config.Strategy)
+	ms.isJoining = true
+	ms.isSending.Store(true)
+	go ms.startHeartbeat()
+	memberLog.Info("Successfully sent join request")
+	return nil
 }
 
-func (ms *MemberServer) HandleLeave(command command.Command) {
+func (ms *MemberServer) HandleLeave(command command.Command) error {
+	if !ms.isSending.Load() {
+		return errors.New("cannot leave, not in a group")
+	}
 	ms.sendLeaveRequest()
+	return nil
+}
+
+// HandleWait blocks until the join request is answered, used by batch scripts.
+// Usage: wait join [timeout in seconds]
+func (ms *MemberServer) HandleWait(command command.Command) error {
+	if len(command.Params) == 0 || command.Params[0] != "join" {
+		return errors.New("usage: wait join [timeout]")
+	}
+
+	timeout := ms.config.JoinTimeout
+	if len(command.Params) >= 2 {
+		seconds, err := strconv.Atoi(command.Params[1])
+		if err != nil {
+			return errors.New("invalid timeout: " + command.Params[1])
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	return ms.WaitForJoin(timeout)
 }
@@ -0,0 +1,160 @@
+package member_service
+
+/*
+This file decides how long a member may stay silent before it is failed.
+Under the all and gossip strategies the timeout detector waits a fixed fail_time after the last
+heartbeat, while the accrual detector learns the usual time between heartbeats of each member and
+fails it once it missed accrual_threshold of them, which adapts to both fast and loaded networks.
+The phi detector also learns how much that time varies, and fails a member once phi, how unlikely
+it is that its next heartbeat is merely late, reaches phi_threshold.
+Under swim the suspicion timeout starts at suspect_max_time and shrinks towards suspect_time as
+other members independently confirm the suspicion.
+*/
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/member_service/protocol_buffer"
+	"math"
+	"time"
+)
+
+// how many inter-arrival times are kept per member, and how many are needed before they are trusted
+const arrivalWindow = 100
+const minArrivalSamples = 3
+
+type arrivalHistory struct {
+	last      time.Time
+	intervals []time.Duration
+}
+
+func (h *arrivalHistory) record(now time.Time) {
+	if !h.last.IsZero() {
+		h.intervals = append(h.intervals, now.Sub(h.last))
+		if len(h.intervals) > arrivalWindow {
+			h.intervals = h.intervals[1:]
+		}
+	}
+	h.last = now
+}
+
+func (h *arrivalHistory) mean() time.Duration {
+	var sum time.Duration
+	for _, interval := range h.intervals {
+		sum += interval
+	}
+	return sum / time.Duration(len(h.intervals))
+}
+
+// deviation is the standard deviation of the intervals, at least a quarter of their mean so that
+// perfectly regular heartbeats don't make a member fail at its first late one
+func (h *arrivalHistory) deviation() time.Duration {
+	mean := float64(h.mean())
+	var sum float64
+	for _, interval := range h.intervals {
+		sum += (float64(interval) - mean) * (float64(interval) - mean)
+	}
+	return time.Duration(math.Max(math.Sqrt(sum/float64(len(h.intervals))), mean/4))
+}
+
+// phi is -log10 of the probability that a heartbeat comes even later than elapsed, assuming
+// normally distributed intervals. It uses the logistic approximation of the normal distribution.
+func phi(elapsed, mean, deviation time.Duration) float64 {
+	y := float64(elapsed-mean) / float64(deviation)
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1.0 + e))
+	}
+	return -math.Log10(1.0 - 1.0/(1.0+e))
+}
+
+// phiTimeout inverts phi, the elapsed time at which phi reaches threshold
+func phiTimeout(threshold float64, mean, deviation time.Duration) time.Duration {
+	low, high := mean, mean+100*deviation
+	for i := 0; i < 50; i++ {
+		middle := (low + high) / 2
+		if phi(middle, mean, deviation) < threshold {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return high
+}
+
+// recordHeartbeat notes that a new heartbeat of machineID arrived, the caller holds ms.mux
+func (ms *MemberServer) recordHeartbeat(machineID string) {
+	history, ok := ms.arrivals[machineID]
+	if !ok {
+		history = &arrivalHistory{}
+		ms.arrivals[machineID] = history
+	}
+	history.record(time.Now())
+}
+
+// failTimeout is how long machineID may go without a heartbeat before it is failed, the caller holds ms.mux
+func (ms *MemberServer) failTimeout(machineID string) time.Duration {
+	timeout := ms.config.FailTime
+	history, ok := ms.arrivals[machineID]
+	if ok && len(history.intervals) >= minArrivalSamples {
+		switch ms.config.Detector {
+		case config.DETECTOR_ACCRUAL:
+			timeout = time.Duration(ms.config.AccrualLevel * float64(history.mean()))
+		case config.DETECTOR_PHI:
+			timeout = phiTimeout(ms.config.PhiThreshold, history.mean(), history.deviation())
+		}
+	}
+	return ms.health.scale(timeout)
+}
+
+// currentPhi is the phi of machineID right now, false until enough heartbeats arrived, the caller holds ms.mux
+func (ms *MemberServer) currentPhi(machineID string) (float64, bool) {
+	history, ok := ms.arrivals[machineID]
+	if !ok || len(history.intervals) < minArrivalSamples {
+		return 0, false
+	}
+	return phi(time.Since(history.last), history.mean(), history.deviation()), true
+}
+
+// suspicionTimeout follows Lifeguard: each independent confirmation brings the timeout
+// logarithmically closer to suspect_time, the caller holds ms.mux
+func (ms *MemberServer) suspicionTimeout(member *protocol_buffer.Member) time.Duration {
+	min := ms.config.SuspectTime
+	max := ms.config.SuspectMaxTime
+
+	// besides the suspected member and the first suspecter, there are only so many members to confirm
+	expected := ms.config.Confirmations
+	if others := len(ms.localMessage.MemberList) - 2; others < expected {
+		expected = others
+	}
+	confirmations := len(member.Suspecters) - 1
+	if expected < 1 || confirmations >= expected {
+		return min
+	}
+	if confirmations < 0 {
+		confirmations = 0
+	}
+
+	progress := math.Log(float64(confirmations+1)) / math.Log(float64(expected+1))
+	timeout := max - time.Duration(progress*float64(max-min))
+	if timeout < min {
+		return min
+	}
+	return timeout
+}
+
+// addSuspecters adds the machine IDs missing from suspecters
+func addSuspecters(suspecters []string, machineIDs ...string) []string {
+	for _, machineID := range machineIDs {
+		known := false
+		for _, suspecter := range suspecters {
+			if suspecter == machineID {
+				known = true
+				break
+			}
+		}
+		if !known {
+			suspecters = append(suspecters, machineID)
+		}
+	}
+	return suspecters
+}
@@ -0,0 +1,126 @@
+package member_service
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/member_service/protocol_buffer"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPhi(t *testing.T) {
+	mean, deviation := time.Second, 200*time.Millisecond
+	// a heartbeat as late as usual comes later half of the time
+	if got := phi(mean, mean, deviation); math.Abs(got-math.Log10(2)) > 0.01 {
+		t.Errorf("phi at the mean is %v, expected %v", got, math.Log10(2))
+	}
+	last := phi(0, mean, deviation)
+	for elapsed := 100 * time.Millisecond; elapsed <= 3*time.Second; elapsed += 100 * time.Millisecond {
+		got := phi(elapsed, mean, deviation)
+		if got < last {
+			t.Fatalf("phi went down from %v to %v at %v", last, got, elapsed)
+		}
+		last = got
+	}
+	if last < 12 {
+		t.Errorf("phi is only %v ten deviations after the mean", last)
+	}
+}
+
+func TestPhiTimeout(t *testing.T) {
+	mean, deviation := time.Second, 200*time.Millisecond
+	var last time.Duration
+	for _, threshold := range []float64{1, 4, 8, 12} {
+		timeout := phiTimeout(threshold, mean, deviation)
+		if got := phi(timeout, mean, deviation); math.Abs(got-threshold) > 0.01 {
+			t.Errorf("phi at the timeout of threshold %v is %v", threshold, got)
+		}
+		if timeout <= last {
+			t.Errorf("threshold %v times out after %v, no later than a lower threshold", threshold, timeout)
+		}
+		last = timeout
+	}
+}
+
+func TestArrivalHistory(t *testing.T) {
+	var h arrivalHistory
+	start := time.Now()
+	for _, at := range []time.Duration{0, time.Second, 3 * time.Second} {
+		h.record(start.Add(at))
+	}
+	if h.mean() != 1500*time.Millisecond || h.deviation() != 500*time.Millisecond {
+		t.Errorf("intervals %v have mean %v and deviation %v", h.intervals, h.mean(), h.deviation())
+	}
+
+	// perfectly regular heartbeats still have a deviation of a quarter of their mean
+	h = arrivalHistory{}
+	for i := 0; i < arrivalWindow+50; i++ {
+		h.record(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	if len(h.intervals) != arrivalWindow {
+		t.Errorf("%v intervals are kept, expected %v", len(h.intervals), arrivalWindow)
+	}
+	if h.mean() != 100*time.Millisecond || h.deviation() != 25*time.Millisecond {
+		t.Errorf("regular intervals have mean %v and deviation %v", h.mean(), h.deviation())
+	}
+}
+
+func TestFailTimeout(t *testing.T) {
+	start := time.Now()
+	history := &arrivalHistory{}
+	for i := 0; i <= minArrivalSamples; i++ {
+		history.record(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	for i, test := range []struct {
+		detector string
+		history  *arrivalHistory
+		health   int
+		expected time.Duration
+	}{
+		{config.DETECTOR_TIMEOUT, history, 0, 5 * time.Second},
+		{config.DETECTOR_ACCRUAL, history, 0, 300 * time.Millisecond},
+		{config.DETECTOR_PHI, history, 0, phiTimeout(8, 100*time.Millisecond, 25*time.Millisecond)},
+		// until enough heartbeats arrived, fail_time applies
+		{config.DETECTOR_ACCRUAL, &arrivalHistory{intervals: history.intervals[:minArrivalSamples-1]}, 0, 5 * time.Second},
+		{config.DETECTOR_PHI, nil, 0, 5 * time.Second},
+		// an unhealthy member waits longer
+		{config.DETECTOR_ACCRUAL, history, 2, 900 * time.Millisecond},
+	} {
+		ms := &MemberServer{
+			config:   config.MemberServiceConfig{FailTime: 5 * time.Second, Detector: test.detector, AccrualLevel: 3, PhiThreshold: 8},
+			arrivals: map[string]*arrivalHistory{},
+			health:   localHealth{score: test.health, max: 8},
+		}
+		if test.history != nil {
+			ms.arrivals["member"] = test.history
+		}
+		if got := ms.failTimeout("member"); got != test.expected {
+			t.Errorf("case %v, %v detector: timeout %v, expected %v", i, test.detector, got, test.expected)
+		}
+	}
+}
+
+func TestSuspicionTimeout(t *testing.T) {
+	members := map[string]*protocol_buffer.Member{}
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		members[id] = &protocol_buffer.Member{}
+	}
+	ms := &MemberServer{
+		config:       config.MemberServiceConfig{SuspectTime: time.Second, SuspectMaxTime: 9 * time.Second, Confirmations: 3},
+		localMessage: &protocol_buffer.MembershipServiceMessage{MemberList: members},
+	}
+	for _, test := range []struct {
+		suspecters []string
+		expected   time.Duration
+	}{
+		{[]string{"a"}, 9 * time.Second},
+		{[]string{"a", "b"}, 5 * time.Second},
+		{[]string{"a", "b", "c", "d"}, time.Second},
+		{[]string{"a", "b", "c", "d", "e"}, time.Second},
+	} {
+		got := ms.suspicionTimeout(&protocol_buffer.Member{Suspecters: test.suspecters})
+		if diff := got - test.expected; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Errorf("suspected by %v: timeout %v, expected %v", test.suspecters, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package member_service
+
+/*
+Upper level services learn about membership changes through events. Each subscriber gets its own
+queue, so publishing never blocks the member service, which publishes while it holds ms.mux.
+A subscriber starts with the current state replayed: a joined event for every alive member, a
+suspected or failed event for the others and a leader-changed event for the current master.
+*/
+
+import (
+	"better_mp3/app/member_service/protocol_buffer"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	// EventJoined: a member was added to the list, or a suspected or failed member turned out alive
+	EventJoined EventType = iota
+	EventSuspected
+	EventFailed
+	// EventLeft: a member left voluntarily
+	EventLeft
+	// EventLeaderChanged: a new master or a new term, Member is empty while there is no master
+	EventLeaderChanged
+	// EventUpdated: the tags or endpoints of a member changed
+	EventUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoined:
+		return "joined"
+	case EventSuspected:
+		return "suspected"
+	case EventFailed:
+		return "failed"
+	case EventLeft:
+		return "left"
+	case EventLeaderChanged:
+		return "leader-changed"
+	case EventUpdated:
+		return "updated"
+	}
+	return "unknown"
+}
+
+// MemberInfo describes a member when the event happened
+type MemberInfo struct {
+	ID          string
+	Addr        string
+	Incarnation int32
+	Heartbeat   int32
+	State       string // alive, suspect, failed or leaving
+	Tags        map[string]string
+	Endpoints   map[string]string
+}
+
+type Event struct {
+	Type   EventType
+	Member MemberInfo
+	Term   int64 // the term of the member service when the event happened
+	Time   time.Time
+	Replay bool // part of the state replayed on Subscribe
+}
+
+// at most this many events wait for a slow subscriber, later ones are dropped
+const maxQueuedEvents = 4096
+
+// Subscription delivers events in the order they were published on C
+type Subscription struct {
+	C    <-chan Event
+	out  chan Event
+	name string
+
+	mux     sync.Mutex
+	queue   []Event
+	dropped int
+	wake    chan struct{}
+	done    chan struct{}
+	bus     *eventBus
+}
+
+type eventBus struct {
+	mux  sync.Mutex
+	subs map[*Subscription]bool
+}
+
+// Subscribe registers a subscriber under name, which is only used in logs.
+// The current state is replayed before any later event.
+func (ms *MemberServer) Subscribe(name string) *Subscription {
+	sub := &Subscription{
+		out:  make(chan Event),
+		name: name,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		bus:  &ms.events,
+	}
+	sub.C = sub.out
+
+	// holding ms.mux, no event is published between the replay and the registration
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	for _, event := range ms.currentState() {
+		event.Replay = true
+		sub.push(event)
+	}
+	ms.events.mux.Lock()
+	if ms.events.subs == nil {
+		ms.events.subs = map[*Subscription]bool{}
+	}
+	ms.events.subs[sub] = true
+	ms.events.mux.Unlock()
+
+	go sub.pump()
+	return sub
+}
+
+// Close stops the delivery of events, events still queued are dropped
+func (sub *Subscription) Close() {
+	sub.bus.mux.Lock()
+	defer sub.bus.mux.Unlock()
+	if sub.bus.subs[sub] {
+		delete(sub.bus.subs, sub)
+		close(sub.done)
+	}
+}
+
+func (sub *Subscription) push(event Event) {
+	sub.mux.Lock()
+	if len(sub.queue) >= maxQueuedEvents {
+		if sub.dropped == 0 {
+			memberLog.Warn("Subscriber", sub.name, "falls behind, dropping member events")
+		}
+		sub.dropped++
+	} else {
+		sub.queue = append(sub.queue, event)
+	}
+	sub.mux.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump moves the queued events to the subscriber
+func (sub *Subscription) pump() {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.wake:
+		}
+		for {
+			sub.mux.Lock()
+			if len(sub.queue) == 0 {
+				sub.mux.Unlock()
+				break
+			}
+			event := sub.queue[0]
+			sub.queue = sub.queue[1:]
+			sub.mux.Unlock()
+
+			select {
+			case sub.out <- event:
+			case <-sub.done:
+				return
+			}
+		}
+	}
+}
+
+// publish queues an event for every subscriber, the caller holds ms.mux
+func (ms *MemberServer) publish(eventType EventType, machineID string) {
+	event := Event{Type: eventType, Term: ms.localMessage.Term, Time: time.Now()}
+	if machineID != "" {
+		event.Member = ms.memberInfo(machineID)
+	}
+	memberLog.Debug("Member event", eventType, machineID)
+	ms.metrics.events.WithLabelValues(eventType.String()).Inc()
+	// whatever other services hear about, the other members should hear about too
+	if machineID != "" && eventType != EventLeaderChanged {
+		ms.queueBroadcast(machineID)
+	}
+
+	ms.events.mux.Lock()
+	defer ms.events.mux.Unlock()
+	for sub := range ms.events.subs {
+		sub.push(event)
+	}
+}
+
+// memberInfo describes a member of the list, the caller holds ms.mux
+func (ms *MemberServer) memberInfo(machineID string) MemberInfo {
+	info := MemberInfo{ID: machineID, Addr: AddrOfID(machineID), State: "alive"}
+	member, ok := ms.localMessage.MemberList[machineID]
+	if !ok {
+		return info
+	}
+	info.Incarnation = member.Incarnation
+	info.Heartbeat = member.HeartbeatCounter
+	info.Tags = copyTags(member.Tags)
+	info.Endpoints = copyTags(member.Endpoints)
+	switch {
+	case member.IsLeaving:
+		info.State = "leaving"
+	case ms.failureList[machineID] || member.State == protocol_buffer.MemberState_DEAD:
+		info.State = "failed"
+	case member.State == protocol_buffer.MemberState_SUSPECT:
+		info.State = "suspect"
+	}
+	return info
+}
+
+// currentState describes the membership list as events, the caller holds ms.mux
+func (ms *MemberServer) currentState() []Event {
+	events := make([]Event, 0)
+	if ms.localMessage == nil {
+		return events
+	}
+	now := time.Now()
+	for machineID := range ms.localMessage.MemberList {
+		info := ms.memberInfo(machineID)
+		event := Event{Type: EventJoined, Member: info, Term: ms.localMessage.Term, Time: now}
+		switch info.State {
+		case "leaving":
+			event.Type = EventLeft
+		case "failed":
+			event.Type = EventFailed
+		case "suspect":
+			event.Type = EventSuspected
+		}
+		events = append(events, event)
+	}
+	if ms.localMessage.Leader != "" {
+		events = append(events, Event{
+			Type:   EventLeaderChanged,
+			Member: ms.memberInfo(ms.localMessage.Leader),
+			Term:   ms.localMessage.Term,
+			Time:   now,
+		})
+	}
+	return events
+}
@@ -0,0 +1,64 @@
+package member_service
+
+/*
+Local health awareness, as in Lifeguard. A member that is itself overloaded or badly connected misses
+acks and heartbeats of healthy members and would accuse them. So every member keeps a score of how
+unhealthy it looks: probes without any ack, suspicions about itself and a heartbeat loop waking up late
+raise it, and successful probes or timely heartbeats lower it. The member's own timeouts are
+multiplied by score+1, so a slow member gives the others more time instead of declaring them failed.
+*/
+
+import (
+	"better_mp3/app/config"
+	"time"
+)
+
+type localHealth struct {
+	score int
+	max   int
+}
+
+func (h *localHealth) raise(reason string) {
+	if h.score < h.max {
+		h.score++
+		memberLog.Debug("Local health score raised to", h.score, "-", reason)
+	}
+}
+
+func (h *localHealth) lower() {
+	if h.score > 0 {
+		h.score--
+	}
+}
+
+func (h *localHealth) scale(d time.Duration) time.Duration {
+	return d * time.Duration(h.score+1)
+}
+
+// HealthScore returns how much this member currently stretches its timeouts, 0 when healthy
+func (ms *MemberServer) HealthScore() int {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	return ms.health.score
+}
+
+// checkHeartbeatDelay looks at how late the heartbeat loop woke up, the caller holds ms.mux
+func (ms *MemberServer) checkHeartbeatDelay(sinceLastTick time.Duration) {
+	if sinceLastTick > 2*ms.heartbeatInterval() {
+		ms.health.raise("heartbeat loop is late by " + (sinceLastTick - ms.heartbeatInterval()).String())
+	} else if ms.localMessage.Strategy != config.STRAT_SWIM {
+		// under swim the probes tell whether we are healthy
+		ms.health.lower()
+	}
+}
+
+// heartbeatInterval is the time between two rounds of the heartbeat loop, the caller holds ms.mux.
+// Swim members probe less often while unhealthy, heartbeats of the other strategies keep their pace
+// because the other members time them.
+func (ms *MemberServer) heartbeatInterval() time.Duration {
+	if ms.localMessage.Strategy == config.STRAT_SWIM {
+		return ms.health.scale(ms.config.GossipInterval)
+	}
+	return ms.config.GossipInterval
+}
@@ -0,0 +1,512 @@
+//go:build integration
+
+package member_service_test
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/harness"
+	"better_mp3/app/member_service"
+	"better_mp3/app/member_service/protocol_buffer"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestJoin(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4, MessageLoss: 0.1}, checkJoin)
+	harness.Run(t, "swim", harness.Options{Size: 4, Strategy: config.STRAT_SWIM, MessageLoss: 0.1}, checkJoin)
+	harness.Run(t, "phi", harness.Options{Size: 4, Detector: config.DETECTOR_PHI, MessageLoss: 0.1}, checkJoin)
+}
+
+func TestFailureDetection(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkFailureDetection)
+	harness.Run(t, "swim", harness.Options{Size: 4, Strategy: config.STRAT_SWIM}, checkFailureDetection)
+	harness.Run(t, "swim-encryption", harness.Options{Size: 4, Strategy: config.STRAT_SWIM, Keys: testKeys, Encrypt: true}, checkFailureDetection)
+}
+
+func TestEvents(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkEvents)
+	harness.Run(t, "swim", harness.Options{Size: 4, Strategy: config.STRAT_SWIM}, checkEvents)
+}
+
+func TestMetadata(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkMetadata)
+	harness.Run(t, "gossip", harness.Options{Size: 4, Strategy: config.STRAT_GOSSIP}, checkMetadata)
+}
+
+func TestLargeList(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 10, BufferSize: 1024}, checkLargeList)
+	harness.Run(t, "gossip", harness.Options{Size: 10, BufferSize: 1024, Strategy: config.STRAT_GOSSIP}, checkLargeList)
+	harness.Run(t, "swim", harness.Options{Size: 10, BufferSize: 1024, Strategy: config.STRAT_SWIM}, checkLargeList)
+}
+
+func TestAuthentication(t *testing.T) {
+	harness.Run(t, "signed", harness.Options{Size: 4, Keys: testKeys}, checkAuthentication)
+	harness.Run(t, "encrypted", harness.Options{Size: 4, Keys: testKeys, Encrypt: true}, checkAuthentication)
+}
+
+func TestSlowLink(t *testing.T) {
+	harness.Run(t, "swim", harness.Options{Size: 4, Strategy: config.STRAT_SWIM}, checkSlowLink)
+}
+
+func TestRefute(t *testing.T) {
+	harness.Run(t, "swim", harness.Options{Size: 4, Strategy: config.STRAT_SWIM}, checkRefute)
+}
+
+func TestConfirmations(t *testing.T) {
+	harness.Run(t, "swim", harness.Options{Size: 5, Strategy: config.STRAT_SWIM}, checkConfirmations)
+}
+
+func TestSlowMember(t *testing.T) {
+	harness.Run(t, "swim", harness.Options{Size: 4, Strategy: config.STRAT_SWIM}, checkSlowMember)
+}
+
+func TestAccrualDetection(t *testing.T) {
+	harness.Run(t, "accrual", harness.Options{Size: 4, Detector: config.DETECTOR_ACCRUAL}, checkAccrual)
+	harness.Run(t, "phi", harness.Options{Size: 4, Detector: config.DETECTOR_PHI}, checkAccrual)
+}
+
+// every member converges on the full membership even when messages get lost from the first join
+// on, which the cluster is started with
+func checkJoin(c *harness.Cluster) error {
+	expected := harness.Addrs(c.Nodes)
+	for _, node := range c.Nodes {
+		got := node.Member.GetAliveMemberAddrList()
+		if !reflect.DeepEqual(got, expected) {
+			return fmt.Errorf("%v sees members %v, expected %v", node.Addr, got, expected)
+		}
+	}
+
+	// nobody is declared failed while heartbeats are lossy
+	time.Sleep(3 * c.Config.MemberServiceConfig.FailTime)
+	for _, node := range c.Nodes {
+		if failed := node.Member.GetFailedMemberAddrList(); len(failed) != 0 {
+			return fmt.Errorf("%v marked %v as failed under 10%% message loss", node.Addr, failed)
+		}
+	}
+	return nil
+}
+
+// a crashed member is detected by all the others, and only that member
+func checkFailureDetection(c *harness.Cluster) error {
+	victim := c.Nodes[2]
+	c.Crash(victim.Index)
+
+	err := c.WaitFor("the crash to be detected", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range c.Alive() {
+			if !contains(node.Member.GetFailedMemberAddrList(), victim.Addr) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, node := range c.Alive() {
+		if failed := node.Member.GetFailedMemberAddrList(); len(failed) != 1 {
+			return fmt.Errorf("%v marked %v as failed, expected only %v", node.Addr, failed, victim.Addr)
+		}
+	}
+	return nil
+}
+
+// a membership list larger than a message spreads through deltas and full syncs: a late joiner
+// learns every member, and a crash is still detected by everyone and nothing else is
+func checkLargeList(c *harness.Cluster) error {
+	memberConfig := c.Config.MemberServiceConfig
+	joiner, err := c.AddNode()
+	if err != nil {
+		return err
+	}
+	expected := harness.Addrs(c.Nodes)
+	if err := c.WaitFor("the new node to be seen by everyone", 3*memberConfig.SyncInterval+memberConfig.FailTime, func() bool {
+		for _, node := range c.Nodes {
+			if !reflect.DeepEqual(node.Member.GetAliveMemberAddrList(), expected) {
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+
+	time.Sleep(3 * memberConfig.FailTime)
+	for _, node := range c.Nodes {
+		if failed := node.Member.GetFailedMemberAddrList(); len(failed) != 0 {
+			return fmt.Errorf("%v marked %v as failed without a crash", node.Addr, failed)
+		}
+	}
+
+	victim := c.Nodes[3]
+	c.Crash(victim.Index)
+	err = c.WaitFor("the crash to be detected", 3*memberConfig.SuspectTime+3*memberConfig.FailTime, func() bool {
+		for _, node := range c.Alive() {
+			if !contains(node.Member.GetFailedMemberAddrList(), victim.Addr) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, node := range c.Alive() {
+		if failed := node.Member.GetFailedMemberAddrList(); len(failed) != 1 {
+			return fmt.Errorf("%v marked %v as failed, expected only %v", node.Addr, failed, victim.Addr)
+		}
+	}
+	if got := joiner.Member.GetAliveMemberAddrList(); len(got) != len(c.Nodes)-1 {
+		return fmt.Errorf("%v sees members %v after the crash", joiner.Addr, got)
+	}
+	return nil
+}
+
+// base64 keys shared by the nodes of the authentication scenarios
+var testKeys = []string{"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}
+
+// forged membership messages, unsigned or signed with another key, over udp or tcp, are rejected
+// and counted; the cluster key is then rotated without anyone being marked as failed
+func checkAuthentication(c *harness.Cluster) error {
+	target := c.Nodes[1]
+	victim := c.Nodes[2]
+	victimID := ""
+	for _, member := range target.Member.Members() {
+		if member.Addr == victim.Addr {
+			victimID = member.ID
+		}
+	}
+	forged, err := member_service.EncodeMembershipServiceMessage(&protocol_buffer.MembershipServiceMessage{
+		MemberList: map[string]*protocol_buffer.Member{
+			victimID: {HeartbeatCounter: 1 << 30, IsLeaving: true},
+		},
+		Strategy:        config.STRAT_GOSSIP,
+		StrategyCounter: 1 << 30,
+		Sender:          victimID,
+	})
+	if err != nil {
+		return err
+	}
+	otherKey := []byte("another key of thirty two bytes!")
+	signed := append([]byte{1}, forged...)
+	mac := hmac.New(sha256.New, otherKey)
+	mac.Write(signed)
+	signed = mac.Sum(signed)
+
+	outsider := "127.0.0.1:1"
+	for _, message := range [][]byte{forged, signed} {
+		if err := member_service.Send(outsider, target.Addr, message); err != nil {
+			return err
+		}
+	}
+	if err := sendSync(target.Addr, forged); err == nil {
+		return fmt.Errorf("%v answered a full sync with a forged membership list", target.Addr)
+	}
+	if err := c.WaitFor("the forged messages to be rejected", c.Config.MemberServiceConfig.FailTime, func() bool {
+		return target.Member.RejectedMessages() >= 3
+	}); err != nil {
+		return err
+	}
+	if !contains(target.Member.GetAliveMemberAddrList(), victim.Addr) {
+		return fmt.Errorf("%v believed a forged leave of %v", target.Addr, victim.Addr)
+	}
+
+	newKey := member_service.GenerateKey()
+	for _, step := range []func(node *harness.Node) error{
+		func(node *harness.Node) error { return node.Member.InstallKey(newKey) },
+		func(node *harness.Node) error { return node.Member.UseKey(newKey) },
+		func(node *harness.Node) error { return node.Member.RemoveKey(testKeys[0]) },
+	} {
+		for _, node := range c.Nodes {
+			if err := step(node); err != nil {
+				return fmt.Errorf("%v: %v", node.Addr, err)
+			}
+		}
+	}
+
+	time.Sleep(3 * c.Config.MemberServiceConfig.FailTime)
+	expected := harness.Addrs(c.Nodes)
+	for _, node := range c.Nodes {
+		if got := node.Member.GetAliveMemberAddrList(); !reflect.DeepEqual(got, expected) {
+			return fmt.Errorf("%v sees members %v after the key rotation, expected %v", node.Addr, got, expected)
+		}
+		if keys := node.Member.Keys(); !reflect.DeepEqual(keys, []string{newKey}) {
+			return fmt.Errorf("%v has keys %v after the key rotation", node.Addr, keys)
+		}
+	}
+	return nil
+}
+
+// sendSync starts a full sync with addr and returns an error unless it is answered
+func sendSync(addr string, message []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	frame := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	if _, err := conn.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, frame)
+	return err
+}
+
+// a subscriber that doesn't read doesn't hold up the member service, and once it reads it gets
+// the replayed state, then the crash of a member: suspected first with swim, then failed
+func checkEvents(c *harness.Cluster) error {
+	observer := c.Nodes[1]
+	victim := c.Nodes[3]
+	events := observer.Member.Subscribe("harness")
+	defer events.Close()
+
+	c.Crash(victim.Index)
+	if err := c.WaitFor("the crash to be detected", 3*c.Config.MemberServiceConfig.SuspectTime+3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		return contains(observer.Member.GetFailedMemberAddrList(), victim.Addr)
+	}); err != nil {
+		return err
+	}
+
+	replayed := map[string]bool{}
+	var seen []string
+	timeout := time.After(time.Second)
+	for {
+		var event member_service.Event
+		select {
+		case event = <-events.C:
+		case <-timeout:
+			return fmt.Errorf("no failed event for %v, got %v", victim.Addr, seen)
+		}
+		seen = append(seen, event.Type.String()+" "+event.Member.Addr)
+		if event.Replay {
+			if event.Type == member_service.EventJoined {
+				replayed[event.Member.Addr] = true
+			}
+			continue
+		}
+		if event.Member.Addr != victim.Addr {
+			continue
+		}
+		if event.Type == member_service.EventSuspected && c.Config.MemberServiceConfig.Strategy != config.STRAT_SWIM {
+			return fmt.Errorf("%v was suspected without swim", victim.Addr)
+		}
+		if event.Type == member_service.EventFailed {
+			break
+		}
+	}
+	if len(replayed) != len(c.Nodes) {
+		return fmt.Errorf("replayed joins of %v, expected all %v members", replayed, len(c.Nodes))
+	}
+	if c.Config.MemberServiceConfig.Strategy == config.STRAT_SWIM && !contains(seen, "suspected "+victim.Addr) {
+		return fmt.Errorf("%v failed without being suspected first: %v", victim.Addr, seen)
+	}
+
+	// a late subscriber learns about the failure from the replay
+	late := observer.Member.Subscribe("harness-late")
+	defer late.Close()
+	for {
+		select {
+		case event := <-late.C:
+			if event.Type == member_service.EventFailed && event.Member.Addr == victim.Addr && event.Replay {
+				return nil
+			}
+		case <-time.After(time.Second):
+			return fmt.Errorf("the failure of %v was not replayed", victim.Addr)
+		}
+	}
+}
+
+// tags and endpoints spread to every member, a newer version replaces the older one
+func checkMetadata(c *harness.Cluster) error {
+	err := c.WaitFor("the file endpoints to be advertised", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range c.Nodes {
+			for _, other := range c.Nodes {
+				host, _, _ := net.SplitHostPort(other.Addr)
+				expected := net.JoinHostPort(host, other.Config.FileServiceConfig.Port)
+				if endpoint, ok := node.Member.Endpoint(other.Addr, member_service.EndpointFile); !ok || endpoint != expected {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	tagged := c.Nodes[2]
+	events := c.Nodes[0].Member.Subscribe("harness")
+	defer events.Close()
+	for _, node := range c.Nodes {
+		node.Member.SetTag("zone", "a")
+	}
+	tagged.Member.SetTag("zone", "b")
+	tagged.Member.SetTag("role", "worker")
+	err = c.WaitFor("the tags to spread", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range c.Nodes {
+			if fmt.Sprint(memberAddrs(node.Member.MembersWithTag("zone", "b"))) != fmt.Sprint([]string{tagged.Addr}) ||
+				len(node.Member.MembersWithTag("zone", "")) != len(c.Nodes) ||
+				len(node.Member.MembersWithTag("role", "worker")) != 1 {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	tagged.Member.RemoveTag("role")
+	err = c.WaitFor("the removed tag to spread", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range c.Nodes {
+			if len(node.Member.MembersWithTag("role", "")) != 0 {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events.C:
+			if event.Type == member_service.EventUpdated && event.Member.Addr == tagged.Addr && event.Member.Tags["zone"] == "b" {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("no update event for %v", tagged.Addr)
+		}
+	}
+}
+
+func memberAddrs(members []member_service.MemberInfo) []string {
+	addrs := make([]string, 0, len(members))
+	for _, member := range members {
+		addrs = append(addrs, member.Addr)
+	}
+	return addrs
+}
+
+// a link that drops everything between two members doesn't get either of them declared failed,
+// the indirect probes go around it
+func checkSlowLink(c *harness.Cluster) error {
+	c.CutLink(1, 2)
+	time.Sleep(3 * c.Config.MemberServiceConfig.SuspectTime)
+
+	for _, node := range c.Nodes {
+		if failed := node.Member.GetFailedMemberAddrList(); len(failed) != 0 {
+			return fmt.Errorf("%v marked %v as failed because of one bad link", node.Addr, failed)
+		}
+	}
+	return nil
+}
+
+// a member cut off for less than suspect_time gets suspected, refutes it with a new incarnation
+// and is never declared failed
+func checkRefute(c *harness.Cluster) error {
+	victim := c.Nodes[2]
+	c.Partition([]int{victim.Index})
+	time.Sleep(c.Config.MemberServiceConfig.SuspectTime / 2)
+	c.Heal()
+
+	err := c.WaitFor("the suspicion to be refuted", 2*c.Config.MemberServiceConfig.SuspectTime, func() bool {
+		return victim.Member.Incarnation() > 0
+	})
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(2 * c.Config.MemberServiceConfig.SuspectTime)
+	for _, node := range c.Nodes {
+		if failed := node.Member.GetFailedMemberAddrList(); len(failed) != 0 {
+			return fmt.Errorf("%v marked %v as failed although the suspicion was refuted", node.Addr, failed)
+		}
+		if len(node.Member.GetAliveMemberAddrList()) != len(c.Nodes) {
+			return fmt.Errorf("%v sees members %v", node.Addr, node.Member.GetAliveMemberAddrList())
+		}
+	}
+	return nil
+}
+
+// with several members confirming the suspicion, a crash is detected well before suspect_max_time
+func checkConfirmations(c *harness.Cluster) error {
+	victim := c.Nodes[2]
+	start := time.Now()
+	if err := checkFailureDetection(c); err != nil {
+		return err
+	}
+	if took := time.Since(start); took >= c.Config.MemberServiceConfig.SuspectMaxTime {
+		return fmt.Errorf("detecting the crash of %v took %v, confirmations did not shorten the suspicion", victim.Addr, took)
+	}
+	return nil
+}
+
+// a member whose own sends are slow backs off: its health score goes up and it doesn't
+// declare the healthy members failed
+func checkSlowMember(c *harness.Cluster) error {
+	slow := c.Nodes[3]
+	c.SlowDown(slow.Index, 3*c.Config.MemberServiceConfig.ProbeTimeout)
+
+	err := c.WaitFor("the slow member to notice", 2*c.Config.MemberServiceConfig.SuspectMaxTime, func() bool {
+		return slow.Member.HealthScore() > 0
+	})
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(c.Config.MemberServiceConfig.SuspectMaxTime)
+	if failed := slow.Member.GetFailedMemberAddrList(); len(failed) != 0 {
+		return fmt.Errorf("the slow member %v marked %v as failed", slow.Addr, failed)
+	}
+	return nil
+}
+
+// on a fast network the accrual and phi detectors fail a crashed member before fail_time
+func checkAccrual(c *harness.Cluster) error {
+	// let every member learn the usual heartbeat interval of the others
+	time.Sleep(c.Config.MemberServiceConfig.FailTime)
+
+	victim := c.Nodes[2]
+	c.Crash(victim.Index)
+	start := time.Now()
+	err := c.WaitFor("the crash to be detected", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range c.Alive() {
+			if !contains(node.Member.GetFailedMemberAddrList(), victim.Addr) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if took := time.Since(start); took >= c.Config.MemberServiceConfig.FailTime {
+		return fmt.Errorf("accrual detection took %v, not faster than fail_time", took)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	i := sort.SearchStrings(sorted, s)
+	return i < len(sorted) && sorted[i] == s
+}
@@ -0,0 +1,321 @@
+package member_service
+
+/*
+Members authenticate their messages with a key shared by the cluster. Without keys, messages are
+sent as they are and anyone reaching the member port could inject one. With keys, every message
+starts with a mode byte and the time it was sealed, in big endian unix nanoseconds:
+	modeSigned:    mode | time | message | hmac-sha256(key, mode | time | message)
+	modeEncrypted: mode | time | nonce | aes-gcm(key, message), mode and time are authenticated as well
+A message sealed more than maxMessageAge away from the clock of the receiver is rejected, so that
+a recorded message can't be replayed later on; the clocks of the members must agree that closely.
+The first key of the keyring seals outgoing messages, incoming ones are accepted under any key of
+the keyring, so a key is rotated without downtime: install the new key on every member, use it on
+every member, then remove the old one. Either mode is accepted, so encryption is turned on one
+member at a time too. Messages that fail to open are rejected and counted.
+*/
+
+import (
+	"better_mp3/app/member_service/protocol_buffer"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	modeSigned    byte = 1
+	modeEncrypted byte = 2
+)
+
+const nonceSize = 12
+
+// the mode byte and the time a message was sealed
+const headerSize = 1 + 8
+
+// the most a sealed message adds to the encoded one
+const sealOverhead = headerSize + sha256.Size
+
+// maxMessageAge bounds how long ago, or how far ahead by a skewed clock, a message was sealed
+const maxMessageAge = 30 * time.Second
+
+var ErrUnknownKey = errors.New("key is not installed")
+var ErrPrimaryKey = errors.New("cannot remove the key in use")
+
+var errUnauthenticated = errors.New("message is not authenticated by any installed key")
+var errStale = errors.New("message was sealed too long ago, it may be replayed")
+
+type keyring struct {
+	mux     sync.RWMutex
+	keys    [][]byte // the first one seals
+	encrypt bool
+	now     func() time.Time
+
+	rejected int64
+}
+
+// DecodeKey decodes a base64 key of 16, 24 or 32 bytes
+func DecodeKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != 16 && len(decoded) != 24 && len(decoded) != 32 {
+		return nil, errors.New("a key must be 16, 24 or 32 bytes long")
+	}
+	return decoded, nil
+}
+
+// GenerateKey returns a new random key, base64 encoded
+func GenerateKey() string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newKeyring(keys []string, encrypt bool) *keyring {
+	k := &keyring{encrypt: encrypt, now: time.Now}
+	for _, key := range keys {
+		decoded, err := DecodeKey(key)
+		if err != nil {
+			memberLog.Error("Ignoring invalid member_service key:", err)
+			continue
+		}
+		k.keys = append(k.keys, decoded)
+	}
+	return k
+}
+
+func (k *keyring) overhead() int {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	if len(k.keys) == 0 {
+		return 0
+	}
+	return sealOverhead
+}
+
+// seal authenticates, and encrypts if configured, an encoded message with the primary key
+func (k *keyring) seal(message []byte) ([]byte, error) {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	if len(k.keys) == 0 {
+		return message, nil
+	}
+	key := k.keys[0]
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint64(header[1:], uint64(k.now().UnixNano()))
+
+	if !k.encrypt {
+		header[0] = modeSigned
+		sealed := make([]byte, 0, headerSize+len(message)+sha256.Size)
+		sealed = append(sealed, header...)
+		sealed = append(sealed, message...)
+		return append(sealed, sign(key, sealed)...), nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header[0] = modeEncrypted
+	sealed := make([]byte, headerSize+nonceSize, headerSize+nonceSize+len(message)+gcm.Overhead())
+	copy(sealed, header)
+	nonce := sealed[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(sealed, nonce, message, header), nil
+}
+
+// open checks a sealed message against every installed key and its age, and returns the encoded
+// message
+func (k *keyring) open(sealed []byte) ([]byte, error) {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	if len(k.keys) == 0 {
+		return sealed, nil
+	}
+	if len(sealed) < headerSize {
+		return nil, errUnauthenticated
+	}
+	message, err := k.authenticate(sealed)
+	if err != nil {
+		return nil, err
+	}
+	age := k.now().Sub(time.Unix(0, int64(binary.BigEndian.Uint64(sealed[1:headerSize]))))
+	if age > maxMessageAge || age < -maxMessageAge {
+		return nil, errStale
+	}
+	return message, nil
+}
+
+// authenticate opens a sealed message of at least headerSize bytes, the caller holds k.mux
+func (k *keyring) authenticate(sealed []byte) ([]byte, error) {
+	switch sealed[0] {
+	case modeSigned:
+		if len(sealed) < headerSize+sha256.Size {
+			return nil, errUnauthenticated
+		}
+		body, mac := sealed[:len(sealed)-sha256.Size], sealed[len(sealed)-sha256.Size:]
+		for _, key := range k.keys {
+			if hmac.Equal(mac, sign(key, body)) {
+				return body[headerSize:], nil
+			}
+		}
+	case modeEncrypted:
+		if len(sealed) < headerSize+nonceSize {
+			return nil, errUnauthenticated
+		}
+		header, nonce, ciphertext := sealed[:headerSize], sealed[headerSize:headerSize+nonceSize], sealed[headerSize+nonceSize:]
+		for _, key := range k.keys {
+			gcm, err := newGCM(key)
+			if err != nil {
+				continue
+			}
+			if message, err := gcm.Open(nil, nonce, ciphertext, header); err == nil {
+				return message, nil
+			}
+		}
+	}
+	return nil, errUnauthenticated
+}
+
+// reject counts a message that failed to open
+func (k *keyring) reject(err error) {
+	rejected := atomic.AddInt64(&k.rejected, 1)
+	if rejected == 1 || rejected%100 == 0 {
+		memberLog.Warn("Rejected a membership message:", err, "- rejected so far:", rejected)
+	} else {
+		memberLog.Debug("Rejected a membership message:", err)
+	}
+}
+
+func sign(key []byte, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encodeMessage encodes and seals a message to another member
+func (ms *MemberServer) encodeMessage(message *protocol_buffer.MembershipServiceMessage) ([]byte, error) {
+	encoded, err := EncodeMembershipServiceMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	return ms.keyring.seal(encoded)
+}
+
+// decodeMessage opens and decodes a message from another member, rejected messages are counted
+func (ms *MemberServer) decodeMessage(sealed []byte) (*protocol_buffer.MembershipServiceMessage, error) {
+	encoded, err := ms.keyring.open(sealed)
+	if err != nil {
+		ms.keyring.reject(err)
+		ms.metrics.rejected.Inc()
+		return nil, err
+	}
+	message, err := DecodeMembershipServiceMessage(encoded)
+	if err != nil {
+		ms.keyring.reject(err)
+		ms.metrics.rejected.Inc()
+		return nil, err
+	}
+	return message, nil
+}
+
+// RejectedMessages returns how many messages failed authentication or decoding
+func (ms *MemberServer) RejectedMessages() int64 {
+	return atomic.LoadInt64(&ms.keyring.rejected)
+}
+
+// InstallKey adds a key that incoming messages are accepted under. The first key installed turns
+// authentication on.
+func (ms *MemberServer) InstallKey(key string) error {
+	decoded, err := DecodeKey(key)
+	if err != nil {
+		return err
+	}
+	k := ms.keyring
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if k.index(decoded) < 0 {
+		k.keys = append(k.keys, decoded)
+	}
+	return nil
+}
+
+// UseKey makes an installed key the one outgoing messages are sealed with
+func (ms *MemberServer) UseKey(key string) error {
+	decoded, err := DecodeKey(key)
+	if err != nil {
+		return err
+	}
+	k := ms.keyring
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	i := k.index(decoded)
+	if i < 0 {
+		return ErrUnknownKey
+	}
+	k.keys[0], k.keys[i] = k.keys[i], k.keys[0]
+	return nil
+}
+
+// RemoveKey removes a key that is not in use
+func (ms *MemberServer) RemoveKey(key string) error {
+	decoded, err := DecodeKey(key)
+	if err != nil {
+		return err
+	}
+	k := ms.keyring
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	i := k.index(decoded)
+	if i < 0 {
+		return ErrUnknownKey
+	}
+	if i == 0 {
+		return ErrPrimaryKey
+	}
+	k.keys = append(k.keys[:i], k.keys[i+1:]...)
+	return nil
+}
+
+// Keys returns the installed keys, base64 encoded, the one in use first
+func (ms *MemberServer) Keys() []string {
+	k := ms.keyring
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+	keys := make([]string, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, base64.StdEncoding.EncodeToString(key))
+	}
+	return keys
+}
+
+// index finds key in the keyring, the caller holds k.mux
+func (k *keyring) index(key []byte) int {
+	for i, installed := range k.keys {
+		if bytes.Equal(installed, key) {
+			return i
+		}
+	}
+	return -1
+}
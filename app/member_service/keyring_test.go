@@ -0,0 +1,150 @@
+package member_service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeKey(t *testing.T) {
+	for size, valid := range map[int]bool{0: false, 15: false, 16: true, 24: true, 32: true, 33: false} {
+		key := base64.StdEncoding.EncodeToString(make([]byte, size))
+		if _, err := DecodeKey(key); (err == nil) != valid {
+			t.Errorf("a key of %v bytes is decoded with %v", size, err)
+		}
+	}
+	if _, err := DecodeKey("not base64!"); err == nil {
+		t.Error("a key that is not base64 was decoded")
+	}
+	if _, err := DecodeKey(GenerateKey()); err != nil {
+		t.Errorf("a generated key is invalid: %v", err)
+	}
+}
+
+// sealed messages open under any installed key, in either mode, and nowhere else
+func TestSeal(t *testing.T) {
+	message := []byte("membership message")
+	old, current, other := GenerateKey(), GenerateKey(), GenerateKey()
+	for _, encrypt := range []bool{false, true} {
+		sealed, err := newKeyring([]string{current}, encrypt).seal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypt == bytes.Contains(sealed, message) {
+			t.Errorf("encrypt %v: the sealed message contains the message %v", encrypt, !encrypt)
+		}
+
+		for _, receiver := range []*keyring{
+			newKeyring([]string{current}, encrypt),
+			newKeyring([]string{old, current}, !encrypt),
+		} {
+			if opened, err := receiver.open(sealed); err != nil || !bytes.Equal(opened, message) {
+				t.Errorf("encrypt %v: opened %q with %v", encrypt, opened, err)
+			}
+		}
+		if _, err := newKeyring([]string{other}, encrypt).open(sealed); err != errUnauthenticated {
+			t.Errorf("encrypt %v: opened under a wrong key with %v", encrypt, err)
+		}
+
+		for i := range sealed {
+			tampered := append([]byte(nil), sealed...)
+			tampered[i] ^= 1
+			if _, err := newKeyring([]string{current}, encrypt).open(tampered); err != errUnauthenticated {
+				t.Fatalf("encrypt %v: opened with byte %v flipped", encrypt, i)
+			}
+		}
+		if _, err := newKeyring([]string{current}, encrypt).open(sealed[:len(sealed)/2]); err != errUnauthenticated {
+			t.Errorf("encrypt %v: opened a truncated message", encrypt)
+		}
+	}
+}
+
+// a message sealed more than maxMessageAge before or after the clock of the receiver is rejected,
+// so that a recorded message can't be replayed
+func TestReplay(t *testing.T) {
+	key := GenerateKey()
+	for _, encrypt := range []bool{false, true} {
+		sealed, err := newKeyring([]string{key}, encrypt).seal([]byte("membership message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, skew := range []time.Duration{-maxMessageAge / 2, maxMessageAge / 2} {
+			receiver := newKeyring([]string{key}, encrypt)
+			receiver.now = func() time.Time { return time.Now().Add(skew) }
+			if _, err := receiver.open(sealed); err != nil {
+				t.Errorf("encrypt %v: a message %v off was rejected: %v", encrypt, skew, err)
+			}
+		}
+		for _, skew := range []time.Duration{-2 * maxMessageAge, 2 * maxMessageAge} {
+			receiver := newKeyring([]string{key}, encrypt)
+			receiver.now = func() time.Time { return time.Now().Add(skew) }
+			if _, err := receiver.open(sealed); err != errStale {
+				t.Errorf("encrypt %v: a message %v off was opened with %v", encrypt, skew, err)
+			}
+		}
+	}
+}
+
+// without keys messages are sent as they are, and anything is accepted
+func TestNoKeys(t *testing.T) {
+	k := newKeyring(nil, true)
+	message := []byte("membership message")
+	if sealed, err := k.seal(message); err != nil || !bytes.Equal(sealed, message) || k.overhead() != 0 {
+		t.Errorf("sealed %q with %v, overhead %v", sealed, err, k.overhead())
+	}
+	if opened, err := k.open(message); err != nil || !bytes.Equal(opened, message) {
+		t.Errorf("opened %q with %v", opened, err)
+	}
+	// invalid keys are ignored
+	if k := newKeyring([]string{"short"}, false); len(k.keys) != 0 {
+		t.Errorf("an invalid key was installed")
+	}
+}
+
+// a key is rotated by installing it, using it, then removing the old one
+func TestRotation(t *testing.T) {
+	old, current := GenerateKey(), GenerateKey()
+	ms := &MemberServer{keyring: newKeyring([]string{old}, false)}
+	sealed, err := ms.keyring.seal([]byte("before"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ms.UseKey(current); err != ErrUnknownKey {
+		t.Errorf("used a key that is not installed with %v", err)
+	}
+	if err := ms.InstallKey(current); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.InstallKey(current); err != nil || len(ms.Keys()) != 2 {
+		t.Errorf("installing a key twice: %v, keys %v", err, ms.Keys())
+	}
+	if err := ms.UseKey(current); err != nil {
+		t.Fatal(err)
+	}
+	if keys := ms.Keys(); !reflect.DeepEqual(keys, []string{current, old}) {
+		t.Errorf("keys %v, expected the one in use first", keys)
+	}
+	if err := ms.RemoveKey(current); err != ErrPrimaryKey {
+		t.Errorf("removed the key in use with %v", err)
+	}
+
+	// messages sealed under the old key still open until it is removed
+	if _, err := ms.keyring.open(sealed); err != nil {
+		t.Errorf("a message under the old key was rejected: %v", err)
+	}
+	if err := ms.RemoveKey(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.RemoveKey(old); err != ErrUnknownKey {
+		t.Errorf("removed a key twice with %v", err)
+	}
+	if _, err := ms.keyring.open(sealed); err != errUnauthenticated {
+		t.Errorf("a message under a removed key was opened with %v", err)
+	}
+	if keys := ms.Keys(); !reflect.DeepEqual(keys, []string{current}) {
+		t.Errorf("keys %v, expected only %v", keys, current)
+	}
+}
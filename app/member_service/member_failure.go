@@ -2,50 +2,97 @@ package member_service
 
 /*
 This file is used to handle a failure of node, especially, the failure of the master.
-Once the master is detected to be failed, the member service would wait and then begin an election.
-The election is implemented simply by choosing the node with biggest unique ID.
- */
-
-import (
-	"better_mp3/app/config"
-	"better_mp3/app/logger"
-	"strings"
-	"time"
-)
-
-// MachineID to be in format IP:timestamp
+The master is elected by the raft service: time is divided into terms, and each term has at most
+one master. The raft service hands every new term and leader to FollowLeader, and a master that
+enters a newer term steps down. Membership messages carry the term and master of their sender for
+display only. The file and maplejuice services stamp their requests with the term, which fences
+out members that missed an election.
+*/
+
+// MachineID to be in format host:port#timestamp, the caller holds ms.mux
 func (ms *MemberServer) HandleMemberFailure(machineID string) {
-	ip := strings.Split(machineID, ":")[0]
-	ms.FailedNodeChan <- ip
-	if ip == ms.LeaderIP {
-		logger.PrintInfo("Master is down. Please waiting for electing a new Master...")
-		go ms.Election()
+	if member, ok := ms.localMessage.MemberList[machineID]; ok && member.IsLeaving {
+		ms.publish(EventLeft, machineID)
+	} else {
+		ms.publish(EventFailed, machineID)
+	}
+	if machineID == ms.localMessage.Leader {
+		memberLog.Info("Master is down, waiting for the raft service to elect a new one...")
 	}
 }
 
-func (ms *MemberServer) Election() {
-	time.Sleep(config.WaitTimeForElection * time.Second)
-	logger.PrintInfo("Begin electing a new master:")
-	newMasterID := ms.getLargestAliveServer()
-	if ms.SelfID == newMasterID {
-		logger.PrintInfo("This server has been elected as the new master.")
-		ms.IsLeader = true
-	} else {
-		logger.PrintInfo("New master is selected:", newMasterID)
+// Term returns the current election term, requests of other services are stamped with it
+func (ms *MemberServer) Term() int64 {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.localMessage == nil {
+		return 0
+	}
+	return ms.localMessage.Term
+}
+
+// Leader returns the address of the master followed in the current term, empty when there is none
+func (ms *MemberServer) Leader() (string, int64) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.localMessage == nil || ms.localMessage.Leader == "" {
+		return "", 0
 	}
-	ms.LeaderIP = strings.Split(newMasterID, ":")[0]
-	// notify the file service
-	ms.MasterChanged <- 1
+	return AddrOfID(ms.localMessage.Leader), ms.localMessage.Term
 }
 
-func (ms *MemberServer) getLargestAliveServer() string {
-	largestID := ms.SelfID
-	for machineID, member := range ms.localMessage.MemberList {
-		if !ms.failureList[machineID] && !member.IsLeaving {
-			if strings.Compare(machineID, largestID) > 0 {
-				largestID = machineID
-			}
+// FollowLeader makes the member at addr the master of term, an empty addr leaves the term
+// without a master. It fails while that member is not in the membership list.
+func (ms *MemberServer) FollowLeader(addr string, term int64) bool {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.localMessage == nil {
+		return false
+	}
+	if term < ms.localMessage.Term {
+		return true
+	}
+	if addr == "" {
+		if term > ms.localMessage.Term || ms.localMessage.Leader != "" {
+			ms.enterTerm(term, "")
 		}
+		return true
 	}
-	return largestID
-}
\ No newline at end of file
+	for machineID := range ms.localMessage.MemberList {
+		if AddrOfID(machineID) != addr || ms.failureList[machineID] {
+			continue
+		}
+		if term > ms.localMessage.Term || machineID != ms.localMessage.Leader {
+			ms.enterTerm(term, machineID)
+		}
+		return true
+	}
+	return false
+}
+
+func (ms *MemberServer) enterTerm(term int64, leader string) {
+	if ms.IsLeader {
+		memberLog.Info("Stepping down as master, term", term, "has begun")
+	}
+	ms.localMessage.Term = term
+	ms.localMessage.Leader = ""
+	ms.IsLeader = false
+	if leader != "" {
+		ms.setLeader(leader)
+	} else {
+		ms.publish(EventLeaderChanged, "")
+	}
+}
+
+func (ms *MemberServer) setLeader(leader string) {
+	ms.localMessage.Leader = leader
+	ms.LeaderAddr = AddrOfID(leader)
+	ms.IsLeader = leader == ms.SelfID
+	if !ms.IsLeader {
+		memberLog.Info("New master is selected:", leader, "term", ms.localMessage.Term)
+	}
+	ms.publish(EventLeaderChanged, leader)
+}
@@ -2,45 +2,42 @@ package member_service
 
 import (
 	"better_mp3/app/config"
-	"better_mp3/app/logger"
 	"better_mp3/app/member_service/protocol_buffer"
-	"cs425_mp2/util"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/jinzhu/copier"
 
 )
 
-func (ms *MemberServer) initMembershipList(isGossip bool) {
+func (ms *MemberServer) initMembershipList(strategy string) {
 	selfMember := protocol_buffer.Member{
 		HeartbeatCounter: 1,
 		LastSeen:         ptypes.TimestampNow(),
 	}
-
-	strat := config.STRAT_GOSSIP
-
-	if !isGossip {
-		strat = config.STRAT_ALL
-	}
+	ms.copyMetadata(&selfMember)
 
 	ms.localMessage = &protocol_buffer.MembershipServiceMessage{
 		MemberList:      make(map[string]*protocol_buffer.Member),
-		Strategy:        strat,
+		Strategy:        strategy,
 		StrategyCounter: 1,
 	}
 
+	ms.SelfID = ms.SelfAddr + "#" + ptypes.TimestampString(selfMember.LastSeen)
+	ms.known = map[string]bool{ms.SelfAddr: true}
+	ms.quorum = true
+	ms.broadcasts = make(map[string]int)
+
 	if ms.IsLeader {
 		ms.localMessage.Type = protocol_buffer.MessageType_STANDARD
+		ms.localMessage.Leader = ms.SelfID
 	} else {
 		ms.localMessage.Type = protocol_buffer.MessageType_JOINREQ
 	}
 
-	localIP := util.GetLocalIPAddr().String()
-	ms.SelfID = localIP + ":" + ptypes.TimestampString(selfMember.LastSeen)
-
 	ms.AddMemberToMembershipList(ms.localMessage, ms.SelfID, &selfMember)
 }
 
@@ -49,62 +46,90 @@ func (ms *MemberServer) mergeMembershipLists(localMessage, remoteMessage *protoc
 	if remoteMessage.StrategyCounter > localMessage.StrategyCounter {
 		localMessage.Strategy = remoteMessage.Strategy
 		localMessage.StrategyCounter = remoteMessage.StrategyCounter
-		logger.PrintInfo("Received request to change system strategy to", localMessage.Strategy)
+		memberLog.Info("Received request to change system strategy to", localMessage.Strategy)
 	}
 
+	isSwim := localMessage.Strategy == config.STRAT_SWIM
+
 	for machineID, member := range remoteMessage.MemberList {
 		if _, ok := localMessage.MemberList[machineID]; !ok {
 			if remoteMessage.MemberList[machineID].IsLeaving {
 				break
 			}
+			// a member we already removed, don't bring it back
+			if member.State == protocol_buffer.MemberState_DEAD {
+				continue
+			}
 
 			memberCpy := protocol_buffer.Member{}
 			err := copier.Copy(&memberCpy, &member)
 			if err != nil {
-				logger.PrintError("Error when copying: ", err)
+				memberLog.Error("Error when copying: ", err)
+			}
+			if isSwim {
+				// swim timers are local, they start when we learn about the member
+				memberCpy.LastSeen = ptypes.TimestampNow()
 			}
 			ms.AddMemberToMembershipList(localMessage, machineID, &memberCpy)
 			continue
-		} else if remoteMessage.MemberList[machineID].IsLeaving {
+		} else if remoteMessage.MemberList[machineID].IsLeaving && !localMessage.MemberList[machineID].IsLeaving {
 			localMessage.MemberList[machineID].IsLeaving = true
+			ms.queueBroadcast(machineID)
 		}
 
+		ms.mergeMetadata(machineID, localMessage.MemberList[machineID], member)
+
 		remoteHeartBeat := remoteMessage.MemberList[machineID].HeartbeatCounter
 
-		if localMessage.MemberList[machineID].HeartbeatCounter < remoteHeartBeat {
-			delete(failureList, machineID)
+		if isSwim {
+			if localMessage.MemberList[machineID].HeartbeatCounter < remoteHeartBeat {
+				localMessage.MemberList[machineID].HeartbeatCounter = remoteHeartBeat
+			}
+			ms.mergeSwimMember(machineID, localMessage.MemberList[machineID], member)
+		} else if localMessage.MemberList[machineID].HeartbeatCounter < remoteHeartBeat {
+			if failureList[machineID] {
+				delete(failureList, machineID)
+				ms.publish(EventJoined, machineID)
+			}
 			localMessage.MemberList[machineID].HeartbeatCounter = remoteHeartBeat
 			localMessage.MemberList[machineID].LastSeen = ptypes.TimestampNow()
+			ms.recordHeartbeat(machineID)
 		}
 	}
 	return localMessage
 }
 
-// GetOtherMembershipListIPs : Expecting MachineID to be in format IP:timestamp
-func GetOtherMembershipListIPs(message *protocol_buffer.MembershipServiceMessage, selfID string) []string {
-	ips := make([]string, 0, len(message.MemberList))
+// AddrOfID : MachineID is in format host:port#timestamp, the timestamp tells apart restarts of the same node
+func AddrOfID(machineID string) string {
+	return strings.Split(machineID, "#")[0]
+}
+
+// GetOtherMembershipListAddrs : Expecting MachineID to be in format host:port#timestamp
+func GetOtherMembershipListAddrs(message *protocol_buffer.MembershipServiceMessage, selfID string) []string {
+	addrs := make([]string, 0, len(message.MemberList))
 
 	for machineID := range message.MemberList {
-		splitRes := strings.Split(machineID, ":")
 		if machineID != selfID {
-			ips = append(ips, splitRes[0])
+			addrs = append(addrs, AddrOfID(machineID))
 		}
 	}
 
-	return ips
+	return addrs
 }
 
 // CheckAndRemoveMembershipListFailures : Upon sending of membership list mark failures and remove failed machines
 func (ms *MemberServer) CheckAndRemoveMembershipListFailures(message *protocol_buffer.MembershipServiceMessage, failureList *map[string]bool) {
 	for machineID, member := range message.MemberList {
-		timeElapsedSinceLastSeen := float64(ptypes.TimestampNow().GetSeconds() - member.LastSeen.GetSeconds())
+		timeElapsedSinceLastSeen := time.Since(member.LastSeen.AsTime())
 
-		if timeElapsedSinceLastSeen >= config.T_TIMEOUT+config.T_CLEANUP {
+		failTimeout := ms.failTimeout(machineID)
+
+		if timeElapsedSinceLastSeen >= failTimeout+ms.config.RemoveTime {
 			delete(*failureList, machineID)
 			ms.RemoveMemberFromMembershipList(message, machineID)
-		} else if !(*failureList)[machineID] && timeElapsedSinceLastSeen >= config.T_TIMEOUT {
+		} else if !(*failureList)[machineID] && timeElapsedSinceLastSeen >= failTimeout {
 			(*failureList)[machineID] = true
-			logger.PrintInfo("Marking machine", machineID, "as failed")
+			memberLog.Info("Marking machine", machineID, "as failed")
 			ms.HandleMemberFailure(machineID)
 		}
 	}
@@ -113,14 +138,15 @@ func (ms *MemberServer) CheckAndRemoveMembershipListFailures(message *protocol_b
 // AddMemberToMembershipList : add new member to membership list
 func (ms *MemberServer) AddMemberToMembershipList(message *protocol_buffer.MembershipServiceMessage, machineID string, member *protocol_buffer.Member) {
 	message.MemberList[machineID] = member
-	ms.JoinedNodeChan <- strings.Split(machineID, ":")[0]
-	logger.PrintInfo("Adding machine", machineID, "to membership list")
+	ms.publish(EventJoined, machineID)
+	memberLog.Info("Adding machine", machineID, "to membership list")
 }
 
 // RemoveMemberFromMembershipList : remove member from membership list
 func (ms *MemberServer) RemoveMemberFromMembershipList(message *protocol_buffer.MembershipServiceMessage, machineID string) {
 	delete(message.MemberList, machineID)
-	logger.PrintInfo("Removing machine", machineID, "from membership list")
+	delete(ms.arrivals, machineID)
+	memberLog.Info("Removing machine", machineID, "from membership list")
 }
 
 func (ms *MemberServer) GetMembershipListString(message *protocol_buffer.MembershipServiceMessage, failureList map[string]bool) string {
@@ -136,13 +162,26 @@ func (ms *MemberServer) GetMembershipListString(message *protocol_buffer.Members
 	for _, machineID := range machineIDs {
 		if failureList[machineID] {
 			sb.WriteString("FAILED:")
+		} else if message.MemberList[machineID].State == protocol_buffer.MemberState_SUSPECT {
+			sb.WriteString("SUSPECT:")
 		}
 		sb.WriteString(machineID +
 			" - { HeartbeatCounter: " +
 			strconv.Itoa(int(message.MemberList[machineID].HeartbeatCounter)) +
+			", Incarnation: " +
+			strconv.Itoa(int(message.MemberList[machineID].Incarnation)) +
 			", LastSeen: " +
-			ptypes.TimestampString(message.MemberList[machineID].LastSeen) +
-			" }\n")
+			ptypes.TimestampString(message.MemberList[machineID].LastSeen))
+		if value, ok := ms.currentPhi(machineID); ok {
+			sb.WriteString(", Phi: " + strconv.FormatFloat(value, 'f', 2, 64))
+		}
+		if tags := message.MemberList[machineID].Tags; len(tags) > 0 {
+			sb.WriteString(", Tags: " + formatTags(tags))
+		}
+		if endpoints := message.MemberList[machineID].Endpoints; len(endpoints) > 0 {
+			sb.WriteString(", Endpoints: " + formatTags(endpoints))
+		}
+		sb.WriteString(" }\n")
 	}
 
 	sb.WriteString("\n")
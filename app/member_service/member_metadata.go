@@ -0,0 +1,190 @@
+package member_service
+
+/*
+Members describe themselves with key/value tags, e.g. a zone or a capacity, and with the endpoints
+of their services. Only a member changes its own metadata; every change raises its MetaVersion,
+and gossip keeps the copy with the highest version. Upper level services look up the endpoints of
+a node and filter the members by tag.
+*/
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/member_service/protocol_buffer"
+	"net"
+	"sort"
+	"strings"
+)
+
+// the services that advertise an endpoint
+const (
+	EndpointMember     = "member"
+	EndpointFile       = "file"
+	EndpointMapleJuice = "maple_juice"
+	EndpointRaft       = "raft"
+	EndpointHTTP       = "http"
+)
+
+// SetTag sets a tag of this member
+func (ms *MemberServer) SetTag(key string, value string) {
+	ms.updateMetadata(func() {
+		ms.tags[key] = value
+	})
+}
+
+// RemoveTag removes a tag of this member
+func (ms *MemberServer) RemoveTag(key string) {
+	ms.updateMetadata(func() {
+		delete(ms.tags, key)
+	})
+}
+
+// AdvertiseEndpoint tells the other members that this node runs service on port
+func (ms *MemberServer) AdvertiseEndpoint(service string, port string) {
+	host, _, _ := net.SplitHostPort(ms.SelfAddr)
+	ms.updateMetadata(func() {
+		ms.endpoints[service] = net.JoinHostPort(host, port)
+	})
+}
+
+// updateMetadata applies change to the metadata of this member and raises its version
+func (ms *MemberServer) updateMetadata(change func()) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	change()
+	ms.metaVersion++
+	if ms.localMessage == nil {
+		return
+	}
+	if self, ok := ms.localMessage.MemberList[ms.SelfID]; ok {
+		ms.copyMetadata(self)
+		ms.publish(EventUpdated, ms.SelfID)
+	}
+}
+
+// copyMetadata puts the metadata of this member into its entry of the list, the caller holds ms.mux.
+// The maps are replaced rather than changed, since encoded messages may still share them.
+func (ms *MemberServer) copyMetadata(self *protocol_buffer.Member) {
+	self.Tags = copyTags(ms.tags)
+	self.Endpoints = copyTags(ms.endpoints)
+	self.MetaVersion = ms.metaVersion
+}
+
+// mergeMetadata keeps the newer metadata of another member, the caller holds ms.mux
+func (ms *MemberServer) mergeMetadata(machineID string, local, remote *protocol_buffer.Member) {
+	if machineID == ms.SelfID || remote.MetaVersion <= local.MetaVersion {
+		return
+	}
+	local.Tags = copyTags(remote.Tags)
+	local.Endpoints = copyTags(remote.Endpoints)
+	local.MetaVersion = remote.MetaVersion
+	ms.publish(EventUpdated, machineID)
+}
+
+// Members returns the alive members, sorted by address
+func (ms *MemberServer) Members() []MemberInfo {
+	return ms.MembersWhere(func(MemberInfo) bool { return true })
+}
+
+// MembersWithTag returns the alive members whose tag key is value, or that have the tag at all
+// when value is empty
+func (ms *MemberServer) MembersWithTag(key string, value string) []MemberInfo {
+	return ms.MembersWhere(func(member MemberInfo) bool {
+		tag, ok := member.Tags[key]
+		return ok && (value == "" || tag == value)
+	})
+}
+
+// MembersWhere returns the alive members that match, sorted by address
+func (ms *MemberServer) MembersWhere(match func(MemberInfo) bool) []MemberInfo {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	members := make([]MemberInfo, 0)
+	if ms.localMessage == nil {
+		return members
+	}
+	for machineID, member := range ms.localMessage.MemberList {
+		if member.IsLeaving || ms.failureList[machineID] {
+			continue
+		}
+		if info := ms.memberInfo(machineID); match(info) {
+			members = append(members, info)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Addr < members[j].Addr
+	})
+	return members
+}
+
+// AllMembers returns every member of the list, the suspected, failed and leaving ones included,
+// sorted by address
+func (ms *MemberServer) AllMembers() []MemberInfo {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	members := make([]MemberInfo, 0)
+	if ms.localMessage == nil {
+		return members
+	}
+	for machineID := range ms.localMessage.MemberList {
+		members = append(members, ms.memberInfo(machineID))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Addr != members[j].Addr {
+			return members[i].Addr < members[j].Addr
+		}
+		return members[i].ID < members[j].ID
+	})
+	return members
+}
+
+// Endpoint returns where the node at nodeAddr runs service, as advertised by that node
+func (ms *MemberServer) Endpoint(nodeAddr string, service string) (string, bool) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.localMessage == nil {
+		return "", false
+	}
+	// a node that restarted may be listed twice for a while, its latest ID sorts last
+	latest := ""
+	for machineID := range ms.localMessage.MemberList {
+		if AddrOfID(machineID) == nodeAddr && machineID > latest {
+			latest = machineID
+		}
+	}
+	if latest == "" {
+		return "", false
+	}
+	endpoint, ok := ms.localMessage.MemberList[latest].Endpoints[service]
+	return endpoint, ok
+}
+
+// ServiceAddr returns the advertised endpoint of service on the node at nodeAddr. Until it is
+// known, the service is assumed to keep the same distance to the member port as port on this node.
+func (ms *MemberServer) ServiceAddr(nodeAddr string, service string, port string) string {
+	if endpoint, ok := ms.Endpoint(nodeAddr, service); ok {
+		return endpoint
+	}
+	return config.ServiceAddr(nodeAddr, ms.SelfAddr, port)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	copied := make(map[string]string, len(tags))
+	for key, value := range tags {
+		copied[key] = value
+	}
+	return copied
+}
+
+// formatTags lists tags as key=value, sorted by key
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
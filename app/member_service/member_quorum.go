@@ -0,0 +1,107 @@
+package member_service
+
+/*
+This file keeps track of partitions. The member service remembers the last known membership: every
+address that joined, until it leaves voluntarily or it is removed while this member still reaches a
+majority. A member that is cut off from the others doesn't forget them, so it can't mistake the
+side it is on for the whole cluster. Operations that need a majority (copying the files of failed
+nodes, scheduling maple juice jobs, writing files) check HasQuorum, and the side without a quorum
+stays read-only until the partition heals, meanwhile it keeps asking the members it lost to let it
+join again.
+*/
+
+import (
+	"better_mp3/app/member_service/protocol_buffer"
+	"sort"
+)
+
+// updateQuorum folds the membership list into the last known membership, the caller holds ms.mux
+func (ms *MemberServer) updateQuorum() {
+	present := map[string]bool{}
+	for machineID, member := range ms.localMessage.MemberList {
+		addr := AddrOfID(machineID)
+		if member.IsLeaving {
+			delete(ms.known, addr)
+			continue
+		}
+		present[addr] = true
+		if !ms.failureList[machineID] {
+			ms.known[addr] = true
+		}
+	}
+
+	quorum := ms.countQuorum()
+	if quorum {
+		// members removed from the list while we reach a majority are gone for good
+		for addr := range ms.known {
+			if !present[addr] {
+				delete(ms.known, addr)
+			}
+		}
+	}
+	if quorum != ms.quorum {
+		if quorum {
+			memberLog.Info("Reached a majority of the membership again, leaving read-only mode")
+		} else {
+			memberLog.Warn("Lost the majority of the membership", ms.knownAddrs(), "entering read-only mode")
+		}
+		ms.quorum = quorum
+	}
+}
+
+// reachLostMembers asks the known members missing from the list to let us join again. Once
+// both sides of a partition removed each other, heartbeats no longer cross it, so the side
+// without a quorum looks for the others. The caller holds ms.mux.
+func (ms *MemberServer) reachLostMembers() {
+	present := map[string]bool{}
+	for machineID := range ms.localMessage.MemberList {
+		if !ms.failureList[machineID] {
+			present[AddrOfID(machineID)] = true
+		}
+	}
+
+	message, err := ms.encodeMessage(ms.outgoingMessage(protocol_buffer.MessageType_JOINREQ, true))
+	if err != nil {
+		memberLog.Error("Failed to encode join request:", err)
+		return
+	}
+	for addr := range ms.known {
+		if !present[addr] {
+			ms.send(addr, message)
+		}
+	}
+}
+
+// countQuorum tells whether the alive members are a majority of the last known membership
+func (ms *MemberServer) countQuorum() bool {
+	alive := 0
+	for machineID, member := range ms.localMessage.MemberList {
+		if !member.IsLeaving && !ms.failureList[machineID] && ms.known[AddrOfID(machineID)] {
+			alive++
+		}
+	}
+	return 2*alive > len(ms.known)
+}
+
+func (ms *MemberServer) knownAddrs() []string {
+	addrs := make([]string, 0, len(ms.known))
+	for addr := range ms.known {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// HasQuorum tells whether this member reaches a majority of the last known membership
+func (ms *MemberServer) HasQuorum() bool {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	return ms.localMessage != nil && ms.quorum
+}
+
+// KnownMembers returns the last known membership that quorums are counted against
+func (ms *MemberServer) KnownMembers() []string {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	return ms.knownAddrs()
+}
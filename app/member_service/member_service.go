@@ -2,7 +2,11 @@
 This package provides member service, including:
 	1. membership list
 	2. failure detector
-	3. simple master election
+	3. the master and term elected by the raft service
+	4. partition awareness through a quorum of the last known membership
+	5. member metadata: tags and service endpoints
+	6. delta gossip with periodic full syncs over tcp
+	7. authenticated, optionally encrypted messages
 
 Credit: This package is adapted from CS425 Fall Recommended MP1 Solutions.
 */
@@ -12,181 +16,312 @@ import (
 	"better_mp3/app/config"
 	"better_mp3/app/logger"
 	"better_mp3/app/member_service/protocol_buffer"
+	"errors"
 	"github.com/golang/protobuf/ptypes"
+	"math/rand"
+	"net"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var memberLog = logger.New(logger.ComponentMember)
+
 type MemberServer struct {
 	config       config.MemberServiceConfig
 	failureList  map[string]bool
-	useGossip    bool
 	localMessage *protocol_buffer.MembershipServiceMessage
 	mux          sync.Mutex
+	conn         *net.UDPConn
 
-	isSending bool
+	// read without ms.mux by the heartbeat and receive loops
+	isSending atomic.Bool
 	isJoining bool
 
-	SelfIP string
-	SelfID string
+	// the members asked to let us join, and when to ask the next one, see sendJoinRequest
+	seeds       []string
+	joinAttempt int
+	nextJoin    time.Time
+
+	// the metadata of this member, see member_metadata.go
+	tags        map[string]string
+	endpoints   map[string]string
+	metaVersion int64
+
+	// the last known membership by address, and whether the alive members are a majority of it
+	known  map[string]bool
+	quorum bool
+
+	// the changed entries to piggyback and how often each was sent, see broadcast.go
+	broadcasts map[string]int
+	// the cluster keys messages are sealed with, see keyring.go
+	keyring *keyring
+	// the tcp listener of the full syncs, see anti_entropy.go
+	syncListener net.Listener
+	syncDone     chan struct{}
+
+	// swim probes in flight by sequence number, and the members left to probe this round
+	seqNo      uint64
+	probes     map[uint64]chan bool
+	probeOrder []string
 
-	LeaderIP string
+	health   localHealth
+	arrivals map[string]*arrivalHistory
+
+	SelfAddr string
+	SelfID   string
+
+	LeaderAddr string
 	IsLeader bool
 
-	// these channels are used by upper level service
-	FailedNodeChan chan string
-	JoinedNodeChan chan string
-	MasterChanged  chan int
+	// upper level services subscribe to membership events, see events.go
+	events eventBus
+
+	metrics *memberMetrics
 }
 
 func NewMemberServer() *MemberServer {
+	return NewMemberServerWithConfig(config.GetMemberServiceConfig())
+}
+
+// NewMemberServerWithConfig doesn't read the global config, so that several
+// member servers can run in one process
+func NewMemberServerWithConfig(memberConfig config.MemberServiceConfig) *MemberServer {
 	var ms MemberServer
 
-	ms.SelfIP = GetLocalIPAddr()
-	ms.config = config.GetMemberServiceConfig()
-	ms.LeaderIP = ms.config.IntroducerIP
-	ms.IsLeader = ms.SelfIP == ms.config.IntroducerIP
-	ms.useGossip = false // todo: change
-	ms.MasterChanged = make(chan int)
-	ms.isSending = true
+	ms.config = memberConfig
+	ms.SelfAddr = net.JoinHostPort(ms.config.Host, ms.config.Port)
+	ms.LeaderAddr = ms.config.Introducer
+	ms.IsLeader = ms.SelfAddr == ms.config.Introducer
+	ms.isSending.Store(true)
 	ms.isJoining = !ms.IsLeader
-	ms.FailedNodeChan = make(chan string, 10)
-	ms.JoinedNodeChan = make(chan string, 10)
+	ms.seeds = ms.joinSeeds(ms.config.Introducer, ms.config.Seeds)
 	ms.failureList = make(map[string]bool)
-	ms.initMembershipList(ms.useGossip)
-
-
+	ms.probes = make(map[uint64]chan bool)
+	ms.health = localHealth{max: ms.config.HealthMax}
+	ms.arrivals = make(map[string]*arrivalHistory)
+	ms.tags = copyTags(ms.config.Tags)
+	ms.endpoints = map[string]string{EndpointMember: ms.SelfAddr}
+	ms.keyring = newKeyring(ms.config.Keys, ms.config.Encrypt)
+	ms.metrics = metricsOf(ms.SelfAddr)
+	ms.initMembershipList(ms.config.Strategy)
 
 	return &ms
 }
 
 // the entry point of the package, run the member service
 func (ms *MemberServer) Run() {
-	go Listen(config.MemberServicePort, ms.readNewMessage)
+	conn, err := ListenUDP(ms.config.Port)
+	if err != nil {
+		memberLog.Error("Failed to listen on port", ms.config.Port, err)
+		return
+	}
+	ms.conn = conn
+	go Serve(conn, ms.config.BufferSize, ms.readNewMessage)
+	if err := ms.listenSync(); err != nil {
+		memberLog.Error("Failed to listen for full syncs on port", ms.config.Port, err)
+	}
 	go ms.startHeartbeat()
 
-	logger.PrintInfo(
+	memberLog.Info(
 		"Member Service is now running\n",
-		"\tPort:", config.MemberServicePort,
+		"\tPort:", ms.config.Port,
 		"\tIs Master:", ms.IsLeader,
-		"\tMasterIP:", ms.LeaderIP,
-		"\tIs gossip:", ms.useGossip,
+		"\tMaster:", ms.LeaderAddr,
+		"\tStrategy:", ms.config.Strategy,
 		"\n",
 		"\tMember Self ID:", ms.SelfID)
 }
 
+// Stop leaves the group and closes the membership listener
+func (ms *MemberServer) Stop() {
+	if ms.isSending.Load() {
+		ms.sendLeaveRequest()
+	}
+	if ms.conn != nil {
+		_ = ms.conn.Close()
+	}
+	ms.closeSync()
+	memberLog.Info("Member service stopped")
+}
+
+// Crash stops the member service without telling anyone, as if the process had died.
+// It is used by the test harness to inject failures.
+func (ms *MemberServer) Crash() {
+	ms.isSending.Store(false)
+	if ms.conn != nil {
+		_ = ms.conn.Close()
+	}
+	ms.closeSync()
+}
+
 /*
 	Following methods are exported for other packages so that they can access the membership list
 */
 
-func (ms *MemberServer) GetAliveMemberIPList() []string {
-	ipList := make([]string, 0)
+func (ms *MemberServer) GetAliveMemberAddrList() []string {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	addrList := make([]string, 0)
+	if ms.localMessage == nil {
+		return addrList
+	}
 	for machineID, member := range ms.localMessage.MemberList {
 		if !ms.failureList[machineID] && !member.IsLeaving {
 			//if machineID == selfID {
 			//	continue
 			//}
-			ip := strings.Split(machineID, ":")[0]
-			ipList = append(ipList, ip)
+			addrList = append(addrList, AddrOfID(machineID))
 		}
 	}
-	sort.Strings(ipList)
-	return ipList
+	sort.Strings(addrList)
+	return addrList
 }
 
-func (ms *MemberServer) GetFailedMemberIPList() []string {
+func (ms *MemberServer) GetFailedMemberAddrList() []string {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
 	failNodes := make([]string, 0)
 	for k := range ms.failureList {
 		if ms.failureList[k] {
-			ip := strings.Split(k, ":")[0]
-			failNodes = append(failNodes, ip)
+			failNodes = append(failNodes, AddrOfID(k))
 		}
 	}
 	return failNodes
 }
 
-func (ms *MemberServer) ChangeStrategy(input string) {
+func (ms *MemberServer) ChangeStrategy(input string) error {
 	if input == config.STRAT_GOSSIP {
 		if ms.localMessage.Strategy == config.STRAT_GOSSIP {
-			logger.PrintError("System strategy is already gossip")
-			return
+			return errors.New("system strategy is already gossip")
 		}
 
 		ms.localMessage.Strategy = config.STRAT_GOSSIP
 	} else if input == config.STRAT_ALL {
 		if ms.localMessage.Strategy == config.STRAT_ALL {
-			logger.PrintError("System strategy is already all-to-all")
-			return
+			return errors.New("system strategy is already all-to-all")
 		}
 
 		ms.localMessage.Strategy = config.STRAT_ALL
+	} else if input == config.STRAT_SWIM {
+		if ms.localMessage.Strategy == config.STRAT_SWIM {
+			return errors.New("system strategy is already swim")
+		}
+
+		ms.localMessage.Strategy = config.STRAT_SWIM
 	} else {
-		logger.PrintError("Invalid strategy - must be gossip or all")
-		return
+		return errors.New("invalid strategy - must be gossip, all or swim")
 	}
 
 	ms.localMessage.StrategyCounter++
-	logger.PrintInfo("System strategy successfully changed to", ms.localMessage.Strategy)
+	memberLog.Info("System strategy successfully changed to", ms.localMessage.Strategy)
+	return nil
+}
+
+// WaitForJoin blocks until the introducer has answered our join request
+func (ms *MemberServer) WaitForJoin(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ms.mux.Lock()
+		joined := ms.isSending.Load() && !ms.isJoining
+		ms.mux.Unlock()
+
+		if joined {
+			return nil
+		}
+		if !ms.isSending.Load() {
+			return errors.New("not joining any group")
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for join reply from " + strings.Join(ms.seeds, ", "))
+		}
+		time.Sleep(ms.config.GossipInterval)
+	}
 }
 
 func (ms *MemberServer) sendLeaveRequest() {
-	ms.isSending = false
+	ms.isSending.Store(false)
 
 	ms.mux.Lock()
 	ms.localMessage.MemberList[ms.SelfID].IsLeaving = true
 
 	if ms.localMessage.Strategy == config.STRAT_GOSSIP {
-		HeartbeatGossip(ms.localMessage, config.GOSSIP_FANOUT, ms.SelfID)
+		ms.sendGossip()
 	} else {
-		HeartbeatAllToAll(ms.localMessage, ms.SelfID)
+		ms.sendHeartbeat()
 	}
-	ms.mux.Unlock()
 
 	ms.SelfID = ""
 	ms.localMessage = nil
-	logger.PrintInfo("Successfully left")
+	ms.IsLeader = false
+	ms.mux.Unlock()
+	memberLog.Info("Successfully left")
 }
 
 func (ms *MemberServer) readNewMessage(message []byte) error {
-	if !ms.isSending {
+	if !ms.isSending.Load() {
 		return nil
 	}
+	ms.metrics.gossipBytes.WithLabelValues("received").Add(float64(len(message)))
+	ms.metrics.gossipMessages.WithLabelValues("received").Inc()
 
-	remoteMessage, err := DecodeMembershipServiceMessage(message)
+	remoteMessage, err := ms.decodeMessage(message)
 	if err != nil {
 		return err
 	}
-	logger.PrintDebug("Member service received message:", remoteMessage)
+	memberLog.Debug("Member service received message:", remoteMessage)
 
 	ms.mux.Lock()
 
+	// we may have left while waiting for the lock
+	if ms.localMessage == nil {
+		ms.mux.Unlock()
+		return nil
+	}
+
 	if ms.isJoining && remoteMessage.Type == protocol_buffer.MessageType_JOINREP {
 		ms.isJoining = false
 		ms.localMessage.Type = protocol_buffer.MessageType_STANDARD
+		// the reply only holds what fits in a datagram, the whole list comes over tcp
+		if remoteMessage.Sender != "" {
+			go ms.pushPull(AddrOfID(remoteMessage.Sender))
+		}
 	}
 
-	if !ms.IsLeader && remoteMessage.Type == protocol_buffer.MessageType_JOINREQ {
+	// any member that has joined answers a join request
+	if ms.isJoining && remoteMessage.Type == protocol_buffer.MessageType_JOINREQ {
 		ms.mux.Unlock()
 		return nil
 	}
+	// a join request without a sender is answered at a member it lists
+	if remoteMessage.Type == protocol_buffer.MessageType_JOINREQ && remoteMessage.Sender == "" &&
+		len(GetOtherMembershipListAddrs(remoteMessage, ms.SelfID)) == 0 {
+		ms.mux.Unlock()
+		memberLog.Warn("Dropping a join request that names no member to answer")
+		return nil
+	}
 
-	logger.PrintDebug("Merging membership list.")
+	memberLog.Debug("Merging membership list.")
 	ms.mergeMembershipLists(ms.localMessage, remoteMessage, ms.failureList)
+	ms.handleProbeMessage(remoteMessage)
 
-	if ms.IsLeader && remoteMessage.Type == protocol_buffer.MessageType_JOINREQ {
-		logger.PrintInfo("Received a join request.")
-		ms.localMessage.Type = protocol_buffer.MessageType_JOINREP
-		message, err := EncodeMembershipServiceMessage(ms.localMessage)
-		ms.localMessage.Type = protocol_buffer.MessageType_STANDARD
-
+	if remoteMessage.Type == protocol_buffer.MessageType_JOINREQ {
+		memberLog.Info("Received a join request.")
+		message, err := ms.encodeMessage(ms.outgoingMessage(protocol_buffer.MessageType_JOINREP, true))
 		if err != nil {
+			ms.mux.Unlock()
 			return err
 		}
 
-		dests := GetOtherMembershipListIPs(remoteMessage, ms.SelfID)
-		Send(dests[0], message)
+		dest := AddrOfID(remoteMessage.Sender)
+		if remoteMessage.Sender == "" {
+			dest = GetOtherMembershipListAddrs(remoteMessage, ms.SelfID)[0]
+		}
+		ms.send(dest, message)
 	}
 
 	ms.mux.Unlock()
@@ -195,36 +330,90 @@ func (ms *MemberServer) readNewMessage(message []byte) error {
 }
 
 func (ms *MemberServer) startHeartbeat() {
-	for ms.isSending {
+	lastTick := time.Now()
+	for ms.isSending.Load() {
 		ms.mux.Lock()
+		if ms.localMessage == nil {
+			ms.mux.Unlock()
+			break
+		}
+		ms.checkHeartbeatDelay(time.Since(lastTick))
+		lastTick = time.Now()
 
 		ms.localMessage.MemberList[ms.SelfID].LastSeen = ptypes.TimestampNow()
 		ms.localMessage.MemberList[ms.SelfID].HeartbeatCounter++
-		ms.CheckAndRemoveMembershipListFailures(ms.localMessage, &ms.failureList)
-		logger.InfoLogger.Println("Current memberlist:\n", ms.GetMembershipListString(ms.localMessage, ms.failureList), "\n")
+		if ms.localMessage.Strategy == config.STRAT_SWIM {
+			ms.checkSuspects()
+		} else {
+			ms.CheckAndRemoveMembershipListFailures(ms.localMessage, &ms.failureList)
+		}
+		ms.updateQuorum()
+		ms.observeMembers()
+		if memberLog.Enabled(logger.LevelDebug) {
+			memberLog.Debug("Current memberlist:\n" + ms.GetMembershipListString(ms.localMessage, ms.failureList))
+		}
 
 		if ms.isJoining {
-			message, _ := EncodeMembershipServiceMessage(ms.localMessage)
-			Send(ms.LeaderIP, message)
-			logger.PrintDebug("Member service sent Message:", ms.localMessage, "to", ms.LeaderIP)
+			ms.sendJoinRequest()
 		} else {
 			if ms.localMessage.Strategy == config.STRAT_GOSSIP {
-				HeartbeatGossip(ms.localMessage, config.GOSSIP_FANOUT, ms.SelfID)
+				ms.sendGossip()
+			} else if ms.localMessage.Strategy == config.STRAT_SWIM {
+				ms.startProbe()
 			} else {
-				HeartbeatAllToAll(ms.localMessage, ms.SelfID)
+				ms.sendHeartbeat()
 			}
 
 			for machineID := range ms.localMessage.MemberList {
 				if ms.localMessage.MemberList[machineID].IsLeaving && !ms.failureList[machineID] {
-					logger.PrintInfo("Received leave request from machine", machineID)
+					memberLog.Info("Received leave request from machine", machineID)
 					ms.failureList[machineID] = true
-					ms.FailedNodeChan <- strings.Split(machineID, ":")[0]
+					ms.HandleMemberFailure(machineID)
 				}
 			}
+			if !ms.quorum {
+				ms.reachLostMembers()
+			}
 		}
 
+		interval := ms.heartbeatInterval()
 		ms.mux.Unlock()
 
-		time.Sleep(config.PULSE_TIME * time.Millisecond)
+		time.Sleep(interval)
 	}
 }
+
+// joinSeeds lists the members to ask for joining: the introducer, then the seeds
+func (ms *MemberServer) joinSeeds(introducer string, seeds []string) []string {
+	joinSeeds := make([]string, 0)
+	seen := map[string]bool{"": true, ms.SelfAddr: true}
+	for _, seed := range append([]string{introducer}, seeds...) {
+		if !seen[seed] {
+			seen[seed] = true
+			joinSeeds = append(joinSeeds, seed)
+		}
+	}
+	return joinSeeds
+}
+
+// sendJoinRequest asks the seeds in turn to let us join. After each round of seeds without an
+// answer the wait doubles, up to join_backoff. The caller holds ms.mux.
+func (ms *MemberServer) sendJoinRequest() {
+	if len(ms.seeds) == 0 || time.Now().Before(ms.nextJoin) {
+		return
+	}
+	seed := ms.seeds[ms.joinAttempt%len(ms.seeds)]
+	ms.joinAttempt++
+
+	backoff := ms.config.JoinBackoff
+	if round := ms.joinAttempt / len(ms.seeds); round < 16 && ms.config.GossipInterval<<uint(round) < backoff {
+		backoff = ms.config.GossipInterval << uint(round)
+	}
+	// jitter, so that nodes started together don't retry in lockstep
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+	ms.nextJoin = time.Now().Add(backoff)
+
+	message, _ := ms.encodeMessage(ms.outgoingMessage(protocol_buffer.MessageType_JOINREQ, false))
+	ms.send(seed, message)
+	memberLog.Debug("Member service sent join request to", seed, "attempt", ms.joinAttempt)
+}
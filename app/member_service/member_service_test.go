@@ -0,0 +1,36 @@
+package member_service
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/member_service/protocol_buffer"
+	"testing"
+)
+
+// a join request without a sender that lists no other member can't be answered, it is dropped
+func TestJoinRequestWithoutSender(t *testing.T) {
+	ms := NewMemberServerWithConfig(config.MemberServiceConfig{
+		Host:       "127.0.0.1",
+		Port:       "7008",
+		Introducer: "127.0.0.1:7008",
+		Strategy:   config.STRAT_ALL,
+	})
+	for _, members := range []map[string]*protocol_buffer.Member{
+		nil,
+		{ms.SelfID: {HeartbeatCounter: 1}},
+	} {
+		message, err := ms.encodeMessage(&protocol_buffer.MembershipServiceMessage{
+			Type:       protocol_buffer.MessageType_JOINREQ,
+			MemberList: members,
+			Strategy:   config.STRAT_ALL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ms.readNewMessage(message); err != nil {
+			t.Errorf("join request listing %v members: %v", len(members), err)
+		}
+	}
+	if members := ms.GetAliveMemberAddrList(); len(members) != 1 {
+		t.Errorf("members %v after dropped join requests", members)
+	}
+}
@@ -0,0 +1,80 @@
+package member_service
+
+/*
+The metrics of the member service, registered with the registry of the node, see the metrics
+package. The gossip counters count the datagrams of the heartbeats, probes and joins, not the full
+syncs over tcp. Messages dropped by the message loss rate are not counted as sent.
+*/
+
+import (
+	"better_mp3/app/metrics"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsSubsystem = "member"
+
+type memberMetrics struct {
+	members        prometheus.Gauge
+	healthScore    prometheus.Gauge
+	heartbeats     prometheus.Counter
+	events         *prometheus.CounterVec
+	gossipBytes    *prometheus.CounterVec
+	gossipMessages *prometheus.CounterVec
+	rejected       prometheus.Counter
+}
+
+var (
+	metricsMux    sync.Mutex
+	metricsByAddr = map[string]*memberMetrics{}
+)
+
+// metricsOf returns the metrics of the member at addr. Send only knows the address it sends from,
+// so they are kept by address rather than by member server.
+func metricsOf(addr string) *memberMetrics {
+	metricsMux.Lock()
+	defer metricsMux.Unlock()
+	m, ok := metricsByAddr[addr]
+	if ok {
+		return m
+	}
+	m = &memberMetrics{
+		members: metrics.Gauge(addr, metricsSubsystem, "members",
+			"Alive members in the membership list of this node, itself included."),
+		healthScore: metrics.Gauge(addr, metricsSubsystem, "health_score",
+			"Local health score, 0 when healthy, timeouts are multiplied by score+1."),
+		heartbeats: metrics.Counter(addr, metricsSubsystem, "heartbeats_total",
+			"Rounds of the heartbeat loop."),
+		events: metrics.CounterVec(addr, metricsSubsystem, "events_total",
+			"Membership events by type: joined, suspected, failed, left, leader-changed and updated.", "type"),
+		gossipBytes: metrics.CounterVec(addr, metricsSubsystem, "gossip_bytes_total",
+			"Bytes of membership datagrams by direction, sent or received.", "direction"),
+		gossipMessages: metrics.CounterVec(addr, metricsSubsystem, "gossip_messages_total",
+			"Membership datagrams by direction, sent or received.", "direction"),
+		rejected: metrics.Counter(addr, metricsSubsystem, "rejected_messages_total",
+			"Membership datagrams that failed authentication or decoding."),
+	}
+	metricsByAddr[addr] = m
+	return m
+}
+
+// countSent counts a datagram sent from the member at addr
+func countSent(addr string, size int) {
+	m := metricsOf(addr)
+	m.gossipBytes.WithLabelValues("sent").Add(float64(size))
+	m.gossipMessages.WithLabelValues("sent").Inc()
+}
+
+// observeMembers sets the gauges of the heartbeat loop, the caller holds ms.mux
+func (ms *MemberServer) observeMembers() {
+	alive := 0
+	for machineID, member := range ms.localMessage.MemberList {
+		if !ms.failureList[machineID] && !member.IsLeaving {
+			alive++
+		}
+	}
+	ms.metrics.members.Set(float64(alive))
+	ms.metrics.healthScore.Set(float64(ms.health.score))
+	ms.metrics.heartbeats.Inc()
+}
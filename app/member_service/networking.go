@@ -1,21 +1,50 @@
 package member_service
 
 import (
-	"better_mp3/app/config"
-	"better_mp3/app/logger"
 	"better_mp3/app/member_service/protocol_buffer"
+	"errors"
+	"math"
 	"math/rand"
 	"net"
+	"sync/atomic"
 	"time"
 
 
 
 	"github.com/golang/protobuf/proto"
-	"github.com/golang/protobuf/ptypes"
 )
 
-var BytesSent int = 0
-var MessageLossRate float64 = -1
+// ErrMessageTooLarge is returned for a message that doesn't fit in buffer_size
+var ErrMessageTooLarge = errors.New("membership message larger than buffer_size")
+
+// messageLoss holds the bits of the fraction of membership messages dropped, zero outside of tests
+var messageLoss atomic.Uint64
+
+// SetMessageLossRate drops the given fraction of the membership messages sent from now on, zero or
+// less drops none. The sending goroutines read it at any time.
+func SetMessageLossRate(rate float64) {
+	messageLoss.Store(math.Float64bits(rate))
+}
+
+// intercept lets the test harness decide whether a message from one node reaches another,
+// returning false drops it. It is nil outside of tests.
+var intercept atomic.Pointer[func(from string, dest string) bool]
+
+// SetIntercept installs allow as the hook that decides which messages are delivered, nil
+// delivers everything
+func SetIntercept(allow func(from string, dest string) bool) {
+	if allow == nil {
+		intercept.Store(nil)
+		return
+	}
+	intercept.Store(&allow)
+}
+
+// intercepted tells whether the hook drops a message from one node to another
+func intercepted(from string, dest string) bool {
+	allow := intercept.Load()
+	return allow != nil && !(*allow)(from, dest)
+}
 
 func EncodeMembershipServiceMessage(serviceMessage *protocol_buffer.MembershipServiceMessage) ([]byte, error) {
 	message, err := proto.Marshal(serviceMessage)
@@ -30,78 +59,43 @@ func DecodeMembershipServiceMessage(message []byte) (*protocol_buffer.Membership
 	return list, err
 }
 
-func SendGossip(serviceMessage *protocol_buffer.MembershipServiceMessage, k int, selfID string) error {
-	message, err := EncodeMembershipServiceMessage(serviceMessage)
-	if err != nil {
-		return err
-	}
-
-	dests := GetOtherMembershipListIPs(serviceMessage, selfID)
-
-	if k < len(serviceMessage.MemberList) {
-		rand.Seed(time.Now().UnixNano())
-		rand.Shuffle(len(dests), func(i, j int) {
-			dests[i], dests[j] = dests[j], dests[i]
-		})
-
-		dests = dests[:k]
-	}
-
-	return SendAll(dests, message)
-}
-
-func SendHeartbeat(fullMessage, selfMessage *protocol_buffer.MembershipServiceMessage, selfID string) error {
-	dests := GetOtherMembershipListIPs(fullMessage, selfID)
-
-	message, err := EncodeMembershipServiceMessage(selfMessage)
-	if err != nil {
-		return err
+func SendAll(from string, destinations []string, message []byte) error {
+	for _, v := range destinations {
+		err := Send(from, v, message)
+		if err != nil {
+			return err
+		}
 	}
 
-	return SendAll(dests, message)
-}
-
-func HeartbeatGossip(serviceMessage *protocol_buffer.MembershipServiceMessage, k int, selfID string) error {
-	return SendGossip(serviceMessage, k, selfID)
+	return nil
 }
 
-func HeartbeatAllToAll(serviceMessage *protocol_buffer.MembershipServiceMessage, selfID string) error {
-	selfMessage := &protocol_buffer.MembershipServiceMessage{
-		MemberList:      make(map[string]*protocol_buffer.Member),
-		Strategy:        serviceMessage.Strategy,
-		StrategyCounter: serviceMessage.StrategyCounter,
+// send sends a message of this member, unless the receiver would truncate it
+func (ms *MemberServer) send(dest string, message []byte) error {
+	if len(message) > ms.config.BufferSize {
+		memberLog.Warn("Send: dropping a message of", len(message), "bytes to", dest, "larger than buffer_size")
+		return ErrMessageTooLarge
 	}
-
-	selfMember := protocol_buffer.Member{
-		HeartbeatCounter: serviceMessage.MemberList[selfID].HeartbeatCounter,
-		LastSeen:         ptypes.TimestampNow(),
-		IsLeaving:        serviceMessage.MemberList[selfID].IsLeaving,
-	}
-
-	selfMessage.MemberList[selfID] = &selfMember
-
-	return SendHeartbeat(serviceMessage, selfMessage, selfID)
+	return Send(ms.SelfAddr, dest, message)
 }
 
-func SendAll(destinations []string, message []byte) error {
-	for _, v := range destinations {
-		err := Send(v, message)
-		if err != nil {
+func (ms *MemberServer) sendAll(destinations []string, message []byte) error {
+	for _, dest := range destinations {
+		if err := ms.send(dest, message); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func Send(dest string, message []byte) error {
-	if len(message) > config.BUFFER_SIZE {
-		logger.WarningLogger.Println("Send: message is larger than BUFFER_SIZE")
+func Send(from string, dest string, message []byte) error {
+	if intercepted(from, dest) {
+		return nil
 	}
 
 	rand.NewSource(time.Now().UnixNano())
-	if rand.Float64() > MessageLossRate {
-		addr, err := net.ResolveUDPAddr("udp", dest+":"+config.MemberServicePort)
+	if rand.Float64() >= math.Float64frombits(messageLoss.Load()) {
+		addr, err := net.ResolveUDPAddr("udp", dest)
 		if err != nil {
 			return err
 		}
@@ -118,28 +112,35 @@ func Send(dest string, message []byte) error {
 			return err
 		}
 
-		BytesSent += len(message)
+		countSent(from, len(message))
 	}
 
 	return nil
 }
 
-func Listen(port string, callback func(message []byte) error) error {
-	port = ":" + port
-
-	addr, err := net.ResolveUDPAddr("udp", port)
+func Listen(port string, bufferSize int, callback func(message []byte) error) error {
+	conn, err := ListenUDP(port)
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
+	defer conn.Close()
+
+	return Serve(conn, bufferSize, callback)
+}
+
+func ListenUDP(port string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", ":"+port)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer conn.Close()
+	return net.ListenUDP("udp", addr)
+}
 
-	buffer := make([]byte, config.BUFFER_SIZE)
+// Serve reads messages of up to bufferSize bytes from conn until it is closed
+func Serve(conn *net.UDPConn, bufferSize int, callback func(message []byte) error) error {
+	buffer := make([]byte, bufferSize)
 	for {
 		n, err := conn.Read(buffer)
 
@@ -150,15 +151,3 @@ func Listen(port string, callback func(message []byte) error) error {
 		callback(buffer[0:n])
 	}
 }
-
-func GetLocalIPAddr() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		logger.PrintError("net.Dial")
-	}
-	defer conn.Close()
-
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-
-	return localAddr.IP.String()
-}
@@ -1,18 +1,18 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.25.0-devel
-// 	protoc        v3.13.0
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
 // source: memberlist.proto
 
 package protocol_buffer
 
 import (
-	proto "github.com/golang/protobuf/proto"
-	timestamp "github.com/golang/protobuf/ptypes/timestamp"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
+	unsafe "unsafe"
 )
 
 const (
@@ -22,9 +22,54 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// This is a compile-time assertion that a sufficiently up-to-date version
-// of the legacy proto package is being used.
-const _ = proto.ProtoPackageIsVersion4
+type MemberState int32
+
+const (
+	MemberState_ALIVE   MemberState = 0
+	MemberState_SUSPECT MemberState = 1
+	MemberState_DEAD    MemberState = 2
+)
+
+// Enum value maps for MemberState.
+var (
+	MemberState_name = map[int32]string{
+		0: "ALIVE",
+		1: "SUSPECT",
+		2: "DEAD",
+	}
+	MemberState_value = map[string]int32{
+		"ALIVE":   0,
+		"SUSPECT": 1,
+		"DEAD":    2,
+	}
+)
+
+func (x MemberState) Enum() *MemberState {
+	p := new(MemberState)
+	*p = x
+	return p
+}
+
+func (x MemberState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (MemberState) Descriptor() protoreflect.EnumDescriptor {
+	return file_memberlist_proto_enumTypes[0].Descriptor()
+}
+
+func (MemberState) Type() protoreflect.EnumType {
+	return &file_memberlist_proto_enumTypes[0]
+}
+
+func (x MemberState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use MemberState.Descriptor instead.
+func (MemberState) EnumDescriptor() ([]byte, []int) {
+	return file_memberlist_proto_rawDescGZIP(), []int{0}
+}
 
 type MessageType int32
 
@@ -32,6 +77,11 @@ const (
 	MessageType_STANDARD MessageType = 0
 	MessageType_JOINREQ  MessageType = 1
 	MessageType_JOINREP  MessageType = 2
+	MessageType_PING     MessageType = 3
+	MessageType_ACK      MessageType = 4
+	MessageType_PINGREQ  MessageType = 5
+	// anti-entropy: the whole membership list, pushed and pulled over tcp
+	MessageType_SYNC MessageType = 8
 )
 
 // Enum value maps for MessageType.
@@ -40,11 +90,19 @@ var (
 		0: "STANDARD",
 		1: "JOINREQ",
 		2: "JOINREP",
+		3: "PING",
+		4: "ACK",
+		5: "PINGREQ",
+		8: "SYNC",
 	}
 	MessageType_value = map[string]int32{
 		"STANDARD": 0,
 		"JOINREQ":  1,
 		"JOINREP":  2,
+		"PING":     3,
+		"ACK":      4,
+		"PINGREQ":  5,
+		"SYNC":     8,
 	}
 )
 
@@ -59,11 +117,11 @@ func (x MessageType) String() string {
 }
 
 func (MessageType) Descriptor() protoreflect.EnumDescriptor {
-	return file_memberlist_proto_enumTypes[0].Descriptor()
+	return file_memberlist_proto_enumTypes[1].Descriptor()
 }
 
 func (MessageType) Type() protoreflect.EnumType {
-	return &file_memberlist_proto_enumTypes[0]
+	return &file_memberlist_proto_enumTypes[1]
 }
 
 func (x MessageType) Number() protoreflect.EnumNumber {
@@ -72,26 +130,33 @@ func (x MessageType) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use MessageType.Descriptor instead.
 func (MessageType) EnumDescriptor() ([]byte, []int) {
-	return file_memberlist_proto_rawDescGZIP(), []int{0}
+	return file_memberlist_proto_rawDescGZIP(), []int{1}
 }
 
 type Member struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	HeartbeatCounter int32                  `protobuf:"varint,1,opt,name=HeartbeatCounter,proto3" json:"HeartbeatCounter,omitempty"`
+	LastSeen         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=LastSeen,proto3" json:"LastSeen,omitempty"`
+	IsLeaving        bool                   `protobuf:"varint,3,opt,name=IsLeaving,proto3" json:"IsLeaving,omitempty"`
+	// used by the swim strategy, a member raises its incarnation to refute a suspicion
+	Incarnation int32       `protobuf:"varint,4,opt,name=Incarnation,proto3" json:"Incarnation,omitempty"`
+	State       MemberState `protobuf:"varint,5,opt,name=State,proto3,enum=tutorial.MemberState" json:"State,omitempty"`
+	// members that independently failed to reach this one at the current incarnation
+	Suspecters []string `protobuf:"bytes,6,rep,name=Suspecters,proto3" json:"Suspecters,omitempty"`
+	// metadata set by the member itself: arbitrary tags, and the address of each of its services.
+	// The member raises MetaVersion whenever it changes them, the copy with the higher version wins.
+	Tags          map[string]string `protobuf:"bytes,7,rep,name=Tags,proto3" json:"Tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Endpoints     map[string]string `protobuf:"bytes,8,rep,name=Endpoints,proto3" json:"Endpoints,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	MetaVersion   int64             `protobuf:"varint,9,opt,name=MetaVersion,proto3" json:"MetaVersion,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	HeartbeatCounter int32                `protobuf:"varint,1,opt,name=HeartbeatCounter,proto3" json:"HeartbeatCounter,omitempty"`
-	LastSeen         *timestamp.Timestamp `protobuf:"bytes,2,opt,name=LastSeen,proto3" json:"LastSeen,omitempty"`
-	IsLeaving        bool                 `protobuf:"varint,3,opt,name=IsLeaving,proto3" json:"IsLeaving,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Member) Reset() {
 	*x = Member{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_memberlist_proto_msgTypes[0]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_memberlist_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *Member) String() string {
@@ -102,7 +167,7 @@ func (*Member) ProtoMessage() {}
 
 func (x *Member) ProtoReflect() protoreflect.Message {
 	mi := &file_memberlist_proto_msgTypes[0]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -124,7 +189,7 @@ func (x *Member) GetHeartbeatCounter() int32 {
 	return 0
 }
 
-func (x *Member) GetLastSeen() *timestamp.Timestamp {
+func (x *Member) GetLastSeen() *timestamppb.Timestamp {
 	if x != nil {
 		return x.LastSeen
 	}
@@ -138,24 +203,70 @@ func (x *Member) GetIsLeaving() bool {
 	return false
 }
 
+func (x *Member) GetIncarnation() int32 {
+	if x != nil {
+		return x.Incarnation
+	}
+	return 0
+}
+
+func (x *Member) GetState() MemberState {
+	if x != nil {
+		return x.State
+	}
+	return MemberState_ALIVE
+}
+
+func (x *Member) GetSuspecters() []string {
+	if x != nil {
+		return x.Suspecters
+	}
+	return nil
+}
+
+func (x *Member) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Member) GetEndpoints() map[string]string {
+	if x != nil {
+		return x.Endpoints
+	}
+	return nil
+}
+
+func (x *Member) GetMetaVersion() int64 {
+	if x != nil {
+		return x.MetaVersion
+	}
+	return 0
+}
+
 type MembershipServiceMessage struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	MemberList      map[string]*Member     `protobuf:"bytes,1,rep,name=MemberList,proto3" json:"MemberList,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Strategy        string                 `protobuf:"bytes,2,opt,name=Strategy,proto3" json:"Strategy,omitempty"`
+	StrategyCounter int32                  `protobuf:"varint,3,opt,name=StrategyCounter,proto3" json:"StrategyCounter,omitempty"`
+	Type            MessageType            `protobuf:"varint,4,opt,name=Type,proto3,enum=tutorial.MessageType" json:"Type,omitempty"`
+	// swim probes: the sending member, the member probed on behalf of the sender and the probe number
+	Sender string `protobuf:"bytes,5,opt,name=Sender,proto3" json:"Sender,omitempty"`
+	Target string `protobuf:"bytes,6,opt,name=Target,proto3" json:"Target,omitempty"`
+	SeqNo  uint64 `protobuf:"varint,7,opt,name=SeqNo,proto3" json:"SeqNo,omitempty"`
+	// the sender's term and the master it follows in that term, the one raft elected
+	Term          int64  `protobuf:"varint,8,opt,name=Term,proto3" json:"Term,omitempty"`
+	Leader        string `protobuf:"bytes,9,opt,name=Leader,proto3" json:"Leader,omitempty"`
 	unknownFields protoimpl.UnknownFields
-
-	MemberList      map[string]*Member `protobuf:"bytes,1,rep,name=MemberList,proto3" json:"MemberList,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	Strategy        string             `protobuf:"bytes,2,opt,name=Strategy,proto3" json:"Strategy,omitempty"`
-	StrategyCounter int32              `protobuf:"varint,3,opt,name=StrategyCounter,proto3" json:"StrategyCounter,omitempty"`
-	Type            MessageType        `protobuf:"varint,4,opt,name=Type,proto3,enum=tutorial.MessageType" json:"Type,omitempty"`
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *MembershipServiceMessage) Reset() {
 	*x = MembershipServiceMessage{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_memberlist_proto_msgTypes[1]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
-	}
+	mi := &file_memberlist_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
 func (x *MembershipServiceMessage) String() string {
@@ -166,7 +277,7 @@ func (*MembershipServiceMessage) ProtoMessage() {}
 
 func (x *MembershipServiceMessage) ProtoReflect() protoreflect.Message {
 	mi := &file_memberlist_proto_msgTypes[1]
-	if protoimpl.UnsafeEnabled && x != nil {
+	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
@@ -209,79 +320,130 @@ func (x *MembershipServiceMessage) GetType() MessageType {
 	return MessageType_STANDARD
 }
 
-var File_memberlist_proto protoreflect.FileDescriptor
+func (x *MembershipServiceMessage) GetSender() string {
+	if x != nil {
+		return x.Sender
+	}
+	return ""
+}
 
-var file_memberlist_proto_rawDesc = []byte{
-	0x0a, 0x10, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x6c, 0x69, 0x73, 0x74, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x12, 0x08, 0x74, 0x75, 0x74, 0x6f, 0x72, 0x69, 0x61, 0x6c, 0x1a, 0x1f, 0x67, 0x6f,
-	0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69,
-	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x8a, 0x01,
-	0x0a, 0x06, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x2a, 0x0a, 0x10, 0x48, 0x65, 0x61, 0x72,
-	0x74, 0x62, 0x65, 0x61, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x05, 0x52, 0x10, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x43, 0x6f, 0x75,
-	0x6e, 0x74, 0x65, 0x72, 0x12, 0x36, 0x0a, 0x08, 0x4c, 0x61, 0x73, 0x74, 0x53, 0x65, 0x65, 0x6e,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
-	0x6d, 0x70, 0x52, 0x08, 0x4c, 0x61, 0x73, 0x74, 0x53, 0x65, 0x65, 0x6e, 0x12, 0x1c, 0x0a, 0x09,
-	0x49, 0x73, 0x4c, 0x65, 0x61, 0x76, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52,
-	0x09, 0x49, 0x73, 0x4c, 0x65, 0x61, 0x76, 0x69, 0x6e, 0x67, 0x22, 0xb0, 0x02, 0x0a, 0x18, 0x4d,
-	0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x52, 0x0a, 0x0a, 0x4d, 0x65, 0x6d, 0x62, 0x65,
-	0x72, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x32, 0x2e, 0x74, 0x75,
-	0x74, 0x6f, 0x72, 0x69, 0x61, 0x6c, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69,
-	0x70, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e,
-	0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x4c, 0x69, 0x73, 0x74, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52,
-	0x0a, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x53,
-	0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x53,
-	0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x12, 0x28, 0x0a, 0x0f, 0x53, 0x74, 0x72, 0x61, 0x74,
-	0x65, 0x67, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05,
-	0x52, 0x0f, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67, 0x79, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x65,
-	0x72, 0x12, 0x29, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32,
-	0x15, 0x2e, 0x74, 0x75, 0x74, 0x6f, 0x72, 0x69, 0x61, 0x6c, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61,
-	0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x1a, 0x4f, 0x0a, 0x0f,
-	0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x4c, 0x69, 0x73, 0x74, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
-	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
-	0x79, 0x12, 0x26, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b,
-	0x32, 0x10, 0x2e, 0x74, 0x75, 0x74, 0x6f, 0x72, 0x69, 0x61, 0x6c, 0x2e, 0x4d, 0x65, 0x6d, 0x62,
-	0x65, 0x72, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x2a, 0x35, 0x0a,
-	0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0c, 0x0a, 0x08,
-	0x53, 0x54, 0x41, 0x4e, 0x44, 0x41, 0x52, 0x44, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x4a, 0x4f,
-	0x49, 0x4e, 0x52, 0x45, 0x51, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x4a, 0x4f, 0x49, 0x4e, 0x52,
-	0x45, 0x50, 0x10, 0x02, 0x42, 0x10, 0x5a, 0x0e, 0x2e, 0x2f, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x50,
-	0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+func (x *MembershipServiceMessage) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
 }
 
+func (x *MembershipServiceMessage) GetSeqNo() uint64 {
+	if x != nil {
+		return x.SeqNo
+	}
+	return 0
+}
+
+func (x *MembershipServiceMessage) GetTerm() int64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *MembershipServiceMessage) GetLeader() string {
+	if x != nil {
+		return x.Leader
+	}
+	return ""
+}
+
+var File_memberlist_proto protoreflect.FileDescriptor
+
+const file_memberlist_proto_rawDesc = "" +
+	"\n" +
+	"\x10memberlist.proto\x12\btutorial\x1a\x1fgoogle/protobuf/timestamp.proto\"\x81\x04\n" +
+	"\x06Member\x12*\n" +
+	"\x10HeartbeatCounter\x18\x01 \x01(\x05R\x10HeartbeatCounter\x126\n" +
+	"\bLastSeen\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\bLastSeen\x12\x1c\n" +
+	"\tIsLeaving\x18\x03 \x01(\bR\tIsLeaving\x12 \n" +
+	"\vIncarnation\x18\x04 \x01(\x05R\vIncarnation\x12+\n" +
+	"\x05State\x18\x05 \x01(\x0e2\x15.tutorial.MemberStateR\x05State\x12\x1e\n" +
+	"\n" +
+	"Suspecters\x18\x06 \x03(\tR\n" +
+	"Suspecters\x12.\n" +
+	"\x04Tags\x18\a \x03(\v2\x1a.tutorial.Member.TagsEntryR\x04Tags\x12=\n" +
+	"\tEndpoints\x18\b \x03(\v2\x1f.tutorial.Member.EndpointsEntryR\tEndpoints\x12 \n" +
+	"\vMetaVersion\x18\t \x01(\x03R\vMetaVersion\x1a7\n" +
+	"\tTagsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a<\n" +
+	"\x0eEndpointsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb5\x03\n" +
+	"\x18MembershipServiceMessage\x12R\n" +
+	"\n" +
+	"MemberList\x18\x01 \x03(\v22.tutorial.MembershipServiceMessage.MemberListEntryR\n" +
+	"MemberList\x12\x1a\n" +
+	"\bStrategy\x18\x02 \x01(\tR\bStrategy\x12(\n" +
+	"\x0fStrategyCounter\x18\x03 \x01(\x05R\x0fStrategyCounter\x12)\n" +
+	"\x04Type\x18\x04 \x01(\x0e2\x15.tutorial.MessageTypeR\x04Type\x12\x16\n" +
+	"\x06Sender\x18\x05 \x01(\tR\x06Sender\x12\x16\n" +
+	"\x06Target\x18\x06 \x01(\tR\x06Target\x12\x14\n" +
+	"\x05SeqNo\x18\a \x01(\x04R\x05SeqNo\x12\x12\n" +
+	"\x04Term\x18\b \x01(\x03R\x04Term\x12\x16\n" +
+	"\x06Leader\x18\t \x01(\tR\x06Leader\x1aO\n" +
+	"\x0fMemberListEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12&\n" +
+	"\x05value\x18\x02 \x01(\v2\x10.tutorial.MemberR\x05value:\x028\x01J\x04\b\n" +
+	"\x10\vR\vVoteGranted*/\n" +
+	"\vMemberState\x12\t\n" +
+	"\x05ALIVE\x10\x00\x12\v\n" +
+	"\aSUSPECT\x10\x01\x12\b\n" +
+	"\x04DEAD\x10\x02*t\n" +
+	"\vMessageType\x12\f\n" +
+	"\bSTANDARD\x10\x00\x12\v\n" +
+	"\aJOINREQ\x10\x01\x12\v\n" +
+	"\aJOINREP\x10\x02\x12\b\n" +
+	"\x04PING\x10\x03\x12\a\n" +
+	"\x03ACK\x10\x04\x12\v\n" +
+	"\aPINGREQ\x10\x05\x12\b\n" +
+	"\x04SYNC\x10\b\"\x04\b\x06\x10\a*\aVOTEREQ*\x04VOTEB\x10Z\x0e./ProtoPackageb\x06proto3"
+
 var (
 	file_memberlist_proto_rawDescOnce sync.Once
-	file_memberlist_proto_rawDescData = file_memberlist_proto_rawDesc
+	file_memberlist_proto_rawDescData []byte
 )
 
 func file_memberlist_proto_rawDescGZIP() []byte {
 	file_memberlist_proto_rawDescOnce.Do(func() {
-		file_memberlist_proto_rawDescData = protoimpl.X.CompressGZIP(file_memberlist_proto_rawDescData)
+		file_memberlist_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_memberlist_proto_rawDesc), len(file_memberlist_proto_rawDesc)))
 	})
 	return file_memberlist_proto_rawDescData
 }
 
-var file_memberlist_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_memberlist_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
-var file_memberlist_proto_goTypes = []interface{}{
-	(MessageType)(0),                 // 0: tutorial.MessageType
-	(*Member)(nil),                   // 1: tutorial.Member
-	(*MembershipServiceMessage)(nil), // 2: tutorial.MembershipServiceMessage
-	nil,                              // 3: tutorial.MembershipServiceMessage.MemberListEntry
-	(*timestamp.Timestamp)(nil),      // 4: google.protobuf.Timestamp
+var file_memberlist_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_memberlist_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_memberlist_proto_goTypes = []any{
+	(MemberState)(0),                 // 0: tutorial.MemberState
+	(MessageType)(0),                 // 1: tutorial.MessageType
+	(*Member)(nil),                   // 2: tutorial.Member
+	(*MembershipServiceMessage)(nil), // 3: tutorial.MembershipServiceMessage
+	nil,                              // 4: tutorial.Member.TagsEntry
+	nil,                              // 5: tutorial.Member.EndpointsEntry
+	nil,                              // 6: tutorial.MembershipServiceMessage.MemberListEntry
+	(*timestamppb.Timestamp)(nil),    // 7: google.protobuf.Timestamp
 }
 var file_memberlist_proto_depIdxs = []int32{
-	4, // 0: tutorial.Member.LastSeen:type_name -> google.protobuf.Timestamp
-	3, // 1: tutorial.MembershipServiceMessage.MemberList:type_name -> tutorial.MembershipServiceMessage.MemberListEntry
-	0, // 2: tutorial.MembershipServiceMessage.Type:type_name -> tutorial.MessageType
-	1, // 3: tutorial.MembershipServiceMessage.MemberListEntry.value:type_name -> tutorial.Member
-	4, // [4:4] is the sub-list for method output_type
-	4, // [4:4] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	7, // 0: tutorial.Member.LastSeen:type_name -> google.protobuf.Timestamp
+	0, // 1: tutorial.Member.State:type_name -> tutorial.MemberState
+	4, // 2: tutorial.Member.Tags:type_name -> tutorial.Member.TagsEntry
+	5, // 3: tutorial.Member.Endpoints:type_name -> tutorial.Member.EndpointsEntry
+	6, // 4: tutorial.MembershipServiceMessage.MemberList:type_name -> tutorial.MembershipServiceMessage.MemberListEntry
+	1, // 5: tutorial.MembershipServiceMessage.Type:type_name -> tutorial.MessageType
+	2, // 6: tutorial.MembershipServiceMessage.MemberListEntry.value:type_name -> tutorial.Member
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_memberlist_proto_init() }
@@ -289,39 +451,13 @@ func file_memberlist_proto_init() {
 	if File_memberlist_proto != nil {
 		return
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_memberlist_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Member); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-		file_memberlist_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*MembershipServiceMessage); i {
-			case 0:
-				return &v.state
-			case 1:
-				return &v.sizeCache
-			case 2:
-				return &v.unknownFields
-			default:
-				return nil
-			}
-		}
-	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: file_memberlist_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   3,
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_memberlist_proto_rawDesc), len(file_memberlist_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -331,7 +467,6 @@ func file_memberlist_proto_init() {
 		MessageInfos:      file_memberlist_proto_msgTypes,
 	}.Build()
 	File_memberlist_proto = out.File
-	file_memberlist_proto_rawDesc = nil
 	file_memberlist_proto_goTypes = nil
 	file_memberlist_proto_depIdxs = nil
 }
@@ -0,0 +1,221 @@
+//go:build integration
+
+package member_service_test
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/file_service"
+	"better_mp3/app/harness"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkPartition)
+}
+
+func TestMinority(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 5}, checkMinority)
+}
+
+func TestElection(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkElection)
+}
+
+func TestSeedFailover(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkSeedFailover)
+}
+
+// neither half of an even split reaches a majority, so both go read-only until the partition heals
+func checkPartition(c *harness.Cluster) error {
+	left := []*harness.Node{c.Nodes[0], c.Nodes[1]}
+	right := []*harness.Node{c.Nodes[2], c.Nodes[3]}
+	c.Partition([]int{0, 1}, []int{2, 3})
+
+	err := c.WaitFor("both sides to detect the partition", 3*c.Config.MemberServiceConfig.FailTime, func() bool {
+		for _, node := range left {
+			if !reflect.DeepEqual(node.Member.GetAliveMemberAddrList(), harness.Addrs(left)) {
+				return false
+			}
+		}
+		for _, node := range right {
+			if !reflect.DeepEqual(node.Member.GetAliveMemberAddrList(), harness.Addrs(right)) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := checkReadOnly(c, c.Nodes, "split"); err != nil {
+		return err
+	}
+
+	c.Heal()
+	err = c.WaitFor("the partition to heal", 3*c.Config.MemberServiceConfig.RemoveTime, func() bool {
+		for _, node := range c.Nodes {
+			if len(node.Member.GetAliveMemberAddrList()) != len(c.Nodes) || !node.Member.HasQuorum() {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// the side without the master doesn't elect one while it can't reach a majority
+	if _, _, err = c.WaitForLeader(c.Nodes, "a single master after healing"); err != nil {
+		return err
+	}
+	return c.PutFrom(c.Nodes[3], "healed")
+}
+
+// the larger side of a partition keeps working, the smaller one is read-only until it heals,
+// and the larger side doesn't forget it meanwhile
+func checkMinority(c *harness.Cluster) error {
+	majority := c.Nodes[:3]
+	minority := c.Nodes[3:]
+	c.Partition([]int{0, 1, 2}, []int{3, 4})
+
+	memberConfig := c.Config.MemberServiceConfig
+	err := c.WaitFor("the minority to lose its quorum", 3*memberConfig.FailTime, func() bool {
+		for _, node := range minority {
+			if node.Member.HasQuorum() {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := checkReadOnly(c, minority, "minority"); err != nil {
+		return err
+	}
+	if _, _, err := c.WaitForLeader(majority, "a master on the majority side"); err != nil {
+		return err
+	}
+	if err := c.PutFrom(majority[1], "majority"); err != nil {
+		return err
+	}
+	// the minority remembers the whole cluster, even once the other side is removed from its list
+	time.Sleep(memberConfig.RemoveTime)
+	for _, node := range minority {
+		if node.Member.HasQuorum() || len(node.Member.KnownMembers()) != len(c.Nodes) {
+			return fmt.Errorf("minority node %v regained a quorum of %v", node.Addr, node.Member.KnownMembers())
+		}
+	}
+
+	c.Heal()
+	err = c.WaitFor("the minority to rejoin", 3*memberConfig.RemoveTime, func() bool {
+		for _, node := range c.Nodes {
+			if !reflect.DeepEqual(node.Member.GetAliveMemberAddrList(), harness.Addrs(c.Nodes)) ||
+				!reflect.DeepEqual(node.Raft.Peers(), harness.Addrs(c.Nodes)) || !node.Member.HasQuorum() {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return c.PutFrom(minority[0], "rejoined")
+}
+
+// checkReadOnly expects puts and maple jobs of nodes to be refused
+func checkReadOnly(c *harness.Cluster, nodes []*harness.Node, name string) error {
+	local := filepath.Join(c.Dir, name+".txt")
+	if err := ioutil.WriteFile(local, []byte(name+"\n"), 0644); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		err := node.File.RemotePut(node.File.User(), local, name)
+		if err == nil || err.Error() != file_service.ErrReadOnly.Error() {
+			return fmt.Errorf("put on %v was answered with %v, expected %v", node.Addr, err, file_service.ErrReadOnly)
+		}
+		err = node.MapleJuice.ScheduleMapleTask([]string{"maple", "exe", "1", "prefix", "input"})
+		if err == nil || err.Error() != file_service.ErrReadOnly.Error() {
+			return fmt.Errorf("maple on %v was answered with %v, expected %v", node.Addr, err, file_service.ErrReadOnly)
+		}
+	}
+	return nil
+}
+
+// a crashed master is replaced by exactly one new master in a newer term,
+// and the file service refuses requests stamped with the old term
+func checkElection(c *harness.Cluster) error {
+	c.Crash(0)
+	leader, term, err := c.WaitForLeader(c.Alive(), "a new master")
+	if err != nil {
+		return err
+	}
+	if term == 0 {
+		return fmt.Errorf("master %v was elected without a new term", leader)
+	}
+
+	// another election in the same cluster would change neither
+	time.Sleep(2 * c.Config.RaftServiceConfig.ElectionTimeout)
+	if again, againTerm, err := c.WaitForLeader(c.Alive(), "the master to stay"); err != nil || again != leader || againTerm != term {
+		return fmt.Errorf("master changed from %v in term %v to %v in term %v", leader, term, again, againTerm)
+	}
+
+	follower := c.Alive()[0]
+	if follower.Addr == leader {
+		follower = c.Alive()[1]
+	}
+	client, err := c.Credentials.Dial("127.0.0.1:" + follower.Config.FileServiceConfig.Port)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var success bool
+	err = client.Call("FileRPCServer.LocalPut", file_service.FileTask{FileName: "fenced", Content: []byte("stale"), Term: term - 1, User: follower.File.User()}, &success)
+	if err == nil || err.Error() != file_service.ErrStaleTerm.Error() {
+		return fmt.Errorf("put from term %v was answered with %v, expected %v", term-1, err, file_service.ErrStaleTerm)
+	}
+	err = client.Call("FileRPCServer.LocalPut", file_service.FileTask{FileName: "fenced", Content: []byte("current"), Term: term, User: follower.File.User()}, &success)
+	if err != nil {
+		return fmt.Errorf("put from the current term failed: %v", err)
+	}
+	stored, err := ioutil.ReadFile(filepath.Join(follower.Config.FileServiceConfig.Path, "fenced"))
+	if err != nil {
+		return err
+	}
+	if string(stored) != "current" {
+		return fmt.Errorf("fenced file holds %q", stored)
+	}
+	return nil
+}
+
+// checkSeedFailover crashes the introducer, a new node must still join through another seed
+func checkSeedFailover(c *harness.Cluster) error {
+	c.Configure(func(m *config.MemberServiceConfig) {
+		m.Seeds = harness.Addrs(c.Nodes)
+	})
+	c.Crash(0)
+	if _, _, err := c.WaitForLeader(c.Alive(), "a new master"); err != nil {
+		return err
+	}
+	if _, err := c.AddNode(); err != nil {
+		return err
+	}
+
+	expected := harness.Addrs(c.Alive())
+	memberConfig := c.Config.MemberServiceConfig
+	return c.WaitFor("the new node to be a member and a raft peer everywhere", 2*memberConfig.RemoveTime, func() bool {
+		for _, node := range c.Alive() {
+			if !reflect.DeepEqual(node.Member.GetAliveMemberAddrList(), expected) ||
+				!reflect.DeepEqual(node.Raft.Peers(), expected) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,310 @@
+package member_service
+
+/*
+This file implements the swim strategy. Instead of judging members by the age of their heartbeat,
+every protocol period (gossip_interval) a member pings one other member. Without an ack within
+probe_timeout it asks indirect_probes other members to ping the target on its behalf (ping-req), so
+that a single slow link does not cause a failure. If still nobody acks, the target becomes SUSPECT.
+The changes of the membership list ride along every ping and ack, see broadcast.go, so the
+suspected member hears about it and refutes by raising its incarnation. A suspicion that is not refuted in time turns the member DEAD,
+which is when the upper services are told about the failure. How long suspicions last and how
+long probes wait adapt to the cluster, see detector.go and health.go.
+*/
+
+import (
+	"better_mp3/app/member_service/protocol_buffer"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+)
+
+// Incarnation returns how many times this member has refuted a suspicion
+func (ms *MemberServer) Incarnation() int32 {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+
+	if ms.localMessage == nil {
+		return 0
+	}
+	return ms.localMessage.MemberList[ms.SelfID].Incarnation
+}
+
+// startProbe probes the next member in the background, the caller holds ms.mux
+func (ms *MemberServer) startProbe() {
+	target := ms.nextProbeTarget()
+	if target == "" {
+		return
+	}
+	seq, acked := ms.newProbe()
+	message, err := ms.encodeSwimMessage(protocol_buffer.MessageType_PING, seq, "")
+	if err != nil {
+		memberLog.Error("Failed to encode ping:", err)
+		return
+	}
+	go ms.probe(target, seq, acked, message, ms.health.scale(ms.config.ProbeTimeout), ms.heartbeatInterval())
+}
+
+// probe waits timeout for a direct ack and the rest of the protocol period for an indirect one
+func (ms *MemberServer) probe(target string, seq uint64, acked chan bool, ping []byte, timeout time.Duration, period time.Duration) {
+	defer ms.endProbe(seq)
+
+	ms.send(AddrOfID(target), ping)
+	select {
+	case <-acked:
+		ms.probeSucceeded()
+		return
+	case <-time.After(timeout):
+	}
+
+	ms.mux.Lock()
+	if ms.localMessage == nil {
+		ms.mux.Unlock()
+		return
+	}
+	helpers := ms.randomMembers(ms.config.IndirectProbes, target)
+	pingReq, err := ms.encodeSwimMessage(protocol_buffer.MessageType_PINGREQ, seq, target)
+	ms.mux.Unlock()
+	if err != nil {
+		memberLog.Error("Failed to encode ping-req:", err)
+		return
+	}
+	memberLog.Debug("No ack from", target, "asking", helpers)
+	ms.sendAll(helpers, pingReq)
+
+	select {
+	case <-acked:
+		ms.probeSucceeded()
+		return
+	case <-time.After(period - timeout):
+	}
+
+	ms.mux.Lock()
+	if ms.localMessage != nil {
+		ms.health.raise("no ack from " + target)
+		ms.suspect(target)
+	}
+	ms.mux.Unlock()
+}
+
+func (ms *MemberServer) probeSucceeded() {
+	ms.mux.Lock()
+	ms.health.lower()
+	ms.mux.Unlock()
+}
+
+// forwardProbe pings target for a ping-req of requester and relays the ack
+func (ms *MemberServer) forwardProbe(requester string, requesterSeq uint64, target string, seq uint64, acked chan bool, ping []byte, timeout time.Duration) {
+	defer ms.endProbe(seq)
+
+	ms.send(AddrOfID(target), ping)
+	select {
+	case <-acked:
+	case <-time.After(timeout):
+		return
+	}
+
+	ms.mux.Lock()
+	if ms.localMessage == nil {
+		ms.mux.Unlock()
+		return
+	}
+	ack, err := ms.encodeSwimMessage(protocol_buffer.MessageType_ACK, requesterSeq, target)
+	ms.mux.Unlock()
+	if err == nil {
+		ms.send(AddrOfID(requester), ack)
+	}
+}
+
+// handleProbeMessage answers pings and ping-reqs and completes probes on acks, the caller holds ms.mux
+func (ms *MemberServer) handleProbeMessage(remoteMessage *protocol_buffer.MembershipServiceMessage) {
+	switch remoteMessage.Type {
+	case protocol_buffer.MessageType_PING:
+		ack, err := ms.encodeSwimMessage(protocol_buffer.MessageType_ACK, remoteMessage.SeqNo, "")
+		if err != nil {
+			memberLog.Error("Failed to encode ack:", err)
+			return
+		}
+		ms.send(AddrOfID(remoteMessage.Sender), ack)
+
+	case protocol_buffer.MessageType_PINGREQ:
+		seq, acked := ms.newProbe()
+		ping, err := ms.encodeSwimMessage(protocol_buffer.MessageType_PING, seq, "")
+		if err != nil {
+			memberLog.Error("Failed to encode ping:", err)
+			delete(ms.probes, seq)
+			return
+		}
+		timeout := ms.health.scale(ms.config.ProbeTimeout)
+		go ms.forwardProbe(remoteMessage.Sender, remoteMessage.SeqNo, remoteMessage.Target, seq, acked, ping, timeout)
+
+	case protocol_buffer.MessageType_ACK:
+		if acked, ok := ms.probes[remoteMessage.SeqNo]; ok {
+			select {
+			case acked <- true:
+			default:
+			}
+		}
+	}
+}
+
+// checkSuspects turns expired suspicions into failures and removes failed members, the caller holds ms.mux
+func (ms *MemberServer) checkSuspects() {
+	for machineID, member := range ms.localMessage.MemberList {
+		if machineID == ms.SelfID {
+			continue
+		}
+		sinceChange := time.Since(member.LastSeen.AsTime())
+
+		if member.State == protocol_buffer.MemberState_SUSPECT && sinceChange >= ms.suspicionTimeout(member) {
+			ms.markDead(machineID, member)
+		} else if (member.State == protocol_buffer.MemberState_DEAD || member.IsLeaving) && sinceChange >= ms.config.RemoveTime {
+			delete(ms.failureList, machineID)
+			ms.RemoveMemberFromMembershipList(ms.localMessage, machineID)
+		}
+	}
+}
+
+// mergeSwimMember applies what a remote member list says about machineID, the caller holds ms.mux.
+// DEAD is final, otherwise a higher incarnation wins and SUSPECT beats ALIVE at the same incarnation.
+// Suspicions at the same incarnation add up their suspecters as confirmations.
+func (ms *MemberServer) mergeSwimMember(machineID string, local, remote *protocol_buffer.Member) {
+	if machineID == ms.SelfID {
+		if remote.State == protocol_buffer.MemberState_SUSPECT && remote.Incarnation >= local.Incarnation {
+			local.Incarnation = remote.Incarnation + 1
+			memberLog.Info("Refuting suspicion, incarnation is now", local.Incarnation)
+			ms.queueBroadcast(machineID)
+			ms.health.raise("suspected by " + strings.Join(remote.Suspecters, ", "))
+		}
+		return
+	}
+
+	switch {
+	case local.State == protocol_buffer.MemberState_DEAD:
+	case remote.State == protocol_buffer.MemberState_DEAD:
+		local.Incarnation = remote.Incarnation
+		ms.markDead(machineID, local)
+	case remote.Incarnation > local.Incarnation ||
+		remote.Incarnation == local.Incarnation && remote.State == protocol_buffer.MemberState_SUSPECT && local.State == protocol_buffer.MemberState_ALIVE:
+		previous := local.State
+		local.Incarnation = remote.Incarnation
+		local.State = remote.State
+		local.Suspecters = addSuspecters(nil, remote.Suspecters...)
+		local.LastSeen = ptypes.TimestampNow()
+		if remote.State == protocol_buffer.MemberState_SUSPECT && previous != protocol_buffer.MemberState_SUSPECT {
+			memberLog.Info("Machine", machineID, "is suspected")
+			ms.publish(EventSuspected, machineID)
+		} else if remote.State == protocol_buffer.MemberState_ALIVE && previous == protocol_buffer.MemberState_SUSPECT {
+			ms.publish(EventJoined, machineID)
+		}
+	case remote.Incarnation == local.Incarnation && remote.State == protocol_buffer.MemberState_SUSPECT:
+		suspecters := len(local.Suspecters)
+		local.Suspecters = addSuspecters(local.Suspecters, remote.Suspecters...)
+		if len(local.Suspecters) > suspecters {
+			ms.queueBroadcast(machineID)
+		}
+	}
+}
+
+func (ms *MemberServer) suspect(machineID string) {
+	if ms.localMessage == nil {
+		return
+	}
+	member, ok := ms.localMessage.MemberList[machineID]
+	if !ok || member.State == protocol_buffer.MemberState_DEAD {
+		return
+	}
+	if member.State == protocol_buffer.MemberState_SUSPECT {
+		// someone else suspected it first, we confirm
+		member.Suspecters = addSuspecters(member.Suspecters, ms.SelfID)
+		ms.queueBroadcast(machineID)
+		return
+	}
+	memberLog.Info("No ack from machine", machineID, "- suspecting it")
+	member.State = protocol_buffer.MemberState_SUSPECT
+	member.Suspecters = []string{ms.SelfID}
+	member.LastSeen = ptypes.TimestampNow()
+	ms.publish(EventSuspected, machineID)
+}
+
+func (ms *MemberServer) markDead(machineID string, member *protocol_buffer.Member) {
+	member.State = protocol_buffer.MemberState_DEAD
+	member.LastSeen = ptypes.TimestampNow()
+	if !ms.failureList[machineID] && !member.IsLeaving {
+		ms.failureList[machineID] = true
+		memberLog.Info("Marking machine", machineID, "as failed")
+		ms.HandleMemberFailure(machineID)
+	}
+}
+
+// nextProbeTarget walks the members in a random order, one member per protocol period
+func (ms *MemberServer) nextProbeTarget() string {
+	for {
+		if len(ms.probeOrder) == 0 {
+			ms.probeOrder = ms.probeCandidates()
+			rand.Shuffle(len(ms.probeOrder), func(i, j int) {
+				ms.probeOrder[i], ms.probeOrder[j] = ms.probeOrder[j], ms.probeOrder[i]
+			})
+			if len(ms.probeOrder) == 0 {
+				return ""
+			}
+		}
+		target := ms.probeOrder[0]
+		ms.probeOrder = ms.probeOrder[1:]
+		// skip members that failed or left since the order was drawn
+		if member, ok := ms.localMessage.MemberList[target]; ok && member.State != protocol_buffer.MemberState_DEAD && !member.IsLeaving {
+			return target
+		}
+	}
+}
+
+func (ms *MemberServer) probeCandidates() []string {
+	candidates := make([]string, 0)
+	for machineID, member := range ms.localMessage.MemberList {
+		if machineID != ms.SelfID && member.State != protocol_buffer.MemberState_DEAD && !member.IsLeaving {
+			candidates = append(candidates, machineID)
+		}
+	}
+	return candidates
+}
+
+// randomMembers returns the addresses of up to k probe candidates other than exclude
+func (ms *MemberServer) randomMembers(k int, exclude string) []string {
+	candidates := ms.probeCandidates()
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	addrs := make([]string, 0, k)
+	for _, machineID := range candidates {
+		if len(addrs) == k {
+			break
+		}
+		if machineID != exclude {
+			addrs = append(addrs, AddrOfID(machineID))
+		}
+	}
+	return addrs
+}
+
+func (ms *MemberServer) newProbe() (uint64, chan bool) {
+	ms.seqNo++
+	acked := make(chan bool, 1)
+	ms.probes[ms.seqNo] = acked
+	return ms.seqNo, acked
+}
+
+func (ms *MemberServer) endProbe(seq uint64) {
+	ms.mux.Lock()
+	delete(ms.probes, seq)
+	ms.mux.Unlock()
+}
+
+// encodeSwimMessage piggybacks the membership list on a probe message, the caller holds ms.mux
+func (ms *MemberServer) encodeSwimMessage(messageType protocol_buffer.MessageType, seq uint64, target string) ([]byte, error) {
+	message := ms.outgoingMessage(messageType, false)
+	message.Target = target
+	message.SeqNo = seq
+	return ms.encodeMessage(message)
+}
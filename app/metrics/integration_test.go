@@ -0,0 +1,186 @@
+//go:build integration
+
+package metrics_test
+
+import (
+	"better_mp3/app/harness"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestMetrics(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkMetrics)
+}
+
+// scrape reads the metrics a node serves on its advertised http endpoint, by name and labels as
+// they are written, e.g. bmp3_sdfs_operations_total{op="put",result="ok"}
+func scrape(c *harness.Cluster, node *harness.Node) (map[string]float64, error) {
+	endpoint, err := c.HTTPEndpoint(node)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.Get("http://" + endpoint + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/metrics of %v answered %v", node.Addr, response.Status)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	samples := map[string]float64{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample %q: %v", line, err)
+		}
+		samples[line[:i]] = value
+	}
+	return samples, nil
+}
+
+// sumOf adds up a sample over nodes
+func sumOf(c *harness.Cluster, nodes []*harness.Node, sample string) (float64, error) {
+	sum := 0.0
+	for _, node := range nodes {
+		samples, err := scrape(c, node)
+		if err != nil {
+			return 0, err
+		}
+		sum += samples[sample]
+	}
+	return sum, nil
+}
+
+// every node serves its membership, gossip, sdfs and maplejuice metrics on /metrics, and they
+// follow requests, failures, re-replication and jobs
+func checkMetrics(c *harness.Cluster) error {
+	writer := c.Nodes[0]
+	err := c.WaitFor("every node to count 4 members", 5*time.Second, func() bool {
+		members, err := sumOf(c, c.Nodes, "bmp3_member_members")
+		return err == nil && members == 16
+	})
+	if err != nil {
+		return err
+	}
+
+	local := filepath.Join(c.Dir, "metered.txt")
+	content := []byte(strings.Repeat("metered\n", 64))
+	if err := ioutil.WriteFile(local, content, 0644); err != nil {
+		return err
+	}
+	if err := writer.File.RemotePut(writer.File.User(), local, "metered"); err != nil {
+		return err
+	}
+	if err := writer.File.RemoteGet(writer.File.User(), "metered", filepath.Join(c.Dir, "metered-got")); err != nil {
+		return err
+	}
+	if err := writer.File.RemoteDelete(writer.File.User(), "unmetered"); err == nil {
+		return errors.New("deleted a file that doesn't exist")
+	}
+	samples, err := scrape(c, writer)
+	if err != nil {
+		return err
+	}
+	replicaNum := float64(c.Config.FileServiceConfig.ReplicaNum)
+	for sample, want := range map[string]float64{
+		`bmp3_sdfs_operations_total{op="put",result="ok"}`:       1,
+		`bmp3_sdfs_operations_total{op="get",result="ok"}`:       1,
+		`bmp3_sdfs_operations_total{op="delete",result="error"}`: 1,
+		`bmp3_sdfs_operation_duration_seconds_count{op="put"}`:   1,
+		`bmp3_sdfs_operation_duration_seconds_count{op="get"}`:   1,
+		`bmp3_sdfs_bytes_total{op="put"}`:                        replicaNum * float64(len(content)),
+		`bmp3_sdfs_bytes_total{op="get"}`:                        float64(len(content)),
+	} {
+		if samples[sample] != want {
+			return fmt.Errorf("%v is %v, expected %v", sample, samples[sample], want)
+		}
+	}
+	for _, sample := range []string{
+		`bmp3_member_gossip_bytes_total{direction="sent"}`,
+		`bmp3_member_gossip_bytes_total{direction="received"}`,
+		`bmp3_member_gossip_messages_total{direction="received"}`,
+		`bmp3_member_heartbeats_total`,
+		`bmp3_member_events_total{type="joined"}`,
+		`go_goroutines`,
+	} {
+		if samples[sample] <= 0 {
+			return fmt.Errorf("%v is %v, expected it to be counting", sample, samples[sample])
+		}
+	}
+
+	// a crashed replica holder is counted as failed and its replica copied again
+	var victim *harness.Node
+	for _, addr := range writer.File.FileTable.ListLocations("metered") {
+		if node := c.NodeByAddr(addr); node.Index != 0 {
+			victim = node
+			break
+		}
+	}
+	if victim == nil {
+		return errors.New("no replica holder besides the introducer")
+	}
+	c.Crash(victim.Index)
+	err = c.WaitFor("the failure and the re-replication to be counted", 5*c.Config.MemberServiceConfig.FailTime, func() bool {
+		samples, err := scrape(c, writer)
+		if err != nil || samples["bmp3_member_members"] != 3 || samples[`bmp3_member_events_total{type="failed"}`] < 1 {
+			return false
+		}
+		replicated, err := sumOf(c, c.Alive(), "bmp3_sdfs_rereplicated_files_total")
+		return err == nil && replicated >= 1
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := scrape(c, victim); err == nil {
+		return errors.New("the crashed node still serves its metrics")
+	}
+
+	// the master times the job, the nodes running them its tasks
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	if err := writer.MapleJuice.ScheduleMapleTask([]string{"maple", "maple_wordcount", "2", "metered-wc", "words"}); err != nil {
+		return err
+	}
+	samples, err = scrape(c, writer)
+	if err != nil {
+		return err
+	}
+	if jobs := samples[`bmp3_maplejuice_job_duration_seconds_count{kind="maple",result="ok"}`]; jobs != 1 {
+		return fmt.Errorf("%v maple jobs were timed, expected 1", jobs)
+	}
+	tasks, err := sumOf(c, c.Alive(), `bmp3_maplejuice_task_duration_seconds_count{kind="maple",result="ok"}`)
+	if err != nil {
+		return err
+	}
+	if tasks < 2 {
+		return fmt.Errorf("%v maple tasks were timed, expected at least 2", tasks)
+	}
+	running, err := sumOf(c, c.Alive(), `bmp3_maplejuice_running_tasks{kind="maple"}`)
+	if err != nil {
+		return err
+	}
+	if running != 0 {
+		return fmt.Errorf("%v maple tasks still count as running", running)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+This package keeps the metrics of the nodes. Every node has a registry of its own, which its http
+service serves at /metrics in the Prometheus text format. Registries are kept by node address, so
+that the nodes the test harness runs in one process don't mix their numbers, and so that a service
+restarted in the same process keeps counting where it left off: registering a metric a second time
+returns the one registered first.
+
+Metrics are named bmp3_<subsystem>_<name>, the subsystem being the service that updates them.
+*/
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const Namespace = "bmp3"
+
+// LatencyBuckets are the histogram buckets of requests, from 1ms to about 16s
+var LatencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 15)
+
+// TaskBuckets are the histogram buckets of maple and juice tasks and jobs, from 50ms to about 14m
+var TaskBuckets = prometheus.ExponentialBuckets(0.05, 2, 15)
+
+var (
+	mux        sync.Mutex
+	registries = map[string]*prometheus.Registry{}
+)
+
+// For returns the registry of the node at nodeAddr
+func For(nodeAddr string) *prometheus.Registry {
+	mux.Lock()
+	defer mux.Unlock()
+	registry, ok := registries[nodeAddr]
+	if !ok {
+		registry = prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		registries[nodeAddr] = registry
+	}
+	return registry
+}
+
+// Handler serves the metrics of the node at nodeAddr
+func Handler(nodeAddr string) http.Handler {
+	return promhttp.HandlerFor(For(nodeAddr), promhttp.HandlerOpts{})
+}
+
+// register registers a collector with the registry of a node, or returns the one registered before
+// under the same name
+func register(nodeAddr string, collector prometheus.Collector) prometheus.Collector {
+	err := For(nodeAddr).Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		return registered.ExistingCollector
+	}
+	if err != nil {
+		panic(err)
+	}
+	return collector
+}
+
+func Counter(nodeAddr string, subsystem string, name string, help string) prometheus.Counter {
+	opts := prometheus.CounterOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	return register(nodeAddr, prometheus.NewCounter(opts)).(prometheus.Counter)
+}
+
+func CounterVec(nodeAddr string, subsystem string, name string, help string, labels ...string) *prometheus.CounterVec {
+	opts := prometheus.CounterOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	return register(nodeAddr, prometheus.NewCounterVec(opts, labels)).(*prometheus.CounterVec)
+}
+
+func Gauge(nodeAddr string, subsystem string, name string, help string) prometheus.Gauge {
+	opts := prometheus.GaugeOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	return register(nodeAddr, prometheus.NewGauge(opts)).(prometheus.Gauge)
+}
+
+func GaugeVec(nodeAddr string, subsystem string, name string, help string, labels ...string) *prometheus.GaugeVec {
+	opts := prometheus.GaugeOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	return register(nodeAddr, prometheus.NewGaugeVec(opts, labels)).(*prometheus.GaugeVec)
+}
+
+func HistogramVec(nodeAddr string, subsystem string, name string, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	opts := prometheus.HistogramOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help, Buckets: buckets}
+	return register(nodeAddr, prometheus.NewHistogramVec(opts, labels)).(*prometheus.HistogramVec)
+}
@@ -0,0 +1,166 @@
+package raft_service
+
+import (
+	"time"
+)
+
+// run drives the timers of this node: the leader sends heartbeats and reconciles the
+// configuration with the membership, a follower that stopped hearing from it campaigns
+func (rs *RaftServer) run() {
+	ticker := time.NewTicker(rs.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rs.done:
+			return
+		case <-ticker.C:
+		}
+
+		// a node cut off from the majority of the membership neither leads nor campaigns
+		quorum := rs.ms.HasQuorum()
+
+		rs.mux.Lock()
+		if rs.role == leader && !quorum {
+			raftLog.Warn("Raft: lost the majority of the membership")
+			rs.stepDown(rs.term)
+			rs.leader = ""
+		} else if rs.role == leader {
+			rs.broadcastAppend()
+		} else if quorum && rs.isPeer(rs.selfAddr) && time.Since(rs.lastHeard) > rs.timeout {
+			rs.campaign()
+		}
+		isLeader := rs.role == leader
+		leaderAddr, term := rs.leader, rs.term
+		rs.mux.Unlock()
+
+		rs.followLeader(leaderAddr, term)
+		if isLeader {
+			rs.reconcile()
+		}
+	}
+}
+
+// followLeader makes the raft leader the master of the member service, or leaves
+// the member service without a master while there is no raft leader
+func (rs *RaftServer) followLeader(leaderAddr string, term int64) {
+	if leaderAddr == rs.followed && (leaderAddr == "" || term == rs.followedTerm) {
+		return
+	}
+	// the leader may not be in the membership list yet, we try again on the next tick
+	if rs.ms.FollowLeader(leaderAddr, term) {
+		rs.followed, rs.followedTerm = leaderAddr, term
+	}
+}
+
+// campaign starts an election for the next term, the caller holds rs.mux
+func (rs *RaftServer) campaign() {
+	rs.term++
+	rs.role = candidate
+	rs.leader = ""
+	rs.votedFor = rs.selfAddr
+	rs.votes = map[string]bool{rs.selfAddr: true}
+	rs.resetTimeout()
+	if err := rs.persistState(); err != nil {
+		// a vote that isn't on disk could be cast again in this term after a restart
+		rs.role = follower
+		rs.votes = nil
+		return
+	}
+	raftLog.Info("Raft: starting election for term", rs.term)
+
+	if rs.majority(func(peer string) bool { return rs.votes[peer] }) {
+		rs.becomeLeader()
+		return
+	}
+
+	args := RequestVoteArgs{
+		Term:         rs.term,
+		Candidate:    rs.selfAddr,
+		LastLogIndex: rs.lastIndex(),
+		LastLogTerm:  rs.lastTerm(),
+	}
+	for _, peer := range rs.peers {
+		if peer == rs.selfAddr {
+			continue
+		}
+		go rs.requestVote(peer, args)
+	}
+}
+
+func (rs *RaftServer) requestVote(peer string, args RequestVoteArgs) {
+	var reply RequestVoteReply
+	if err := rs.call(peer, "RaftRPCServer.RequestVote", args, &reply); err != nil {
+		return
+	}
+
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	if reply.Term > rs.term {
+		rs.stepDown(reply.Term)
+		return
+	}
+	if rs.role != candidate || rs.term != args.Term || !reply.Granted {
+		return
+	}
+	rs.votes[peer] = true
+	if rs.majority(func(peer string) bool { return rs.votes[peer] }) {
+		rs.becomeLeader()
+	}
+}
+
+// becomeLeader starts replicating to every peer, and commits a no-op entry so that
+// the entries of earlier terms are committed too. The caller holds rs.mux.
+func (rs *RaftServer) becomeLeader() {
+	rs.role = leader
+	rs.leader = rs.selfAddr
+	rs.votes = nil
+	for _, peer := range rs.peers {
+		rs.nextIndex[peer] = rs.lastIndex() + 1
+		rs.matchIndex[peer] = 0
+	}
+	raftLog.Info("Raft: elected leader for term", rs.term)
+
+	if err := rs.appendEntries([]Entry{{Index: rs.lastIndex() + 1, Term: rs.term, Kind: EntryNoop}}); err != nil {
+		rs.stepDown(rs.term)
+		rs.leader = ""
+		return
+	}
+	rs.advanceCommit()
+	rs.broadcastAppend()
+	rs.applied.Broadcast()
+}
+
+func (rs *RaftServer) handleRequestVote(args RequestVoteArgs, reply *RequestVoteReply) error {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	reply.Term = rs.term
+	if args.Term < rs.term {
+		return nil
+	}
+	// a node that still hears from its leader ignores candidates, so that a peer that
+	// was removed from the configuration, or cut off for a while, can't disrupt the cluster
+	if rs.role == leader || (rs.leader != "" && time.Since(rs.lastHeard) < rs.config.ElectionTimeout) {
+		return nil
+	}
+	if args.Term > rs.term {
+		if err := rs.stepDown(args.Term); err != nil {
+			return nil
+		}
+	}
+	reply.Term = rs.term
+
+	upToDate := args.LastLogTerm > rs.lastTerm() ||
+		(args.LastLogTerm == rs.lastTerm() && args.LastLogIndex >= rs.lastIndex())
+	if (rs.votedFor == "" || rs.votedFor == args.Candidate) && upToDate {
+		previous := rs.votedFor
+		rs.votedFor = args.Candidate
+		if err := rs.persistState(); err != nil {
+			rs.votedFor = previous
+			return nil
+		}
+		rs.resetTimeout()
+		reply.Granted = true
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+//go:build integration
+
+package raft_service_test
+
+import (
+	"better_mp3/app/harness"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestControlPlane(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkControlPlane)
+}
+
+// checkControlPlane crashes the raft leader, the file table must survive it on every node,
+// and enough files are put for the log to be compacted into a snapshot
+func checkControlPlane(c *harness.Cluster) error {
+	local := filepath.Join(c.Dir, "small.txt")
+	if err := ioutil.WriteFile(local, []byte("small\n"), 0644); err != nil {
+		return err
+	}
+	puts := c.Config.RaftServiceConfig.SnapshotEntries
+	for i := 0; i < puts; i++ {
+		// followers forward the changes to the leader
+		writer := c.Nodes[1+i%(len(c.Nodes)-1)]
+		if err := writer.File.RemotePut(writer.File.User(), local, "small-"+strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+
+	leaderAddr, _ := c.Nodes[0].Raft.Leader()
+	leader := c.NodeByAddr(leaderAddr)
+	if leader == nil {
+		return errors.New("no raft leader")
+	}
+	c.Crash(leader.Index)
+	if _, _, err := c.WaitForLeader(c.Alive(), "a new raft leader"); err != nil {
+		return err
+	}
+	survivor := c.Alive()[0]
+	if err := survivor.File.RemotePut(survivor.File.User(), local, "after-failover"); err != nil {
+		return err
+	}
+
+	alive := c.Alive()
+	var files []string
+	err := c.WaitFor("the file table to agree on every node", 5*time.Second, func() bool {
+		files = alive[0].File.FileTable.ListFilesByPrefix("")
+		sort.Strings(files)
+		for _, node := range alive {
+			nodeFiles := node.File.FileTable.ListFilesByPrefix("")
+			sort.Strings(nodeFiles)
+			if fmt.Sprint(node.Raft.Peers()) != fmt.Sprint(harness.Addrs(alive)) || !reflect.DeepEqual(nodeFiles, files) {
+				return false
+			}
+		}
+		return len(files) == puts+1
+	})
+	if err != nil {
+		return fmt.Errorf("%v, %v files", err, len(files))
+	}
+
+	for _, node := range alive {
+		if _, err := os.Stat(filepath.Join(node.Config.RaftServiceConfig.Path, "snapshot.json")); err != nil {
+			return fmt.Errorf("node %v took no snapshot: %v", node.Addr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package raft_service
+
+import (
+	"better_mp3/app/member_service"
+)
+
+// watchMembers follows the membership events, the leader turns them into configuration changes
+func (rs *RaftServer) watchMembers() {
+	events := rs.ms.Subscribe("raft")
+	defer events.Close()
+	for {
+		select {
+		case <-rs.done:
+			return
+		case event := <-events.C:
+			switch event.Type {
+			case member_service.EventJoined, member_service.EventFailed, member_service.EventLeft:
+				raftLog.Debug("Raft: member", event.Member.Addr, event.Type)
+				rs.reconcile()
+			}
+		}
+	}
+}
+
+// reconcile appends one configuration change that brings the peers closer to the alive
+// members. Changes go one peer at a time, and only once the previous one is committed,
+// so that the old and the new majorities always overlap. The leader never removes itself.
+func (rs *RaftServer) reconcile() {
+	// without a quorum the missing members may only be cut off, they are not removed
+	if !rs.ms.HasQuorum() {
+		return
+	}
+	alive := rs.ms.GetAliveMemberAddrList()
+
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	if rs.role != leader || rs.configPending() {
+		return
+	}
+
+	peers := append([]string{}, rs.peers...)
+	for _, addr := range alive {
+		if !contains(peers, addr) {
+			raftLog.Info("Raft: adding peer", addr)
+			rs.changeConfig(append(peers, addr))
+			return
+		}
+	}
+	for i, peer := range peers {
+		if peer != rs.selfAddr && !contains(alive, peer) {
+			raftLog.Info("Raft: removing peer", peer)
+			rs.changeConfig(append(peers[:i], peers[i+1:]...))
+			return
+		}
+	}
+}
+
+// changeConfig appends a configuration entry, it takes effect as soon as it is in the log
+func (rs *RaftServer) changeConfig(peers []string) {
+	for _, peer := range peers {
+		if !rs.isPeer(peer) {
+			// a peer added again may have lost its log meanwhile
+			rs.nextIndex[peer] = rs.lastIndex() + 1
+			rs.matchIndex[peer] = 0
+		}
+	}
+	if err := rs.appendEntries([]Entry{{Index: rs.lastIndex() + 1, Term: rs.term, Kind: EntryConfig, Peers: peers}}); err != nil {
+		return
+	}
+	rs.advanceCommit()
+	rs.broadcastAppend()
+}
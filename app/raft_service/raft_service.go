@@ -0,0 +1,447 @@
+/*
+This package keeps the state of the master in a log replicated with Raft, so that it outlives
+the node holding it. The other services register state machines, e.g. the sdfs file table and
+the maplejuice job records, and propose their changes as commands; every node applies the
+committed commands in the same order. The log also holds the configuration of the cluster:
+the leader adds and removes peers as the member service reports joins and failures, and it
+becomes the master of the member service. The log, the vote and the snapshots compacting the
+log are kept under raft_service.path.
+*/
+package raft_service
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/logger"
+	"better_mp3/app/member_service"
+	"better_mp3/app/secure_rpc"
+	"better_mp3/app/tracing"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var raftLog = logger.New(logger.ComponentRaft)
+
+var ErrShuttingDown = errors.New("raft service is shutting down")
+
+// ErrNoLeader is returned when no leader committed a command within commit_timeout
+var ErrNoLeader = errors.New("no raft leader committed the command in time")
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+type EntryKind int
+
+const (
+	EntryNoop EntryKind = iota
+	EntryCommand
+	EntryConfig
+)
+
+// Entry is one record of the replicated log
+type Entry struct {
+	Index   int64
+	Term    int64
+	Kind    EntryKind
+	Machine string // the state machine a command is applied to
+	Command []byte
+	Peers   []string // the configuration a config entry switches to
+}
+
+// Snapshot replaces the log up to and including Index
+type Snapshot struct {
+	Index    int64
+	Term     int64
+	Peers    []string
+	Machines map[string][]byte
+}
+
+// StateMachine is the replicated state of a service. Apply must be deterministic, since every
+// node applies the same commands.
+type StateMachine interface {
+	Apply(command []byte)
+	Snapshot() ([]byte, error)
+	Restore(snapshot []byte) error
+}
+
+// PeerObserver is implemented by state machines that follow the configuration of the cluster.
+// replay is set for changes that this node had already applied before it restarted.
+type PeerObserver interface {
+	PeersChanged(added []string, removed []string, replay bool)
+}
+
+type RaftServer struct {
+	config   config.RaftServiceConfig
+	ms       *member_service.MemberServer
+	selfAddr string
+	store    *storage
+	tracer   trace.Tracer
+
+	mux      sync.Mutex
+	term     int64
+	votedFor string
+	log      []Entry // entries after the snapshot
+	snapshot Snapshot
+
+	role        role
+	leader      string
+	peers       []string // the latest configuration in the log, committed or not
+	votes       map[string]bool
+	commitIndex int64
+	lastApplied int64
+	lastHeard   time.Time
+	timeout     time.Duration
+	nextIndex   map[string]int64
+	matchIndex  map[string]int64
+	replicating map[string]bool
+
+	// the state machines are only changed while holding applyMux, without holding mux
+	applyMux     sync.Mutex
+	applied      *sync.Cond // broadcast on mux when entries are applied or the role changes
+	machines     map[string]StateMachine
+	appliedPeers []string
+	replayIndex  int64 // the last entry in the log when the node started
+
+	// the leader last handed to the member service, only used by the run loop
+	followed     string
+	followedTerm int64
+
+	credentials *secure_rpc.Credentials
+	listener    net.Listener
+	clientMux   sync.Mutex
+	clients     map[string]*rpc.Client
+	closing     bool
+	done        chan struct{}
+}
+
+func NewRaftServer(memberService *member_service.MemberServer) *RaftServer {
+	return NewRaftServerWithConfig(memberService, config.GetRaftServiceConfig())
+}
+
+// NewRaftServerWithConfig doesn't read the global config, so that several
+// raft servers can run in one process
+func NewRaftServerWithConfig(memberService *member_service.MemberServer, raftConfig config.RaftServiceConfig) *RaftServer {
+	var rs RaftServer
+	rs.config = raftConfig
+	credentials, err := secure_rpc.Load(raftConfig.TLS)
+	if err != nil {
+		log.Fatal("Failed to load the tls credentials: ", err)
+	}
+	rs.credentials = credentials
+	rs.ms = memberService
+	rs.selfAddr = memberService.SelfAddr
+	rs.tracer = tracing.Tracer(rs.selfAddr)
+	rs.applied = sync.NewCond(&rs.mux)
+	rs.machines = map[string]StateMachine{}
+	rs.nextIndex = map[string]int64{}
+	rs.matchIndex = map[string]int64{}
+	rs.replicating = map[string]bool{}
+	rs.clients = map[string]*rpc.Client{}
+	rs.done = make(chan struct{})
+	rs.resetTimeout()
+	return &rs
+}
+
+// Register adds a state machine, it must be called before Run
+func (rs *RaftServer) Register(name string, machine StateMachine) {
+	rs.machines[name] = machine
+}
+
+// Run loads the log from disk, restores the state machines and starts taking part in the cluster.
+// The introducer founds the cluster if it has no log yet and raft_service.bootstrap is set.
+func (rs *RaftServer) Run() {
+	if err := rs.load(); err != nil {
+		raftLog.Error("Failed to load the raft log from", rs.config.Path, err)
+		return
+	}
+	RunRPCServer(rs)
+	rs.ms.AdvertiseEndpoint(member_service.EndpointRaft, rs.config.Port)
+	go rs.run()
+	go rs.applyLoop()
+	go rs.watchMembers()
+
+	raftLog.Info(
+		"Raft Service is now running on port "+rs.config.Port,
+		"\n\tLog path: ", rs.config.Path,
+		"\n\tTerm:", rs.term, "Last index:", rs.lastIndex())
+}
+
+// Stop closes the rpc listener and wakes up the proposals still waiting
+func (rs *RaftServer) Stop(timeout time.Duration) {
+	rs.mux.Lock()
+	if rs.closing {
+		rs.mux.Unlock()
+		return
+	}
+	rs.closing = true
+	close(rs.done)
+	rs.applied.Broadcast()
+	rs.mux.Unlock()
+
+	if rs.listener != nil {
+		_ = rs.listener.Close()
+	}
+	rs.clientMux.Lock()
+	for addr, client := range rs.clients {
+		_ = client.Close()
+		delete(rs.clients, addr)
+	}
+	rs.clientMux.Unlock()
+
+	// wait for the state machines to settle before closing the log
+	if !lockTimeout(&rs.applyMux, timeout) {
+		raftLog.Warn("Raft service stopped while applying entries")
+		return
+	}
+	rs.mux.Lock()
+	rs.store.close()
+	rs.mux.Unlock()
+	rs.applyMux.Unlock()
+	raftLog.Info("Raft service stopped")
+}
+
+// Leader returns the member address of the current leader and its term
+func (rs *RaftServer) Leader() (string, int64) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	return rs.leader, rs.term
+}
+
+func (rs *RaftServer) IsLeader() bool {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	return rs.role == leader
+}
+
+// Peers returns the sorted member addresses of the applied configuration
+func (rs *RaftServer) Peers() []string {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	peers := append([]string{}, rs.appliedPeers...)
+	sort.Strings(peers)
+	return peers
+}
+
+// load restores the persisted state, the caller must not hold rs.mux
+func (rs *RaftServer) load() error {
+	store, err := openStorage(rs.config.Path)
+	if err != nil {
+		return err
+	}
+	state, snapshot, entries, err := store.load()
+	if err != nil {
+		return err
+	}
+
+	rs.applyMux.Lock()
+	defer rs.applyMux.Unlock()
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	rs.store = store
+	rs.term = state.Term
+	rs.votedFor = state.VotedFor
+	rs.snapshot = snapshot
+	rs.log = entries
+	rs.commitIndex = snapshot.Index
+	rs.lastApplied = snapshot.Index
+	rs.replayIndex = rs.lastIndex()
+	if snapshot.Index > 0 {
+		for name, machine := range rs.machines {
+			if err := machine.Restore(snapshot.Machines[name]); err != nil {
+				return err
+			}
+		}
+		rs.appliedPeers = snapshot.Peers
+	}
+
+	// without the flag, an introducer that lost its log waits for the leader of the running
+	// cluster to add it again, instead of founding a second cluster
+	if rs.lastIndex() == 0 && rs.ms.IsLeader && rs.config.Bootstrap {
+		raftLog.Info("Raft: founding the cluster")
+		if err := rs.appendEntries([]Entry{{Index: 1, Term: 0, Kind: EntryConfig, Peers: []string{rs.selfAddr}}}); err != nil {
+			return err
+		}
+	}
+	rs.peers = rs.latestConfig()
+	return nil
+}
+
+/*
+	Following helpers expect the caller to hold rs.mux
+*/
+
+func (rs *RaftServer) lastIndex() int64 {
+	if len(rs.log) > 0 {
+		return rs.log[len(rs.log)-1].Index
+	}
+	return rs.snapshot.Index
+}
+
+func (rs *RaftServer) lastTerm() int64 {
+	if len(rs.log) > 0 {
+		return rs.log[len(rs.log)-1].Term
+	}
+	return rs.snapshot.Term
+}
+
+// termAt returns the term of the entry at index, if it is still known
+func (rs *RaftServer) termAt(index int64) (int64, bool) {
+	if index == rs.snapshot.Index {
+		return rs.snapshot.Term, true
+	}
+	if index < rs.snapshot.Index || index > rs.lastIndex() {
+		return 0, false
+	}
+	return rs.log[index-rs.snapshot.Index-1].Term, true
+}
+
+// entriesBetween copies the entries in (from, to]
+func (rs *RaftServer) entriesBetween(from int64, to int64) []Entry {
+	if from < rs.snapshot.Index {
+		from = rs.snapshot.Index
+	}
+	if to > rs.lastIndex() {
+		to = rs.lastIndex()
+	}
+	if to <= from {
+		return nil
+	}
+	return append([]Entry{}, rs.log[from-rs.snapshot.Index:to-rs.snapshot.Index]...)
+}
+
+// latestConfig is the configuration the protocol runs with, committed or not
+func (rs *RaftServer) latestConfig() []string {
+	for i := len(rs.log) - 1; i >= 0; i-- {
+		if rs.log[i].Kind == EntryConfig {
+			return rs.log[i].Peers
+		}
+	}
+	return rs.snapshot.Peers
+}
+
+// configPending tells whether a configuration change is not committed yet
+func (rs *RaftServer) configPending() bool {
+	for i := len(rs.log) - 1; i >= 0 && rs.log[i].Index > rs.commitIndex; i-- {
+		if rs.log[i].Kind == EntryConfig {
+			return true
+		}
+	}
+	return false
+}
+
+// appendEntries persists entries and adds them to the end of the log, the log is left as it was
+// if they can't be persisted
+func (rs *RaftServer) appendEntries(entries []Entry) error {
+	if err := rs.store.append(entries); err != nil {
+		raftLog.Error("Raft: failed to persist log entries:", err)
+		// the file may hold part of the entries, rewriting it drops them
+		if err := rs.store.rewrite(rs.log); err != nil {
+			raftLog.Error("Raft: failed to restore the log file:", err)
+		}
+		return err
+	}
+	rs.log = append(rs.log, entries...)
+	for _, entry := range entries {
+		if entry.Kind == EntryConfig {
+			rs.peers = entry.Peers
+		}
+	}
+	return nil
+}
+
+// truncate drops the entries from index on, which conflict with the leader's log, the log is
+// left as it was if the truncation can't be persisted
+func (rs *RaftServer) truncate(index int64) error {
+	kept := append([]Entry{}, rs.log[:index-rs.snapshot.Index-1]...)
+	if err := rs.store.rewrite(kept); err != nil {
+		raftLog.Error("Raft: failed to persist log truncation:", err)
+		return err
+	}
+	rs.log = kept
+	rs.peers = rs.latestConfig()
+	return nil
+}
+
+func (rs *RaftServer) persistState() error {
+	err := rs.store.saveState(hardState{Term: rs.term, VotedFor: rs.votedFor})
+	if err != nil {
+		raftLog.Error("Raft: failed to persist term and vote:", err)
+	}
+	return err
+}
+
+// stepDown turns this node into a follower of term. It fails if the new term can't be
+// persisted, the node must then not answer rpcs of that term.
+func (rs *RaftServer) stepDown(term int64) error {
+	var err error
+	if term > rs.term {
+		rs.term = term
+		rs.votedFor = ""
+		rs.leader = ""
+		err = rs.persistState()
+	}
+	if rs.role == leader {
+		raftLog.Info("Raft: stepping down as leader in term", rs.term)
+	}
+	rs.role = follower
+	rs.votes = nil
+	rs.applied.Broadcast()
+	return err
+}
+
+func (rs *RaftServer) isPeer(addr string) bool {
+	return contains(rs.peers, addr)
+}
+
+// majority tells whether the peers in set are a majority of the configuration
+func (rs *RaftServer) majority(set func(peer string) bool) bool {
+	count := 0
+	for _, peer := range rs.peers {
+		if set(peer) {
+			count++
+		}
+	}
+	return 2*count > len(rs.peers)
+}
+
+// resetTimeout picks a new election timeout between one and two election_timeout
+func (rs *RaftServer) resetTimeout() {
+	rs.lastHeard = time.Now()
+	rs.timeout = rs.config.ElectionTimeout + time.Duration(rand.Int63n(int64(rs.config.ElectionTimeout)))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lockTimeout acquires mux unless timeout expires first
+func lockTimeout(mux *sync.Mutex, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if mux.TryLock() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,530 @@
+package raft_service
+
+import (
+	"better_mp3/app/tracing"
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBatch bounds the entries sent in one AppendEntries
+const maxBatch = 256
+
+// broadcastAppend replicates to every peer that has no request in flight, the caller holds rs.mux
+func (rs *RaftServer) broadcastAppend() {
+	for _, peer := range rs.peers {
+		if peer == rs.selfAddr || rs.replicating[peer] {
+			continue
+		}
+		if _, ok := rs.nextIndex[peer]; !ok {
+			// added to the configuration after the election
+			rs.nextIndex[peer] = rs.lastIndex() + 1
+		}
+		rs.replicating[peer] = true
+		go rs.replicate(peer)
+	}
+}
+
+// replicate sends the entries peer is missing, or the snapshot if they were compacted,
+// until it has caught up or the request fails
+func (rs *RaftServer) replicate(peer string) {
+	defer func() {
+		rs.mux.Lock()
+		rs.replicating[peer] = false
+		rs.mux.Unlock()
+	}()
+
+	for {
+		rs.mux.Lock()
+		if rs.role != leader {
+			rs.mux.Unlock()
+			return
+		}
+		next := rs.nextIndex[peer]
+		if next <= rs.snapshot.Index {
+			args := InstallSnapshotArgs{Term: rs.term, Leader: rs.selfAddr, Snapshot: rs.snapshot}
+			rs.mux.Unlock()
+			if !rs.sendSnapshot(peer, args) {
+				return
+			}
+			continue
+		}
+
+		prevTerm, _ := rs.termAt(next - 1)
+		last := next - 1 + maxBatch
+		args := AppendEntriesArgs{
+			Term:         rs.term,
+			Leader:       rs.selfAddr,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  prevTerm,
+			Entries:      rs.entriesBetween(next-1, last),
+			LeaderCommit: rs.commitIndex,
+		}
+		rs.mux.Unlock()
+
+		var reply AppendEntriesReply
+		if err := rs.call(peer, "RaftRPCServer.AppendEntries", args, &reply); err != nil {
+			return
+		}
+
+		rs.mux.Lock()
+		if reply.Term > rs.term {
+			rs.stepDown(reply.Term)
+			rs.mux.Unlock()
+			return
+		}
+		if rs.role != leader || rs.term != args.Term {
+			rs.mux.Unlock()
+			return
+		}
+		if reply.Success {
+			match := args.PrevLogIndex + int64(len(args.Entries))
+			if match > rs.matchIndex[peer] {
+				rs.matchIndex[peer] = match
+			}
+			rs.nextIndex[peer] = match + 1
+			rs.advanceCommit()
+		} else {
+			rs.nextIndex[peer] = reply.ConflictIndex
+			if rs.nextIndex[peer] < 1 {
+				rs.nextIndex[peer] = 1
+			}
+		}
+		// once caught up, the peer still learns at once what this reply committed
+		done := rs.nextIndex[peer] > rs.lastIndex() && args.LeaderCommit >= rs.commitIndex
+		rs.mux.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// sendSnapshot installs the leader's snapshot on a peer that is too far behind
+func (rs *RaftServer) sendSnapshot(peer string, args InstallSnapshotArgs) bool {
+	var reply InstallSnapshotReply
+	if err := rs.call(peer, "RaftRPCServer.InstallSnapshot", args, &reply); err != nil {
+		return false
+	}
+
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	if reply.Term > rs.term {
+		rs.stepDown(reply.Term)
+		return false
+	}
+	if rs.role != leader || rs.term != args.Term {
+		return false
+	}
+	if args.Snapshot.Index > rs.matchIndex[peer] {
+		rs.matchIndex[peer] = args.Snapshot.Index
+	}
+	rs.nextIndex[peer] = args.Snapshot.Index + 1
+	rs.advanceCommit()
+	return true
+}
+
+// advanceCommit commits the newest entry of the current term stored on a majority,
+// the caller holds rs.mux
+func (rs *RaftServer) advanceCommit() {
+	rs.matchIndex[rs.selfAddr] = rs.lastIndex()
+	for index := rs.lastIndex(); index > rs.commitIndex; index-- {
+		if term, _ := rs.termAt(index); term != rs.term {
+			// entries of earlier terms are only committed along with a newer one
+			break
+		}
+		if rs.majority(func(peer string) bool { return rs.matchIndex[peer] >= index }) {
+			rs.commitIndex = index
+			rs.applied.Broadcast()
+			break
+		}
+	}
+}
+
+func (rs *RaftServer) handleAppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	reply.Term = rs.term
+	if args.Term < rs.term || rs.closing {
+		return nil
+	}
+	if args.Term > rs.term || rs.role != follower {
+		if err := rs.stepDown(args.Term); err != nil {
+			// the leader retries the same entries
+			reply.Term = rs.term
+			reply.ConflictIndex = args.PrevLogIndex + 1
+			return nil
+		}
+	}
+	rs.leader = args.Leader
+	rs.resetTimeout()
+	reply.Term = rs.term
+
+	if args.PrevLogIndex > rs.lastIndex() {
+		reply.ConflictIndex = rs.lastIndex() + 1
+		return nil
+	}
+	// the entries up to our snapshot are committed, and so match the leader's
+	entries := args.Entries
+	prevIndex, prevTerm := args.PrevLogIndex, args.PrevLogTerm
+	if prevIndex < rs.snapshot.Index {
+		for len(entries) > 0 && entries[0].Index <= rs.snapshot.Index {
+			entries = entries[1:]
+		}
+		prevIndex, prevTerm = rs.snapshot.Index, rs.snapshot.Term
+	}
+	if term, _ := rs.termAt(prevIndex); term != prevTerm {
+		conflict := prevIndex
+		for conflict > rs.snapshot.Index+1 {
+			if before, _ := rs.termAt(conflict - 1); before != term {
+				break
+			}
+			conflict--
+		}
+		reply.ConflictIndex = conflict
+		return nil
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= rs.lastIndex() {
+			if term, _ := rs.termAt(entry.Index); term == entry.Term {
+				continue
+			}
+			if err := rs.truncate(entry.Index); err != nil {
+				reply.ConflictIndex = entry.Index
+				return nil
+			}
+		}
+		if err := rs.appendEntries(entries[i:]); err != nil {
+			reply.ConflictIndex = entry.Index
+			return nil
+		}
+		break
+	}
+
+	if args.LeaderCommit > rs.commitIndex {
+		last := args.PrevLogIndex + int64(len(args.Entries))
+		if args.LeaderCommit < last {
+			last = args.LeaderCommit
+		}
+		if last > rs.commitIndex {
+			rs.commitIndex = last
+			rs.applied.Broadcast()
+		}
+	}
+	reply.Success = true
+	return nil
+}
+
+func (rs *RaftServer) handleInstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	rs.applyMux.Lock()
+	defer rs.applyMux.Unlock()
+	rs.mux.Lock()
+
+	reply.Term = rs.term
+	if args.Term < rs.term || rs.closing {
+		rs.mux.Unlock()
+		return nil
+	}
+	if args.Term > rs.term || rs.role != follower {
+		if err := rs.stepDown(args.Term); err != nil {
+			reply.Term = rs.term
+			rs.mux.Unlock()
+			return nil
+		}
+	}
+	rs.leader = args.Leader
+	rs.resetTimeout()
+	reply.Term = rs.term
+
+	snapshot := args.Snapshot
+	if snapshot.Index <= rs.lastApplied {
+		rs.mux.Unlock()
+		return nil
+	}
+	// entries following the snapshot are kept if the log agrees with it
+	var kept []Entry
+	if term, ok := rs.termAt(snapshot.Index); ok && term == snapshot.Term {
+		kept = rs.entriesBetween(snapshot.Index, rs.lastIndex())
+	}
+	// the leader sends the snapshot again unless both are persisted
+	if err := rs.store.saveSnapshot(snapshot); err != nil {
+		raftLog.Error("Raft: failed to persist snapshot:", err)
+		rs.mux.Unlock()
+		return nil
+	}
+	if err := rs.store.rewrite(kept); err != nil {
+		raftLog.Error("Raft: failed to persist log:", err)
+		rs.mux.Unlock()
+		return nil
+	}
+	rs.log = kept
+	rs.snapshot = snapshot
+	rs.peers = rs.latestConfig()
+	if snapshot.Index > rs.commitIndex {
+		rs.commitIndex = snapshot.Index
+	}
+	rs.lastApplied = snapshot.Index
+	previous := rs.appliedPeers
+	rs.appliedPeers = snapshot.Peers
+	rs.mux.Unlock()
+
+	raftLog.Info("Raft: installing snapshot up to index", snapshot.Index)
+	for name, machine := range rs.machines {
+		if err := machine.Restore(snapshot.Machines[name]); err != nil {
+			raftLog.Error("Raft: failed to restore", name, "from snapshot:", err)
+		}
+	}
+	rs.notifyPeers(previous, snapshot.Peers, false)
+
+	rs.mux.Lock()
+	rs.applied.Broadcast()
+	rs.mux.Unlock()
+	return nil
+}
+
+// applyLoop applies the committed entries to the state machines in log order
+func (rs *RaftServer) applyLoop() {
+	for {
+		rs.mux.Lock()
+		for rs.commitIndex <= rs.lastApplied && !rs.closing {
+			rs.applied.Wait()
+		}
+		rs.mux.Unlock()
+
+		rs.applyMux.Lock()
+		rs.mux.Lock()
+		if rs.closing {
+			rs.mux.Unlock()
+			rs.applyMux.Unlock()
+			return
+		}
+		entries := rs.entriesBetween(rs.lastApplied, rs.commitIndex)
+		replayIndex := rs.replayIndex
+		rs.mux.Unlock()
+
+		for _, entry := range entries {
+			rs.applyEntry(entry, entry.Index <= replayIndex)
+		}
+
+		rs.mux.Lock()
+		if len(entries) > 0 {
+			rs.lastApplied = entries[len(entries)-1].Index
+		}
+		compact := rs.lastApplied-rs.snapshot.Index >= int64(rs.config.SnapshotEntries)
+		rs.applied.Broadcast()
+		rs.mux.Unlock()
+
+		if compact {
+			rs.takeSnapshot()
+		}
+		rs.applyMux.Unlock()
+	}
+}
+
+// applyEntry expects the caller to hold rs.applyMux
+func (rs *RaftServer) applyEntry(entry Entry, replay bool) {
+	switch entry.Kind {
+	case EntryCommand:
+		machine, ok := rs.machines[entry.Machine]
+		if !ok {
+			raftLog.Warn("Raft: no state machine", entry.Machine, "for entry", entry.Index)
+			return
+		}
+		machine.Apply(entry.Command)
+	case EntryConfig:
+		rs.mux.Lock()
+		previous := rs.appliedPeers
+		rs.appliedPeers = entry.Peers
+		rs.mux.Unlock()
+		rs.notifyPeers(previous, entry.Peers, replay)
+	}
+}
+
+// notifyPeers tells the state machines which peers a configuration change added and removed
+func (rs *RaftServer) notifyPeers(previous []string, peers []string, replay bool) {
+	var added, removed []string
+	for _, peer := range peers {
+		if !contains(previous, peer) {
+			added = append(added, peer)
+		}
+	}
+	for _, peer := range previous {
+		if !contains(peers, peer) {
+			removed = append(removed, peer)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	if !replay {
+		raftLog.Info("Raft: peers", peers, "added", added, "removed", removed)
+	}
+	for _, machine := range rs.machines {
+		if observer, ok := machine.(PeerObserver); ok {
+			observer.PeersChanged(added, removed, replay)
+		}
+	}
+}
+
+// takeSnapshot compacts the applied entries, the caller holds rs.applyMux
+func (rs *RaftServer) takeSnapshot() {
+	machines := map[string][]byte{}
+	for name, machine := range rs.machines {
+		data, err := machine.Snapshot()
+		if err != nil {
+			raftLog.Error("Raft: failed to snapshot", name, err)
+			return
+		}
+		machines[name] = data
+	}
+
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	term, _ := rs.termAt(rs.lastApplied)
+	snapshot := Snapshot{
+		Index:    rs.lastApplied,
+		Term:     term,
+		Peers:    rs.appliedPeers,
+		Machines: machines,
+	}
+	if err := rs.store.saveSnapshot(snapshot); err != nil {
+		raftLog.Error("Raft: failed to persist snapshot:", err)
+		return
+	}
+	rs.log = rs.entriesBetween(snapshot.Index, rs.lastIndex())
+	rs.snapshot = snapshot
+	if err := rs.store.rewrite(rs.log); err != nil {
+		raftLog.Error("Raft: failed to persist log:", err)
+	}
+	raftLog.Debug("Raft: compacted the log up to index", snapshot.Index)
+}
+
+// Propose commits command to the state machine registered as machine and waits until this
+// node has applied it. Followers forward it to the leader. It fails with ErrNoLeader if no
+// leader committed it within commit_timeout, e.g. while this node is in a minority partition.
+// A command is only proposed again if it certainly wasn't appended, so it is applied at most
+// once: if the request to the leader fails midway, Propose fails although the command may
+// still be applied.
+func (rs *RaftServer) Propose(machine string, command []byte) error {
+	return rs.ProposeContext(context.Background(), machine, command)
+}
+
+// ProposeContext is Propose as part of the trace of ctx
+func (rs *RaftServer) ProposeContext(ctx context.Context, machine string, command []byte) (err error) {
+	ctx, span := rs.tracer.Start(ctx, "raft.propose", trace.WithAttributes(attribute.String("machine", machine)))
+	defer tracing.End(span, &err)
+	deadline := time.Now().Add(rs.config.CommitTimeout)
+	for {
+		index, term, leaderAddr, err := rs.submit(machine, command)
+		if err == ErrShuttingDown {
+			return err
+		}
+		if err == nil && index == 0 && leaderAddr != "" {
+			var reply ProposeReply
+			carrier, callSpan := tracing.StartCall(ctx, rs.tracer, "RaftRPCServer.Propose", leaderAddr)
+			err = rs.call(leaderAddr, "RaftRPCServer.Propose", ProposeArgs{Machine: machine, Command: command, Trace: carrier}, &reply)
+			tracing.End(callSpan, &err)
+			if err != nil && !notAppended(err) {
+				return err
+			}
+			index, term = reply.Index, reply.Term
+		}
+		if err == nil && index > 0 {
+			if err := rs.waitApplied(index, deadline); err != nil {
+				return err
+			}
+			if rs.appliedTerm(index, term) {
+				return nil
+			}
+			// the entry was overwritten by a new leader, so it was never applied
+		}
+		if time.Now().After(deadline) {
+			return ErrNoLeader
+		}
+		time.Sleep(rs.config.HeartbeatInterval)
+	}
+}
+
+// notAppended tells whether a command forwarded to the leader failed before the leader could
+// append it: the request never left this node, or the leader refused it
+func notAppended(err error) bool {
+	var serverErr rpc.ServerError
+	var opErr *net.OpError
+	return err == errUnreachable || errors.As(err, &serverErr) || errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// submit appends command if this node is the leader, otherwise it returns the leader to forward to
+func (rs *RaftServer) submit(machine string, command []byte) (int64, int64, string, error) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	if rs.closing {
+		return 0, 0, "", ErrShuttingDown
+	}
+	if rs.role != leader {
+		return 0, 0, rs.leader, nil
+	}
+	entry := Entry{Index: rs.lastIndex() + 1, Term: rs.term, Kind: EntryCommand, Machine: machine, Command: command}
+	if err := rs.appendEntries([]Entry{entry}); err != nil {
+		return 0, 0, "", err
+	}
+	rs.advanceCommit()
+	rs.broadcastAppend()
+	return entry.Index, entry.Term, rs.selfAddr, nil
+}
+
+// Sync waits until this node has applied every entry the leader had committed when Sync
+// was called, so that it sees the changes committed through other nodes
+func (rs *RaftServer) Sync() error {
+	deadline := time.Now().Add(rs.config.CommitTimeout)
+	rs.mux.Lock()
+	isLeader, leaderAddr, index := rs.role == leader, rs.leader, rs.commitIndex
+	rs.mux.Unlock()
+
+	if !isLeader {
+		if leaderAddr == "" {
+			return ErrNoLeader
+		}
+		if err := rs.call(leaderAddr, "RaftRPCServer.CommitIndex", 0, &index); err != nil {
+			return err
+		}
+	}
+	return rs.waitApplied(index, deadline)
+}
+
+// waitApplied waits until the entry at index is applied here
+func (rs *RaftServer) waitApplied(index int64, deadline time.Time) error {
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		rs.mux.Lock()
+		rs.applied.Broadcast()
+		rs.mux.Unlock()
+	})
+	defer timer.Stop()
+
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	for rs.lastApplied < index {
+		if rs.closing {
+			return ErrShuttingDown
+		}
+		if time.Now().After(deadline) {
+			return ErrNoLeader
+		}
+		rs.applied.Wait()
+	}
+	return nil
+}
+
+// appliedTerm tells whether the applied entry at index is the one proposed in term
+func (rs *RaftServer) appliedTerm(index int64, term int64) bool {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	applied, ok := rs.termAt(index)
+	// an entry already compacted into the snapshot can't be checked any more
+	return !ok || applied == term
+}
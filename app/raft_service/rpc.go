@@ -0,0 +1,197 @@
+package raft_service
+
+import (
+	"better_mp3/app/member_service"
+	"better_mp3/app/secure_rpc"
+	"better_mp3/app/tracing"
+	"errors"
+	"log"
+	"net/rpc"
+	"sync/atomic"
+	"time"
+)
+
+// intercept decides whether a request from one member address may reach another, nil lets
+// everything through. The test harness uses it to partition the raft peers like the members.
+var intercept atomic.Pointer[func(from string, dest string) bool]
+
+// SetIntercept installs allow as the hook that decides which requests reach their peer, nil lets
+// everything through
+func SetIntercept(allow func(from string, dest string) bool) {
+	if allow == nil {
+		intercept.Store(nil)
+		return
+	}
+	intercept.Store(&allow)
+}
+
+var errUnreachable = errors.New("raft peer unreachable")
+
+type RaftRPCServer struct {
+	raftServer *RaftServer
+}
+
+type RequestVoteArgs struct {
+	Term         int64
+	Candidate    string
+	LastLogIndex int64
+	LastLogTerm  int64
+}
+
+type RequestVoteReply struct {
+	Term    int64
+	Granted bool
+}
+
+type AppendEntriesArgs struct {
+	Term         int64
+	Leader       string
+	PrevLogIndex int64
+	PrevLogTerm  int64
+	Entries      []Entry
+	LeaderCommit int64
+}
+
+// AppendEntriesReply carries the first index of the conflicting term, so that
+// the leader skips a whole term at once when backing up
+type AppendEntriesReply struct {
+	Term          int64
+	Success       bool
+	ConflictIndex int64
+}
+
+type InstallSnapshotArgs struct {
+	Term     int64
+	Leader   string
+	Snapshot Snapshot
+}
+
+type InstallSnapshotReply struct {
+	Term int64
+}
+
+// ProposeArgs is a command forwarded by a follower to the leader
+type ProposeArgs struct {
+	Machine string
+	Command []byte
+	Trace   tracing.Carrier
+}
+
+// ProposeReply tells where the command was appended, it is committed if that entry is
+// still there once the follower has applied up to Index
+type ProposeReply struct {
+	Index int64
+	Term  int64
+}
+
+func RunRPCServer(raftServer *RaftServer) {
+	server := RaftRPCServer{
+		raftServer: raftServer,
+	}
+	rpcServer := rpc.NewServer()
+	err := rpcServer.Register(server)
+	if err != nil {
+		log.Fatal("Failed to register RPC instance")
+	}
+	listener, err := raftServer.credentials.Listen(raftServer.config.Port)
+	if err != nil {
+		log.Fatal("Failed to listen on port ", raftServer.config.Port)
+	}
+	raftServer.listener = listener
+	go secure_rpc.Serve(rpcServer, listener)
+}
+
+func (r RaftRPCServer) RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error {
+	return r.raftServer.handleRequestVote(args, reply)
+}
+
+func (r RaftRPCServer) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error {
+	return r.raftServer.handleAppendEntries(args, reply)
+}
+
+func (r RaftRPCServer) InstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	return r.raftServer.handleInstallSnapshot(args, reply)
+}
+
+func (r RaftRPCServer) Propose(args ProposeArgs, reply *ProposeReply) (err error) {
+	_, span := tracing.StartServer(r.raftServer.tracer, args.Trace, "RaftRPCServer.Propose")
+	defer tracing.End(span, &err)
+	index, term, _, err := r.raftServer.submit(args.Machine, args.Command)
+	if err != nil {
+		return err
+	}
+	if index == 0 {
+		return ErrNoLeader
+	}
+	reply.Index = index
+	reply.Term = term
+	return nil
+}
+
+// CommitIndex lets a follower catch up with the changes committed so far, see Sync
+func (r RaftRPCServer) CommitIndex(_ int, index *int64) error {
+	rs := r.raftServer
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	if rs.role != leader {
+		return ErrNoLeader
+	}
+	*index = rs.commitIndex
+	return nil
+}
+
+// call sends a request to the raft server of peer, through a connection kept per peer.
+// It gives up after election_timeout, so that a dead peer doesn't hold up the caller.
+func (rs *RaftServer) call(peer string, method string, args interface{}, reply interface{}) error {
+	if allow := intercept.Load(); allow != nil && !(*allow)(rs.selfAddr, peer) {
+		return errUnreachable
+	}
+	client, err := rs.client(peer)
+	if err != nil {
+		return err
+	}
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == rpc.ErrShutdown {
+			rs.dropClient(peer, client)
+		}
+		return call.Error
+	case <-time.After(rs.config.ElectionTimeout):
+		rs.dropClient(peer, client)
+		return errors.New("raft request to " + peer + " timed out")
+	case <-rs.done:
+		return ErrShuttingDown
+	}
+}
+
+func (rs *RaftServer) client(peer string) (*rpc.Client, error) {
+	rs.clientMux.Lock()
+	defer rs.clientMux.Unlock()
+	if client, ok := rs.clients[peer]; ok {
+		return client, nil
+	}
+	select {
+	case <-rs.done:
+		return nil, ErrShuttingDown
+	default:
+	}
+	addr := rs.ms.ServiceAddr(peer, member_service.EndpointRaft, rs.config.Port)
+	conn, err := rs.credentials.DialTimeout(addr, rs.config.ElectionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	client := rpc.NewClient(conn)
+	rs.clients[peer] = client
+	return client, nil
+}
+
+// dropClient closes a broken connection, the next call dials again
+func (rs *RaftServer) dropClient(peer string, client *rpc.Client) {
+	rs.clientMux.Lock()
+	defer rs.clientMux.Unlock()
+	if rs.clients[peer] == client {
+		delete(rs.clients, peer)
+	}
+	_ = client.Close()
+}
@@ -0,0 +1,173 @@
+package raft_service
+
+import (
+	"better_mp3/app/config"
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFile    = "state.json"
+	snapshotFile = "snapshot.json"
+	logFile      = "log.jsonl"
+)
+
+// hardState must be on disk before the node answers an rpc with it
+type hardState struct {
+	Term     int64
+	VotedFor string
+}
+
+// storage keeps the log as one json entry per line, appended as entries arrive and
+// rewritten when the log is truncated or compacted. The other files are replaced whole.
+type storage struct {
+	dir string
+	log *os.File
+}
+
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, config.PERM_MODE); err != nil {
+		return nil, err
+	}
+	return &storage{dir: dir}, nil
+}
+
+// load reads what was persisted, a missing file means nothing was persisted yet
+func (s *storage) load() (hardState, Snapshot, []Entry, error) {
+	var state hardState
+	var snapshot Snapshot
+	if err := s.readJSON(stateFile, &state); err != nil {
+		return state, snapshot, nil, err
+	}
+	if err := s.readJSON(snapshotFile, &snapshot); err != nil {
+		return state, snapshot, nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, logFile))
+	if os.IsNotExist(err) {
+		return state, snapshot, nil, nil
+	}
+	if err != nil {
+		return state, snapshot, nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without newline was cut short by a crash and was never acknowledged
+			break
+		}
+		if err != nil {
+			return state, snapshot, nil, err
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return state, snapshot, nil, err
+		}
+		// entries up to the snapshot may remain if the node crashed while compacting
+		if entry.Index > snapshot.Index {
+			entries = append(entries, entry)
+		}
+	}
+	return state, snapshot, entries, nil
+}
+
+func (s *storage) saveState(state hardState) error {
+	return s.writeJSON(stateFile, state)
+}
+
+func (s *storage) saveSnapshot(snapshot Snapshot) error {
+	return s.writeJSON(snapshotFile, snapshot)
+}
+
+// append adds entries to the end of the log file and syncs it
+func (s *storage) append(entries []Entry) error {
+	if s.log == nil {
+		f, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		s.log = f
+	}
+	writer := bufio.NewWriter(s.log)
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, _ = writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// rewrite replaces the log file with entries
+func (s *storage) rewrite(entries []Entry) error {
+	s.close()
+	tmp := filepath.Join(s.dir, logFile+".tmp")
+	if err := ioutil.WriteFile(tmp, nil, 0644); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.log = f
+	if err := s.append(entries); err != nil {
+		// the next append opens the log file again, which is still the old one
+		s.close()
+		return err
+	}
+	s.close()
+	return os.Rename(tmp, filepath.Join(s.dir, logFile))
+}
+
+func (s *storage) close() {
+	if s.log != nil {
+		_ = s.log.Close()
+		s.log = nil
+	}
+}
+
+func (s *storage) readJSON(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON replaces a file through a rename, so that a crash leaves either version
+func (s *storage) writeJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
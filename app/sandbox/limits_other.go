@@ -0,0 +1,29 @@
+//go:build !unix
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errUnsupported = errors.New("sandboxed execution needs a unix system")
+
+func setLimits(cpuSeconds uint64, memory uint64, fileSize uint64) error {
+	return errUnsupported
+}
+
+func execute(path string, args []string, env []string) error {
+	return errUnsupported
+}
+
+func processGroup() *syscall.SysProcAttr {
+	return nil
+}
+
+func killGroup(pid int) {
+	if process, err := os.FindProcess(pid); err == nil {
+		_ = process.Kill()
+	}
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"syscall"
+	"time"
+)
+
+// setLimits applies the rlimits of this process, which the executable inherits. Zero is no limit.
+func setLimits(cpuSeconds uint64, memory uint64, fileSize uint64) error {
+	for _, limit := range []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CPU, cpuSeconds},
+		{syscall.RLIMIT_AS, memory},
+		{syscall.RLIMIT_FSIZE, fileSize},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := syscall.Setrlimit(limit.resource, &rlimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute replaces this process with the executable. Another process forked while the executable
+// was being copied may still hold it open for writing for a moment.
+func execute(path string, args []string, env []string) error {
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = syscall.Exec(path, args, env); err != syscall.ETXTBSY {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// processGroup starts the executable in a process group of its own
+func processGroup() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killGroup kills the executable and everything it started
+func killGroup(pid int) {
+	_ = syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -0,0 +1,184 @@
+/*
+This package runs untrusted executables, such as the maple and juice programs users upload to sdfs.
+An executable runs without a shell, from an argument vector, in a private working directory that is
+removed afterwards, with an environment of its own, and in its own process group, which is killed
+once the wall-clock limit is reached. stdout and stderr are captured separately.
+
+Cpu time, memory and the size of written files are limited with rlimits. They must apply before the
+executable runs its first instruction, and they can't be set on a child by os/exec, so the program
+starts a copy of itself that sets them and then replaces itself with the executable. Programs that
+use the sandbox call Init first thing in main. Cgroups would also limit the children of the
+executable as a whole, but they need privileges or a delegated hierarchy most nodes don't have.
+*/
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// reexecArg marks a copy of the program started by Run
+const reexecArg = "__better_mp3_sandbox__"
+
+// the most of stderr kept, the rest is dropped
+const maxStderr = 64 << 10
+
+var ErrTimeout = errors.New("sandboxed executable exceeded its time limit")
+
+// Limits of an executable, zero is no limit
+type Limits struct {
+	Timeout  time.Duration // wall-clock time
+	CPU      time.Duration // cpu time, rounded up to whole seconds
+	Memory   int64         // bytes of address space
+	FileSize int64         // bytes written to any one file
+}
+
+type Spec struct {
+	Dir        string    // the private working directory is created in Dir
+	Executable string    // copied into the working directory and run from there
+	Args       []string  // the arguments after the name of the executable
+	Files      []string  // copied into the working directory under their base names
+	Stdin      string    // a file read as stdin, empty for none
+	Stdout     io.Writer // receives stdout
+	Limits     Limits
+}
+
+// cpuSeconds rounds a cpu limit up to the whole seconds of RLIMIT_CPU, where zero is no limit
+func cpuSeconds(cpu time.Duration) int64 {
+	return int64((cpu + time.Second - 1) / time.Second)
+}
+
+// Run runs an executable to completion and returns what it wrote to stderr
+func Run(spec Spec) ([]byte, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	// the copy of the program starts in root, the executable runs in root/work
+	root, err := ioutil.TempDir(spec.Dir, "sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+	work := filepath.Join(root, "work")
+	if err := os.Mkdir(work, 0700); err != nil {
+		return nil, err
+	}
+	name := filepath.Base(spec.Executable)
+	if err := copyFile(spec.Executable, filepath.Join(work, name), 0700); err != nil {
+		return nil, err
+	}
+	for _, file := range spec.Files {
+		if err := copyFile(file, filepath.Join(work, filepath.Base(file)), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []string{reexecArg,
+		strconv.FormatInt(cpuSeconds(spec.Limits.CPU), 10),
+		strconv.FormatInt(spec.Limits.Memory, 10),
+		strconv.FormatInt(spec.Limits.FileSize, 10),
+		"./" + name}
+	cmd := exec.Command(self, append(args, spec.Args...)...)
+	cmd.Dir = root
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=" + work, "TMPDIR=" + work}
+	cmd.SysProcAttr = processGroup()
+	cmd.Stdout = spec.Stdout
+	stderr := &limitedBuffer{max: maxStderr}
+	cmd.Stderr = stderr
+	if spec.Stdin != "" {
+		stdin, err := os.Open(spec.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		defer stdin.Close()
+		cmd.Stdin = stdin
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	timedOut := make(chan bool, 1)
+	if spec.Limits.Timeout > 0 {
+		timer := time.AfterFunc(spec.Limits.Timeout, func() {
+			timedOut <- true
+			killGroup(cmd.Process.Pid)
+		})
+		defer timer.Stop()
+	}
+	err = cmd.Wait()
+	select {
+	case <-timedOut:
+		return stderr.data, ErrTimeout
+	default:
+	}
+	if err != nil {
+		return stderr.data, fmt.Errorf("%v: %v", name, err)
+	}
+	return stderr.data, nil
+}
+
+// Init turns this process into the sandbox of an executable if Run started it, in which case it
+// doesn't return
+func Init() {
+	if len(os.Args) < 2 || os.Args[1] != reexecArg {
+		return
+	}
+	err := enter(os.Args[2:])
+	fmt.Fprintln(os.Stderr, "sandbox:", err)
+	os.Exit(126)
+}
+
+// enter sets the limits and executes the executable in the work directory, it only returns on errors
+func enter(args []string) error {
+	if len(args) < 4 {
+		return errors.New("missing arguments")
+	}
+	var limits [3]uint64
+	for i := range limits {
+		limit, err := strconv.ParseUint(args[i], 10, 64)
+		if err != nil {
+			return err
+		}
+		limits[i] = limit
+	}
+	if err := os.Chdir("work"); err != nil {
+		return err
+	}
+	if err := setLimits(limits[0], limits[1], limits[2]); err != nil {
+		return err
+	}
+	return execute(args[3], args[3:], os.Environ())
+}
+
+func copyFile(from string, to string, mode os.FileMode) error {
+	content, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(to, content, mode)
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	max  int
+	data []byte
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.data); room > 0 {
+		if len(p) > room {
+			b.data = append(b.data, p[:room]...)
+		} else {
+			b.data = append(b.data, p...)
+		}
+	}
+	return len(p), nil
+}
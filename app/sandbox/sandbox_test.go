@@ -0,0 +1,99 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the test binary is the copy of the program Run starts
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+var testLimits = Limits{Timeout: 5 * time.Second, CPU: time.Second, Memory: 256 << 20, FileSize: 1 << 20}
+
+// run runs a shell script in the sandbox, under dir
+func run(t *testing.T, dir string, limits Limits, script string, args ...string) (string, string, error) {
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := ioutil.WriteFile(executable, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	stderr, err := Run(Spec{Dir: dir, Executable: executable, Args: args, Stdout: &stdout, Limits: limits})
+	return stdout.String(), string(stderr), err
+}
+
+// executables run without a shell in a private directory, their stdout and stderr are kept apart
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Chdir(outside); err != nil {
+		t.Fatal(err)
+	}
+	hostile := "$(touch injected); touch injected"
+	stdout, stderr, err := run(t, dir, testLimits, `echo out; echo err >&2; printf '%s\n' "$1"; pwd`, hostile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || lines[0] != "out" || lines[1] != hostile || stderr != "err\n" {
+		t.Fatalf("sandbox wrote %q to stdout and %q to stderr", stdout, stderr)
+	}
+	if !strings.HasPrefix(lines[2], dir+"/") {
+		t.Errorf("sandbox ran in %v, expected a directory of its own in %v", lines[2], dir)
+	}
+	if left, _ := ioutil.ReadDir(dir); len(left) != 0 {
+		t.Errorf("sandbox left %v files behind in %v", len(left), dir)
+	}
+	for _, place := range []string{dir, outside} {
+		if _, err := os.Stat(filepath.Join(place, "injected")); err == nil {
+			t.Error("an argument was run by a shell")
+		}
+	}
+}
+
+// executables are killed at their limits
+func TestLimits(t *testing.T) {
+	dir := t.TempDir()
+	for _, limit := range []struct {
+		name   string
+		script string
+	}{
+		{"cpu", "while :; do :; done"},
+		{"memory", `awk 'BEGIN { s = "x"; while (1) s = s s }'`},
+		{"file size", "head -c 2000000 /dev/zero > big"},
+	} {
+		start := time.Now()
+		if _, _, err := run(t, dir, testLimits, limit.script); err == nil || err == ErrTimeout {
+			t.Errorf("the %v limit was answered with %v after %v", limit.name, err, time.Since(start))
+		}
+	}
+	limits := testLimits
+	limits.Timeout = 300 * time.Millisecond
+	if _, _, err := run(t, dir, limits, "sleep 30"); err != ErrTimeout {
+		t.Errorf("the time limit was answered with %v, expected %v", err, ErrTimeout)
+	}
+}
+
+// a cpu limit below a second still limits, rlimits count whole seconds
+func TestCPUSeconds(t *testing.T) {
+	for cpu, expected := range map[time.Duration]int64{
+		0:                       0,
+		time.Millisecond:        1,
+		time.Second:             1,
+		1500 * time.Millisecond: 2,
+		time.Minute:             60,
+	} {
+		if got := cpuSeconds(cpu); got != expected {
+			t.Errorf("%v limited to %v seconds, expected %v", cpu, got, expected)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//go:build integration
+
+package secure_rpc_test
+
+import (
+	"better_mp3/app/file_service"
+	"better_mp3/app/harness"
+	"better_mp3/app/maple_juice_service"
+	"better_mp3/app/secure_rpc"
+	"crypto/tls"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestTLS(t *testing.T) {
+	harness.Run(t, "mutual", harness.Options{Size: 4, TLS: true}, checkTLS)
+}
+
+// with mutual tls, the rpc services refuse clients without a certificate of the cluster authority,
+// while the nodes still replicate files and run jobs
+func checkTLS(c *harness.Cluster) error {
+	target := c.Nodes[1]
+	fileAddr := "127.0.0.1:" + target.Config.FileServiceConfig.Port
+	mapleJuiceAddr := "127.0.0.1:" + target.Config.MapleJuiceServiceConfig.Port
+
+	other, err := secure_rpc.NewCA("intruder", time.Hour)
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := other.Issue("intruder", []string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		return err
+	}
+	foreignCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	// the intruder trusts the servers, but they don't trust it
+	intruder := secure_rpc.NewCredentials(c.CA().Pool(), foreignCert)
+
+	var plain *secure_rpc.Credentials
+	for _, attempt := range []struct {
+		who         string
+		credentials *secure_rpc.Credentials
+	}{{"a plain client", plain}, {"a client of another authority", intruder}} {
+		var deleted bool
+		var mapleResult string
+		for _, call := range []struct {
+			addr   string
+			method string
+			args   interface{}
+			reply  interface{}
+		}{
+			{fileAddr, "FileRPCServer.LocalDelete", file_service.EntryArgs{FileName: "any", Term: 1 << 40}, &deleted},
+			{mapleJuiceAddr, "MapleJuiceRPCServer.RunMapleTask", maple_juice_service.MapleJuiceTask{ExecFileName: "any", Term: 1 << 40}, &mapleResult},
+		} {
+			client, err := attempt.credentials.Dial(call.addr)
+			if err == nil {
+				err = client.Call(call.method, call.args, call.reply)
+				client.Close()
+			}
+			if err == nil {
+				return fmt.Errorf("%v called %v on %v", attempt.who, call.method, call.addr)
+			}
+		}
+	}
+	return c.RunWordcount()
+}
@@ -0,0 +1,160 @@
+/*
+This package secures the net/rpc connections between the file, maplejuice and raft services with
+mutual tls. Every node has its own certificate and key, signed by the certificate authority of the
+cluster: a server only accepts clients presenting a certificate of that authority, and a client
+only talks to servers presenting one for the address it dials. Without a configured authority,
+connections stay plain tcp. See ca.go to create an authority and the node certificates.
+*/
+package secure_rpc
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/logger"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// a peer must finish the tls handshake within handshakeTimeout
+const handshakeTimeout = 10 * time.Second
+
+// Dial connects and finishes the handshake within dialTimeout
+const dialTimeout = 10 * time.Second
+
+// Credentials hold the identity of a node, nil credentials use plain tcp
+type Credentials struct {
+	server *tls.Config
+	client *tls.Config
+}
+
+// Load reads the certificates named by tlsConfig, it returns nil credentials when tls is off
+func Load(tlsConfig config.TLSConfig) (*Credentials, error) {
+	if !tlsConfig.Enabled() {
+		return nil, nil
+	}
+	caPEM, err := ioutil.ReadFile(tlsConfig.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificate found in " + tlsConfig.CA)
+	}
+	cert, err := tls.LoadX509KeyPair(tlsConfig.Cert, tlsConfig.Key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCredentials(pool, cert), nil
+}
+
+// NewCredentials builds credentials from the authority pool and the certificate of the node
+func NewCredentials(pool *x509.CertPool, cert tls.Certificate) *Credentials {
+	return &Credentials{
+		server: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+		client: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+}
+
+// Listen listens for rpc connections on port
+func (c *Credentials) Listen(port string) (net.Listener, error) {
+	if c == nil {
+		return net.Listen("tcp", ":"+port)
+	}
+	return tls.Listen("tcp", ":"+port, c.server)
+}
+
+// Peer is the other end of an rpc connection
+type Peer struct {
+	Addr net.Addr
+	// Name is the common name of the certificate the peer presented, empty without tls
+	Name string
+}
+
+// Serve serves rpc connections until listener is closed. Peers that fail the handshake are
+// logged and disconnected.
+func Serve(rpcServer *rpc.Server, listener net.Listener) {
+	ServePeers(listener, func(Peer) *rpc.Server {
+		return rpcServer
+	})
+}
+
+// ServePeers is Serve with the rpc server newServer returns for the peer of each connection,
+// so that its methods know who calls them
+func ServePeers(listener net.Listener, newServer func(peer Peer) *rpc.Server) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			peer := Peer{Addr: conn.RemoteAddr()}
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+				if err := tlsConn.Handshake(); err != nil {
+					logger.PrintWarning("Rejected an rpc connection from", conn.RemoteAddr(), err)
+					_ = conn.Close()
+					return
+				}
+				_ = tlsConn.SetDeadline(time.Time{})
+				if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+					peer.Name = certs[0].Subject.CommonName
+				}
+			}
+			newServer(peer).ServeConn(conn)
+		}()
+	}
+}
+
+// DialTimeout connects to the rpc server at addr and completes the handshake within timeout
+func (c *Credentials) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	if c == nil {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", addr, c.clientFor(addr))
+}
+
+// Dial returns an rpc client of the server at addr, the caller closes it
+func (c *Credentials) Dial(addr string) (*rpc.Client, error) {
+	conn, err := c.DialTimeout(addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// Call calls method of the rpc server at addr on a connection of its own, failing unless the
+// dial and the call finish within timeout
+func (c *Credentials) Call(addr string, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	conn, err := c.DialTimeout(addr, timeout)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(deadline)
+	client := rpc.NewClient(conn)
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
+
+// clientFor expects the server certificate to be issued for the host of addr
+func (c *Credentials) clientFor(addr string) *tls.Config {
+	client := c.client.Clone()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		client.ServerName = host
+	}
+	return client
+}
@@ -0,0 +1,222 @@
+//go:build integration
+
+package tracing_test
+
+import (
+	"better_mp3/app/config"
+	"better_mp3/app/harness"
+	"better_mp3/app/tracing"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	harness.Main(m)
+}
+
+func TestTracing(t *testing.T) {
+	harness.Run(t, "all", harness.Options{Size: 4}, checkTracing)
+}
+
+// exportedSpan is a span as the file exporter writes it
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ SpanID string }
+	Resource    []struct {
+		Key   string
+		Value struct{ Value interface{} }
+	}
+	Status struct{ Code string }
+}
+
+// node is the member the span was recorded on
+func (s exportedSpan) node() string {
+	for _, attribute := range s.Resource {
+		if attribute.Key == "service.instance.id" {
+			return fmt.Sprint(attribute.Value.Value)
+		}
+	}
+	return ""
+}
+
+func readSpans(path string) ([]exportedSpan, error) {
+	if err := tracing.Flush(); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spans []exportedSpan
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		var span exportedSpan
+		if err := decoder.Decode(&span); err != nil {
+			return nil, err
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
+
+// the spans of a put and of maple and juice jobs form one trace each across the nodes: every rpc
+// has a client span on the caller and a server span on the callee, and the stages of the
+// scheduler and of the tasks are spans of their own
+func checkTracing(c *harness.Cluster) error {
+	saved := tracing.Config()
+	defer func() {
+		_ = tracing.Configure(saved)
+	}()
+	path := filepath.Join(c.Dir, "traces.json")
+	if err := tracing.Configure(config.TracingConfig{Exporter: config.TRACE_FILE, Path: path, SamplePercent: 100}); err != nil {
+		return err
+	}
+
+	master := c.Nodes[0]
+	if err := c.PutFrom(master, "traced"); err != nil {
+		return err
+	}
+	if err := c.WriteWordcount(); err != nil {
+		return err
+	}
+	if err := master.MapleJuice.ScheduleMapleTask([]string{"maple", "maple_wordcount", "3", "traced", "words"}); err != nil {
+		return err
+	}
+	if err := master.MapleJuice.ScheduleJuiceTask([]string{"juice", "juice_wordcount", "2", "traced_", "traced-result"}); err != nil {
+		return err
+	}
+	spans, err := readSpans(path)
+	if err != nil {
+		return err
+	}
+
+	byID := map[string]exportedSpan{}
+	for _, span := range spans {
+		byID[span.SpanContext.SpanID] = span
+	}
+	// root returns the only root span named name
+	root := func(name string) (exportedSpan, error) {
+		var found []exportedSpan
+		for _, span := range spans {
+			if span.Name == name && (span.Parent.SpanID == "" || span.Parent.SpanID == "0000000000000000") {
+				found = append(found, span)
+			}
+		}
+		if len(found) != 1 {
+			return exportedSpan{}, fmt.Errorf("%v root spans named %v, expected 1", len(found), name)
+		}
+		if found[0].node() != master.Addr {
+			return exportedSpan{}, fmt.Errorf("%v was recorded on %v instead of %v", name, found[0].node(), master.Addr)
+		}
+		return found[0], nil
+	}
+	// children returns the spans named name whose parent is named parentName, in the trace of root
+	children := func(root exportedSpan, parentName string, name string) []exportedSpan {
+		var found []exportedSpan
+		for _, span := range spans {
+			parent, ok := byID[span.Parent.SpanID]
+			if span.SpanContext.TraceID == root.SpanContext.TraceID && span.Name == name && ok && parent.Name == parentName {
+				found = append(found, span)
+			}
+		}
+		return found
+	}
+
+	put, err := root("sdfs.put")
+	if err != nil {
+		return err
+	}
+	replicaNum := c.Config.FileServiceConfig.ReplicaNum
+	if calls := children(put, "sdfs.put", "FileRPCServer.LocalPut"); len(calls) != replicaNum {
+		return fmt.Errorf("%v LocalPut calls traced, expected %v", len(calls), replicaNum)
+	}
+	handlers := children(put, "FileRPCServer.LocalPut", "FileRPCServer.LocalPut")
+	if len(handlers) != replicaNum {
+		return fmt.Errorf("%v LocalPut handlers continued the trace of the put, expected %v", len(handlers), replicaNum)
+	}
+	remote := 0
+	for _, handler := range handlers {
+		if handler.node() != master.Addr {
+			remote++
+		}
+	}
+	if remote < replicaNum-1 {
+		return fmt.Errorf("only %v LocalPut handlers ran on other nodes", remote)
+	}
+	if len(children(put, "sdfs.put", "raft.propose")) != 1 {
+		return errors.New("the put didn't trace its proposal to the file table")
+	}
+
+	for _, job := range []struct {
+		name   string
+		stages []string
+		task   string
+		steps  []string
+	}{
+		{"maplejuice.maple", []string{"maple.partition", "maple.upload", "maple.run-tasks", "maple.commit"}, "MapleJuiceRPCServer.RunMapleTask",
+			[]string{"maple-task.fetch-executable", "maple-task.fetch-input", "maple-task.execute", "maple-task.upload"}},
+		{"maplejuice.juice", []string{"juice.upload", "juice.run-tasks", "juice.collect"}, "MapleJuiceRPCServer.RunJuiceTask",
+			[]string{"juice-task.fetch-executable", "juice-task.fetch-input", "juice-task.execute"}},
+	} {
+		jobSpan, err := root(job.name)
+		if err != nil {
+			return err
+		}
+		if jobSpan.Status.Code == "Error" {
+			return fmt.Errorf("%v failed: %+v", job.name, jobSpan)
+		}
+		for _, stage := range job.stages {
+			if len(children(jobSpan, job.name, stage)) != 1 {
+				return fmt.Errorf("%v has no stage %v", job.name, stage)
+			}
+		}
+		// every task is called once from the job and traces each of its steps
+		tasks := children(jobSpan, job.task, job.task)
+		stage := strings.TrimPrefix(job.name, "maplejuice.") + ".run-tasks"
+		if calls := len(children(jobSpan, stage, job.task)); len(tasks) < 2 || calls != len(tasks) {
+			return fmt.Errorf("%v traced %v handlers of %v for %v calls", job.name, len(tasks), job.task, calls)
+		}
+		for _, task := range tasks {
+			if task.Status.Code == "Error" {
+				return fmt.Errorf("a task of %v on %v failed: %+v", job.name, task.node(), task)
+			}
+		}
+		for _, step := range job.steps {
+			if traced := len(children(jobSpan, job.task, step)); traced != len(tasks) {
+				return fmt.Errorf("%v of %v tasks of %v traced %v", traced, len(tasks), job.name, step)
+			}
+		}
+		if len(children(jobSpan, "maple-task.fetch-input", "sdfs.get")) == 0 && job.name == "maplejuice.maple" {
+			return errors.New("the maple tasks didn't trace fetching their input")
+		}
+		if len(children(jobSpan, "maple-task.upload", "sdfs.put")) == 0 && job.name == "maplejuice.maple" {
+			return errors.New("the maple tasks didn't trace putting their output")
+		}
+		if len(children(jobSpan, "maple.commit", "sdfs.append")) == 0 && job.name == "maplejuice.maple" {
+			return errors.New("the maple job didn't trace appending the output of its tasks")
+		}
+	}
+
+	// no span is recorded once tracing is off
+	if err := tracing.Configure(config.TracingConfig{}); err != nil {
+		return err
+	}
+	before := len(spans)
+	if err := c.PutFrom(master, "untraced"); err != nil {
+		return err
+	}
+	if spans, err = readSpans(path); err != nil {
+		return err
+	}
+	if len(spans) != before {
+		return fmt.Errorf("%v spans were recorded with tracing off", len(spans)-before)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+/*
+This package traces the requests of the nodes with OpenTelemetry. Every node has a tracer of its
+own, whose spans name the node as their service instance, so that the nodes the test harness runs
+in one process can be told apart. The spans of all the nodes of a process go to the exporter set by
+Configure: an otlp/http collector, or a file holding one json object per span. Until Configure is
+called, and when tracing.exporter is empty, no span is recorded.
+
+net/rpc has no headers, so the trace context of a request travels in its arguments: the argument
+structs of the rpcs have a Carrier, filled by StartCall on the caller and read by StartServer on
+the callee. Raft's own rpcs, its heartbeats, log replication and elections, are not part of any
+request and are not traced, only the proposals forwarded to the leader are.
+*/
+package tracing
+
+import (
+	"better_mp3/app/config"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "better_mp3"
+
+var (
+	mux        sync.Mutex
+	configured config.TracingConfig
+	processor  sdktrace.SpanProcessor // nil while tracing is off
+	file       *os.File               // the file of the file exporter
+	sampler    sdktrace.Sampler       = sdktrace.NeverSample()
+	providers                         = map[string]*sdktrace.TracerProvider{}
+	propagator                        = propagation.TraceContext{}
+)
+
+// Configure applies the tracing section of the config. Spans ended before are flushed to the
+// exporter configured before.
+func Configure(c config.TracingConfig) error {
+	var opened *os.File
+	var newProcessor sdktrace.SpanProcessor
+	switch c.Exporter {
+	case config.TRACE_FILE:
+		if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+			return err
+		}
+		var err error
+		if opened, err = os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			return err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(opened))
+		if err != nil {
+			opened.Close()
+			return err
+		}
+		newProcessor = sdktrace.NewBatchSpanProcessor(exporter)
+	case config.TRACE_OTLP:
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpoint(c.Endpoint), otlptracehttp.WithInsecure())
+		if err != nil {
+			return err
+		}
+		newProcessor = sdktrace.NewBatchSpanProcessor(exporter)
+	}
+
+	mux.Lock()
+	oldProcessor, oldFile := processor, file
+	configured = c
+	processor, file = newProcessor, opened
+	sampler = sdktrace.NeverSample()
+	if newProcessor != nil {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(float64(c.SamplePercent) / 100))
+	}
+	mux.Unlock()
+
+	shutdown(oldProcessor, oldFile)
+	return nil
+}
+
+// Config returns the config last applied by Configure
+func Config() config.TracingConfig {
+	mux.Lock()
+	defer mux.Unlock()
+	return configured
+}
+
+// Flush exports the spans that have ended
+func Flush() error {
+	mux.Lock()
+	current := processor
+	mux.Unlock()
+	if current == nil {
+		return nil
+	}
+	return current.ForceFlush(context.Background())
+}
+
+// Close exports the spans that have ended and turns tracing off
+func Close() {
+	mux.Lock()
+	oldProcessor, oldFile := processor, file
+	processor, file = nil, nil
+	sampler = sdktrace.NeverSample()
+	mux.Unlock()
+	shutdown(oldProcessor, oldFile)
+}
+
+func shutdown(oldProcessor sdktrace.SpanProcessor, oldFile *os.File) {
+	if oldProcessor != nil {
+		_ = oldProcessor.Shutdown(context.Background())
+	}
+	if oldFile != nil {
+		_ = oldFile.Close()
+	}
+}
+
+// Tracer returns the tracer of the node at nodeAddr
+func Tracer(nodeAddr string) trace.Tracer {
+	mux.Lock()
+	defer mux.Unlock()
+	provider, ok := providers[nodeAddr]
+	if !ok {
+		provider = sdktrace.NewTracerProvider(
+			sdktrace.WithSampler(currentSampler{}),
+			sdktrace.WithSpanProcessor(currentProcessor{}),
+			sdktrace.WithResource(resource.NewSchemaless(
+				attribute.String("service.name", serviceName),
+				attribute.String("service.instance.id", nodeAddr))))
+		providers[nodeAddr] = provider
+	}
+	return provider.Tracer(serviceName)
+}
+
+// currentSampler samples as the config last applied says, the tracer providers outlive configs
+type currentSampler struct{}
+
+func (currentSampler) ShouldSample(parameters sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	mux.Lock()
+	current := sampler
+	mux.Unlock()
+	return current.ShouldSample(parameters)
+}
+
+func (currentSampler) Description() string {
+	return "current"
+}
+
+// currentProcessor hands the spans that end to the exporter configured at the time
+type currentProcessor struct{}
+
+func (currentProcessor) OnStart(parent context.Context, span sdktrace.ReadWriteSpan) {}
+
+func (currentProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	mux.Lock()
+	current := processor
+	mux.Unlock()
+	if current != nil {
+		current.OnEnd(span)
+	}
+}
+
+func (currentProcessor) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (currentProcessor) ForceFlush(ctx context.Context) error {
+	return Flush()
+}
+
+// Carrier holds the trace context of a request in the arguments of an rpc
+type Carrier map[string]string
+
+func (c Carrier) Get(key string) string {
+	return c[key]
+}
+
+func (c Carrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c Carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Inject returns the trace context of ctx, nil when ctx has no span
+func Inject(ctx context.Context) Carrier {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := Carrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// Context returns a context continuing the trace of the carrier
+func (c Carrier) Context() context.Context {
+	return propagator.Extract(context.Background(), c)
+}
+
+// StartCall starts the span of an rpc to peer, its trace context goes into the arguments
+func StartCall(ctx context.Context, tracer trace.Tracer, method string, peer string) (Carrier, trace.Span) {
+	ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("rpc.method", method), attribute.String("peer", peer)))
+	return Inject(ctx), span
+}
+
+// StartServer starts the span of an rpc handler, continuing the trace of its caller
+func StartServer(tracer trace.Tracer, carrier Carrier, method string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, attribute.String("rpc.method", method))
+	return tracer.Start(carrier.Context(), method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+}
+
+// End ends a span, marking it failed with *err, used as defer tracing.End(span, &err)
+func End(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// Stages traces the stages a request goes through one after the other. Each stage is a span that
+// ends when the next one starts, the last one ends with the error of the request.
+type Stages struct {
+	tracer trace.Tracer
+	ctx    context.Context
+	span   trace.Span
+	err    *error
+}
+
+// NewStages traces stages as children of the span of ctx, used as
+// stages := tracing.NewStages(ctx, tracer, &err); defer stages.End()
+func NewStages(ctx context.Context, tracer trace.Tracer, err *error) *Stages {
+	return &Stages{tracer: tracer, ctx: ctx, err: err}
+}
+
+// Next ends the current stage and starts the next one, its context is returned for the calls
+// made during the stage
+func (s *Stages) Next(name string, attributes ...attribute.KeyValue) context.Context {
+	if s.span != nil {
+		s.span.End()
+	}
+	var ctx context.Context
+	ctx, s.span = s.tracer.Start(s.ctx, name, trace.WithAttributes(attributes...))
+	return ctx
+}
+
+// End ends the current stage with the error of the request
+func (s *Stages) End() {
+	if s.span != nil {
+		End(s.span, s.err)
+		s.span = nil
+	}
+}
@@ -1,4 +1,6 @@
 #!/bin/bash
 go clean ./
 rm -rf sdfs/*
-rm -rf tmp/*
\ No newline at end of file
+rm -rf tmp/*
+rm -rf raft/*
+rm -rf nodes/*
@@ -0,0 +1,34 @@
+#!/bin/bash
+# Start a cluster of N nodes on this host, e.g. `bash local_cluster.sh 4`.
+# Node i listens on 7008+10*i (member), 7007+10*i (file), 7009+10*i (maplejuice),
+# 7010+10*i (raft) and 7011+10*i (http, /metrics) and runs inside nodes/<member port>/,
+# where it keeps its sdfs, tmp, raft log and logs.
+# Node 0 is the introducer, later nodes may also join through any node started before them.
+# The introducer founds the raft cluster unless it kept a log from an earlier run.
+# Stop the cluster with `pkill -f better_mp3_node`.
+N=${1:-4}
+go build -o nodes/better_mp3_node ./app/*.go || exit 1
+seeds=""
+bootstrap=true
+for ((i = 0; i < N; i++)); do
+  port=$((7008 + 10 * i))
+  mkdir -p nodes/$port
+  (cd nodes/$port && exec ../better_mp3_node \
+    -config ../../app/conf.yaml \
+    -member_service.host 127.0.0.1 \
+    -member_service.introducer 127.0.0.1:7008 \
+    -member_service.seeds "$seeds" \
+    -member_service.port $port \
+    -file_service.port $((port - 1)) \
+    -maplejuice_service.port $((port + 1)) \
+    -raft_service.port $((port + 2)) \
+    -http_service.port $((port + 3)) \
+    -raft_service.bootstrap $bootstrap \
+    -maplejuice_service.input_dir ../../input/ \
+    -maplejuice_service.exec_dir ../../exec/ \
+    </dev/null >node.out 2>&1) &
+  echo "node $i: 127.0.0.1:$port"
+  seeds="$seeds${seeds:+,}127.0.0.1:$port"
+  bootstrap=false
+  sleep 1
+done
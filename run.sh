@@ -4,4 +4,5 @@ go get k8s.io/apimachinery/pkg/util/sets
 go get gopkg.in/yaml.v2
 go get github.com/emirpasic/gods/maps/treemap
 bash clean.sh
-go run ./app/*.go
\ No newline at end of file
+# clean.sh dropped the raft log, so the introducer founds a new cluster
+go run ./app/*.go -raft_service.bootstrap true
\ No newline at end of file